@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+)
+
+// deviceADB connects the host adb server to the emulator running in the
+// given container and returns a handle to it.
+func deviceADB(ctx context.Context, containerName string) (*adb.Device, error) {
+	ip, err := containerIP(containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	serial := net.JoinHostPort(ip, strconv.Itoa(adbPort))
+	if err := ADBClient.Connect(ctx, serial); err != nil {
+		return nil, err
+	}
+
+	return ADBClient.Device(serial), nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// adbtunnel listens on a local port and forwards every connection made by
+// adb through the authenticated tunnel exposed by the emulator agent, so
+// `adb connect 127.0.0.1:5555` reaches the cloud device.
+func main() {
+	agent := flag.String("agent", "http://localhost:8080", "emulator agent URL")
+	listen := flag.String("listen", "127.0.0.1:5555", "local address adb connects to")
+	accessToken := flag.String("token", os.Getenv("ADB_TUNNEL_TOKEN"), "access token returned by login")
+	flag.Parse()
+
+	if *accessToken == "" {
+		log.Fatalf("access token is required")
+	}
+
+	agentURL, err := url.Parse(*agent)
+	if err != nil {
+		log.Fatalf("invalid agent URL: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", *listen, err)
+	}
+
+	log.Printf("Tunnel listening on %s, run: adb connect %s", *listen, *listen)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatalf("failed to accept connection: %v", err)
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if err := forward(conn, agentURL, *accessToken); err != nil {
+				log.Printf("Error forwarding connection: %v", err)
+			}
+		}(conn)
+	}
+}
+
+// dialAgent opens a TCP or TLS connection to the agent.
+func dialAgent(agentURL *url.URL) (net.Conn, error) {
+	host := agentURL.Host
+	if agentURL.Scheme == "https" {
+		if agentURL.Port() == "" {
+			host = net.JoinHostPort(agentURL.Hostname(), "443")
+		}
+		return tls.Dial("tcp", host, &tls.Config{ServerName: agentURL.Hostname()})
+	}
+
+	if agentURL.Port() == "" {
+		host = net.JoinHostPort(agentURL.Hostname(), "80")
+	}
+	return net.Dial("tcp", host)
+}
+
+// forward upgrades a connection to the agent and pipes the local connection through it.
+func forward(local net.Conn, agentURL *url.URL, accessToken string) error {
+	remote, err := dialAgent(agentURL)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	req, err := http.NewRequest(http.MethodGet, agentURL.JoinPath("adb-tunnel").String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", accessToken)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "adb")
+
+	if err := req.Write(remote); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(remote)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("agent refused tunnel: %s: %s", resp.Status, body)
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, reader)
+		done <- struct{}{}
+	}()
+	<-done
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/appium"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/gorilla/mux"
+)
+
+const maxNewSessionSize = 1 << 20
+
+var AppiumPolicy *appium.Policy
+
+// appiumServer is the Appium server running next to the emulator of a
+// device, with the sessions opened through the agent.
+type appiumServer struct {
+	deviceName string
+	sessions   map[string]*appium.Session
+}
+
+// AppiumRegistry tracks the devices running an Appium server and their
+// sessions.
+type AppiumRegistry struct {
+	mu      sync.Mutex
+	servers map[string]*appiumServer
+}
+
+var Appium = &AppiumRegistry{servers: map[string]*appiumServer{}}
+
+// Enable records that the device runs an Appium server.
+func (reg *AppiumRegistry) Enable(deviceID string, deviceName string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.servers[deviceID] = &appiumServer{deviceName: deviceName, sessions: map[string]*appium.Session{}}
+}
+
+// Capabilities returns the capabilities prefilled in the sessions of the
+// device, and false when it runs no Appium server.
+func (reg *AppiumRegistry) Capabilities(deviceID string) (map[string]any, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	server, ok := reg.servers[deviceID]
+	if !ok {
+		return nil, false
+	}
+	return AppiumPolicy.Capabilities(server.deviceName), true
+}
+
+func (reg *AppiumRegistry) addSession(s *appium.Session) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if server, ok := reg.servers[s.DeviceID]; ok {
+		server.sessions[s.ID] = s
+	}
+}
+
+func (reg *AppiumRegistry) removeSession(deviceID string, id string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if server, ok := reg.servers[deviceID]; ok {
+		delete(server.sessions, id)
+	}
+}
+
+// touchSession records a command of a session of the device, and returns
+// false when the device has no such session.
+func (reg *AppiumRegistry) touchSession(deviceID string, id string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	server, ok := reg.servers[deviceID]
+	if !ok {
+		return false
+	}
+	server.prune()
+	s, ok := server.sessions[id]
+	if ok {
+		s.LastUsedAt = time.Now()
+	}
+	return ok
+}
+
+// Sessions returns the live sessions of the device.
+func (reg *AppiumRegistry) Sessions(deviceID string) []appium.Session {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	sessions := []appium.Session{}
+	server, ok := reg.servers[deviceID]
+	if !ok {
+		return sessions
+	}
+	server.prune()
+	for _, s := range server.sessions {
+		sessions = append(sessions, *s)
+	}
+	return sessions
+}
+
+// EndSessions forgets the sessions of the device, used when the emulator
+// restarts and Appium loses them.
+func (reg *AppiumRegistry) EndSessions(deviceID string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if server, ok := reg.servers[deviceID]; ok {
+		server.sessions = map[string]*appium.Session{}
+	}
+}
+
+// Remove forgets the Appium server of the device, used when the device goes
+// away with its container.
+func (reg *AppiumRegistry) Remove(deviceID string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.servers, deviceID)
+}
+
+// prune drops the sessions Appium ended for waiting too long for a command.
+func (server *appiumServer) prune() {
+	for id, s := range server.sessions {
+		if time.Since(s.LastUsedAt) > AppiumPolicy.NewCommandTimeout() {
+			delete(server.sessions, id)
+		}
+	}
+}
+
+// HandleWebDriver exposes the Appium server of the caller's device as a
+// WebDriver endpoint. New sessions get the capabilities of the device and
+// only sessions opened on the device are reachable.
+func HandleWebDriver(w http.ResponseWriter, r *http.Request) {
+	claims, ok := authenticateWebDriver(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID := mux.Vars(r)["id"]
+	if deviceID != claims.Username+"-Device" || DevicesPortMap[deviceID] == "" {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	caps, ok := Appium.Capabilities(deviceID)
+	if !ok {
+		http.Error(w, "Appium is not enabled on this device", http.StatusNotFound)
+		return
+	}
+
+	command := strings.TrimPrefix(r.URL.Path, "/devices/"+deviceID+"/wd/hub")
+	parts := strings.Split(strings.Trim(command, "/"), "/")
+
+	var modify func(*http.Response) error
+	switch {
+	case command == "/sessions" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"value": Appium.Sessions(deviceID)})
+		return
+
+	case parts[0] == "session" && len(parts) == 1 && r.Method == http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxNewSessionSize))
+		if err == nil {
+			body, err = appium.PrefillSession(body, caps)
+		}
+		if err != nil {
+			webDriverError(w, http.StatusBadRequest, "invalid argument", err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Type", "application/json")
+
+		modify = func(resp *http.Response) error {
+			if resp.StatusCode != http.StatusOK {
+				return nil
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+
+			id, capabilities, err := appium.ParseNewSession(body)
+			if err != nil {
+				log.Printf("Error reading the new Appium session of %s: %s", deviceID, err)
+				return nil
+			}
+			now := time.Now()
+			Appium.addSession(&appium.Session{
+				ID:           id,
+				DeviceID:     deviceID,
+				Username:     claims.Username,
+				Capabilities: capabilities,
+				CreatedAt:    now,
+				LastUsedAt:   now,
+			})
+			log.Printf("Appium session %s opened on %s", id, deviceID)
+			return nil
+		}
+
+	case parts[0] == "session" && len(parts) > 1:
+		id := parts[1]
+		if !Appium.touchSession(deviceID, id) {
+			webDriverError(w, http.StatusNotFound, "invalid session id", "Session not found on this device")
+			return
+		}
+		if len(parts) == 2 && r.Method == http.MethodDelete {
+			modify = func(resp *http.Response) error {
+				if resp.StatusCode < 300 || resp.StatusCode == http.StatusNotFound {
+					Appium.removeSession(deviceID, id)
+					log.Printf("Appium session %s closed on %s", id, deviceID)
+				}
+				return nil
+			}
+		}
+	}
+
+	ip, err := containerIP(deviceID)
+	if err != nil || ip == "" {
+		log.Printf("Error inspecting container %s: %v", deviceID, err)
+		http.Error(w, "Failed to reach device", http.StatusBadGateway)
+		return
+	}
+
+	target := &url.URL{Scheme: "http", Host: net.JoinHostPort(ip, strconv.Itoa(AppiumPolicy.Port()))}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.URL.Path = AppiumPolicy.BasePath() + command
+			pr.Out.URL.RawPath = ""
+			// The access token is for the agent, not for Appium.
+			pr.Out.Header.Del("Authorization")
+		},
+		ModifyResponse: modify,
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// authenticateWebDriver also accepts the access token as the password of
+// basic authentication, which WebDriver clients send from the user info of
+// the server URL.
+func authenticateWebDriver(r *http.Request) (*token.AccessPayload, bool) {
+	if _, password, ok := r.BasicAuth(); ok {
+		return verifyToken(password)
+	}
+	return authenticate(r)
+}
+
+func webDriverError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(appium.NewError(code, message))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+)
+
+const retentionInterval = time.Hour
+
+// HandleArtifacts lists or deletes the artifacts of an owner.
+func HandleArtifacts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ListArtifacts(w, r)
+	case http.MethodDelete:
+		DeleteArtifact(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ListArtifacts returns the artifacts of an owner with their download URLs.
+func ListArtifacts(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("owner")
+	if owner == "" {
+		http.Error(w, "Owner is required", http.StatusBadRequest)
+		return
+	}
+
+	list, err := Artifacts.List(r.Context(), owner)
+	if err != nil {
+		log.Printf("Error listing artifacts of %s: %s", owner, err)
+		http.Error(w, "Failed to list artifacts", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range list {
+		list[i].URL, err = Artifacts.DownloadURL(r.Context(), list[i])
+		if err != nil {
+			log.Printf("Error signing artifact %s: %s", list[i].ID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// DeleteArtifact deletes an artifact of an owner.
+func DeleteArtifact(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("owner")
+	id := r.URL.Query().Get("id")
+	if owner == "" || id == "" {
+		http.Error(w, "Owner and id are required", http.StatusBadRequest)
+		return
+	}
+
+	err := Artifacts.Delete(r.Context(), owner, id)
+	if errors.Is(err, artifacts.ErrNotFound) {
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error deleting artifact %s of %s: %s", id, owner, err)
+		http.Error(w, "Failed to delete artifact", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "Artifact deleted successfully")
+}
+
+// DownloadArtifact serves the content behind a signed local download URL.
+func DownloadArtifact(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	local, ok := Artifacts.Storage().(*artifacts.LocalStorage)
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	key, err := local.VerifyURL(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	rc, err := local.Get(r.Context(), key)
+	if err != nil {
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, rc)
+}
+
+// runRetention periodically deletes the expired artifacts.
+func runRetention() {
+	for {
+		deleted, err := Artifacts.ApplyRetention(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("Error applying artifact retention: %s", err)
+		} else if deleted > 0 {
+			log.Printf("Deleted %d expired artifacts", deleted)
+		}
+		time.Sleep(retentionInterval)
+	}
+}
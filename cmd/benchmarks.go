@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/benchmark"
+)
+
+const (
+	benchmarkTimeout   = time.Hour
+	benchmarkRetention = 24 * time.Hour
+	// benchmarkSettle is the time left to the app after a launch to render
+	// its first frames, and to the system after the app left the screen.
+	benchmarkSettle = 2 * time.Second
+)
+
+// benchmarkRun times the launches of an activity on a device until it
+// finished.
+type benchmarkRun struct {
+	mu     sync.Mutex
+	run    agent.Benchmark
+	cancel context.CancelFunc
+}
+
+func (b *benchmarkRun) snapshot() agent.Benchmark {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.run
+}
+
+func (b *benchmarkRun) running() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.run.FinishedAt == nil
+}
+
+func (b *benchmarkRun) finish(result *benchmark.Result, report *artifacts.Artifact, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.run.FinishedAt = &now
+	b.run.Result = result
+	b.run.Report = report
+	if err != nil {
+		b.run.Status = agent.BenchmarkError
+		b.run.Error = err.Error()
+		return
+	}
+	b.run.Status = agent.BenchmarkCompleted
+}
+
+// BenchmarkRegistry tracks the benchmarks, one at a time on a device so
+// the launches are not slowed down by another one.
+type BenchmarkRegistry struct {
+	mu   sync.Mutex
+	runs map[string]*benchmarkRun
+}
+
+var Benchmarks = &BenchmarkRegistry{runs: map[string]*benchmarkRun{}}
+
+// start registers a benchmark unless one is running on its device.
+func (reg *BenchmarkRegistry) start(b *benchmarkRun) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, current := range reg.runs {
+		if current.run.DeviceID == b.run.DeviceID && current.running() {
+			return false
+		}
+	}
+	reg.runs[b.run.ID] = b
+	return true
+}
+
+// get returns a benchmark of the owner.
+func (reg *BenchmarkRegistry) get(owner string, id string) (agent.Benchmark, bool) {
+	reg.mu.Lock()
+	b, ok := reg.runs[id]
+	reg.mu.Unlock()
+	if !ok {
+		return agent.Benchmark{}, false
+	}
+
+	run := b.snapshot()
+	return run, run.Owner == owner
+}
+
+// list returns the benchmarks of the owner, newest first, and forgets the
+// ones finished longer than the retention ago; their reports are kept.
+func (reg *BenchmarkRegistry) list(owner string) []agent.Benchmark {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	runs := []agent.Benchmark{}
+	for id, b := range reg.runs {
+		run := b.snapshot()
+		if run.FinishedAt != nil && time.Since(*run.FinishedAt) > benchmarkRetention {
+			delete(reg.runs, id)
+			continue
+		}
+		if run.Owner == owner {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.After(runs[j].StartedAt) })
+	return runs
+}
+
+// Abort cancels the benchmarks on the device, used when the device goes
+// away or restarts.
+func (reg *BenchmarkRegistry) Abort(deviceID string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, b := range reg.runs {
+		if b.run.DeviceID == deviceID && b.running() {
+			b.cancel()
+		}
+	}
+}
+
+// RunBenchmark times the startup of an activity of a device. It answers
+// once the benchmark started; the benchmark is polled through the
+// benchmarks.
+func RunBenchmark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	owner := r.URL.Query().Get("owner")
+	if containerName == "" || owner == "" {
+		http.Error(w, "Container name and owner are required", http.StatusBadRequest)
+		return
+	}
+
+	var opts benchmark.Options
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := opts.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), benchmarkTimeout)
+	b := &benchmarkRun{
+		run: agent.Benchmark{
+			ID:        newID(),
+			DeviceID:  containerName,
+			Owner:     owner,
+			Status:    agent.BenchmarkRunning,
+			Options:   opts,
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+	if !Benchmarks.start(b) {
+		cancel()
+		http.Error(w, "A benchmark is already running on this device", http.StatusConflict)
+		return
+	}
+
+	go func() {
+		defer cancel()
+
+		result, report, err := runBenchmark(ctx, b.snapshot())
+		b.finish(result, report, err)
+		if err != nil {
+			log.Printf("Error benchmarking %s on %s: %s", opts.Activity, containerName, err)
+			return
+		}
+		log.Printf("Benchmark %s of %s on %s finished", b.run.ID, opts.Activity, containerName)
+	}()
+
+	log.Printf("Started benchmark %s of %s on %s", b.run.ID, opts.Activity, containerName)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(b.snapshot())
+}
+
+// HandleBenchmarks returns a benchmark of an owner, or all of them.
+func HandleBenchmarks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, id := r.URL.Query().Get("owner"), r.URL.Query().Get("id")
+	if owner == "" {
+		http.Error(w, "Owner is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if id == "" {
+		json.NewEncoder(w).Encode(Benchmarks.list(owner))
+		return
+	}
+
+	run, ok := Benchmarks.get(owner, id)
+	if !ok {
+		http.Error(w, "Benchmark not found", http.StatusNotFound)
+		return
+	}
+	if run.Report != nil {
+		report := *run.Report
+		report.URL, _ = Artifacts.DownloadURL(r.Context(), report)
+		run.Report = &report
+	}
+	json.NewEncoder(w).Encode(run)
+}
+
+// BenchmarkHistory returns the summaries of the stored benchmarks of an
+// owner, oldest first with the change of each from the previous one, to
+// compare builds over time.
+func BenchmarkHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, activity := r.URL.Query().Get("owner"), r.URL.Query().Get("activity")
+	if owner == "" {
+		http.Error(w, "Owner is required", http.StatusBadRequest)
+		return
+	}
+
+	all, err := Artifacts.List(r.Context(), owner)
+	if err != nil {
+		log.Printf("Error listing artifacts of %s: %s", owner, err)
+		http.Error(w, "Failed to list benchmarks", http.StatusInternalServerError)
+		return
+	}
+
+	summaries := []benchmark.Summary{}
+	for _, a := range all {
+		if a.Kind != artifacts.KindBenchmark || (activity != "" && a.Metadata["activity"] != activity) {
+			continue
+		}
+		s := benchmark.ParseMetadata(a.Metadata)
+		s.ReportID, s.RunID, s.CreatedAt = a.ID, a.Session, a.CreatedAt
+		summaries = append(summaries, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(benchmark.History(summaries))
+}
+
+// runBenchmark times the launches of each mode of the benchmark and stores
+// the result.
+func runBenchmark(ctx context.Context, run agent.Benchmark) (*benchmark.Result, *artifacts.Artifact, error) {
+	device, err := deviceADB(ctx, run.DeviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := run.Options
+	out, err := device.RunShell(ctx, "dumpsys package "+opts.Package())
+	if err != nil {
+		return nil, nil, err
+	}
+	version := benchmark.ParseVersionName(out)
+	if version == "" {
+		return nil, nil, fmt.Errorf("package %s is not installed", opts.Package())
+	}
+
+	startedAt := time.Now()
+	result := benchmark.Result{Activity: opts.Activity, Label: opts.Label, VersionName: version}
+	for _, mode := range opts.Modes {
+		launches, err := benchmarkMode(ctx, device, opts, mode)
+		if err != nil {
+			return nil, nil, err
+		}
+		modeResult := benchmark.NewModeResult(mode, launches)
+		if modeResult.TotalTime.Count == 0 {
+			return nil, nil, fmt.Errorf("no %s launch of %s was timed: %s", mode, opts.Activity, launches[0].Error)
+		}
+		result.Modes = append(result.Modes, modeResult)
+	}
+	result.DurationMs = time.Since(startedAt).Milliseconds()
+
+	report, err := saveBenchmarkReport(ctx, run, result)
+	if err != nil {
+		log.Printf("Error storing report of benchmark %s: %s", run.ID, err)
+		return &result, nil, nil
+	}
+	return &result, &report, nil
+}
+
+// benchmarkMode times the launches of a mode, sampling the frames and the
+// memory of the app after each. A launch that fails is recorded with its
+// error, only a failure of the device fails the mode.
+func benchmarkMode(ctx context.Context, device *adb.Device, opts benchmark.Options, mode string) ([]benchmark.Launch, error) {
+	pkg := opts.Package()
+
+	// A warm or hot launch brings back an app already running.
+	if mode != benchmark.ModeCold {
+		if _, err := device.RunShell(ctx, benchmark.StartCommand(opts.Activity)); err != nil {
+			return nil, err
+		}
+	}
+
+	launches := make([]benchmark.Launch, 0, opts.Iterations)
+	for i := 1; i <= opts.Iterations; i++ {
+		var leave string
+		switch mode {
+		case benchmark.ModeCold:
+			leave = "am force-stop " + pkg
+		case benchmark.ModeWarm:
+			leave = "input keyevent KEYCODE_BACK"
+		case benchmark.ModeHot:
+			leave = "input keyevent KEYCODE_HOME"
+		}
+		if _, err := device.RunShell(ctx, leave); err != nil {
+			return nil, err
+		}
+		if err := settle(ctx); err != nil {
+			return nil, err
+		}
+		if _, err := device.RunShell(ctx, "dumpsys gfxinfo "+pkg+" reset"); err != nil {
+			return nil, err
+		}
+
+		out, err := device.RunShell(ctx, benchmark.StartCommand(opts.Activity))
+		if err != nil {
+			return nil, err
+		}
+		launch, err := benchmark.ParseStart(out)
+		launch.Iteration = i
+		if err != nil {
+			launch.Error = err.Error()
+			launches = append(launches, launch)
+			continue
+		}
+
+		if err := settle(ctx); err != nil {
+			return nil, err
+		}
+		if out, err := device.RunShell(ctx, "dumpsys gfxinfo "+pkg); err == nil {
+			launch.Frames = benchmark.ParseGfxInfo(out)
+		}
+		if out, err := device.RunShell(ctx, "dumpsys meminfo "+pkg); err == nil {
+			launch.Memory = benchmark.ParseMeminfo(out)
+		}
+		launches = append(launches, launch)
+	}
+	return launches, nil
+}
+
+func settle(ctx context.Context) error {
+	select {
+	case <-time.After(benchmarkSettle):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// saveBenchmarkReport stores the result of the benchmark, with the summary
+// of its startup times in the metadata for the history.
+func saveBenchmarkReport(ctx context.Context, run agent.Benchmark, result benchmark.Result) (artifacts.Artifact, error) {
+	report, err := json.MarshalIndent(struct {
+		Options benchmark.Options `json:"options"`
+		Result  benchmark.Result  `json:"result"`
+	}{run.Options, result}, "", "  ")
+	if err != nil {
+		return artifacts.Artifact{}, err
+	}
+
+	return Artifacts.Save(ctx, artifacts.Artifact{
+		Owner:       run.Owner,
+		DeviceID:    run.DeviceID,
+		Session:     run.ID,
+		Kind:        artifacts.KindBenchmark,
+		Name:        "benchmark-" + run.ID + ".json",
+		ContentType: "application/json",
+		Metadata:    benchmark.Metadata(result),
+	}, bytes.NewReader(report))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/emuconsole"
+)
+
+// dialConsole opens an authenticated emulator console connection to the
+// emulator running in the given container.
+func dialConsole(ctx context.Context, containerName string) (*emuconsole.Client, error) {
+	ip, err := containerIP(containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	// The emulator writes its console token to the home of the user running it.
+	out, err := exec.CommandContext(ctx, "sudo", "docker", "exec", "-i", containerName, "sh", "-c", "cat $HOME/.emulator_console_auth_token").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(ip, strconv.Itoa(emuconsole.DefaultPort))
+	return emuconsole.Dial(ctx, addr, strings.TrimSpace(string(out)))
+}
+
+// pauseDevice stops the virtual machine of the emulator in the given
+// container, so its disk images can be copied without being written. The
+// returned function resumes it; it may be called more than once.
+func pauseDevice(ctx context.Context, containerName string) (func(), error) {
+	console, err := dialConsole(ctx, containerName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := console.Command(ctx, "avd stop"); err != nil {
+		console.Close()
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			defer console.Close()
+
+			// Resume even when the request that paused the device is gone.
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if _, err := console.Command(ctx, "avd start"); err != nil {
+				log.Printf("Error resuming %s: %s", containerName, err)
+			}
+		})
+	}, nil
+}
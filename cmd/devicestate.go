@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/devicestate"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/volumes"
+)
+
+const maxDeviceStateRequestSize = 64 << 10
+
+// ExportDevice packages the data partition of a device, from its volume or
+// its AVD, with a manifest of the device and its installed packages into an
+// archive stored as an artifact of the owner.
+func ExportDevice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	owner := r.URL.Query().Get("owner")
+	if containerName == "" || owner == "" {
+		http.Error(w, "Container name and owner are required", http.StatusBadRequest)
+		return
+	}
+
+	var req devicestate.ExportRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxDeviceStateRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	device, err := deviceADB(ctx, containerName)
+	if err != nil {
+		log.Printf("Error connecting to %s: %s", containerName, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+	out, err := device.RunShell(ctx, "pm list packages -3")
+	if err != nil {
+		log.Printf("Error listing packages of %s: %s", containerName, err)
+		http.Error(w, "Failed to list installed packages", http.StatusBadGateway)
+		return
+	}
+	// Flush the data partition before it is copied.
+	if _, err := device.RunShell(ctx, "sync"); err != nil {
+		log.Printf("Error syncing %s: %s", containerName, err)
+	}
+
+	// The emulator keeps writing its disk images, whether they are in the
+	// AVD or on a volume, so it is paused while they are copied.
+	resume, err := pauseDevice(ctx, containerName)
+	if err != nil {
+		log.Printf("Error pausing %s: %s", containerName, err)
+		http.Error(w, "Failed to pause device", http.StatusBadGateway)
+		return
+	}
+	defer resume()
+
+	manifest := devicestate.Manifest{
+		Version:    devicestate.Version,
+		DeviceID:   containerName,
+		AndroidAPI: req.AndroidAPI,
+		DeviceName: req.DeviceName,
+		Packages:   devicestate.ParsePackages(out),
+		Source:     devicestate.SourceAVD,
+		ExportedAt: time.Now(),
+	}
+
+	var data *exec.Cmd
+	if req.Volume != "" {
+		dv, err := inspectVolume(ctx, volumes.DockerName(owner, req.AndroidAPI, req.Volume))
+		if err != nil {
+			log.Printf("Error inspecting volume %s of %s: %s", req.Volume, owner, err)
+			http.Error(w, "Failed to find the data volume of the device", http.StatusInternalServerError)
+			return
+		}
+		manifest.Source = devicestate.SourceVolume
+		data = exec.CommandContext(ctx, "sudo", "tar", "cf", "-", "-C", dv.Mountpoint, ".")
+	} else {
+		data = exec.CommandContext(ctx, "sudo", "docker", "exec", containerName, "sh", "-c",
+			fmt.Sprintf("cd %s && tar cf - userdata-qemu.img*", SnapshotPolicy.AVDDir()))
+	}
+
+	a, err := saveDeviceState(ctx, owner, manifest, req.SharedWith, data, resume)
+	if errors.Is(err, artifacts.ErrQuotaExceeded) {
+		http.Error(w, "Artifact quota exceeded", http.StatusInsufficientStorage)
+		return
+	} else if err != nil {
+		log.Printf("Error exporting %s: %s", containerName, err)
+		http.Error(w, "Failed to export device", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Device %s exported as %s (%d bytes)", containerName, a.ID, a.Size)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// saveDeviceState writes the archive of the data tar stream of cmd into
+// the artifact storage. copied is called once cmd ended, before the
+// archive is stored.
+func saveDeviceState(ctx context.Context, owner string, manifest devicestate.Manifest, sharedWith []string, cmd *exec.Cmd, copied func()) (artifacts.Artifact, error) {
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	data, err := cmd.StdoutPipe()
+	if err != nil {
+		return artifacts.Artifact{}, err
+	}
+	if err := cmd.Start(); err != nil {
+		return artifacts.Artifact{}, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := devicestate.Write(pw, manifest, data)
+		// Drain the command so it does not block on a failed archive.
+		io.Copy(io.Discard, data)
+		if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+			err = fmt.Errorf("%w: %s", waitErr, strings.TrimSpace(stderr.String()))
+		}
+		copied()
+		pw.CloseWithError(err)
+	}()
+
+	a, err := Artifacts.Save(ctx, artifacts.Artifact{
+		Owner:       owner,
+		DeviceID:    manifest.DeviceID,
+		Kind:        artifacts.KindDeviceState,
+		Name:        fmt.Sprintf("device-state-%s.tar.gz", manifest.ExportedAt.Format("20060102-150405")),
+		ContentType: "application/gzip",
+		Metadata:    devicestate.Metadata(manifest, sharedWith),
+	}, pr)
+	pr.CloseWithError(err)
+	return a, err
+}
+
+// DeviceArchive returns the manifest of a device state archive a user may
+// import.
+func DeviceArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, manifest, rc, ok := openDeviceArchive(w, r)
+	if !ok {
+		return
+	}
+	rc.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// ImportDevice creates a device of a user from a device state archive: the
+// data partition is written to a new volume of the user the emulator
+// starts with.
+func ImportDevice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var android AndroidConfig
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxDeviceStateRequestSize)).Decode(&android); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if android.ContainerName == "" || android.Owner == "" || android.Volume == "" {
+		http.Error(w, "Container name, owner and volume are required", http.StatusBadRequest)
+		return
+	}
+
+	reader, manifest, rc, ok := openDeviceArchive(w, r)
+	if !ok {
+		return
+	}
+	defer rc.Close()
+
+	android.AndroidAPI = manifest.AndroidAPI
+	if android.DeviceName == "" {
+		android.DeviceName = manifest.DeviceName
+	}
+	if err := volumes.Check(android.Volume, android.AndroidAPI); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	// An existing volume holds other data, it is never overwritten.
+	dockerName := volumes.DockerName(android.Owner, android.AndroidAPI, android.Volume)
+	unlock := lockVolume(dockerName)
+	if _, err := inspectVolume(ctx, dockerName); err == nil {
+		unlock()
+		http.Error(w, "Volume already exists, choose another name or delete it", http.StatusConflict)
+		return
+	} else if !errors.Is(err, volumes.ErrNotFound) {
+		unlock()
+		log.Printf("Error inspecting volume %s: %s", dockerName, err)
+		http.Error(w, "Failed to import device", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := prepareVolume(ctx, android.ContainerName, android.Owner, android.AndroidAPI, android.Volume); err != nil {
+		unlock()
+		log.Printf("Error creating volume %s: %s", dockerName, err)
+		http.Error(w, "Failed to import device", http.StatusInternalServerError)
+		return
+	}
+	dv, err := inspectVolume(ctx, dockerName)
+	if err == nil {
+		err = extractDeviceState(ctx, reader, dv.Mountpoint)
+	}
+	// startEmulator locks the volume again to mount it.
+	unlock()
+	if err != nil {
+		log.Printf("Error importing into volume %s: %s", dockerName, err)
+		dockerOutput(context.Background(), "volume", "rm", dockerName)
+		if errors.Is(err, devicestate.ErrInvalidArchive) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		http.Error(w, "Failed to import device", http.StatusInternalServerError)
+		return
+	}
+
+	if err := startEmulator(ctx, android); err != nil {
+		log.Printf("Error starting imported device %s: %s", android.ContainerName, err)
+		// The volume is only left to a device that imported it.
+		dockerOutput(context.Background(), "volume", "rm", dockerName)
+		http.Error(w, "Failed to start device", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Device %s imported from %s", android.ContainerName, r.URL.Query().Get("id"))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// openDeviceArchive opens the archive named in the request, answering the
+// request itself when the user may not import it.
+func openDeviceArchive(w http.ResponseWriter, r *http.Request) (*devicestate.Reader, devicestate.Manifest, io.ReadCloser, bool) {
+	query := r.URL.Query()
+	owner, id, user := query.Get("owner"), query.Get("id"), query.Get("user")
+	if owner == "" || id == "" || user == "" {
+		http.Error(w, "Owner, id and user are required", http.StatusBadRequest)
+		return nil, devicestate.Manifest{}, nil, false
+	}
+
+	a, rc, err := Artifacts.Open(r.Context(), owner, id)
+	if errors.Is(err, artifacts.ErrNotFound) || (err == nil && !devicestate.CanImport(a, user)) {
+		if rc != nil {
+			rc.Close()
+		}
+		// Archives not shared with the user are not disclosed.
+		http.Error(w, "Device state archive not found", http.StatusNotFound)
+		return nil, devicestate.Manifest{}, nil, false
+	} else if err != nil {
+		log.Printf("Error opening artifact %s of %s: %s", id, owner, err)
+		http.Error(w, "Failed to open device state archive", http.StatusInternalServerError)
+		return nil, devicestate.Manifest{}, nil, false
+	}
+
+	reader, manifest, err := devicestate.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return nil, devicestate.Manifest{}, nil, false
+	}
+
+	return reader, manifest, rc, true
+}
+
+// extractDeviceState writes the data partition of an archive into the
+// directory of a volume, owned by the user running the emulator.
+func extractDeviceState(ctx context.Context, reader *devicestate.Reader, dir string) error {
+	var stderr strings.Builder
+	cmd := exec.CommandContext(ctx, "sudo", "tar", "xf", "-", "--no-same-owner", "-C", dir)
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	err = reader.WriteData(stdin)
+	stdin.Close()
+	if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("%w: %s", waitErr, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return err
+	}
+
+	if out, err := exec.CommandContext(ctx, "sudo", "chown", "-R", VolumePolicy.OwnerID(), dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
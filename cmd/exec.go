@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/redis"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/shell"
+)
+
+const (
+	maxExecRequestSize = 64 << 10
+	defaultAuditLimit  = 100
+)
+
+var ShellPolicy *shell.Policy
+
+var ShellAudit *shell.AuditLog
+
+// Exec runs a shell command on a device for the functions and answers with
+// its whole output.
+func Exec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	var req agent.ExecRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxExecRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Owner == "" {
+		http.Error(w, "Owner is required", http.StatusBadRequest)
+		return
+	}
+
+	decision, ok := checkExec(w, req, containerName, false)
+	if !ok {
+		return
+	}
+
+	stdout := &limitedBuffer{limit: ShellPolicy.OutputLimit()}
+	stderr := &limitedBuffer{limit: ShellPolicy.OutputLimit()}
+
+	result, err := runExec(r.Context(), req, containerName, decision, stdout, stderr, false)
+	if err != nil {
+		log.Printf("Error running command on %s: %s", containerName, err)
+		http.Error(w, "Failed to run command", http.StatusBadGateway)
+		return
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.Truncated = stdout.truncated || stderr.truncated
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ExecStream runs a shell command on the caller's device and streams its
+// output as Server-Sent Events: stdout and stderr events carry chunks of
+// output as JSON strings and a final exit event carries the result.
+func ExecStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := authenticate(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID := claims.Username + "-Device"
+	if DevicesPortMap[deviceID] == "" {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	var req agent.ExecRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxExecRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Owner = claims.Username
+	req.Role = claims.Role
+
+	decision, ok := checkExec(w, req, deviceID, true)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	send := func(event string, data []byte) error {
+		rc.SetWriteDeadline(time.Now().Add(logWriteTimeout))
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	stdout := &eventWriter{event: "stdout", send: send}
+	stderr := &eventWriter{event: "stderr", send: send}
+
+	result, err := runExec(r.Context(), req, deviceID, decision, stdout, stderr, true)
+	if err != nil {
+		log.Printf("Error running command on %s: %s", deviceID, err)
+		data, _ := json.Marshal(map[string]string{"error": "Failed to run command"})
+		send("error", data)
+		return
+	}
+
+	data, _ := json.Marshal(result)
+	send("exit", data)
+}
+
+// ExecAudit lists the commands run by the caller, admins may list the
+// commands of anyone.
+func ExecAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := authenticate(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	username := claims.Username
+	if claims.Role == redis.RoleAdmin {
+		username = r.URL.Query().Get("username")
+	}
+
+	limit := defaultAuditLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := ShellAudit.List(username, limit)
+	if err != nil {
+		log.Printf("Error reading exec audit: %s", err)
+		http.Error(w, "Failed to read audit trail", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// checkExec applies the policy to the request and records denied commands.
+func checkExec(w http.ResponseWriter, req agent.ExecRequest, deviceID string, streamed bool) (shell.Decision, bool) {
+	decision, err := ShellPolicy.Check(req.Role, req.Command, time.Duration(req.Timeout)*time.Second)
+	if err == nil {
+		return decision, true
+	}
+
+	recordExec(shell.AuditEntry{
+		Time:     time.Now(),
+		Username: req.Owner,
+		Role:     decision.Role,
+		DeviceID: deviceID,
+		Command:  decision.Command,
+		Reason:   err.Error(),
+		Streamed: streamed,
+	})
+
+	if errors.Is(err, shell.ErrDenied) || errors.Is(err, shell.ErrUnknownRole) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+	} else {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+	return decision, false
+}
+
+// runExec runs an allowed command within its timeout and records it.
+func runExec(ctx context.Context, req agent.ExecRequest, deviceID string, decision shell.Decision, stdout io.Writer, stderr io.Writer, streamed bool) (agent.ExecResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, decision.Timeout)
+	defer cancel()
+
+	entry := shell.AuditEntry{
+		Time:     time.Now(),
+		Username: req.Owner,
+		Role:     decision.Role,
+		DeviceID: deviceID,
+		Command:  decision.Command,
+		Allowed:  true,
+		Streamed: streamed,
+	}
+	defer func() {
+		entry.Duration = time.Since(entry.Time)
+		recordExec(entry)
+	}()
+
+	device, err := deviceADB(ctx, deviceID)
+	if err != nil {
+		entry.Reason = err.Error()
+		return agent.ExecResult{}, err
+	}
+
+	code, err := device.Exec(ctx, decision.Command, stdout, stderr)
+	result := agent.ExecResult{
+		ExitCode:   code,
+		DurationMs: time.Since(entry.Time).Milliseconds(),
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.TimedOut = true
+		entry.TimedOut = true
+	case err != nil && !errors.Is(err, adb.ErrNoExitCode):
+		entry.Reason = err.Error()
+		return result, err
+	}
+
+	entry.ExitCode = &result.ExitCode
+	return result, nil
+}
+
+func recordExec(entry shell.AuditEntry) {
+	if err := ShellAudit.Record(entry); err != nil {
+		log.Printf("Error recording exec audit: %s", err)
+	}
+}
+
+// limitedBuffer keeps the first limit bytes written to it.
+type limitedBuffer struct {
+	strings.Builder
+	limit     int64
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	room := b.limit - int64(b.Len())
+	if int64(len(p)) > room {
+		b.truncated = true
+		b.Builder.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Builder.Write(p)
+}
+
+// eventWriter sends what is written to it as events.
+type eventWriter struct {
+	event string
+	send  func(event string, data []byte) error
+}
+
+func (ew *eventWriter) Write(p []byte) (int, error) {
+	data, _ := json.Marshal(string(p))
+	if err := ew.send(ew.event, data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/files"
+)
+
+const (
+	pushDirection = "push"
+	pullDirection = "pull"
+	fileTimeout   = 30 * time.Minute
+)
+
+var FilePolicy *files.Policy
+
+// Transfer is a file moving between a user and a device, reported to the
+// user while it is in progress.
+type Transfer struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`
+	DeviceID    string    `json:"device_id"`
+	Direction   string    `json:"direction"`
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	Transferred int64     `json:"transferred"`
+	Percent     float64   `json:"percent,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+
+	done atomic.Int64
+}
+
+func (t *Transfer) snapshot() Transfer {
+	done := t.done.Load()
+
+	var percent float64
+	if t.Size > 0 {
+		percent = float64(done) * 100 / float64(t.Size)
+	}
+
+	return Transfer{
+		ID:          t.ID,
+		Owner:       t.Owner,
+		DeviceID:    t.DeviceID,
+		Direction:   t.Direction,
+		Path:        t.Path,
+		Size:        t.Size,
+		Transferred: done,
+		Percent:     percent,
+		StartedAt:   t.StartedAt,
+	}
+}
+
+// Read and Write count the bytes going through the transfer.
+type transferReader struct {
+	r io.Reader
+	t *Transfer
+}
+
+func (tr *transferReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	tr.t.done.Add(int64(n))
+	return n, err
+}
+
+type transferWriter struct {
+	w io.Writer
+	t *Transfer
+}
+
+func (tw *transferWriter) Write(p []byte) (int, error) {
+	n, err := tw.w.Write(p)
+	tw.t.done.Add(int64(n))
+	return n, err
+}
+
+// TransferRegistry keeps the transfers in progress.
+type TransferRegistry struct {
+	mu        sync.Mutex
+	transfers map[string]*Transfer
+}
+
+var Transfers = &TransferRegistry{transfers: map[string]*Transfer{}}
+
+func (reg *TransferRegistry) start(target fileTarget, direction string, devicePath string, size int64) *Transfer {
+	t := &Transfer{
+		ID:        newID(),
+		Owner:     target.owner,
+		DeviceID:  target.deviceID,
+		Direction: direction,
+		Path:      devicePath,
+		Size:      size,
+		StartedAt: time.Now(),
+	}
+
+	reg.mu.Lock()
+	reg.transfers[t.ID] = t
+	reg.mu.Unlock()
+
+	return t
+}
+
+func (reg *TransferRegistry) finish(t *Transfer) {
+	reg.mu.Lock()
+	delete(reg.transfers, t.ID)
+	reg.mu.Unlock()
+}
+
+// List returns the transfers of owner in progress, oldest first.
+func (reg *TransferRegistry) List(owner string) []Transfer {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	list := []Transfer{}
+	for _, t := range reg.transfers {
+		if t.Owner == owner {
+			list = append(list, t.snapshot())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+	return list
+}
+
+// fileTarget is the device and owner of a file request.
+type fileTarget struct {
+	deviceID string
+	owner    string
+}
+
+type fileTargetKey struct{}
+
+// internalFiles serves file requests of the functions, which name the
+// container and owner in the query.
+func internalFiles(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := fileTarget{
+			deviceID: r.URL.Query().Get("containerName"),
+			owner:    r.URL.Query().Get("owner"),
+		}
+		if target.deviceID == "" || target.owner == "" {
+			http.Error(w, "Container name and owner are required", http.StatusBadRequest)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), fileTargetKey{}, target)))
+	}
+}
+
+// userFiles serves file requests of users on their own device, streamed
+// straight through the agent.
+func userFiles(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := authenticate(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		target := fileTarget{deviceID: claims.Username + "-Device", owner: claims.Username}
+		if DevicesPortMap[target.deviceID] == "" {
+			http.Error(w, "Device not found", http.StatusNotFound)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), fileTargetKey{}, target)))
+	}
+}
+
+// PushFile streams the request body to a path on the device. The file is
+// written next to its destination first so a failed upload leaves nothing
+// behind.
+func PushFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.Context().Value(fileTargetKey{}).(fileTarget)
+
+	devicePath, err := FilePolicy.CheckPath(r.URL.Query().Get("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	mode := os.FileMode(0o644)
+	if v := r.URL.Query().Get("mode"); v != "" {
+		m, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || m > 0o777 {
+			http.Error(w, "mode must be octal permissions such as 644", http.StatusBadRequest)
+			return
+		}
+		mode = os.FileMode(m)
+	}
+
+	if r.ContentLength > FilePolicy.MaxPushBytes() {
+		http.Error(w, fmt.Sprintf("File is larger than %d bytes", FilePolicy.MaxPushBytes()), http.StatusRequestEntityTooLarge)
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, FilePolicy.MaxPushBytes())
+
+	ctx, cancel := context.WithTimeout(r.Context(), fileTimeout)
+	defer cancel()
+
+	device, err := deviceADB(ctx, target.deviceID)
+	if err != nil {
+		log.Printf("Error connecting to %s: %s", target.deviceID, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+
+	transfer := Transfers.start(target, pushDirection, devicePath, r.ContentLength)
+	defer Transfers.finish(transfer)
+
+	partial := fmt.Sprintf("%s.part-%s", devicePath, transfer.ID)
+	err = device.Push(ctx, &transferReader{r: body, t: transfer}, partial, mode, time.Now())
+	if err != nil {
+		device.RunShell(context.Background(), "rm -f "+files.Quote(partial))
+
+		var maxErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxErr):
+			http.Error(w, fmt.Sprintf("File is larger than %d bytes", FilePolicy.MaxPushBytes()), http.StatusRequestEntityTooLarge)
+		case isSyncFailure(err):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			log.Printf("Error pushing %s to %s: %s", devicePath, target.deviceID, err)
+			http.Error(w, "Failed to push file", http.StatusBadGateway)
+		}
+		return
+	}
+
+	out, err := device.RunShell(ctx, fmt.Sprintf("mv -f %s %s && echo ok", files.Quote(partial), files.Quote(devicePath)))
+	if err != nil || !strings.HasPrefix(out, "ok") {
+		device.RunShell(context.Background(), "rm -f "+files.Quote(partial))
+		log.Printf("Error moving %s into place on %s: %v %s", devicePath, target.deviceID, err, out)
+		http.Error(w, "Failed to write file", http.StatusUnprocessableEntity)
+		return
+	}
+
+	log.Printf("Pushed %d bytes to %s on %s", transfer.done.Load(), devicePath, target.deviceID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files.NewEntry(devicePath, mode, transfer.done.Load(), time.Now()))
+}
+
+// PullFile streams a file of the device to the client. With the package
+// parameter the path is read from the data directory of that app with
+// run-as, which works for debuggable apps.
+func PullFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.Context().Value(fileTargetKey{}).(fileTarget)
+	pkg := r.URL.Query().Get("package")
+
+	var devicePath string
+	var err error
+	if pkg != "" {
+		devicePath, err = files.CheckAppPath(pkg, r.URL.Query().Get("path"))
+	} else {
+		devicePath, err = FilePolicy.CheckPath(r.URL.Query().Get("path"))
+	}
+	if errors.Is(err, files.ErrInvalidPackage) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), fileTimeout)
+	defer cancel()
+
+	device, err := deviceADB(ctx, target.deviceID)
+	if err != nil {
+		log.Printf("Error connecting to %s: %s", target.deviceID, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+
+	if pkg != "" {
+		pullAppFile(ctx, w, device, target, pkg, devicePath)
+		return
+	}
+
+	info, err := device.Stat(ctx, devicePath)
+	if errors.Is(err, adb.ErrNotExist) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error reading %s on %s: %s", devicePath, target.deviceID, err)
+		http.Error(w, "Failed to read file", http.StatusBadGateway)
+		return
+	}
+	if info.IsDir() {
+		http.Error(w, "Path is a directory", http.StatusBadRequest)
+		return
+	}
+	if info.Size > FilePolicy.MaxPullBytes() {
+		http.Error(w, fmt.Sprintf("File is larger than %d bytes", FilePolicy.MaxPullBytes()), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	transfer := Transfers.start(target, pullDirection, devicePath, info.Size)
+	defer Transfers.finish(transfer)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(devicePath)))
+	w.Header().Set("X-Transfer-ID", transfer.ID)
+
+	// Once the body started the status is sent, errors can only cut the
+	// download short of its Content-Length.
+	if _, err := device.Pull(ctx, devicePath, &transferWriter{w: w, t: transfer}); err != nil {
+		log.Printf("Error pulling %s from %s: %s", devicePath, target.deviceID, err)
+	}
+}
+
+// pullAppFile streams a file of an app data directory, whose size is only
+// known once it is read.
+func pullAppFile(ctx context.Context, w http.ResponseWriter, device *adb.Device, target fileTarget, pkg string, appPath string) {
+	var stderr limitedBuffer
+	stderr.limit = 4096
+
+	transfer := Transfers.start(target, pullDirection, pkg+":"+appPath, -1)
+	defer Transfers.finish(transfer)
+
+	// The command output is held back until it is known to be the file.
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	codeCh := make(chan int, 1)
+	go func() {
+		code, err := device.Exec(ctx, fmt.Sprintf("run-as %s cat %s", pkg, files.Quote(appPath)), pw, &stderr)
+		codeCh <- code
+		pw.CloseWithError(err)
+	}()
+
+	first := make([]byte, 32*1024)
+	n, readErr := io.ReadFull(pr, first)
+	if readErr == io.ErrUnexpectedEOF || readErr == io.EOF {
+		// The whole file fits in the first chunk, check the command succeeded.
+		if code := <-codeCh; code != 0 {
+			http.Error(w, "Failed to read app file: "+stderr.String(), http.StatusUnprocessableEntity)
+			return
+		}
+	} else if readErr != nil {
+		log.Printf("Error pulling %s from %s on %s: %s", appPath, pkg, target.deviceID, readErr)
+		http.Error(w, "Failed to read app file", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(appPath)))
+	w.Header().Set("X-Transfer-ID", transfer.ID)
+
+	limit := FilePolicy.MaxPullBytes()
+	out := &transferWriter{w: w, t: transfer}
+	if _, err := out.Write(first[:n]); err != nil {
+		pr.CloseWithError(err)
+		return
+	}
+	if _, err := io.Copy(out, io.LimitReader(pr, limit-int64(n))); err != nil {
+		log.Printf("Error pulling %s from %s on %s: %s", appPath, pkg, target.deviceID, err)
+		return
+	}
+
+	// Abort rather than end a file over the limit as if it was complete.
+	if n, _ := pr.Read(make([]byte, 1)); n > 0 {
+		log.Printf("Aborted pulling %s from %s on %s: larger than %d bytes", appPath, pkg, target.deviceID, limit)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// ListFiles returns the entries of a directory of the device.
+func ListFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.Context().Value(fileTargetKey{}).(fileTarget)
+
+	devicePath, err := FilePolicy.CheckPath(r.URL.Query().Get("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	device, err := deviceADB(r.Context(), target.deviceID)
+	if err != nil {
+		log.Printf("Error connecting to %s: %s", target.deviceID, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+
+	list, err := device.List(r.Context(), devicePath)
+	if isSyncFailure(err) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error listing %s on %s: %s", devicePath, target.deviceID, err)
+		http.Error(w, "Failed to list files", http.StatusBadGateway)
+		return
+	}
+
+	entries := make([]files.Entry, 0, len(list))
+	for _, info := range list {
+		entries = append(entries, files.NewEntry(path.Join(devicePath, info.Name), info.Mode, info.Size, info.ModTime))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// ListTransfers returns the transfers of the caller in progress.
+func ListTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.Context().Value(fileTargetKey{}).(fileTarget)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Transfers.List(target.owner))
+}
+
+// isSyncFailure reports whether the device refused a sync request, such
+// as for a missing file or a permission denied.
+func isSyncFailure(err error) bool {
+	var serverErr *adb.ServerError
+	return errors.As(err, &serverErr)
+}
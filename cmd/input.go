@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/emuconsole"
+)
+
+const (
+	maxInputRequestSize = 1 << 20
+	// gestureFrame is the interval between the touch events of a gesture.
+	gestureFrame = 16 * time.Millisecond
+)
+
+var (
+	screenSizeRe   = regexp.MustCompile(`(Physical|Override) size: (\d+)x(\d+)`)
+	orientationRe  = regexp.MustCompile(`SurfaceOrientation: (\d)`)
+	inputFailureRe = regexp.MustCompile(`(?i)exception|error|usage:`)
+)
+
+// Input runs a batch of input actions on a device in order, after checking
+// all of them against the screen size.
+func Input(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	var req agent.InputRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxInputRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Actions) == 0 || len(req.Actions) > agent.MaxInputActions {
+		http.Error(w, fmt.Sprintf("A batch needs between 1 and %d actions", agent.MaxInputActions), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	device, err := deviceADB(ctx, containerName)
+	if err != nil {
+		log.Printf("Error connecting to %s: %s", containerName, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+
+	screen, err := readScreen(ctx, device)
+	if err != nil {
+		log.Printf("Error reading screen size of %s: %s", containerName, err)
+		http.Error(w, "Failed to read screen size", http.StatusBadGateway)
+		return
+	}
+
+	result := agent.InputResult{Width: screen.width, Height: screen.height}
+
+	// Nothing runs unless the whole batch is valid.
+	valid := true
+	for i := range req.Actions {
+		action := &req.Actions[i]
+		action.Normalize()
+		res := agent.ActionResult{Index: i, Type: action.Type, Status: agent.ActionSkipped}
+		if err := action.Validate(screen.width, screen.height); err != nil {
+			res.Status = agent.ActionInvalid
+			res.Error = err.Error()
+			valid = false
+		}
+		result.Results = append(result.Results, res)
+	}
+
+	if valid {
+		runActions(ctx, containerName, device, screen, req, result.Results)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func runActions(ctx context.Context, containerName string, device *adb.Device, screen screen, req agent.InputRequest, results []agent.ActionResult) {
+	var console *emuconsole.Client
+	defer func() {
+		if console != nil {
+			console.Close()
+		}
+	}()
+
+	for i, action := range req.Actions {
+		started := time.Now()
+
+		var err error
+		if action.Type == agent.ActionGesture {
+			if console == nil {
+				console, err = dialConsole(ctx, containerName)
+			}
+			if err == nil {
+				err = runGesture(ctx, console, screen, action)
+			}
+		} else {
+			err = runInput(ctx, device, action)
+		}
+
+		results[i].DurationMs = time.Since(started).Milliseconds()
+		if err != nil {
+			results[i].Status = agent.ActionFailed
+			results[i].Error = err.Error()
+			if !req.ContinueOnError {
+				return
+			}
+			continue
+		}
+		results[i].Status = agent.ActionOK
+	}
+}
+
+// screen is the display input coordinates are given in, in its current
+// orientation and with any override size, and the touch panel behind it.
+type screen struct {
+	width       int
+	height      int
+	panelWidth  int
+	panelHeight int
+	orientation int
+}
+
+func readScreen(ctx context.Context, device *adb.Device) (screen, error) {
+	out, err := device.RunShell(ctx, "wm size; dumpsys input | grep -m 1 SurfaceOrientation")
+	if err != nil {
+		return screen{}, err
+	}
+
+	// An override size, when set, is listed after the physical size.
+	matches := screenSizeRe.FindAllStringSubmatch(out, -1)
+	if len(matches) == 0 {
+		return screen{}, fmt.Errorf("unexpected wm size output: %q", strings.TrimSpace(out))
+	}
+
+	var s screen
+	s.panelWidth, _ = strconv.Atoi(matches[0][2])
+	s.panelHeight, _ = strconv.Atoi(matches[0][3])
+	s.width, _ = strconv.Atoi(matches[len(matches)-1][2])
+	s.height, _ = strconv.Atoi(matches[len(matches)-1][3])
+
+	if m := orientationRe.FindStringSubmatch(out); m != nil {
+		s.orientation, _ = strconv.Atoi(m[1])
+	}
+	if s.orientation == 1 || s.orientation == 3 {
+		s.width, s.height = s.height, s.width
+	}
+
+	return s, nil
+}
+
+// toPanel maps a point of the display to the touch panel, undoing the
+// rotation and override size the system applies to touches.
+func (s screen) toPanel(p agent.Point) agent.Point {
+	// Back to the natural orientation of the display.
+	w, h := s.width, s.height
+	switch s.orientation {
+	case 1:
+		p = agent.Point{X: h - 1 - p.Y, Y: p.X}
+		w, h = h, w
+	case 2:
+		p = agent.Point{X: w - 1 - p.X, Y: h - 1 - p.Y}
+	case 3:
+		p = agent.Point{X: p.Y, Y: w - 1 - p.X}
+		w, h = h, w
+	}
+
+	return agent.Point{
+		X: p.X * s.panelWidth / w,
+		Y: p.Y * s.panelHeight / h,
+	}
+}
+
+// runInput runs a single-pointer, text or key action with the input tool.
+func runInput(ctx context.Context, device *adb.Device, action agent.Action) error {
+	var command string
+	switch action.Type {
+	case agent.ActionTap:
+		command = fmt.Sprintf("input tap %d %d", action.X, action.Y)
+	case agent.ActionLongPress:
+		command = fmt.Sprintf("input swipe %d %d %d %d %d", action.X, action.Y, action.X, action.Y, action.DurationMs)
+	case agent.ActionSwipe:
+		command = fmt.Sprintf("input swipe %d %d %d %d %d", action.X, action.Y, action.ToX, action.ToY, action.DurationMs)
+	case agent.ActionText:
+		command = "input text " + quoteInputText(action.Text)
+	case agent.ActionKeyEvent:
+		command = "input keyevent "
+		if action.LongPress {
+			command += "--longpress "
+		}
+		command += action.Key
+	}
+
+	out, err := device.RunShell(ctx, command)
+	if err != nil {
+		return err
+	}
+	if inputFailureRe.MatchString(out) {
+		return fmt.Errorf("input failed: %s", strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// quoteInputText escapes text for the input tool, which reads spaces as
+// %s, and quotes it for the shell.
+func quoteInputText(text string) string {
+	text = strings.ReplaceAll(text, " ", "%s")
+	return "'" + strings.ReplaceAll(text, "'", `'\''`) + "'"
+}
+
+// runGesture moves the pointers of a multi-touch gesture along their paths
+// by sending touch events through the emulator console.
+func runGesture(ctx context.Context, console *emuconsole.Client, screen screen, action agent.Action) error {
+	duration := time.Duration(action.DurationMs) * time.Millisecond
+	frames := max(1, int(duration/gestureFrame))
+	started := time.Now()
+
+	for frame := 0; frame <= frames; frame++ {
+		t := float64(frame) / float64(frames)
+
+		events := []string{}
+		for slot, path := range action.Pointers {
+			p := screen.toPanel(pointAt(path, t))
+			events = append(events, fmt.Sprintf("EV_ABS:ABS_MT_SLOT:%d", slot))
+			if frame == 0 {
+				events = append(events, fmt.Sprintf("EV_ABS:ABS_MT_TRACKING_ID:%d", slot+1))
+			}
+			events = append(events,
+				fmt.Sprintf("EV_ABS:ABS_MT_POSITION_X:%d", p.X),
+				fmt.Sprintf("EV_ABS:ABS_MT_POSITION_Y:%d", p.Y))
+		}
+		if frame == 0 {
+			events = append(events, "EV_KEY:BTN_TOUCH:1")
+		}
+		events = append(events, "EV_SYN:SYN_REPORT:0")
+
+		if _, err := console.Command(ctx, "event send "+strings.Join(events, " ")); err != nil {
+			liftPointers(console, len(action.Pointers))
+			return err
+		}
+
+		// Keep to the requested duration whatever the console latency is.
+		next := started.Add(time.Duration(frame+1) * duration / time.Duration(frames))
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			liftPointers(console, len(action.Pointers))
+			return ctx.Err()
+		}
+	}
+
+	return liftPointers(console, len(action.Pointers))
+}
+
+// liftPointers ends the touches of a gesture.
+func liftPointers(console *emuconsole.Client, pointers int) error {
+	events := []string{}
+	for slot := 0; slot < pointers; slot++ {
+		events = append(events, fmt.Sprintf("EV_ABS:ABS_MT_SLOT:%d", slot), "EV_ABS:ABS_MT_TRACKING_ID:-1")
+	}
+	events = append(events, "EV_KEY:BTN_TOUCH:0", "EV_SYN:SYN_REPORT:0")
+
+	_, err := console.Command(context.Background(), "event send "+strings.Join(events, " "))
+	return err
+}
+
+// pointAt returns the position at t, from 0 to 1, along a path whose
+// segments take the same time.
+func pointAt(path []agent.Point, t float64) agent.Point {
+	if len(path) == 1 || t <= 0 {
+		return path[0]
+	}
+	if t >= 1 {
+		return path[len(path)-1]
+	}
+
+	pos := t * float64(len(path)-1)
+	i := int(pos)
+	frac := pos - float64(i)
+	from, to := path[i], path[i+1]
+
+	return agent.Point{
+		X: from.X + int(float64(to.X-from.X)*frac),
+		Y: from.Y + int(float64(to.Y-from.Y)*frac),
+	}
+}
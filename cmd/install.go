@@ -0,0 +1,294 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/apk"
+)
+
+const (
+	maxAPKSize     = 1 << 30
+	installTimeout = 5 * time.Minute
+	remoteTmpDir   = "/data/local/tmp"
+)
+
+var installSessionRe = regexp.MustCompile(`\[(\d+)\]`)
+
+// localAPK is an APK extracted from an upload.
+type localAPK struct {
+	path     string
+	size     int64
+	manifest apk.Manifest
+}
+
+func InstallApp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	opts := agent.ParseInstallOptions(r.URL.Query())
+
+	dir, err := os.MkdirTemp("", "install-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	upload, err := saveUpload(w, r, filepath.Join(dir, "upload"))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "APK is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	apks, err := extractAPKs(upload, dir)
+	if err != nil {
+		http.Error(w, "Invalid APK: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), installTimeout)
+	defer cancel()
+
+	device, err := deviceADB(ctx, containerName)
+	if err != nil {
+		log.Printf("Error connecting adb to %s: %s", containerName, err)
+		http.Error(w, "Failed to reach device", http.StatusBadGateway)
+		return
+	}
+
+	result, err := installAPKs(ctx, device, apks, opts)
+	if err != nil {
+		log.Printf("Error installing app on %s: %s", containerName, err)
+		http.Error(w, "Failed to install app", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Installed %s on %s: %s", result.Package, containerName, result.Message)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// saveUpload writes the request body to path.
+func saveUpload(w http.ResponseWriter, r *http.Request, path string) (*localAPK, error) {
+	return saveFile(http.MaxBytesReader(w, r.Body, maxAPKSize), path)
+}
+
+// saveFile writes an uploaded APK to path.
+func saveFile(r io.Reader, path string) (*localAPK, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localAPK{path: path, size: n}, nil
+}
+
+// extractAPKs returns the APKs of an upload, which is either an APK or a
+// split APK set. The base APK comes first.
+func extractAPKs(upload *localAPK, dir string) ([]localAPK, error) {
+	f, err := os.Open(upload.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := zip.NewReader(f, upload.size)
+	if err != nil {
+		return nil, err
+	}
+
+	if apk.IsAPK(zr) {
+		manifest, err := apk.ReadManifest(f, upload.size)
+		if err != nil {
+			return nil, err
+		}
+		upload.manifest = manifest
+		return []localAPK{*upload}, nil
+	}
+
+	var apks []localAPK
+	for i, entry := range apk.SplitEntries(zr) {
+		extracted, err := extractEntry(entry, filepath.Join(dir, fmt.Sprintf("split-%d.apk", i)))
+		if err != nil {
+			return nil, err
+		}
+
+		if extracted.manifest.Split == "" {
+			apks = append([]localAPK{extracted}, apks...)
+		} else {
+			apks = append(apks, extracted)
+		}
+	}
+
+	if len(apks) == 0 || apks[0].manifest.Split != "" {
+		return nil, errors.New("no base APK found")
+	}
+
+	return apks, nil
+}
+
+func extractEntry(entry *zip.File, path string) (localAPK, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return localAPK{}, err
+	}
+	defer rc.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return localAPK{}, err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, rc)
+	if err != nil {
+		return localAPK{}, err
+	}
+
+	manifest, err := apk.ReadManifest(f, n)
+	if err != nil {
+		return localAPK{}, fmt.Errorf("%s: %w", entry.Name, err)
+	}
+
+	return localAPK{path: path, size: n, manifest: manifest}, nil
+}
+
+// pushAPKs copies the APKs to the device temporary directory.
+func pushAPKs(ctx context.Context, device *adb.Device, apks []localAPK) ([]string, error) {
+	prefix := fmt.Sprintf("%s/install-%d", remoteTmpDir, time.Now().UnixNano())
+
+	var remotePaths []string
+	for i, a := range apks {
+		f, err := os.Open(a.path)
+		if err != nil {
+			return remotePaths, err
+		}
+
+		remotePath := fmt.Sprintf("%s-%d.apk", prefix, i)
+		err = device.Push(ctx, f, remotePath, 0644, time.Now())
+		f.Close()
+		if err != nil {
+			return remotePaths, err
+		}
+		remotePaths = append(remotePaths, remotePath)
+	}
+
+	return remotePaths, nil
+}
+
+func installAPKs(ctx context.Context, device *adb.Device, apks []localAPK, opts agent.InstallOptions) (agent.InstallResult, error) {
+	result := agent.InstallResult{Manifest: apks[0].manifest}
+	for _, a := range apks[1:] {
+		result.Splits = append(result.Splits, a.manifest.Split)
+	}
+
+	remotePaths, err := pushAPKs(ctx, device, apks)
+	defer func() {
+		if len(remotePaths) > 0 {
+			device.RunShell(context.Background(), "rm -f "+strings.Join(remotePaths, " "))
+		}
+	}()
+	if err != nil {
+		return result, err
+	}
+
+	flags := installFlags(opts)
+
+	var out string
+	if len(apks) == 1 {
+		out, err = device.RunShell(ctx, "pm install"+flags+" "+remotePaths[0])
+	} else {
+		out, err = installSession(ctx, device, apks, remotePaths, flags)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	result.Message = strings.TrimSpace(out)
+	result.Success = strings.HasPrefix(result.Message, "Success")
+
+	return result, nil
+}
+
+// installSession installs split APKs in a single pm install session.
+func installSession(ctx context.Context, device *adb.Device, apks []localAPK, remotePaths []string, flags string) (string, error) {
+	var total int64
+	for _, a := range apks {
+		total += a.size
+	}
+
+	out, err := device.RunShell(ctx, fmt.Sprintf("pm install-create%s -S %d", flags, total))
+	if err != nil {
+		return "", err
+	}
+
+	match := installSessionRe.FindStringSubmatch(out)
+	if match == nil {
+		return out, nil
+	}
+	session := match[1]
+
+	for i, a := range apks {
+		out, err := device.RunShell(ctx, fmt.Sprintf("pm install-write -S %d %s %d %s", a.size, session, i, remotePaths[i]))
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(strings.TrimSpace(out), "Success") {
+			device.RunShell(ctx, "pm install-abandon "+session)
+			return out, nil
+		}
+	}
+
+	return device.RunShell(ctx, "pm install-commit "+session)
+}
+
+func installFlags(opts agent.InstallOptions) string {
+	var flags string
+	if opts.Replace {
+		flags += " -r"
+	}
+	if opts.GrantPermissions {
+		flags += " -g"
+	}
+	if opts.Downgrade {
+		flags += " -d"
+	}
+	if opts.AllowTest {
+		flags += " -t"
+	}
+	return flags
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/emuconsole"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/geo"
+)
+
+const (
+	maxRouteRequestSize = 8 << 20
+	// routeInterval is how often a replayed route moves the device.
+	routeInterval = time.Second
+	// maxFixFailures stops a replay whose console keeps failing.
+	maxFixFailures = 5
+)
+
+var errNoRoute = errors.New("no route to control")
+
+// simulator holds the simulated location of a device and replays its route.
+type simulator struct {
+	deviceID string
+
+	// ctl serializes the changes of location, which wait for the replay to
+	// stop before sending their own position.
+	ctl sync.Mutex
+
+	mu       sync.Mutex
+	location agent.Location
+	route    *geo.Route
+	// offset is the route time reached when the replay last resumed, at
+	// resumed, or where it was paused.
+	offset  time.Duration
+	resumed time.Time
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// LocationRegistry keeps the simulated location of each device.
+type LocationRegistry struct {
+	mu         sync.Mutex
+	simulators map[string]*simulator
+}
+
+var Locations = &LocationRegistry{simulators: map[string]*simulator{}}
+
+func (reg *LocationRegistry) get(deviceID string) *simulator {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	s, ok := reg.simulators[deviceID]
+	if !ok {
+		s = &simulator{deviceID: deviceID}
+		reg.simulators[deviceID] = s
+	}
+	return s
+}
+
+// Status returns the simulated location of the device.
+func (reg *LocationRegistry) Status(deviceID string) agent.Location {
+	reg.mu.Lock()
+	s, ok := reg.simulators[deviceID]
+	reg.mu.Unlock()
+
+	if !ok {
+		return agent.Location{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status()
+}
+
+// Discard stops the route replay of the device and forgets its location,
+// used when the device goes away.
+func (reg *LocationRegistry) Discard(deviceID string) {
+	reg.mu.Lock()
+	s, ok := reg.simulators[deviceID]
+	delete(reg.simulators, deviceID)
+	reg.mu.Unlock()
+
+	if ok {
+		s.ctl.Lock()
+		s.halt()
+		s.ctl.Unlock()
+	}
+}
+
+// halt stops the replay goroutine and waits for it, with ctl held.
+func (s *simulator) halt() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// setFixed stops any replay and places the device at p.
+func (s *simulator) setFixed(ctx context.Context, p geo.Point) (agent.Location, error) {
+	s.ctl.Lock()
+	defer s.ctl.Unlock()
+
+	s.halt()
+
+	console, err := dialConsole(ctx, s.deviceID)
+	if err != nil {
+		return agent.Location{}, err
+	}
+	defer console.Close()
+
+	if _, err := console.Command(ctx, geo.FixCommand(p)); err != nil {
+		return agent.Location{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.route = nil
+	s.offset = 0
+	s.location = agent.Location{Mode: agent.LocationFixed, Position: &p, UpdatedAt: &now}
+	return s.status(), nil
+}
+
+// startRoute replaces any replay with route, from its first point.
+func (s *simulator) startRoute(ctx context.Context, route *geo.Route, loop bool) (agent.Location, error) {
+	s.ctl.Lock()
+	defer s.ctl.Unlock()
+
+	s.halt()
+
+	// The first position is sent before answering so a device that cannot
+	// be moved fails the request.
+	console, err := dialConsole(ctx, s.deviceID)
+	if err != nil {
+		return agent.Location{}, err
+	}
+	first := route.At(0)
+	if _, err := console.Command(ctx, geo.FixCommand(first)); err != nil {
+		console.Close()
+		return agent.Location{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.route = route
+	s.offset = 0
+	s.location = agent.Location{
+		Mode:       agent.LocationRoute,
+		Position:   &first,
+		Points:     route.Len(),
+		DurationMs: route.Duration().Milliseconds(),
+		Loop:       loop,
+		UpdatedAt:  &now,
+	}
+	s.play(console)
+	return s.status(), nil
+}
+
+// control plays, pauses or stops the replay.
+func (s *simulator) control(action string) (agent.Location, error) {
+	s.ctl.Lock()
+	defer s.ctl.Unlock()
+
+	s.mu.Lock()
+	route, state := s.route, s.location.State
+	s.mu.Unlock()
+
+	if route == nil {
+		return agent.Location{}, errNoRoute
+	}
+
+	switch action {
+	case agent.RoutePlay:
+		if state == agent.RoutePlaying {
+			break
+		}
+		s.mu.Lock()
+		if state != agent.RoutePaused {
+			s.offset = 0
+		}
+		s.play(nil)
+		s.mu.Unlock()
+	case agent.RoutePause:
+		if state != agent.RoutePlaying {
+			break
+		}
+		s.mu.Lock()
+		s.offset = s.elapsed()
+		s.mu.Unlock()
+		s.halt()
+		s.mu.Lock()
+		s.location.State = agent.RoutePaused
+		s.mu.Unlock()
+	case agent.RouteStop:
+		// The device stays at the last position sent.
+		s.halt()
+		s.mu.Lock()
+		s.offset = 0
+		s.location.State = agent.RouteStopped
+		s.location.Error = ""
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status(), nil
+}
+
+// play starts the replay goroutine from offset, with ctl and mu held. An
+// open console is handed over to it.
+func (s *simulator) play(console *emuconsole.Client) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.resumed = time.Now()
+	s.location.State = agent.RoutePlaying
+	s.location.Error = ""
+
+	go s.run(ctx, s.done, console, s.route, s.location.Loop, s.resumed.Add(-s.offset))
+}
+
+// run moves the device along the route, which it started at started.
+func (s *simulator) run(ctx context.Context, done chan struct{}, console *emuconsole.Client, route *geo.Route, loop bool, started time.Time) {
+	defer close(done)
+	defer func() {
+		if console != nil {
+			console.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(routeInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		elapsed, finished := routeElapsed(route, loop, time.Since(started))
+		p := route.At(elapsed)
+
+		var err error
+		if console == nil {
+			console, err = dialConsole(ctx, s.deviceID)
+		}
+		if err == nil {
+			_, err = console.Command(ctx, geo.FixCommand(p))
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			log.Printf("Error moving %s along its route: %s", s.deviceID, err)
+			if console != nil {
+				console.Close()
+				console = nil
+			}
+			failures++
+			if failures >= maxFixFailures {
+				s.end(agent.RouteFailed, elapsed, err)
+				return
+			}
+		} else {
+			failures = 0
+			s.moved(p)
+			if finished {
+				s.end(agent.RouteFinished, elapsed, nil)
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *simulator) moved(p geo.Point) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.location.Position = &p
+	s.location.UpdatedAt = &now
+}
+
+func (s *simulator) end(state string, elapsed time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.offset = elapsed
+	s.location.State = state
+	if err != nil {
+		s.location.Error = err.Error()
+	}
+}
+
+// elapsed returns the route time reached, with mu held.
+func (s *simulator) elapsed() time.Duration {
+	if s.location.State != agent.RoutePlaying {
+		return s.offset
+	}
+	elapsed, _ := routeElapsed(s.route, s.location.Loop, s.offset+time.Since(s.resumed))
+	return elapsed
+}
+
+// status returns the location with the progress of the route, with mu held.
+func (s *simulator) status() agent.Location {
+	location := s.location
+	if s.route != nil {
+		elapsed := s.elapsed()
+		location.ElapsedMs = elapsed.Milliseconds()
+		if duration := s.route.Duration(); duration > 0 {
+			location.Progress = float64(elapsed) / float64(duration)
+		}
+	}
+	return location
+}
+
+// routeElapsed maps the time since a replay started to the route time,
+// wrapping around for a loop, and reports whether the route is done.
+func routeElapsed(route *geo.Route, loop bool, elapsed time.Duration) (time.Duration, bool) {
+	duration := route.Duration()
+	if elapsed < duration {
+		return elapsed, false
+	}
+	if loop && duration > 0 {
+		return elapsed % duration, false
+	}
+	return duration, true
+}
+
+func HandleLocation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		GetLocation(w, r)
+	case http.MethodPost:
+		SetLocation(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// SetLocation places a device at a fixed position.
+func SetLocation(w http.ResponseWriter, r *http.Request) {
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	var p geo.Point
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRouteRequestSize)).Decode(&p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := p.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	location, err := Locations.get(containerName).setFixed(r.Context(), p.Position())
+	if err != nil {
+		locationError(w, containerName, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(location)
+}
+
+// ReplayRoute starts moving a device along a route.
+func ReplayRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	var req agent.RouteRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRouteRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	route, err := req.Route()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	location, err := Locations.get(containerName).startRoute(r.Context(), route, req.Loop)
+	if err != nil {
+		locationError(w, containerName, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(location)
+}
+
+// ControlRoute plays, pauses or stops the route replay of a device.
+func ControlRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	action := r.URL.Query().Get("action")
+	if action != agent.RoutePlay && action != agent.RoutePause && action != agent.RouteStop {
+		http.Error(w, "action must be play, pause or stop", http.StatusBadRequest)
+		return
+	}
+
+	location, err := Locations.get(containerName).control(action)
+	if errors.Is(err, errNoRoute) {
+		http.Error(w, "No route to control, replay a route first", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(location)
+}
+
+// GetLocation returns the simulated location of a device.
+func GetLocation(w http.ResponseWriter, r *http.Request) {
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Locations.Status(containerName))
+}
+
+func locationError(w http.ResponseWriter, containerName string, err error) {
+	var cmdErr *emuconsole.CommandError
+	if errors.As(err, &cmdErr) {
+		http.Error(w, cmdErr.Message, http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("Error setting location of %s: %s", containerName, err)
+	http.Error(w, "Failed to set location", http.StatusBadGateway)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/logcat"
+)
+
+const (
+	defaultLogTail = 100
+	maxLogTail     = 10000
+	// logBufferSize is how many entries may wait for a slow client before
+	// newer ones are dropped.
+	logBufferSize    = 1024
+	logWriteTimeout  = 10 * time.Second
+	logKeepAlive     = 15 * time.Second
+	pidRefreshPeriod = 5 * time.Second
+	maxLogLineSize   = 1 << 20
+)
+
+var packageNameRe = regexp.MustCompile(`^[A-Za-z0-9_.:]+$`)
+
+// logStream is a logcat process whose filtered entries are handed to a
+// client through a bounded buffer.
+type logStream struct {
+	filter  logcat.Filter
+	tracker *logcat.Tracker
+	entries chan logEvent
+	dropped atomic.Int64
+
+	mu   sync.RWMutex
+	pids map[int]bool
+}
+
+type logEvent struct {
+	cursor logcat.Cursor
+	entry  logcat.Entry
+}
+
+// StreamLogs streams the logcat of the caller's device as Server-Sent
+// Events. Clients resume after a reconnect with the Last-Event-ID header
+// or the since query parameter.
+func StreamLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Browsers cannot set headers on an EventSource, so the access token
+	// is also accepted in the token query parameter, on this route only.
+	claims, ok := authenticate(r)
+	if !ok && r.Header.Get("Authorization") == "" {
+		claims, ok = verifyToken(r.URL.Query().Get("token"))
+	}
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID := claims.Username + "-Device"
+	if DevicesPortMap[deviceID] == "" {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+
+	filter, err := logcat.ParseFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Package != "" && !packageNameRe.MatchString(filter.Package) {
+		http.Error(w, "Invalid package name", http.StatusBadRequest)
+		return
+	}
+
+	var after logcat.Cursor
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = query.Get("since")
+	}
+	if since != "" {
+		if after, err = logcat.ParseCursor(since); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tail := defaultLogTail
+	if v := query.Get("tail"); v != "" {
+		if tail, err = strconv.Atoi(v); err != nil || tail < 0 || tail > maxLogTail {
+			http.Error(w, fmt.Sprintf("tail must be between 0 and %d", maxLogTail), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithDeadline(r.Context(), claims.ExpiredAt)
+	defer cancel()
+
+	device, err := deviceADB(ctx, deviceID)
+	if err != nil {
+		log.Printf("Error connecting to %s: %s", deviceID, err)
+		http.Error(w, "Failed to reach device", http.StatusBadGateway)
+		return
+	}
+
+	stream, err := device.Shell(ctx, logcat.Command(filter.MinPriority, after.Time, tail))
+	if err != nil {
+		log.Printf("Error starting logcat on %s: %s", deviceID, err)
+		http.Error(w, "Failed to start logcat", http.StatusBadGateway)
+		return
+	}
+	defer stream.Close()
+
+	ls := &logStream{
+		filter:  filter,
+		tracker: logcat.NewTracker(after),
+		entries: make(chan logEvent, logBufferSize),
+	}
+
+	if filter.Package != "" {
+		ls.refreshPIDs(ctx, device)
+		go ls.watchPIDs(ctx, device)
+	}
+
+	go func() {
+		defer close(ls.entries)
+		scanner := bufio.NewScanner(stream)
+		scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+		for scanner.Scan() {
+			ls.handleLine(strings.TrimSuffix(scanner.Text(), "\r"))
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	send := func(event string) error {
+		rc.SetWriteDeadline(time.Now().Add(logWriteTimeout))
+		if _, err := fmt.Fprint(w, event); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := send("retry: 3000\n\n"); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(logKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case ev, ok := <-ls.entries:
+			if !ok {
+				if ctx.Err() == nil {
+					send("event: end\ndata: {}\n\n")
+				}
+				return
+			}
+
+			if n := ls.dropped.Swap(0); n > 0 {
+				if err := send(fmt.Sprintf("event: dropped\ndata: {\"count\":%d}\n\n", n)); err != nil {
+					return
+				}
+			}
+
+			data, _ := json.Marshal(ev.entry)
+			if err := send(fmt.Sprintf("id: %s\nevent: log\ndata: %s\n\n", ev.cursor, data)); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := send(": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleLine parses a logcat line and queues it if it passes the filter,
+// dropping it when the client is too slow to keep up.
+func (ls *logStream) handleLine(line string) {
+	entry, err := logcat.ParseLine(line)
+	if err != nil {
+		// Buffer separators such as "--------- beginning of main".
+		return
+	}
+
+	cursor, fresh := ls.tracker.Next(entry)
+	if !fresh {
+		return
+	}
+
+	ls.mu.RLock()
+	match := ls.filter.Match(entry, ls.pids)
+	ls.mu.RUnlock()
+	if !match {
+		return
+	}
+
+	select {
+	case ls.entries <- logEvent{cursor: cursor, entry: entry}:
+	default:
+		ls.dropped.Add(1)
+	}
+}
+
+// watchPIDs follows the processes of the filtered package, which change
+// when the app restarts.
+func (ls *logStream) watchPIDs(ctx context.Context, device *adb.Device) {
+	ticker := time.NewTicker(pidRefreshPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ls.refreshPIDs(ctx, device)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (ls *logStream) refreshPIDs(ctx context.Context, device *adb.Device) {
+	out, err := device.RunShell(ctx, "pidof "+ls.filter.Package)
+	if err != nil {
+		return
+	}
+
+	pids := map[int]bool{}
+	for _, field := range strings.Fields(out) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids[pid] = true
+		}
+	}
+
+	ls.mu.Lock()
+	// Keep the processes seen before so their last lines still match.
+	for pid := range ls.pids {
+		pids[pid] = true
+	}
+	ls.pids = pids
+	ls.mu.Unlock()
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,9 +12,20 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/appium"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
 	"github.com/SajjadManafi/android-emulator-serverless/internal/config"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/files"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/network"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/sharding"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/shell"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/snapshots"
 	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/volumes"
 	"github.com/gorilla/mux"
 )
 
@@ -23,12 +36,27 @@ type AndroidConfig struct {
 	DeviceName    string `json:"DeviceName"`
 	AndroidAPI    string `json:"AndroidAPI"`
 	Status        string `json:"status"`
+	// Owner and Volume name the persistent data volume of the device, a
+	// device without a volume starts with fresh data.
+	Owner  string `json:"owner,omitempty"`
+	Volume string `json:"volume,omitempty"`
+	// Appium starts an Appium server next to the emulator.
+	Appium bool `json:"appium,omitempty"`
+	// Locale is the locale the device boots with, such as fr-FR.
+	Locale string `json:"locale,omitempty"`
 }
 
+// vncPort is the port of the web VNC client inside the emulator container.
+const vncPort = 6080
+
 var DevicesPortMap = map[string]string{}
 
 var TokenMaker token.Maker
 
+var ADBClient *adb.Client
+
+var Artifacts *artifacts.Store
+
 func main() {
 
 	config, err := config.InitConfig()
@@ -41,11 +69,80 @@ func main() {
 		log.Fatalf("failed to init token maker: %v", err)
 	}
 
+	ADBClient = adb.NewClient(config.ADB)
+
+	Artifacts, err = artifacts.NewStore(config.Artifacts)
+	if err != nil {
+		log.Fatalf("failed to init artifact store: %v", err)
+	}
+
+	go runRetention()
+
+	ShellPolicy = shell.NewPolicy(config.Shell)
+	ShellAudit, err = shell.NewAuditLog(config.Shell.AuditPath)
+	if err != nil {
+		log.Fatalf("failed to init exec audit log: %v", err)
+	}
+
+	FilePolicy = files.NewPolicy(config.Files)
+	NetworkPresets = network.NewPresets(config.Network)
+	SnapshotPolicy = snapshots.NewPolicy(config.Snapshots)
+	VolumePolicy = volumes.NewPolicy(config.Volumes)
+	AppiumPolicy = appium.NewPolicy(config.Appium)
+	ShardPolicy = sharding.NewPolicy(config.Sharding)
+
 	r := mux.NewRouter()
 
 	r.HandleFunc("/run-emulator", RunEmulator)
 	r.HandleFunc("/stop-emulator", StopEmulator)
 	r.HandleFunc("/device-status", DeviceStatus)
+	r.HandleFunc("/power", PowerDevice)
+	r.HandleFunc("/install-app", InstallApp)
+	r.HandleFunc("/run-tests", RunTests)
+	r.HandleFunc("/run-sharded-tests", RunShardedTests)
+	r.HandleFunc("/test-runs", HandleTestRuns)
+	r.HandleFunc("/monkey", RunMonkey)
+	r.HandleFunc("/monkey-runs", HandleMonkeyRuns)
+	r.HandleFunc("/benchmark", RunBenchmark)
+	r.HandleFunc("/benchmarks", HandleBenchmarks)
+	r.HandleFunc("/benchmark-history", BenchmarkHistory)
+	r.HandleFunc("/screenshot", TakeScreenshot)
+	r.HandleFunc("/start-recording", StartRecording)
+	r.HandleFunc("/stop-recording", StopRecording)
+	r.HandleFunc("/exec", Exec)
+	r.HandleFunc("/input", Input)
+	r.HandleFunc("/push-file", internalFiles(PushFile))
+	r.HandleFunc("/pull-file", internalFiles(PullFile))
+	r.HandleFunc("/list-files", internalFiles(ListFiles))
+	r.HandleFunc("/location", HandleLocation)
+	r.HandleFunc("/route", ReplayRoute)
+	r.HandleFunc("/route-control", ControlRoute)
+	r.HandleFunc("/network", SetNetwork)
+	r.HandleFunc("/sensors", HandleSensors)
+	r.HandleFunc("/fingerprint", TouchFingerprint)
+	r.HandleFunc("/sms", SendSMS)
+	r.HandleFunc("/call", Call)
+	r.HandleFunc("/snapshots", HandleSnapshots)
+	r.HandleFunc("/restore-snapshot", RestoreSnapshot)
+	r.HandleFunc("/volumes", HandleVolumes)
+	r.HandleFunc("/reset-volume", ResetVolume)
+	r.HandleFunc("/export-device", ExportDevice)
+	r.HandleFunc("/device-archive", DeviceArchive)
+	r.HandleFunc("/import-device", ImportDevice)
+	r.HandleFunc("/artifacts", HandleArtifacts)
+	r.HandleFunc(artifacts.DownloadPath, DownloadArtifact)
+
+	r.HandleFunc("/adb-tunnel", HandleAdbTunnel)
+	r.HandleFunc("/adb-tunnels", ListAdbTunnels)
+	r.HandleFunc("/adb-tunnels/{id}", RevokeAdbTunnel)
+	r.HandleFunc("/logs", StreamLogs)
+	r.HandleFunc("/exec-stream", ExecStream)
+	r.HandleFunc("/exec-audit", ExecAudit)
+	r.HandleFunc("/files/push", userFiles(PushFile))
+	r.HandleFunc("/files/pull", userFiles(PullFile))
+	r.HandleFunc("/files/list", userFiles(ListFiles))
+	r.HandleFunc("/files/transfers", userFiles(ListTransfers))
+	r.PathPrefix("/devices/{id}/wd/hub").HandlerFunc(HandleWebDriver)
 
 	//TODO: maybe ned to run this in another port
 	r.PathPrefix("/").Handler(HandleProxy())
@@ -106,22 +203,73 @@ func RunEmulator(w http.ResponseWriter, r *http.Request) {
 
 	log.Println("got android config: ", android)
 
-	portStr := fmt.Sprintf("%d", android.Port)
-	servicePort := fmt.Sprintf("%d", 6080)
-	cmd := exec.Command("sudo", "docker", "run", "-d", "-p", portStr+":"+servicePort, "-e", "EMULATOR_DEVICE="+android.DeviceName, "-e", "WEB_VNC=true", "--device", "/dev/kvm", "--name", android.ContainerName, "docker.arvancloud.ir/budtmo/docker-android:"+android.AndroidAPI)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
+	err := startEmulator(r.Context(), android)
+	if errors.Is(err, volumes.ErrInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if errors.Is(err, volumes.ErrInvalidName) || errors.Is(err, volumes.ErrInvalidAPI) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	DevicesPortMap[android.ContainerName] = portStr
-
 	fmt.Fprintf(w, "Emulator started successfully")
 }
 
+// startEmulator runs the container of a device, with its data volume when
+// it has one.
+func startEmulator(ctx context.Context, android AndroidConfig) error {
+	portStr := fmt.Sprintf("%d", android.Port)
+	servicePort := strconv.Itoa(vncPort)
+	args := []string{"docker", "run", "-d", "-e", "EMULATOR_DEVICE=" + android.DeviceName, "-e", "WEB_VNC=true", "--device", "/dev/kvm", "--name", android.ContainerName}
+	// A device without a port, as the ones of sharded test runs, is only
+	// reached over adb.
+	if android.Port != 0 {
+		args = append(args, "-p", portStr+":"+servicePort)
+	}
+	var emulatorArgs []string
+	if android.Volume != "" {
+		// The volume is checked to be free and mounted at once.
+		unlock := lockVolume(volumes.DockerName(android.Owner, android.AndroidAPI, android.Volume))
+		defer unlock()
+
+		volumeArgs, err := prepareVolume(ctx, android.ContainerName, android.Owner, android.AndroidAPI, android.Volume)
+		if err != nil {
+			log.Printf("Error preparing volume %s of %s: %s", android.Volume, android.ContainerName, err)
+			return err
+		}
+		args = append(args, volumeArgs...)
+		emulatorArgs = append(emulatorArgs, VolumePolicy.EmulatorArgs())
+	}
+	if android.Locale != "" {
+		emulatorArgs = append(emulatorArgs, "-change-locale "+android.Locale)
+	}
+	if len(emulatorArgs) > 0 {
+		args = append(args, "-e", "EMULATOR_ADDITIONAL_ARGS="+strings.Join(emulatorArgs, " "))
+	}
+	if android.Appium {
+		args = append(args, AppiumPolicy.DockerArgs()...)
+	}
+	args = append(args, "docker.arvancloud.ir/budtmo/docker-android:"+android.AndroidAPI)
+
+	cmd := exec.Command("sudo", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	if android.Port != 0 {
+		DevicesPortMap[android.ContainerName] = portStr
+	}
+	if android.Appium {
+		Appium.Enable(android.ContainerName, android.DeviceName)
+	}
+	return nil
+}
+
 func StopEmulator(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -162,6 +310,14 @@ func StopEmulator(w http.ResponseWriter, r *http.Request) {
 	}(android.ContainerName)
 
 	delete(DevicesPortMap, android.ContainerName)
+	Tunnels.RevokeDevice(android.ContainerName)
+	Recordings.Discard(android.ContainerName)
+	Locations.Discard(android.ContainerName)
+	Power.Discard(android.ContainerName)
+	Appium.Remove(android.ContainerName)
+	TestRuns.Abort(android.ContainerName)
+	MonkeyRuns.Abort(android.ContainerName)
+	Benchmarks.Abort(android.ContainerName)
 
 	// Immediately respond to the request
 	fmt.Fprintf(w, "Emulator stop and delete initiated successfully")
@@ -179,6 +335,12 @@ func DeviceStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The emulator is restarting, its own status is stale or unavailable.
+	if status, ok := Power.Status(containerName); ok {
+		fmt.Fprintf(w, "Device Status: %s", status)
+		return
+	}
+
 	cmd := exec.Command("sudo", "docker", "exec", "-i", containerName, "cat", "device_status")
 	// Removing the '-t' option because it's not suitable for non-interactive sessions like this
 	var out bytes.Buffer
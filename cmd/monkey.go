@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/monkey"
+)
+
+const (
+	monkeyRunTimeout   = 2 * time.Hour
+	monkeyRunRetention = 24 * time.Hour
+	// maxMonkeyOutput bounds the output stored of a run, a long verbose
+	// run logs every event.
+	maxMonkeyOutput = 32 << 20
+	// maxANRTraces bounds the thread dumps stored of a run ignoring errors.
+	maxANRTraces = 5
+)
+
+// monkeyRun runs the monkey on a device until it finished.
+type monkeyRun struct {
+	mu     sync.Mutex
+	run    agent.MonkeyRun
+	cancel context.CancelFunc
+}
+
+func (m *monkeyRun) snapshot() agent.MonkeyRun {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.run
+}
+
+func (m *monkeyRun) running() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.run.FinishedAt == nil
+}
+
+// finish records the outcome of the run, from its result unless it failed
+// with err.
+func (m *monkeyRun) finish(result *monkey.Result, saved []artifacts.Artifact, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.run.FinishedAt = &now
+	m.run.Result = result
+	m.run.Artifacts = saved
+	switch {
+	case err != nil:
+		m.run.Status = agent.MonkeyError
+		m.run.Error = err.Error()
+	case len(result.Issues) > 0:
+		m.run.Status = agent.MonkeyFailed
+	case !result.Completed:
+		m.run.Status = agent.MonkeyError
+		m.run.Error = result.Message
+	default:
+		m.run.Status = agent.MonkeyPassed
+	}
+}
+
+// MonkeyRunRegistry tracks the monkey runs, one at a time on a device.
+type MonkeyRunRegistry struct {
+	mu   sync.Mutex
+	runs map[string]*monkeyRun
+}
+
+var MonkeyRuns = &MonkeyRunRegistry{runs: map[string]*monkeyRun{}}
+
+// start registers a run unless one is running on its device.
+func (reg *MonkeyRunRegistry) start(m *monkeyRun) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, current := range reg.runs {
+		if current.run.DeviceID == m.run.DeviceID && current.running() {
+			return false
+		}
+	}
+	reg.runs[m.run.ID] = m
+	return true
+}
+
+// get returns a run of the owner.
+func (reg *MonkeyRunRegistry) get(owner string, id string) (agent.MonkeyRun, bool) {
+	reg.mu.Lock()
+	m, ok := reg.runs[id]
+	reg.mu.Unlock()
+	if !ok {
+		return agent.MonkeyRun{}, false
+	}
+
+	run := m.snapshot()
+	return run, run.Owner == owner
+}
+
+// list returns the runs of the owner, newest first, and forgets the runs
+// finished longer than the retention ago.
+func (reg *MonkeyRunRegistry) list(owner string) []agent.MonkeyRun {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	runs := []agent.MonkeyRun{}
+	for id, m := range reg.runs {
+		run := m.snapshot()
+		if run.FinishedAt != nil && time.Since(*run.FinishedAt) > monkeyRunRetention {
+			delete(reg.runs, id)
+			continue
+		}
+		if run.Owner == owner {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.After(runs[j].StartedAt) })
+	return runs
+}
+
+// Abort cancels the runs on the device, used when the device goes away or
+// restarts.
+func (reg *MonkeyRunRegistry) Abort(deviceID string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, m := range reg.runs {
+		if m.run.DeviceID == deviceID && m.running() {
+			m.cancel()
+		}
+	}
+}
+
+// RunMonkey runs the monkey against a package of a device, with the
+// options of the request or the ones of the run it replays. It answers
+// once the run started; the run is polled through the monkey runs.
+func RunMonkey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	owner := r.URL.Query().Get("owner")
+	if containerName == "" || owner == "" {
+		http.Error(w, "Container name and owner are required", http.StatusBadRequest)
+		return
+	}
+
+	var req agent.MonkeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := req.Options
+	if req.ReplayOf != "" {
+		replayed, ok := MonkeyRuns.get(owner, req.ReplayOf)
+		if !ok {
+			http.Error(w, "Monkey run to replay not found", http.StatusNotFound)
+			return
+		}
+		opts = replayed.Options
+	}
+	if opts.Seed == 0 {
+		opts.Seed = rand.Int63()
+	}
+	if err := opts.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), monkeyRunTimeout)
+	m := &monkeyRun{
+		run: agent.MonkeyRun{
+			ID:        newID(),
+			DeviceID:  containerName,
+			Owner:     owner,
+			Status:    agent.MonkeyRunning,
+			Options:   opts,
+			ReplayOf:  req.ReplayOf,
+			Command:   monkey.Command(opts),
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+	if !MonkeyRuns.start(m) {
+		cancel()
+		http.Error(w, "A monkey run is already running on this device", http.StatusConflict)
+		return
+	}
+
+	go func() {
+		defer cancel()
+
+		result, saved, err := runMonkey(ctx, m.snapshot())
+		m.finish(result, saved, err)
+		run := m.snapshot()
+		if err != nil {
+			log.Printf("Error running monkey %s on %s: %s", run.ID, containerName, err)
+			return
+		}
+		log.Printf("Monkey run %s on %s finished: %s", run.ID, containerName, run.Status)
+	}()
+
+	log.Printf("Started monkey run %s against %s on %s with seed %d", m.run.ID, opts.Package, containerName, opts.Seed)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(m.snapshot())
+}
+
+// HandleMonkeyRuns returns a monkey run of an owner, or all of them.
+func HandleMonkeyRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, id := r.URL.Query().Get("owner"), r.URL.Query().Get("id")
+	if owner == "" {
+		http.Error(w, "Owner is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if id == "" {
+		json.NewEncoder(w).Encode(MonkeyRuns.list(owner))
+		return
+	}
+
+	run, ok := MonkeyRuns.get(owner, id)
+	if !ok {
+		http.Error(w, "Monkey run not found", http.StatusNotFound)
+		return
+	}
+	for i := range run.Artifacts {
+		run.Artifacts[i].URL, _ = Artifacts.DownloadURL(r.Context(), run.Artifacts[i])
+	}
+	json.NewEncoder(w).Encode(run)
+}
+
+// runMonkey runs the monkey from a fresh start of the package and stores
+// its result, output and logcat.
+func runMonkey(ctx context.Context, run agent.MonkeyRun) (*monkey.Result, []artifacts.Artifact, error) {
+	device, err := deviceADB(ctx, run.DeviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The same seed only replays the same events from the same state.
+	if _, err := device.RunShell(ctx, "am force-stop "+run.Options.Package); err != nil {
+		return nil, nil, err
+	}
+	if run.Options.ClearData {
+		out, err := device.RunShell(ctx, "pm clear "+run.Options.Package)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !strings.HasPrefix(strings.TrimSpace(out), "Success") {
+			return nil, nil, fmt.Errorf("clearing the data of %s failed: %s", run.Options.Package, strings.TrimSpace(out))
+		}
+	}
+	if _, err := device.RunShell(ctx, "logcat -c"); err != nil {
+		log.Printf("Error clearing logcat of %s: %s", run.DeviceID, err)
+	}
+
+	output, err := os.CreateTemp("", "monkey-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+
+	result, saved, err := readMonkey(ctx, device, run, output)
+	if err != nil {
+		return nil, saved, err
+	}
+
+	if _, err := output.Seek(0, io.SeekStart); err != nil {
+		return nil, saved, err
+	}
+	if a, err := saveMonkeyOutput(ctx, run, output); err != nil {
+		log.Printf("Error storing output of monkey run %s: %s", run.ID, err)
+	} else {
+		saved = append(saved, a)
+	}
+	if a, err := saveTestLogcat(ctx, device, run.Owner, run.DeviceID, run.ID, "logcat-"+run.ID+".txt"); err != nil {
+		log.Printf("Error storing logcat of monkey run %s: %s", run.ID, err)
+	} else {
+		saved = append(saved, a)
+	}
+	if a, err := saveMonkeyReport(ctx, run, result); err != nil {
+		log.Printf("Error storing report of monkey run %s: %s", run.ID, err)
+	} else {
+		saved = append([]artifacts.Artifact{a}, saved...)
+	}
+
+	return &result, saved, nil
+}
+
+// readMonkey runs the monkey and parses its output as it comes, copying it
+// to output and storing the thread dumps of the ANRs.
+func readMonkey(ctx context.Context, device *adb.Device, run agent.MonkeyRun, output io.Writer) (monkey.Result, []artifacts.Artifact, error) {
+	startedAt := time.Now()
+	stream, err := device.Shell(ctx, run.Command)
+	if err != nil {
+		return monkey.Result{}, nil, err
+	}
+	defer stream.Close()
+
+	parser := monkey.NewParser(startedAt)
+	var traces []artifacts.Artifact
+	written := 0
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64<<10), maxInstrumentLineSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if written < maxMonkeyOutput {
+			n, _ := fmt.Fprintln(output, line)
+			written += n
+		}
+
+		issue, ended := parser.Line(line)
+		if !ended || issue.Type != monkey.IssueANR || len(traces) >= maxANRTraces {
+			continue
+		}
+		a, err := saveANRTraces(ctx, device, run, len(traces))
+		if err != nil {
+			log.Printf("Error storing the ANR traces of %s on %s: %s", issue.Package, run.DeviceID, err)
+			continue
+		}
+		parser.SetTraces(parser.Issues()-1, a.ID)
+		traces = append(traces, a)
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return monkey.Result{}, traces, err
+	}
+	if ctx.Err() != nil {
+		return monkey.Result{}, traces, ctx.Err()
+	}
+
+	return parser.Result(), traces, nil
+}
+
+// saveANRTraces stores the latest thread dumps of the device, written by
+// the system when the ANR was reported.
+func saveANRTraces(ctx context.Context, device *adb.Device, run agent.MonkeyRun, index int) (artifacts.Artifact, error) {
+	out, err := device.RunShell(ctx, "cat $(ls -t /data/anr/* | head -n 1)")
+	if err != nil {
+		return artifacts.Artifact{}, err
+	}
+	if strings.TrimSpace(out) == "" {
+		return artifacts.Artifact{}, errors.New("no thread dumps found")
+	}
+
+	return Artifacts.Save(ctx, artifacts.Artifact{
+		Owner:       run.Owner,
+		DeviceID:    run.DeviceID,
+		Session:     run.ID,
+		Kind:        artifacts.KindLog,
+		Name:        fmt.Sprintf("anr-%s-%d.txt", run.ID, index),
+		ContentType: "text/plain",
+	}, strings.NewReader(out))
+}
+
+func saveMonkeyOutput(ctx context.Context, run agent.MonkeyRun, output io.Reader) (artifacts.Artifact, error) {
+	return Artifacts.Save(ctx, artifacts.Artifact{
+		Owner:       run.Owner,
+		DeviceID:    run.DeviceID,
+		Session:     run.ID,
+		Kind:        artifacts.KindLog,
+		Name:        "monkey-" + run.ID + ".txt",
+		ContentType: "text/plain",
+	}, output)
+}
+
+// saveMonkeyReport stores the result of the run with the options that
+// replay it.
+func saveMonkeyReport(ctx context.Context, run agent.MonkeyRun, result monkey.Result) (artifacts.Artifact, error) {
+	report, err := json.MarshalIndent(struct {
+		Options monkey.Options `json:"options"`
+		Command string         `json:"command"`
+		Result  monkey.Result  `json:"result"`
+	}{run.Options, run.Command, result}, "", "  ")
+	if err != nil {
+		return artifacts.Artifact{}, err
+	}
+
+	return Artifacts.Save(ctx, artifacts.Artifact{
+		Owner:       run.Owner,
+		DeviceID:    run.DeviceID,
+		Session:     run.ID,
+		Kind:        artifacts.KindMonkeyReport,
+		Name:        "monkey-" + run.ID + ".json",
+		ContentType: "application/json",
+		Metadata: map[string]string{
+			"package": run.Options.Package,
+			"seed":    strconv.FormatInt(run.Options.Seed, 10),
+			"crashes": strconv.Itoa(result.Crashes),
+			"anrs":    strconv.Itoa(result.ANRs),
+		},
+	}, bytes.NewReader(report))
+}
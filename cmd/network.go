@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/emuconsole"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/network"
+)
+
+const maxNetworkRequestSize = 64 << 10
+
+var NetworkPresets *network.Presets
+
+// SetNetwork applies a network profile to a device: airplane mode through
+// adb, speed and delay through the emulator console and packet loss with
+// tc in the network namespace of the container. The loss spares the ports
+// of the services of the container, such as adb, the console and VNC.
+func SetNetwork(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	var req network.Request
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxNetworkRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	profile, err := NetworkPresets.Resolve(req)
+	if errors.Is(err, network.ErrUnknownPreset) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	// The loss goes first, so that a loss left by an earlier profile is
+	// lifted before the device is reached.
+	if err := applyLoss(ctx, containerName, profile); err != nil {
+		log.Printf("Error setting packet loss of %s: %s", containerName, err)
+		http.Error(w, "Failed to set packet loss", http.StatusBadGateway)
+		return
+	}
+
+	device, err := deviceADB(ctx, containerName)
+	if err != nil {
+		log.Printf("Error connecting to %s: %s", containerName, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+	if _, err := device.RunShell(ctx, profile.AirplaneModeCommand()); err != nil {
+		log.Printf("Error switching airplane mode of %s: %s", containerName, err)
+		http.Error(w, "Failed to switch airplane mode", http.StatusBadGateway)
+		return
+	}
+
+	if err := applyConsoleNetwork(ctx, containerName, profile); err != nil {
+		var cmdErr *emuconsole.CommandError
+		if errors.As(err, &cmdErr) {
+			http.Error(w, cmdErr.Message, http.StatusUnprocessableEntity)
+			return
+		}
+		log.Printf("Error setting network of %s: %s", containerName, err)
+		http.Error(w, "Failed to set network speed and delay", http.StatusBadGateway)
+		return
+	}
+
+	log.Printf("Applied network profile %s to %s", profile.Name, containerName)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+func applyConsoleNetwork(ctx context.Context, containerName string, profile network.Profile) error {
+	console, err := dialConsole(ctx, containerName)
+	if err != nil {
+		return err
+	}
+	defer console.Close()
+
+	if _, err := console.Command(ctx, profile.SpeedCommand()); err != nil {
+		return err
+	}
+	_, err = console.Command(ctx, profile.DelayCommand())
+	return err
+}
+
+// applyLoss runs tc in the network namespace of the container, which has
+// no permission to change its own queueing. The loss of the earlier profile
+// is removed before the one of the profile is added.
+func applyLoss(ctx context.Context, containerName string, profile network.Profile) error {
+	out, err := exec.CommandContext(ctx, "sudo", "docker", "inspect", "-f", "{{.State.Pid}}", containerName).Output()
+	if err != nil {
+		return err
+	}
+	pid := strings.TrimSpace(string(out))
+
+	iface := NetworkPresets.Interface()
+	if err := runTC(ctx, pid, network.ClearLossArgs(iface)); err != nil {
+		// Removing the loss of a container that has none is fine.
+		if !strings.Contains(err.Error(), "No such file or directory") && !strings.Contains(err.Error(), "handle of zero") {
+			return err
+		}
+	}
+
+	exempt := []int{adbPort, emuconsole.DefaultPort, vncPort}
+	if AppiumPolicy != nil {
+		exempt = append(exempt, AppiumPolicy.Port())
+	}
+	for _, args := range profile.LossCommands(iface, exempt) {
+		if err := runTC(ctx, pid, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTC runs tc with args in the network namespace of the process pid.
+func runTC(ctx context.Context, pid string, args []string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sudo", append([]string{"nsenter", "-t", pid, "-n", "tc"}, args...)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/volumes"
+)
+
+const (
+	// powerTimeout bounds an operation up to the device having booted again.
+	powerTimeout = 10 * time.Minute
+	// rebootDownTimeout bounds the wait for a rebooting device to go down.
+	rebootDownTimeout = time.Minute
+	bootPollInterval  = 2 * time.Second
+)
+
+// powerOp runs a power operation on a device until it booted again.
+type powerOp struct {
+	mu sync.Mutex
+	op agent.PowerOperation
+}
+
+func (p *powerOp) snapshot() agent.PowerOperation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.op
+}
+
+func (p *powerOp) running() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.op.FinishedAt == nil
+}
+
+func (p *powerOp) finish(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.op.FinishedAt = &now
+	if err != nil {
+		p.op.Status = agent.StatusPowerFailed
+		p.op.Error = err.Error()
+	} else {
+		p.op.Status = "READY"
+	}
+}
+
+// PowerRegistry tracks the last power operation of each device, one at a
+// time.
+type PowerRegistry struct {
+	mu  sync.Mutex
+	ops map[string]*powerOp
+}
+
+var Power = &PowerRegistry{ops: map[string]*powerOp{}}
+
+// start registers an operation for the device unless one is running.
+func (reg *PowerRegistry) start(p *powerOp) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if current, ok := reg.ops[p.op.DeviceID]; ok && current.running() {
+		return false
+	}
+	reg.ops[p.op.DeviceID] = p
+	return true
+}
+
+// Status returns the status the device has because of a power operation:
+// the operation while it runs or its failure, and false otherwise.
+func (reg *PowerRegistry) Status(deviceID string) (string, bool) {
+	reg.mu.Lock()
+	p, ok := reg.ops[deviceID]
+	reg.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	op := p.snapshot()
+	switch {
+	case op.FinishedAt == nil:
+		return op.Status, true
+	case op.Error != "":
+		return op.Status + ": " + op.Error, true
+	}
+	return "", false
+}
+
+// Discard forgets the operations of the device, used when the device goes
+// away. A running operation ends on its timeout.
+func (reg *PowerRegistry) Discard(deviceID string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.ops, deviceID)
+}
+
+// PowerDevice reboots, cold boots or wipes the data of a device in its
+// container, which keeps its name, port and address. It answers once the
+// operation started; the device status reports it until the device booted.
+func PowerDevice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	operation := r.URL.Query().Get("operation")
+	if !slices.Contains(agent.PowerOperations, operation) {
+		http.Error(w, fmt.Sprintf("Operation must be one of %v", agent.PowerOperations), http.StatusBadRequest)
+		return
+	}
+
+	p := &powerOp{op: agent.PowerOperation{
+		Operation: operation,
+		DeviceID:  containerName,
+		Status:    agent.PowerStatuses[operation],
+		StartedAt: time.Now(),
+	}}
+	if !Power.start(p) {
+		http.Error(w, "A power operation is already running on this device", http.StatusConflict)
+		return
+	}
+
+	// The connections to the device do not survive the boot.
+	Tunnels.RevokeDevice(containerName)
+	Recordings.Discard(containerName)
+	Appium.EndSessions(containerName)
+	TestRuns.Abort(containerName)
+	MonkeyRuns.Abort(containerName)
+	Benchmarks.Abort(containerName)
+	if operation != agent.PowerReboot {
+		Locations.Discard(containerName)
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), powerTimeout)
+		defer cancel()
+
+		err := runPower(ctx, containerName, operation)
+		p.finish(err)
+		if err != nil {
+			log.Printf("Error running %s on %s: %s", operation, containerName, err)
+			return
+		}
+		log.Printf("Device %s is ready after %s", containerName, operation)
+	}()
+
+	log.Printf("Started %s on %s", operation, containerName)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(p.snapshot())
+}
+
+func runPower(ctx context.Context, containerName string, operation string) error {
+	if operation == agent.PowerReboot {
+		device, err := deviceADB(ctx, containerName)
+		if err != nil {
+			return err
+		}
+		if err := device.Reboot(ctx); err != nil {
+			return err
+		}
+		if err := waitForBootState(ctx, containerName, false, rebootDownTimeout); err != nil {
+			return fmt.Errorf("device did not go down: %w", err)
+		}
+		return waitForBootState(ctx, containerName, true, 0)
+	}
+
+	// Without the quick boot snapshot the emulator boots from scratch,
+	// without the user data, in the AVD or its volume, it creates a new
+	// data partition.
+	script := fmt.Sprintf("rm -rf %s/default_boot", SnapshotPolicy.Dir())
+	if operation == agent.PowerWipeData {
+		script += fmt.Sprintf(" && rm -f %s/%s* && cd %s && rm -rf userdata-qemu.img* cache.img* sdcard.img.qcow2",
+			VolumePolicy.MountPath(), volumes.DataImage, SnapshotPolicy.AVDDir())
+	}
+	if err := containerShell(ctx, containerName, script, nil); err != nil {
+		return err
+	}
+
+	// Kill the emulator at once so it does not save its state on the way out.
+	if out, err := exec.CommandContext(ctx, "sudo", "docker", "restart", "-t", "0", containerName).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return waitForBootState(ctx, containerName, true, 0)
+}
+
+// waitForBootState polls the device until it has booted, or until it is
+// down when booted is false. A timeout of 0 waits as long as ctx allows.
+func waitForBootState(ctx context.Context, containerName string, booted bool, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(bootPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if bootCompleted(ctx, containerName) == booted {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return errors.New("timed out waiting for the device")
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func bootCompleted(ctx context.Context, containerName string) bool {
+	ctx, cancel := context.WithTimeout(ctx, bootPollInterval)
+	defer cancel()
+
+	device, err := deviceADB(ctx, containerName)
+	if err != nil {
+		return false
+	}
+	out, err := device.RunShell(ctx, "getprop sys.boot_completed")
+	return err == nil && strings.TrimSpace(out) == "1"
+}
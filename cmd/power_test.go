@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+)
+
+func TestPowerRegistry(t *testing.T) {
+	reg := &PowerRegistry{ops: map[string]*powerOp{}}
+	newOp := func(operation string) *powerOp {
+		return &powerOp{op: agent.PowerOperation{
+			Operation: operation,
+			DeviceID:  "alice-Device",
+			Status:    agent.PowerStatuses[operation],
+			StartedAt: time.Now(),
+		}}
+	}
+
+	if _, ok := reg.Status("alice-Device"); ok {
+		t.Fatal("a device without operations has a power status")
+	}
+
+	reboot := newOp(agent.PowerReboot)
+	if !reg.start(reboot) {
+		t.Fatal("start of the first operation refused")
+	}
+	// One operation at a time per device.
+	if reg.start(newOp(agent.PowerWipeData)) {
+		t.Error("start while a reboot runs accepted")
+	}
+	if status, ok := reg.Status("alice-Device"); !ok || status != agent.StatusRebooting {
+		t.Errorf("Status = %q, %t, want %s", status, ok, agent.StatusRebooting)
+	}
+
+	// A device that booted again has its usual status.
+	reboot.finish(nil)
+	if status, ok := reg.Status("alice-Device"); ok {
+		t.Errorf("Status after the reboot = %q, want none", status)
+	}
+
+	wipe := newOp(agent.PowerWipeData)
+	if !reg.start(wipe) {
+		t.Fatal("start after the reboot finished refused")
+	}
+	wipe.finish(errors.New("device did not boot within 10m0s"))
+	want := agent.StatusPowerFailed + ": device did not boot within 10m0s"
+	if status, ok := reg.Status("alice-Device"); !ok || status != want {
+		t.Errorf("Status after a failure = %q, %t, want %q", status, ok, want)
+	}
+	if op := wipe.snapshot(); op.FinishedAt == nil || op.Error == "" {
+		t.Errorf("failed operation = %+v", op)
+	}
+
+	// The failure is reported until the device goes away.
+	reg.Discard("alice-Device")
+	if _, ok := reg.Status("alice-Device"); ok {
+		t.Error("a discarded device has a power status")
+	}
+	if !reg.start(newOp(agent.PowerColdBoot)) {
+		t.Error("start after discard refused")
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+)
+
+const (
+	// screenrecord stops on its own after 3 minutes, longer recordings are
+	// chained from segments of this length.
+	segmentTimeLimit = 180
+	// maxSegments bounds a forgotten recording to an hour.
+	maxSegments = 20
+	// minSegmentDuration tells a segment that screenrecord refused to record
+	// from one that was recorded.
+	minSegmentDuration = time.Second
+	stopTimeout        = 30 * time.Second
+	assembleTimeout    = 10 * time.Minute
+	ffmpegBinary       = "ffmpeg"
+)
+
+// recorder runs screenrecord in a loop until it is stopped.
+type recorder struct {
+	recording agent.Recording
+	session   string
+	device    *adb.Device
+	opts      agent.RecordingOptions
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	segments []string
+	stopping bool
+	err      error
+}
+
+// RecordingRegistry tracks the recording of each device, one at a time.
+type RecordingRegistry struct {
+	mu        sync.Mutex
+	recorders map[string]*recorder
+}
+
+var Recordings = &RecordingRegistry{recorders: map[string]*recorder{}}
+
+// start registers a recorder for the device unless one is already running.
+func (reg *RecordingRegistry) start(rec *recorder) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if _, ok := reg.recorders[rec.recording.DeviceID]; ok {
+		return false
+	}
+	reg.recorders[rec.recording.DeviceID] = rec
+	return true
+}
+
+// take removes and returns the recorder of the device.
+func (reg *RecordingRegistry) take(deviceID string) (*recorder, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	rec, ok := reg.recorders[deviceID]
+	delete(reg.recorders, deviceID)
+	return rec, ok
+}
+
+// Discard stops the recording of the device without keeping the video,
+// used when the device goes away.
+func (reg *RecordingRegistry) Discard(deviceID string) {
+	if rec, ok := reg.take(deviceID); ok {
+		rec.cancel()
+	}
+}
+
+func StartRecording(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	opts, err := agent.ParseRecordingOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Owner == "" {
+		http.Error(w, "Owner is required", http.StatusBadRequest)
+		return
+	}
+
+	device, err := deviceADB(r.Context(), containerName)
+	if err != nil {
+		log.Printf("Error connecting to %s: %s", containerName, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := &recorder{
+		recording: agent.Recording{
+			ID:        newID(),
+			DeviceID:  containerName,
+			Owner:     opts.Owner,
+			StartedAt: time.Now(),
+		},
+		session: opts.Session,
+		device:  device,
+		opts:    opts,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	if !Recordings.start(rec) {
+		cancel()
+		http.Error(w, "A recording is already running on this device", http.StatusConflict)
+		return
+	}
+
+	go rec.run(ctx)
+
+	log.Printf("Recording %s started on %s", rec.recording.ID, containerName)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rec.recording)
+}
+
+func StopRecording(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	rec, ok := Recordings.take(containerName)
+	if !ok {
+		http.Error(w, "No recording is running on this device", http.StatusNotFound)
+		return
+	}
+	defer rec.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), assembleTimeout)
+	defer cancel()
+
+	if err := rec.stop(ctx); err != nil {
+		log.Printf("Error stopping recording %s: %s", rec.recording.ID, err)
+		http.Error(w, "Failed to stop recording", http.StatusBadGateway)
+		return
+	}
+
+	artifact, err := rec.save(ctx)
+	if err == artifacts.ErrQuotaExceeded {
+		http.Error(w, "Artifact quota exceeded", http.StatusInsufficientStorage)
+		return
+	} else if err != nil {
+		log.Printf("Error saving recording %s: %s", rec.recording.ID, err)
+		http.Error(w, "Failed to save recording", http.StatusInternalServerError)
+		return
+	}
+
+	artifact.URL, _ = Artifacts.DownloadURL(ctx, artifact)
+
+	log.Printf("Recording %s of %s stored as artifact %s", rec.recording.ID, containerName, artifact.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(artifact)
+}
+
+// run records segments back to back until the recording is stopped.
+func (rec *recorder) run(ctx context.Context) {
+	defer close(rec.done)
+
+	for n := 0; n < maxSegments; n++ {
+		path := fmt.Sprintf("%s/recording-%s-%03d.mp4", remoteTmpDir, rec.recording.ID, n)
+
+		rec.mu.Lock()
+		if rec.stopping {
+			rec.mu.Unlock()
+			return
+		}
+		rec.segments = append(rec.segments, path)
+		rec.mu.Unlock()
+
+		started := time.Now()
+		output, err := rec.recordSegment(ctx, path)
+
+		rec.mu.Lock()
+		stopping := rec.stopping
+		switch {
+		case stopping || ctx.Err() != nil:
+		case err != nil:
+			rec.err = err
+		case time.Since(started) < minSegmentDuration:
+			rec.err = fmt.Errorf("screenrecord exited: %s", strings.TrimSpace(output))
+		}
+		failed := rec.err != nil
+		rec.mu.Unlock()
+
+		if stopping || failed || ctx.Err() != nil {
+			return
+		}
+	}
+
+	log.Printf("Recording %s reached %d segments, waiting to be stopped", rec.recording.ID, maxSegments)
+}
+
+// recordSegment runs screenrecord once and returns its output.
+func (rec *recorder) recordSegment(ctx context.Context, path string) (string, error) {
+	command := fmt.Sprintf("screenrecord --time-limit %d", segmentTimeLimit)
+	if rec.opts.BitRate > 0 {
+		command += fmt.Sprintf(" --bit-rate %d", rec.opts.BitRate)
+	}
+	if rec.opts.Size != "" {
+		command += " --size " + rec.opts.Size
+	}
+	command += " " + path
+
+	stream, err := rec.device.Shell(ctx, command)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	output, err := io.ReadAll(stream)
+	return string(output), err
+}
+
+// stop interrupts screenrecord so it finalizes the current segment and
+// waits for the loop to end. The interrupt is repeated in case the loop was
+// starting the next segment.
+func (rec *recorder) stop(ctx context.Context) error {
+	rec.mu.Lock()
+	rec.stopping = true
+	rec.mu.Unlock()
+
+	stopCtx, cancel := context.WithTimeout(ctx, stopTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if _, err := rec.device.RunShell(stopCtx, "pkill -INT screenrecord"); err != nil {
+			return err
+		}
+
+		select {
+		case <-rec.done:
+			rec.mu.Lock()
+			defer rec.mu.Unlock()
+			// Segments recorded before a failure are still worth keeping.
+			if rec.err != nil && len(rec.segments) <= 1 {
+				return rec.err
+			}
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// save pulls the segments, joins them and stores the video as an artifact.
+func (rec *recorder) save(ctx context.Context) (artifacts.Artifact, error) {
+	dir, err := os.MkdirTemp("", "recording-")
+	if err != nil {
+		return artifacts.Artifact{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	defer rec.device.RunShell(context.Background(), "rm -f "+strings.Join(rec.segments, " "))
+
+	var local []string
+	for i, remote := range rec.segments {
+		info, err := rec.device.Stat(ctx, remote)
+		if errors.Is(err, adb.ErrNotExist) || (err == nil && info.Size == 0) {
+			// The recording was stopped before the segment had any frame.
+			continue
+		} else if err != nil {
+			return artifacts.Artifact{}, err
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("segment-%03d.mp4", i))
+		if err := pullFile(ctx, rec.device, remote, path); err != nil {
+			return artifacts.Artifact{}, err
+		}
+		local = append(local, path)
+	}
+
+	if len(local) == 0 {
+		return artifacts.Artifact{}, errors.New("recording has no video")
+	}
+
+	video := local[0]
+	if len(local) > 1 {
+		video = filepath.Join(dir, "recording.mp4")
+		if err := concatVideos(ctx, local, video); err != nil {
+			return artifacts.Artifact{}, err
+		}
+	}
+
+	f, err := os.Open(video)
+	if err != nil {
+		return artifacts.Artifact{}, err
+	}
+	defer f.Close()
+
+	return Artifacts.Save(ctx, artifacts.Artifact{
+		Owner:       rec.recording.Owner,
+		DeviceID:    rec.recording.DeviceID,
+		Session:     rec.session,
+		Kind:        artifacts.KindVideo,
+		Name:        fmt.Sprintf("recording-%s.mp4", rec.recording.StartedAt.Format("20060102-150405")),
+		ContentType: "video/mp4",
+	}, f)
+}
+
+func pullFile(ctx context.Context, device *adb.Device, remotePath string, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := device.Pull(ctx, remotePath, f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// concatVideos joins the segments without re-encoding them, they share the
+// codec and size since screenrecord recorded them with the same options.
+func concatVideos(ctx context.Context, segments []string, output string) error {
+	list := filepath.Join(filepath.Dir(output), "segments.txt")
+
+	var b strings.Builder
+	for _, segment := range segments {
+		fmt.Fprintf(&b, "file '%s'\n", segment)
+	}
+	if err := os.WriteFile(list, []byte(b.String()), 0o600); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, ffmpegBinary, "-y", "-loglevel", "error",
+		"-f", "concat", "-safe", "0", "-i", list, "-c", "copy", output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
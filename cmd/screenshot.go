@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/webp"
+)
+
+const (
+	screenshotTimeout  = 30 * time.Second
+	defaultJPEGQuality = 90
+)
+
+func TakeScreenshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	opts, err := agent.ParseScreenshotOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Store && opts.Owner == "" {
+		http.Error(w, "Owner is required to store a screenshot", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), screenshotTimeout)
+	defer cancel()
+
+	img, err := captureScreen(ctx, containerName)
+	if err != nil {
+		log.Printf("Error capturing screen of %s: %s", containerName, err)
+		http.Error(w, "Failed to capture screen", http.StatusBadGateway)
+		return
+	}
+
+	img = resizeScreenshot(img, opts)
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, img, opts); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !opts.Store {
+		w.Header().Set("Content-Type", agent.ContentType(opts.Format))
+		w.Write(buf.Bytes())
+		return
+	}
+
+	artifact, err := Artifacts.Save(ctx, artifacts.Artifact{
+		Owner:       opts.Owner,
+		DeviceID:    containerName,
+		Session:     opts.Session,
+		Kind:        artifacts.KindScreenshot,
+		Name:        fmt.Sprintf("screenshot-%s.%s", time.Now().Format("20060102-150405"), opts.Format),
+		ContentType: agent.ContentType(opts.Format),
+	}, &buf)
+	if err == artifacts.ErrQuotaExceeded {
+		http.Error(w, "Artifact quota exceeded", http.StatusInsufficientStorage)
+		return
+	} else if err != nil {
+		log.Printf("Error storing screenshot of %s: %s", containerName, err)
+		http.Error(w, "Failed to store screenshot", http.StatusInternalServerError)
+		return
+	}
+
+	artifact.URL, _ = Artifacts.DownloadURL(ctx, artifact)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(artifact)
+}
+
+// captureScreen reads the framebuffer of the device as a PNG through screencap.
+func captureScreen(ctx context.Context, containerName string) (image.Image, error) {
+	device, err := deviceADB(ctx, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := device.ExecOut(ctx, "screencap -p")
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	return png.Decode(stream)
+}
+
+func encodeImage(buf *bytes.Buffer, img image.Image, opts agent.ScreenshotOptions) error {
+	switch opts.Format {
+	case agent.FormatJPEG:
+		quality := opts.Quality
+		if quality == 0 {
+			quality = defaultJPEGQuality
+		}
+		return jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	case agent.FormatWebP:
+		return webp.Encode(buf, img)
+	default:
+		return png.Encode(buf, img)
+	}
+}
+
+// resizeScreenshot scales the image down to the requested size.
+func resizeScreenshot(img image.Image, opts agent.ScreenshotOptions) image.Image {
+	bounds := img.Bounds()
+
+	width := bounds.Dx()
+	switch {
+	case opts.Width > 0 && opts.Width < bounds.Dx():
+		width = opts.Width
+	case opts.Width == 0 && opts.Scale > 0 && opts.Scale < 1:
+		width = int(float64(bounds.Dx()) * opts.Scale)
+	}
+
+	if width == bounds.Dx() || width < 1 {
+		return img
+	}
+
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	return scaleDown(img, width, height)
+}
+
+// scaleDown resizes img by averaging the source pixels covered by each
+// destination pixel.
+func scaleDown(img image.Image, width, height int) *image.NRGBA {
+	src := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y*src.Dy()/height
+		y1 := max(y0+1, src.Min.Y+(y+1)*src.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x*src.Dx()/width
+			x1 := max(x0+1, src.Min.X+(x+1)*src.Dx()/width)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBAModel.Convert(img.At(sx, sy)).(color.NRGBA)
+					r += uint32(c.R)
+					g += uint32(c.G)
+					b += uint32(c.B)
+					a += uint32(c.A)
+					n++
+				}
+			}
+
+			dst.SetNRGBA(x, y, color.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: uint8(a / n)})
+		}
+	}
+
+	return dst
+}
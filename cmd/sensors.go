@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/emuconsole"
+)
+
+const maxSensorsRequestSize = 64 << 10
+
+func HandleSensors(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		GetSensors(w, r)
+	case http.MethodPost:
+		SetSensors(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// SetSensors changes the simulated battery and sensors of a device through
+// the emulator console and its orientation through the system settings,
+// then answers with the values the device has.
+func SetSensors(w http.ResponseWriter, r *http.Request) {
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	var req agent.SensorsRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSensorsRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	console, device, err := dialSensors(ctx, containerName)
+	if err != nil {
+		log.Printf("Error connecting to %s: %s", containerName, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+	defer console.Close()
+
+	if err := setSensors(ctx, console, device, req); err != nil {
+		sensorsError(w, containerName, err)
+		return
+	}
+
+	state, err := readSensors(ctx, console, device)
+	if err != nil {
+		sensorsError(w, containerName, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// GetSensors returns the simulated battery, orientation and sensor values
+// of a device.
+func GetSensors(w http.ResponseWriter, r *http.Request) {
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	console, device, err := dialSensors(ctx, containerName)
+	if err != nil {
+		log.Printf("Error connecting to %s: %s", containerName, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+	defer console.Close()
+
+	state, err := readSensors(ctx, console, device)
+	if err != nil {
+		sensorsError(w, containerName, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// TouchFingerprint puts an enrolled finger on the fingerprint sensor and
+// lifts it after the duration of the touch.
+func TouchFingerprint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	var req agent.FingerprintRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSensorsRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Normalize()
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	console, err := dialConsole(ctx, containerName)
+	if err != nil {
+		log.Printf("Error connecting to the console of %s: %s", containerName, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+	defer console.Close()
+
+	if _, err := console.Commandf(ctx, "finger touch %d", req.FingerID); err != nil {
+		sensorsError(w, containerName, err)
+		return
+	}
+
+	select {
+	case <-time.After(time.Duration(req.DurationMs) * time.Millisecond):
+	case <-ctx.Done():
+	}
+
+	// Lift the finger even when the client went away.
+	if _, err := console.Command(context.Background(), "finger remove"); err != nil {
+		sensorsError(w, containerName, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func dialSensors(ctx context.Context, containerName string) (*emuconsole.Client, *adb.Device, error) {
+	device, err := deviceADB(ctx, containerName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	console, err := dialConsole(ctx, containerName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return console, device, nil
+}
+
+func setSensors(ctx context.Context, console *emuconsole.Client, device *adb.Device, req agent.SensorsRequest) error {
+	var commands []string
+
+	if b := req.Battery; b != nil {
+		if b.Charging != nil {
+			ac, status := "off", "discharging"
+			if *b.Charging {
+				ac, status = "on", "charging"
+			}
+			commands = append(commands, "power ac "+ac)
+			if b.Status == "" {
+				commands = append(commands, "power status "+status)
+			}
+		}
+		if b.Status != "" {
+			commands = append(commands, "power status "+b.Status)
+		}
+		if b.Health != "" {
+			commands = append(commands, "power health "+b.Health)
+		}
+		if b.Level != nil {
+			commands = append(commands, fmt.Sprintf("power capacity %d", *b.Level))
+		}
+	}
+
+	for name, values := range req.Sensors {
+		formatted := make([]string, len(values))
+		for i, v := range values {
+			formatted[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		commands = append(commands, fmt.Sprintf("sensor set %s %s", name, strings.Join(formatted, ":")))
+	}
+
+	for _, command := range commands {
+		if _, err := console.Command(ctx, command); err != nil {
+			return err
+		}
+	}
+
+	if req.Orientation == "" {
+		return nil
+	}
+
+	// A fixed orientation needs the rotation by the accelerometer off.
+	command := "settings put system accelerometer_rotation 1"
+	if req.Orientation != agent.OrientationAuto {
+		command = fmt.Sprintf("settings put system accelerometer_rotation 0 && settings put system user_rotation %d",
+			slices.Index(agent.Orientations, req.Orientation))
+	}
+	out, err := device.RunShell(ctx, command)
+	if err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		return fmt.Errorf("failed to set orientation: %s", out)
+	}
+	return nil
+}
+
+func readSensors(ctx context.Context, console *emuconsole.Client, device *adb.Device) (agent.SensorsState, error) {
+	state := agent.SensorsState{Sensors: map[string][]float64{}}
+
+	lines, err := console.Command(ctx, "power display")
+	if err != nil {
+		return state, err
+	}
+	state.Battery = parseBattery(lines)
+
+	names := make([]string, 0, len(agent.Sensors))
+	for name := range agent.Sensors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		lines, err := console.Command(ctx, "sensor get "+name)
+		var cmdErr *emuconsole.CommandError
+		if errors.As(err, &cmdErr) {
+			// The sensor is not available on this device.
+			continue
+		} else if err != nil {
+			return state, err
+		}
+		if values, ok := parseSensor(lines); ok {
+			state.Sensors[name] = values
+		}
+	}
+
+	screen, err := readScreen(ctx, device)
+	if err != nil {
+		return state, err
+	}
+	if screen.orientation >= 0 && screen.orientation < len(agent.Orientations) {
+		state.Orientation = agent.Orientations[screen.orientation]
+	}
+
+	return state, nil
+}
+
+// parseBattery reads the output of power display, such as
+//
+//	AC: online
+//	status: Charging
+//	health: Good
+//	present: true
+//	capacity: 100
+func parseBattery(lines []string) agent.Battery {
+	var battery agent.Battery
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.ToLower(strings.TrimSpace(value))
+
+		switch strings.TrimSpace(key) {
+		case "AC":
+			charging := value == "online"
+			battery.Charging = &charging
+		case "status":
+			battery.Status = strings.ReplaceAll(value, " ", "-")
+		case "health":
+			battery.Health = strings.ReplaceAll(value, " ", "-")
+		case "capacity":
+			if level, err := strconv.Atoi(value); err == nil {
+				battery.Level = &level
+			}
+		}
+	}
+	return battery
+}
+
+// parseSensor reads the output of sensor get, such as
+// "acceleration = 0:9.77622:0.812345".
+func parseSensor(lines []string) ([]float64, bool) {
+	for _, line := range lines {
+		_, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		var values []float64
+		for _, field := range strings.Split(strings.TrimSpace(value), ":") {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, false
+			}
+			values = append(values, v)
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+func sensorsError(w http.ResponseWriter, containerName string, err error) {
+	var cmdErr *emuconsole.CommandError
+	if errors.As(err, &cmdErr) {
+		http.Error(w, cmdErr.Message, http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("Error simulating sensors of %s: %s", containerName, err)
+	http.Error(w, "Failed to simulate sensors", http.StatusBadGateway)
+}
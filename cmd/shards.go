@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/instrument"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/sharding"
+)
+
+// maxDurationReports bounds the earlier reports read to balance the shards
+// of a run by duration.
+const maxDurationReports = 5
+
+var ShardPolicy *sharding.Policy
+
+// shardDevice is a device a sharded run uses.
+type shardDevice struct {
+	index           int
+	id              string
+	device          *adb.Device
+	instrumentation instrument.Instrumentation
+}
+
+// RunShardedTests installs an app and its test APK on several devices and
+// runs the instrumentation tests split into shards, one on each device.
+// The device of the owner is used when containerName is set, the other
+// devices are provisioned for the run and deleted with it. Failed tests are
+// retried on another device and the results are merged into one report.
+func RunShardedTests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	owner := r.URL.Query().Get("owner")
+	if owner == "" {
+		http.Error(w, "Owner is required", http.StatusBadRequest)
+		return
+	}
+
+	opts, err := agent.ParseShardOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Shards > ShardPolicy.MaxShards() {
+		http.Error(w, fmt.Sprintf("At most %d shards are allowed", ShardPolicy.MaxShards()), http.StatusBadRequest)
+		return
+	}
+	if opts.Retries > ShardPolicy.MaxRetries() {
+		http.Error(w, fmt.Sprintf("At most %d retries are allowed", ShardPolicy.MaxRetries()), http.StatusBadRequest)
+		return
+	}
+
+	var devices []string
+	if containerName != "" {
+		devices = append(devices, containerName)
+	}
+	if len(devices) < opts.Shards && (opts.AndroidAPI == "" || opts.DeviceName == "") {
+		http.Error(w, "Android API and device name are required to provision devices", http.StatusBadRequest)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "tests-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	form, err := readTestForm(w, r, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "APKs are too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testRunTimeout)
+	t := &testRun{
+		run: agent.TestRun{
+			ID:          newID(),
+			DeviceID:    containerName,
+			Owner:       owner,
+			Status:      agent.TestRunRunning,
+			AppPackage:  form.app[0].manifest.Package,
+			TestPackage: form.test[0].manifest.Package,
+			Args:        form.args,
+			StartedAt:   time.Now(),
+			Strategy:    opts.Strategy,
+		},
+		cancel: cancel,
+	}
+	for i := 0; i < opts.Shards; i++ {
+		shard := agent.TestShard{Index: i, Status: agent.TestRunRunning}
+		if i < len(devices) {
+			shard.DeviceID = devices[i]
+		} else {
+			shard.DeviceID = fmt.Sprintf("%s-Shard-%s-%d", owner, t.run.ID, i)
+			shard.Provisioned = true
+		}
+		t.run.Shards = append(t.run.Shards, shard)
+	}
+	if !TestRuns.start(t) {
+		cancel()
+		os.RemoveAll(dir)
+		http.Error(w, "A test run is already running on this device or a sharded run is already running", http.StatusConflict)
+		return
+	}
+
+	go func() {
+		defer cancel()
+		defer os.RemoveAll(dir)
+
+		err := runShardedTests(ctx, t, form, opts)
+		t.finish(err)
+		run := t.snapshot()
+		if err != nil {
+			log.Printf("Error running sharded tests %s: %s", run.ID, err)
+			return
+		}
+		log.Printf("Sharded test run %s finished: %s", run.ID, run.Status)
+	}()
+
+	log.Printf("Started sharded test run %s of %s on %d shards", t.run.ID, t.run.TestPackage, opts.Shards)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(t.snapshot())
+}
+
+// runShardedTests provisions the devices of the run, runs the shards and
+// the retries, and stores the merged report.
+func runShardedTests(ctx context.Context, t *testRun, form testForm, opts agent.ShardOptions) error {
+	run := t.snapshot()
+
+	defer func() {
+		for _, shard := range run.Shards {
+			if !shard.Provisioned {
+				continue
+			}
+			if _, err := dockerOutput(context.Background(), "rm", "-f", shard.DeviceID); err != nil {
+				log.Printf("Error deleting shard device %s: %s", shard.DeviceID, err)
+			}
+		}
+	}()
+
+	devices := prepareShards(ctx, t, form, opts)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(devices) == 0 {
+		return errors.New("no device of the run is ready")
+	}
+
+	instrumentation := devices[0].instrumentation
+	t.update(func(run *agent.TestRun) { run.Instrumentation = instrumentation.Name })
+
+	tests, err := listTests(ctx, devices[0].device, instrumentation.Name, form.args)
+	if err != nil {
+		return fmt.Errorf("listing the tests: %w", err)
+	}
+
+	startedAt := time.Now()
+	for _, d := range devices {
+		if _, err := d.device.RunShell(ctx, "logcat -c"); err != nil {
+			log.Printf("Error clearing logcat of %s: %s", d.id, err)
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		attempts = map[string][]instrument.TestResult{}
+		saved    []artifacts.Artifact
+	)
+	record := func(d shardDevice, result instrument.Result, screenshots []artifacts.Artifact) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, test := range result.Tests {
+			test.DeviceID = d.id
+			attempts[test.FullName()] = append(attempts[test.FullName()], test)
+		}
+		saved = append(saved, screenshots...)
+	}
+
+	// The first pass runs a shard on each device.
+	var shardTests [][]string
+	if opts.Strategy == sharding.ByDuration {
+		shardTests = sharding.ByDurations(tests, testDurations(ctx, run.Owner, run.TestPackage), len(devices))
+	}
+	var wg sync.WaitGroup
+	for i, d := range devices {
+		args := maps.Clone(form.args)
+		if args == nil {
+			args = map[string]string{}
+		}
+		var selected []string
+		if shardTests != nil {
+			selected = shardTests[i]
+		} else {
+			args["numShards"] = fmt.Sprint(len(devices))
+			args["shardIndex"] = fmt.Sprint(i)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, screenshots, err := runShard(ctx, d, run, instrumentation.Name, args, selected, shardTests != nil)
+			record(d, result, screenshots)
+			t.update(func(run *agent.TestRun) {
+				shard := &run.Shards[d.index]
+				shard.Tests = len(result.Tests)
+				shard.Summary = &result.Summary
+				switch {
+				case err != nil:
+					shard.Status, shard.Error = agent.TestRunError, err.Error()
+				case !result.Completed:
+					shard.Status, shard.Error = agent.TestRunError, result.Message
+				case result.Summary.Failed > 0 || result.Summary.Errors > 0:
+					shard.Status = agent.TestRunFailed
+				default:
+					shard.Status = agent.TestRunPassed
+				}
+			})
+			if err != nil {
+				log.Printf("Error running shard %d of test run %s on %s: %s", d.index, run.ID, d.id, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// Each retry runs the tests that failed, or did not run, on another
+	// device than their last attempt, there is none with a single device.
+	retries := opts.Retries
+	if retries > 0 && len(devices) < 2 {
+		retries = 0
+		t.update(func(run *agent.TestRun) {
+			run.Warnings = append(run.Warnings, "Failed tests were not retried, a single device of the run is ready")
+		})
+	}
+	for retry := 0; retry < retries; retry++ {
+		groups := retryGroups(tests, attempts, devices)
+		if len(groups) == 0 {
+			break
+		}
+
+		for i, selected := range groups {
+			d := devices[i]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result, screenshots, err := runShard(ctx, d, run, instrumentation.Name, form.args, selected, true)
+				if err != nil {
+					log.Printf("Error retrying tests of test run %s on %s: %s", run.ID, d.id, err)
+				}
+				record(d, result, screenshots)
+			}()
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	result := sharding.Merge(tests, attempts, time.Since(startedAt))
+
+	for _, d := range devices {
+		name := fmt.Sprintf("logcat-%s-%d.txt", run.ID, d.index)
+		if a, err := saveTestLogcat(ctx, d.device, run.Owner, d.id, run.ID, name); err != nil {
+			log.Printf("Error storing logcat of test run %s on %s: %s", run.ID, d.id, err)
+		} else {
+			saved = append(saved, a)
+		}
+	}
+	report, err := saveJUnit(ctx, run.Owner, devices[0].id, run.ID, run.TestPackage, run.StartedAt, result)
+	if err != nil {
+		log.Printf("Error storing report of test run %s: %s", run.ID, err)
+	} else {
+		saved = append([]artifacts.Artifact{report}, saved...)
+	}
+
+	t.update(func(run *agent.TestRun) {
+		run.Result = &result
+		run.Artifacts = saved
+	})
+	return nil
+}
+
+// prepareShards provisions the devices of the run, waits for them to boot
+// and installs the APKs on all of them. It returns the devices that are
+// ready, the shards of the others are marked as errors.
+func prepareShards(ctx context.Context, t *testRun, form testForm, opts agent.ShardOptions) []shardDevice {
+	run := t.snapshot()
+
+	ready := make([]*shardDevice, len(run.Shards))
+	var wg sync.WaitGroup
+	for i, shard := range run.Shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, err := prepareShard(ctx, shard, form, opts)
+			if err != nil {
+				log.Printf("Error preparing shard %d of test run %s on %s: %s", i, run.ID, shard.DeviceID, err)
+				t.update(func(run *agent.TestRun) {
+					run.Shards[i].Status, run.Shards[i].Error = agent.TestRunError, err.Error()
+				})
+				return
+			}
+			ready[i] = d
+		}()
+	}
+	wg.Wait()
+
+	var devices []shardDevice
+	for _, d := range ready {
+		if d != nil {
+			devices = append(devices, *d)
+		}
+	}
+	return devices
+}
+
+func prepareShard(ctx context.Context, shard agent.TestShard, form testForm, opts agent.ShardOptions) (*shardDevice, error) {
+	if shard.Provisioned {
+		err := startEmulator(ctx, AndroidConfig{
+			ContainerName: shard.DeviceID,
+			DeviceName:    opts.DeviceName,
+			AndroidAPI:    opts.AndroidAPI,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("starting the device: %w", err)
+		}
+		if err := waitForBootState(ctx, shard.DeviceID, true, ShardPolicy.BootTimeout()); err != nil {
+			return nil, fmt.Errorf("booting the device: %w", err)
+		}
+	}
+
+	device, err := deviceADB(ctx, shard.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	instrumentation, err := prepareTests(ctx, device, form.app, form.test, form.runner)
+	if err != nil {
+		return nil, err
+	}
+	return &shardDevice{index: shard.Index, id: shard.DeviceID, device: device, instrumentation: instrumentation}, nil
+}
+
+// listTests returns the tests the runner would run with args, without
+// running them.
+func listTests(ctx context.Context, device *adb.Device, instrumentation string, args map[string]string) ([]string, error) {
+	args = maps.Clone(args)
+	if args == nil {
+		args = map[string]string{}
+	}
+	args["log"] = "true"
+
+	command, err := instrument.Command(instrumentation, args)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := device.Shell(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	parser := instrument.NewParser(time.Now())
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64<<10), maxInstrumentLineSize)
+	for scanner.Scan() {
+		parser.Line(scanner.Text(), time.Now())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	result := parser.Result()
+	if !result.Completed {
+		return nil, errors.New(result.Message)
+	}
+
+	tests := make([]string, 0, len(result.Tests))
+	for _, test := range result.Tests {
+		tests = append(tests, test.FullName())
+	}
+	return tests, nil
+}
+
+// runShard runs tests on a device, the selected ones when byFile is set
+// and the ones args select otherwise.
+func runShard(ctx context.Context, d shardDevice, run agent.TestRun, instrumentation string, args map[string]string, selected []string, byFile bool) (instrument.Result, []artifacts.Artifact, error) {
+	if byFile {
+		if len(selected) == 0 {
+			return instrument.Result{Tests: []instrument.TestResult{}, Completed: true}, nil, nil
+		}
+
+		path := fmt.Sprintf("%s/shard-%s-%d.txt", remoteTmpDir, run.ID, d.index)
+		content := strings.Join(selected, "\n") + "\n"
+		if err := d.device.Push(ctx, strings.NewReader(content), path, 0644, time.Now()); err != nil {
+			return instrument.Result{}, nil, err
+		}
+		defer d.device.RunShell(context.Background(), "rm -f "+path)
+
+		args = maps.Clone(args)
+		if args == nil {
+			args = map[string]string{}
+		}
+		args["testFile"] = path
+	}
+
+	command, err := instrument.Command(instrumentation, args)
+	if err != nil {
+		return instrument.Result{}, nil, err
+	}
+	return runInstrumentation(ctx, d.device, d.id, run.Owner, run.ID, command)
+}
+
+// retryGroups returns the tests to retry on each device: the tests whose
+// last attempt failed, or which did not run, each on the device after the
+// one of its last attempt. There are none with less than two devices.
+func retryGroups(tests []string, attempts map[string][]instrument.TestResult, devices []shardDevice) map[int][]string {
+	groups := map[int][]string{}
+	if len(devices) < 2 {
+		return groups
+	}
+	next := 0
+	for _, name := range tests {
+		tries := attempts[name]
+		if len(tries) == 0 {
+			groups[next%len(devices)] = append(groups[next%len(devices)], name)
+			next++
+			continue
+		}
+
+		last := tries[len(tries)-1]
+		if last.Status != instrument.StatusFailed && last.Status != instrument.StatusError {
+			continue
+		}
+		for i, d := range devices {
+			if d.id == last.DeviceID {
+				groups[(i+1)%len(devices)] = append(groups[(i+1)%len(devices)], name)
+				break
+			}
+		}
+	}
+	return groups
+}
+
+// testDurations returns the durations of the tests of a test package in the
+// latest reports of the owner.
+func testDurations(ctx context.Context, owner string, testPackage string) map[string]int64 {
+	list, err := Artifacts.List(ctx, owner)
+	if err != nil {
+		log.Printf("Error listing the test reports of %s: %s", owner, err)
+		return nil
+	}
+
+	var results []instrument.Result
+	for i := len(list) - 1; i >= 0 && len(results) < maxDurationReports; i-- {
+		a := list[i]
+		if a.Kind != artifacts.KindTestReport || a.Metadata["test_package"] != testPackage {
+			continue
+		}
+
+		_, rc, err := Artifacts.Open(ctx, owner, a.ID)
+		if err != nil {
+			log.Printf("Error opening test report %s: %s", a.ID, err)
+			continue
+		}
+		result, err := instrument.ReadJUnit(rc)
+		rc.Close()
+		if err != nil {
+			log.Printf("Error reading test report %s: %s", a.ID, err)
+			continue
+		}
+		results = append(results, result)
+	}
+	return sharding.Durations(results)
+}
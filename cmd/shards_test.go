@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/instrument"
+)
+
+func TestRetryGroups(t *testing.T) {
+	devices := []shardDevice{{index: 0, id: "d0"}, {index: 1, id: "d1"}, {index: 2, id: "d2"}}
+	attempt := func(status string, deviceID string) instrument.TestResult {
+		return instrument.TestResult{Status: status, DeviceID: deviceID}
+	}
+
+	tests := []struct {
+		name     string
+		tests    []string
+		attempts map[string][]instrument.TestResult
+		devices  []shardDevice
+		want     map[int][]string
+	}{
+		{
+			name:  "failures move to the next device",
+			tests: []string{"A#a", "A#b", "A#c", "A#d"},
+			attempts: map[string][]instrument.TestResult{
+				"A#a": {attempt(instrument.StatusFailed, "d0")},
+				"A#b": {attempt(instrument.StatusError, "d2")},
+				"A#c": {attempt(instrument.StatusPassed, "d1")},
+				"A#d": {attempt(instrument.StatusSkipped, "d1")},
+			},
+			devices: devices,
+			want:    map[int][]string{1: {"A#a"}, 0: {"A#b"}},
+		},
+		{
+			name:  "the last attempt decides",
+			tests: []string{"A#a", "A#b"},
+			attempts: map[string][]instrument.TestResult{
+				"A#a": {attempt(instrument.StatusFailed, "d0"), attempt(instrument.StatusPassed, "d1")},
+				"A#b": {attempt(instrument.StatusPassed, "d0"), attempt(instrument.StatusFailed, "d1")},
+			},
+			devices: devices,
+			want:    map[int][]string{2: {"A#b"}},
+		},
+		{
+			name:     "tests that did not run spread over the devices",
+			tests:    []string{"A#a", "A#b", "A#c", "A#d"},
+			attempts: map[string][]instrument.TestResult{},
+			devices:  devices,
+			want:     map[int][]string{0: {"A#a", "A#d"}, 1: {"A#b"}, 2: {"A#c"}},
+		},
+		{
+			name:  "nothing to retry",
+			tests: []string{"A#a"},
+			attempts: map[string][]instrument.TestResult{
+				"A#a": {attempt(instrument.StatusPassed, "d0")},
+			},
+			devices: devices,
+			want:    map[int][]string{},
+		},
+		{
+			name:  "a single device has no other device",
+			tests: []string{"A#a", "A#b"},
+			attempts: map[string][]instrument.TestResult{
+				"A#a": {attempt(instrument.StatusFailed, "d0")},
+			},
+			devices: devices[:1],
+			want:    map[int][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := retryGroups(tt.tests, tt.attempts, tt.devices)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("retryGroups = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
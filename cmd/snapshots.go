@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/emuconsole"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/snapshots"
+)
+
+const maxSnapshotRequestSize = 16 << 10
+
+var SnapshotPolicy *snapshots.Policy
+
+// HandleSnapshots lists, saves or deletes the snapshots of an owner.
+func HandleSnapshots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ListSnapshots(w, r)
+	case http.MethodPost:
+		SaveSnapshot(w, r)
+	case http.MethodDelete:
+		DeleteSnapshot(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ListSnapshots returns the snapshots of an owner, oldest first.
+func ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("owner")
+	if owner == "" {
+		http.Error(w, "Owner is required", http.StatusBadRequest)
+		return
+	}
+
+	list, err := ownerSnapshots(r.Context(), owner)
+	if err != nil {
+		log.Printf("Error listing snapshots of %s: %s", owner, err)
+		http.Error(w, "Failed to list snapshots", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// SaveSnapshot saves the state of a running emulator through its console
+// and copies the snapshot out of the container into the artifact storage
+// of the owner, where it counts against the quota. A snapshot of the same
+// name is replaced.
+func SaveSnapshot(w http.ResponseWriter, r *http.Request) {
+	containerName := r.URL.Query().Get("containerName")
+	owner := r.URL.Query().Get("owner")
+	if containerName == "" || owner == "" {
+		http.Error(w, "Container name and owner are required", http.StatusBadRequest)
+		return
+	}
+
+	var req snapshots.Request
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSnapshotRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := snapshots.CheckName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	existing, err := ownerSnapshots(ctx, owner)
+	if err != nil {
+		log.Printf("Error listing snapshots of %s: %s", owner, err)
+		http.Error(w, "Failed to save snapshot", http.StatusInternalServerError)
+		return
+	}
+	names := map[string]bool{}
+	for _, s := range existing {
+		if s.Name != req.Name {
+			names[s.Name] = true
+		}
+	}
+	if len(names) >= SnapshotPolicy.MaxPerUser() {
+		http.Error(w, fmt.Sprintf("%s, delete one of your %d snapshots first", snapshots.ErrLimitReached, len(names)), http.StatusConflict)
+		return
+	}
+
+	if err := snapshotCommand(ctx, containerName, "avd snapshot save "+req.Name); err != nil {
+		snapshotError(w, containerName, err)
+		return
+	}
+
+	saved, err := exportSnapshot(ctx, containerName, owner, req)
+	if errors.Is(err, artifacts.ErrQuotaExceeded) {
+		// Keep the container from holding a snapshot the owner cannot store.
+		removeContainerSnapshot(containerName, req.Name)
+		http.Error(w, "Artifact quota exceeded", http.StatusInsufficientStorage)
+		return
+	} else if err != nil {
+		log.Printf("Error storing snapshot %s of %s: %s", req.Name, containerName, err)
+		http.Error(w, "Failed to store snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	snapshot, _ := snapshots.FromArtifact(saved)
+	for _, old := range existing {
+		if old.Name != snapshot.Name {
+			continue
+		}
+		if err := Artifacts.Delete(ctx, owner, old.ArtifactID); err != nil && !errors.Is(err, artifacts.ErrNotFound) {
+			log.Printf("Error deleting replaced snapshot %s of %s: %s", old.ArtifactID, owner, err)
+		}
+	}
+
+	log.Printf("Snapshot %s of %s saved as %s (%d bytes)", snapshot.Name, containerName, snapshot.ArtifactID, snapshot.Size)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// RestoreSnapshot loads a snapshot into a running emulator, copying it into
+// the container first unless the container already holds that copy.
+func RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	owner := r.URL.Query().Get("owner")
+	if containerName == "" || owner == "" {
+		http.Error(w, "Container name and owner are required", http.StatusBadRequest)
+		return
+	}
+
+	var req snapshots.Request
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSnapshotRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := snapshots.CheckName(req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	snapshot, err := findSnapshot(ctx, owner, req.Name)
+	if errors.Is(err, snapshots.ErrNotFound) {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error finding snapshot %s of %s: %s", req.Name, owner, err)
+		http.Error(w, "Failed to restore snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	if err := snapshot.Compatible(req.AndroidAPI, req.DeviceName); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if err := importSnapshot(ctx, containerName, owner, snapshot); err != nil {
+		log.Printf("Error copying snapshot %s into %s: %s", snapshot.ArtifactID, containerName, err)
+		http.Error(w, "Failed to copy snapshot to device", http.StatusBadGateway)
+		return
+	}
+
+	if err := snapshotCommand(ctx, containerName, "avd snapshot load "+snapshot.Name); err != nil {
+		snapshotError(w, containerName, err)
+		return
+	}
+
+	log.Printf("Snapshot %s restored on %s", snapshot.Name, containerName)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// DeleteSnapshot deletes a snapshot from the artifact storage of the owner
+// and from the container when it is running.
+func DeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("owner")
+	name := r.URL.Query().Get("name")
+	if owner == "" || name == "" {
+		http.Error(w, "Owner and name are required", http.StatusBadRequest)
+		return
+	}
+	if err := snapshots.CheckName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	list, err := ownerSnapshots(ctx, owner)
+	if err != nil {
+		log.Printf("Error listing snapshots of %s: %s", owner, err)
+		http.Error(w, "Failed to delete snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	found := false
+	for _, s := range list {
+		if s.Name != name {
+			continue
+		}
+		found = true
+		if err := Artifacts.Delete(ctx, owner, s.ArtifactID); err != nil && !errors.Is(err, artifacts.ErrNotFound) {
+			log.Printf("Error deleting snapshot %s of %s: %s", s.ArtifactID, owner, err)
+			http.Error(w, "Failed to delete snapshot", http.StatusInternalServerError)
+			return
+		}
+	}
+	if !found {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	}
+
+	if containerName := r.URL.Query().Get("containerName"); containerName != "" && DevicesPortMap[containerName] != "" {
+		removeContainerSnapshot(containerName, name)
+	}
+
+	fmt.Fprintf(w, "Snapshot deleted successfully")
+}
+
+// ownerSnapshots returns the snapshots among the artifacts of owner.
+func ownerSnapshots(ctx context.Context, owner string) ([]snapshots.Snapshot, error) {
+	list, err := Artifacts.List(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []snapshots.Snapshot{}
+	for _, a := range list {
+		if s, ok := snapshots.FromArtifact(a); ok {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// findSnapshot returns the latest snapshot of owner with the given name.
+func findSnapshot(ctx context.Context, owner string, name string) (snapshots.Snapshot, error) {
+	list, err := ownerSnapshots(ctx, owner)
+	if err != nil {
+		return snapshots.Snapshot{}, err
+	}
+
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].Name == name {
+			return list[i], nil
+		}
+	}
+	return snapshots.Snapshot{}, snapshots.ErrNotFound
+}
+
+// snapshotCommand runs an avd snapshot command on the emulator console,
+// which answers once the snapshot is written or loaded.
+func snapshotCommand(ctx context.Context, containerName string, command string) error {
+	console, err := dialConsole(ctx, containerName)
+	if err != nil {
+		return err
+	}
+	defer console.Close()
+
+	console.SetTimeout(SnapshotPolicy.Timeout())
+	_, err = console.Command(ctx, command)
+	return err
+}
+
+// exportSnapshot streams the snapshot directory out of the container into
+// the artifact storage and marks the container copy with the artifact.
+func exportSnapshot(ctx context.Context, containerName string, owner string, req snapshots.Request) (artifacts.Artifact, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sudo", "docker", "exec", containerName, "sh", "-c",
+		fmt.Sprintf("cd %s && tar czf - %s", SnapshotPolicy.Dir(), req.Name))
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return artifacts.Artifact{}, err
+	}
+	if err := cmd.Start(); err != nil {
+		return artifacts.Artifact{}, err
+	}
+
+	saved, err := Artifacts.Save(ctx, artifacts.Artifact{
+		Owner:       owner,
+		DeviceID:    containerName,
+		Kind:        artifacts.KindSnapshot,
+		Name:        req.Name + ".tar.gz",
+		ContentType: "application/gzip",
+		Metadata: map[string]string{
+			snapshots.MetaName:       req.Name,
+			snapshots.MetaAndroidAPI: req.AndroidAPI,
+			snapshots.MetaDeviceName: req.DeviceName,
+		},
+	}, stdout)
+	if err != nil {
+		cancel()
+		cmd.Wait()
+		return artifacts.Artifact{}, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		Artifacts.Delete(context.Background(), owner, saved.ID)
+		return artifacts.Artifact{}, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	if err := containerShell(ctx, containerName, fmt.Sprintf("cd %s && echo %s > .%s.artifact", SnapshotPolicy.Dir(), saved.ID, req.Name), nil); err != nil {
+		log.Printf("Error marking snapshot %s of %s: %s", req.Name, containerName, err)
+	}
+
+	return saved, nil
+}
+
+// importSnapshot copies a snapshot into the container, replacing a
+// different snapshot of the same name.
+func importSnapshot(ctx context.Context, containerName string, owner string, snapshot snapshots.Snapshot) error {
+	marker := fmt.Sprintf("cat %s/.%s.artifact 2>/dev/null || true", SnapshotPolicy.Dir(), snapshot.Name)
+	out, err := exec.CommandContext(ctx, "sudo", "docker", "exec", containerName, "sh", "-c", marker).Output()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(out)) == snapshot.ArtifactID {
+		return nil
+	}
+
+	_, rc, err := Artifacts.Open(ctx, owner, snapshot.ArtifactID)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dir := SnapshotPolicy.Dir()
+	return containerShell(ctx, containerName,
+		fmt.Sprintf("mkdir -p %[1]s && cd %[1]s && rm -rf %[2]s && tar xzf - && echo %[3]s > .%[2]s.artifact", dir, snapshot.Name, snapshot.ArtifactID), rc)
+}
+
+// removeContainerSnapshot deletes the copy of a snapshot in a container.
+func removeContainerSnapshot(containerName string, name string) {
+	script := fmt.Sprintf("cd %s && rm -rf %s .%s.artifact", SnapshotPolicy.Dir(), name, name)
+	if err := containerShell(context.Background(), containerName, script, nil); err != nil {
+		log.Printf("Error deleting snapshot %s from %s: %s", name, containerName, err)
+	}
+}
+
+// containerShell runs a shell script in a container, with stdin as its
+// input when it is not nil.
+func containerShell(ctx context.Context, containerName string, script string, stdin io.Reader) error {
+	args := []string{"docker", "exec", containerName, "sh", "-c", script}
+	if stdin != nil {
+		args = []string{"docker", "exec", "-i", containerName, "sh", "-c", script}
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sudo", args...)
+	cmd.Stdin = stdin
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func snapshotError(w http.ResponseWriter, containerName string, err error) {
+	var cmdErr *emuconsole.CommandError
+	if errors.As(err, &cmdErr) {
+		http.Error(w, cmdErr.Message, http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("Error running snapshot command on %s: %s", containerName, err)
+	http.Error(w, "Failed to reach the emulator console", http.StatusBadGateway)
+}
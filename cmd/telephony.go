@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/emuconsole"
+)
+
+const maxTelephonyRequestSize = 16 << 10
+
+// SendSMS delivers an SMS to a device through the emulator modem.
+func SendSMS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	var req agent.SMSRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxTelephonyRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	console, err := dialConsole(r.Context(), containerName)
+	if err != nil {
+		log.Printf("Error connecting to the console of %s: %s", containerName, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+	defer console.Close()
+
+	if _, err := console.Command(r.Context(), req.Command()); err != nil {
+		telephonyError(w, containerName, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Call places, accepts, holds or ends a call on a device and answers with
+// the calls of the device.
+func Call(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	if containerName == "" {
+		http.Error(w, "Container name is required", http.StatusBadRequest)
+		return
+	}
+
+	var req agent.CallRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxTelephonyRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	console, err := dialConsole(r.Context(), containerName)
+	if err != nil {
+		log.Printf("Error connecting to the console of %s: %s", containerName, err)
+		http.Error(w, "Failed to connect to device", http.StatusBadGateway)
+		return
+	}
+	defer console.Close()
+
+	if _, err := console.Command(r.Context(), req.Command()); err != nil {
+		telephonyError(w, containerName, err)
+		return
+	}
+
+	lines, err := console.Command(r.Context(), "gsm list")
+	if err != nil {
+		telephonyError(w, containerName, err)
+		return
+	}
+
+	calls := agent.Calls{Calls: []string{}}
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			calls.Calls = append(calls.Calls, line)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calls)
+}
+
+func telephonyError(w http.ResponseWriter, containerName string, err error) {
+	var cmdErr *emuconsole.CommandError
+	if errors.As(err, &cmdErr) {
+		http.Error(w, cmdErr.Message, http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("Error simulating telephony on %s: %s", containerName, err)
+	http.Error(w, "Failed to reach the device modem", http.StatusBadGateway)
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/instrument"
+)
+
+const (
+	testRunTimeout = time.Hour
+	// testRunRetention is how long a finished run is kept, its artifacts
+	// follow the artifact retention.
+	testRunRetention = 24 * time.Hour
+	// maxFailureScreenshots bounds the screenshots of a run whose tests
+	// fail in bulk.
+	maxFailureScreenshots = 20
+	maxTestFormField      = 4 << 10
+	maxInstrumentLineSize = 1 << 20
+)
+
+var artifactNameRe = regexp.MustCompile(`[^A-Za-z0-9_.#-]+`)
+
+// testRun runs the tests of an app on a device until it finished.
+type testRun struct {
+	mu     sync.Mutex
+	run    agent.TestRun
+	cancel context.CancelFunc
+}
+
+func (t *testRun) snapshot() agent.TestRun {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	run := t.run
+	run.Shards = slices.Clone(run.Shards)
+	return run
+}
+
+func (t *testRun) update(f func(run *agent.TestRun)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f(&t.run)
+}
+
+func (t *testRun) running() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.run.FinishedAt == nil
+}
+
+// finish records the outcome of the run, from its result unless it failed
+// with err.
+func (t *testRun) finish(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.run.FinishedAt = &now
+	switch result := t.run.Result; {
+	case err != nil:
+		t.run.Status = agent.TestRunError
+		t.run.Error = err.Error()
+	case result == nil || !result.Completed:
+		t.run.Status = agent.TestRunError
+		if result != nil {
+			t.run.Error = result.Message
+		}
+	case result.Summary.Failed > 0 || result.Summary.Errors > 0:
+		t.run.Status = agent.TestRunFailed
+	default:
+		t.run.Status = agent.TestRunPassed
+	}
+}
+
+// TestRunRegistry tracks the test runs, one at a time on a device and one
+// sharded run at a time for an owner.
+type TestRunRegistry struct {
+	mu   sync.Mutex
+	runs map[string]*testRun
+}
+
+var TestRuns = &TestRunRegistry{runs: map[string]*testRun{}}
+
+// start registers a run unless one is running on its device.
+func (reg *TestRunRegistry) start(t *testRun) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, current := range reg.runs {
+		if !current.running() {
+			continue
+		}
+		if t.run.DeviceID != "" && current.run.DeviceID == t.run.DeviceID {
+			return false
+		}
+		if t.run.Strategy != "" && current.run.Strategy != "" && current.run.Owner == t.run.Owner {
+			return false
+		}
+	}
+	reg.runs[t.run.ID] = t
+	return true
+}
+
+// get returns a run of the owner.
+func (reg *TestRunRegistry) get(owner string, id string) (agent.TestRun, bool) {
+	reg.mu.Lock()
+	t, ok := reg.runs[id]
+	reg.mu.Unlock()
+	if !ok {
+		return agent.TestRun{}, false
+	}
+
+	run := t.snapshot()
+	return run, run.Owner == owner
+}
+
+// list returns the runs of the owner, newest first, and forgets the runs
+// finished longer than the retention ago.
+func (reg *TestRunRegistry) list(owner string) []agent.TestRun {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	runs := []agent.TestRun{}
+	for id, t := range reg.runs {
+		run := t.snapshot()
+		if run.FinishedAt != nil && time.Since(*run.FinishedAt) > testRunRetention {
+			delete(reg.runs, id)
+			continue
+		}
+		if run.Owner == owner {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.After(runs[j].StartedAt) })
+	return runs
+}
+
+// Abort cancels the runs on the device, used when the device goes away or
+// restarts.
+func (reg *TestRunRegistry) Abort(deviceID string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, t := range reg.runs {
+		if t.run.DeviceID == deviceID && t.running() {
+			t.cancel()
+		}
+	}
+}
+
+// testForm is the upload of a test run.
+type testForm struct {
+	app    []localAPK
+	test   []localAPK
+	runner string
+	args   map[string]string
+}
+
+// RunTests installs an app and its test APK on a device and runs the
+// instrumentation tests. It answers once the run started; the run is
+// polled through the test runs.
+func RunTests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	containerName := r.URL.Query().Get("containerName")
+	owner := r.URL.Query().Get("owner")
+	if containerName == "" || owner == "" {
+		http.Error(w, "Container name and owner are required", http.StatusBadRequest)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "tests-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	form, err := readTestForm(w, r, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "APKs are too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testRunTimeout)
+	t := &testRun{
+		run: agent.TestRun{
+			ID:          newID(),
+			DeviceID:    containerName,
+			Owner:       owner,
+			Status:      agent.TestRunRunning,
+			AppPackage:  form.app[0].manifest.Package,
+			TestPackage: form.test[0].manifest.Package,
+			Args:        form.args,
+			StartedAt:   time.Now(),
+		},
+		cancel: cancel,
+	}
+	if !TestRuns.start(t) {
+		cancel()
+		os.RemoveAll(dir)
+		http.Error(w, "A test run is already running on this device", http.StatusConflict)
+		return
+	}
+
+	go func() {
+		defer cancel()
+		defer os.RemoveAll(dir)
+
+		err := runTests(ctx, t, form)
+		t.finish(err)
+		run := t.snapshot()
+		if err != nil {
+			log.Printf("Error running tests %s on %s: %s", run.ID, containerName, err)
+			return
+		}
+		log.Printf("Test run %s on %s finished: %s", run.ID, containerName, run.Status)
+	}()
+
+	log.Printf("Started test run %s of %s on %s", t.run.ID, t.run.TestPackage, containerName)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(t.snapshot())
+}
+
+// HandleTestRuns returns a test run of an owner, or all of them.
+func HandleTestRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, id := r.URL.Query().Get("owner"), r.URL.Query().Get("id")
+	if owner == "" {
+		http.Error(w, "Owner is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if id == "" {
+		json.NewEncoder(w).Encode(TestRuns.list(owner))
+		return
+	}
+
+	run, ok := TestRuns.get(owner, id)
+	if !ok {
+		http.Error(w, "Test run not found", http.StatusNotFound)
+		return
+	}
+	for i := range run.Artifacts {
+		run.Artifacts[i].URL, _ = Artifacts.DownloadURL(r.Context(), run.Artifacts[i])
+	}
+	json.NewEncoder(w).Encode(run)
+}
+
+// readTestForm saves the APKs of a test run upload into dir and reads the
+// runner arguments.
+func readTestForm(w http.ResponseWriter, r *http.Request, dir string) (testForm, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, 2*maxAPKSize)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return testForm{}, err
+	}
+
+	var form testForm
+	var args []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return testForm{}, err
+		}
+
+		switch name := part.FormName(); name {
+		case "app", "test":
+			apks, err := readAPKPart(part, filepath.Join(dir, name))
+			if err != nil {
+				return testForm{}, fmt.Errorf("invalid %s APK: %w", name, err)
+			}
+			if name == "app" {
+				form.app = apks
+			} else {
+				form.test = apks
+			}
+		case "runner":
+			form.runner, err = readFormField(part)
+		case "arg":
+			var arg string
+			arg, err = readFormField(part)
+			args = append(args, arg)
+		}
+		part.Close()
+		if err != nil {
+			return testForm{}, err
+		}
+	}
+
+	if form.app == nil || form.test == nil {
+		return testForm{}, errors.New("app and test APKs are required")
+	}
+
+	form.args, err = instrument.ParseArgs(args)
+	return form, err
+}
+
+func readAPKPart(part *multipart.Part, dir string) ([]localAPK, error) {
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return nil, err
+	}
+	upload, err := saveFile(part, filepath.Join(dir, "upload"))
+	if err != nil {
+		return nil, err
+	}
+	return extractAPKs(upload, dir)
+}
+
+func readFormField(part *multipart.Part) (string, error) {
+	value, err := io.ReadAll(io.LimitReader(part, maxTestFormField+1))
+	if err != nil {
+		return "", err
+	}
+	if len(value) > maxTestFormField {
+		return "", fmt.Errorf("%s is too long", part.FormName())
+	}
+	return string(value), nil
+}
+
+// runTests installs the APKs of the form, runs the tests and stores the
+// report and logcat of the run.
+func runTests(ctx context.Context, t *testRun, form testForm) error {
+	run := t.snapshot()
+
+	device, err := deviceADB(ctx, run.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	instrumentation, err := prepareTests(ctx, device, form.app, form.test, form.runner)
+	if err != nil {
+		return err
+	}
+	command, err := instrument.Command(instrumentation.Name, form.args)
+	if err != nil {
+		return err
+	}
+	t.update(func(run *agent.TestRun) { run.Instrumentation = instrumentation.Name })
+
+	// The logcat of the run starts with it.
+	if _, err := device.RunShell(ctx, "logcat -c"); err != nil {
+		log.Printf("Error clearing logcat of %s: %s", run.DeviceID, err)
+	}
+
+	result, screenshots, err := runInstrumentation(ctx, device, run.DeviceID, run.Owner, run.ID, command)
+	if err != nil {
+		return err
+	}
+
+	saved := screenshots
+	if a, err := saveTestLogcat(ctx, device, run.Owner, run.DeviceID, run.ID, "logcat-"+run.ID+".txt"); err != nil {
+		log.Printf("Error storing logcat of test run %s: %s", run.ID, err)
+	} else {
+		saved = append(saved, a)
+	}
+	report, err := saveJUnit(ctx, run.Owner, run.DeviceID, run.ID, run.TestPackage, run.StartedAt, result)
+	if err != nil {
+		log.Printf("Error storing report of test run %s: %s", run.ID, err)
+	} else {
+		saved = append([]artifacts.Artifact{report}, saved...)
+	}
+
+	t.update(func(run *agent.TestRun) {
+		run.Result = &result
+		run.Artifacts = saved
+	})
+	return nil
+}
+
+// prepareTests installs the app and its test APK and returns the
+// instrumentation of the test APK, the one of runner when it is set.
+func prepareTests(ctx context.Context, device *adb.Device, app []localAPK, test []localAPK, runner string) (instrument.Instrumentation, error) {
+	opts := agent.InstallOptions{Replace: true, GrantPermissions: true, AllowTest: true}
+	for _, install := range []struct {
+		name string
+		apks []localAPK
+	}{{"app", app}, {"test", test}} {
+		result, err := installAPKs(ctx, device, install.apks, opts)
+		if err != nil {
+			return instrument.Instrumentation{}, err
+		}
+		if !result.Success {
+			return instrument.Instrumentation{}, fmt.Errorf("installing the %s APK failed: %s", install.name, result.Message)
+		}
+	}
+
+	out, err := device.RunShell(ctx, "pm list instrumentation")
+	if err != nil {
+		return instrument.Instrumentation{}, err
+	}
+
+	testPackage := test[0].manifest.Package
+	for _, i := range instrument.ParseInstrumentations(out) {
+		if i.Package() != testPackage {
+			continue
+		}
+		if runner == "" || i.Name == runner || i.Name == testPackage+"/"+runner {
+			return i, nil
+		}
+	}
+	return instrument.Instrumentation{}, instrument.ErrNoInstrumentation
+}
+
+// runInstrumentation runs an instrumentation command and parses its
+// output as it comes, capturing the screen when a test fails.
+func runInstrumentation(ctx context.Context, device *adb.Device, containerName string, owner string, session string, command string) (instrument.Result, []artifacts.Artifact, error) {
+	startedAt := time.Now()
+	stream, err := device.Shell(ctx, command)
+	if err != nil {
+		return instrument.Result{}, nil, err
+	}
+	defer stream.Close()
+
+	parser := instrument.NewParser(startedAt)
+	var screenshots []artifacts.Artifact
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64<<10), maxInstrumentLineSize)
+	for scanner.Scan() {
+		test, ended := parser.Line(scanner.Text(), time.Now())
+		if !ended || (test.Status != instrument.StatusFailed && test.Status != instrument.StatusError) {
+			continue
+		}
+		if len(screenshots) >= maxFailureScreenshots {
+			continue
+		}
+
+		a, err := saveFailureScreenshot(ctx, containerName, owner, session, test)
+		if err != nil {
+			log.Printf("Error capturing the failure of %s on %s: %s", test.FullName(), containerName, err)
+			continue
+		}
+		parser.SetScreenshot(test.FullName(), a.ID)
+		screenshots = append(screenshots, a)
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return instrument.Result{}, screenshots, err
+	}
+	if ctx.Err() != nil {
+		return instrument.Result{}, screenshots, ctx.Err()
+	}
+
+	return parser.Result(), screenshots, nil
+}
+
+func saveFailureScreenshot(ctx context.Context, containerName string, owner string, session string, test instrument.TestResult) (artifacts.Artifact, error) {
+	img, err := captureScreen(ctx, containerName)
+	if err != nil {
+		return artifacts.Artifact{}, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return artifacts.Artifact{}, err
+	}
+
+	return Artifacts.Save(ctx, artifacts.Artifact{
+		Owner:       owner,
+		DeviceID:    containerName,
+		Session:     session,
+		Kind:        artifacts.KindScreenshot,
+		Name:        "failure-" + artifactNameRe.ReplaceAllString(test.FullName(), "_") + ".png",
+		ContentType: agent.ContentType(agent.FormatPNG),
+		Metadata:    map[string]string{"test": test.FullName()},
+	}, &buf)
+}
+
+func saveTestLogcat(ctx context.Context, device *adb.Device, owner string, containerName string, session string, name string) (artifacts.Artifact, error) {
+	stream, err := device.Shell(ctx, "logcat -d -v threadtime")
+	if err != nil {
+		return artifacts.Artifact{}, err
+	}
+	defer stream.Close()
+
+	return Artifacts.Save(ctx, artifacts.Artifact{
+		Owner:       owner,
+		DeviceID:    containerName,
+		Session:     session,
+		Kind:        artifacts.KindLog,
+		Name:        name,
+		ContentType: "text/plain",
+	}, stream)
+}
+
+func saveJUnit(ctx context.Context, owner string, containerName string, session string, name string, startedAt time.Time, result instrument.Result) (artifacts.Artifact, error) {
+	var buf bytes.Buffer
+	if err := instrument.WriteJUnit(&buf, name, containerName, startedAt, result); err != nil {
+		return artifacts.Artifact{}, err
+	}
+
+	return Artifacts.Save(ctx, artifacts.Artifact{
+		Owner:       owner,
+		DeviceID:    containerName,
+		Session:     session,
+		Kind:        artifacts.KindTestReport,
+		Name:        "junit-" + session + ".xml",
+		ContentType: "application/xml",
+		Metadata: map[string]string{
+			"tests":    strconv.Itoa(result.Summary.Tests),
+			"failures": strconv.Itoa(result.Summary.Failed),
+			"errors":   strconv.Itoa(result.Summary.Errors),
+			"skipped":  strconv.Itoa(result.Summary.Skipped),
+			// The test package finds the reports of earlier runs, whose
+			// durations balance the shards of a sharded run.
+			"test_package": name,
+		},
+	}, &buf)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/gorilla/mux"
+)
+
+// adbTunnelProtocol is the value of the Upgrade header used to open a tunnel.
+const adbTunnelProtocol = "adb"
+
+// adbPort is the port adbd listens on inside the emulator container.
+const adbPort = 5555
+
+// Tunnel is an active ADB-over-TCP connection bridged to a device.
+type Tunnel struct {
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	DeviceID   string    `json:"device_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	StartedAt  time.Time `json:"started_at"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+
+	client   net.Conn
+	upstream net.Conn
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+// close closes both ends of the tunnel.
+func (t *Tunnel) close() {
+	t.client.Close()
+	t.upstream.Close()
+}
+
+// snapshot returns a copy of the tunnel suitable for JSON encoding.
+func (t *Tunnel) snapshot() Tunnel {
+	return Tunnel{
+		ID:         t.ID,
+		Username:   t.Username,
+		DeviceID:   t.DeviceID,
+		RemoteAddr: t.RemoteAddr,
+		StartedAt:  t.StartedAt,
+		BytesIn:    t.bytesIn.Load(),
+		BytesOut:   t.bytesOut.Load(),
+	}
+}
+
+// TunnelRegistry keeps track of the active ADB tunnels.
+type TunnelRegistry struct {
+	mu      sync.Mutex
+	tunnels map[string]*Tunnel
+}
+
+var Tunnels = &TunnelRegistry{tunnels: map[string]*Tunnel{}}
+
+func (reg *TunnelRegistry) add(t *Tunnel) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.tunnels[t.ID] = t
+}
+
+func (reg *TunnelRegistry) remove(id string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.tunnels, id)
+}
+
+// List returns the active tunnels of the given user.
+func (reg *TunnelRegistry) List(username string) []Tunnel {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	tunnels := []Tunnel{}
+	for _, t := range reg.tunnels {
+		if t.Username == username {
+			tunnels = append(tunnels, t.snapshot())
+		}
+	}
+	return tunnels
+}
+
+// Revoke closes the tunnel with the given id if it belongs to the user.
+func (reg *TunnelRegistry) Revoke(id string, username string) bool {
+	reg.mu.Lock()
+	t, ok := reg.tunnels[id]
+	if !ok || t.Username != username {
+		reg.mu.Unlock()
+		return false
+	}
+	delete(reg.tunnels, id)
+	reg.mu.Unlock()
+
+	t.close()
+	return true
+}
+
+// RevokeDevice closes every tunnel opened to the given device.
+func (reg *TunnelRegistry) RevokeDevice(deviceID string) {
+	reg.mu.Lock()
+	var revoked []*Tunnel
+	for id, t := range reg.tunnels {
+		if t.DeviceID == deviceID {
+			revoked = append(revoked, t)
+			delete(reg.tunnels, id)
+		}
+	}
+	reg.mu.Unlock()
+
+	for _, t := range revoked {
+		t.close()
+	}
+}
+
+// authenticate validates the access token in the Authorization header.
+func authenticate(r *http.Request) (*token.AccessPayload, bool) {
+	return verifyToken(r.Header.Get("Authorization"))
+}
+
+func verifyToken(auth string) (*token.AccessPayload, bool) {
+	if auth == "" {
+		return nil, false
+	}
+
+	claims, err := TokenMaker.VerifyAccessToken(auth)
+	if err != nil {
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// containerIP returns the IP address of the container on the docker network.
+func containerIP(containerName string) (string, error) {
+	out, err := exec.Command("sudo", "docker", "inspect", "-f", "{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}", containerName).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// HandleAdbTunnel upgrades the request to a raw TCP stream bridged to the
+// adbd port of the caller's device.
+func HandleAdbTunnel(w http.ResponseWriter, r *http.Request) {
+	claims, ok := authenticate(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID := claims.Username + "-Device"
+	if DevicesPortMap[deviceID] == "" {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	if !strings.EqualFold(r.Header.Get("Upgrade"), adbTunnelProtocol) {
+		w.Header().Set("Upgrade", adbTunnelProtocol)
+		http.Error(w, "Upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+
+	ip, err := containerIP(deviceID)
+	if err != nil || ip == "" {
+		log.Printf("Error inspecting container %s: %v", deviceID, err)
+		http.Error(w, "Failed to reach device", http.StatusBadGateway)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(adbPort)), 5*time.Second)
+	if err != nil {
+		log.Printf("Error dialing adb of %s: %v", deviceID, err)
+		http.Error(w, "Failed to reach device", http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "Tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client, buf, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		log.Printf("Error hijacking connection: %v", err)
+		return
+	}
+
+	_, err = client.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: " + adbTunnelProtocol + "\r\nConnection: Upgrade\r\n\r\n"))
+	if err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	tunnel := &Tunnel{
+		ID:         newID(),
+		Username:   claims.Username,
+		DeviceID:   deviceID,
+		RemoteAddr: r.RemoteAddr,
+		StartedAt:  time.Now(),
+		client:     client,
+		upstream:   upstream,
+	}
+	Tunnels.add(tunnel)
+	log.Printf("ADB tunnel %s opened to %s from %s", tunnel.ID, deviceID, r.RemoteAddr)
+
+	// The tunnel must not outlive the token it was opened with.
+	expiry := time.AfterFunc(time.Until(claims.ExpiredAt), func() {
+		Tunnels.Revoke(tunnel.ID, tunnel.Username)
+	})
+
+	go pipeTunnel(tunnel, buf.Reader, expiry)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n.Add(int64(n))
+	return n, err
+}
+
+// pipeTunnel copies data in both directions until either side closes.
+func pipeTunnel(t *Tunnel, clientReader *bufio.Reader, expiry *time.Timer) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(&countingWriter{w: t.upstream, n: &t.bytesIn}, clientReader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(&countingWriter{w: t.client, n: &t.bytesOut}, t.upstream)
+		done <- struct{}{}
+	}()
+
+	<-done
+	t.close()
+	<-done
+
+	expiry.Stop()
+	Tunnels.remove(t.ID)
+	log.Printf("ADB tunnel %s closed (in: %d bytes, out: %d bytes)", t.ID, t.bytesIn.Load(), t.bytesOut.Load())
+}
+
+// ListAdbTunnels returns the active tunnels of the caller.
+func ListAdbTunnels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := authenticate(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Tunnels.List(claims.Username))
+}
+
+// RevokeAdbTunnel closes an active tunnel of the caller.
+func RevokeAdbTunnel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := authenticate(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !Tunnels.Revoke(mux.Vars(r)["id"], claims.Username) {
+		http.Error(w, "Tunnel not found", http.StatusNotFound)
+		return
+	}
+
+	fmt.Fprintf(w, "Tunnel revoked successfully")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+)
+
+func newTestToken(t *testing.T) string {
+	t.Helper()
+
+	maker, err := token.NewPasetoMaker(strings.Repeat("k", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	TokenMaker = maker
+
+	accessToken, err := maker.CreateAccessToken("bob", "Bob", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accessToken
+}
+
+func TestAuthenticateHeaderOnly(t *testing.T) {
+	accessToken := newTestToken(t)
+
+	r := httptest.NewRequest("GET", "/exec", nil)
+	r.Header.Set("Authorization", accessToken)
+	if claims, ok := authenticate(r); !ok || claims.Username != "bob" {
+		t.Fatalf("header token: ok = %v", ok)
+	}
+
+	// Tokens in URLs end up in logs and histories.
+	r = httptest.NewRequest("GET", "/exec?token="+url.QueryEscape(accessToken), nil)
+	if _, ok := authenticate(r); ok {
+		t.Fatal("query token accepted")
+	}
+
+	r = httptest.NewRequest("GET", "/exec", nil)
+	r.Header.Set("Authorization", "invalid")
+	if _, ok := authenticate(r); ok {
+		t.Fatal("invalid token accepted")
+	}
+}
+
+func TestStreamLogsQueryToken(t *testing.T) {
+	accessToken := newTestToken(t)
+
+	// A valid query token passes authentication, the device is then looked
+	// up.
+	w := httptest.NewRecorder()
+	StreamLogs(w, httptest.NewRequest("GET", "/logs?token="+url.QueryEscape(accessToken), nil))
+	if w.Code != 404 {
+		t.Errorf("query token: status = %d, want 404", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	StreamLogs(w, httptest.NewRequest("GET", "/logs?token=invalid", nil))
+	if w.Code != 401 {
+		t.Errorf("invalid query token: status = %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/volumes"
+)
+
+var VolumePolicy *volumes.Policy
+
+// volumeLocks serializes, for each docker volume, the check that no
+// device uses it with the change relying on it, as mounting it in a new
+// device, so that two devices never share a data image.
+var volumeLocks = struct {
+	mu    sync.Mutex
+	locks map[string]*volumeLock
+}{locks: map[string]*volumeLock{}}
+
+type volumeLock struct {
+	mu sync.Mutex
+	// refs counts the holders and waiters, the lock is dropped without.
+	refs int
+}
+
+// lockVolume locks the docker volume name and returns the function
+// unlocking it.
+func lockVolume(name string) func() {
+	volumeLocks.mu.Lock()
+	l, ok := volumeLocks.locks[name]
+	if !ok {
+		l = &volumeLock{}
+		volumeLocks.locks[name] = l
+	}
+	l.refs++
+	volumeLocks.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		volumeLocks.mu.Lock()
+		defer volumeLocks.mu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(volumeLocks.locks, name)
+		}
+	}
+}
+
+// dockerVolume is the part of docker volume inspect used here.
+type dockerVolume struct {
+	Name       string            `json:"Name"`
+	CreatedAt  time.Time         `json:"CreatedAt"`
+	Labels     map[string]string `json:"Labels"`
+	Mountpoint string            `json:"Mountpoint"`
+}
+
+// HandleVolumes lists or deletes the data volumes of an owner.
+func HandleVolumes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ListVolumes(w, r)
+	case http.MethodDelete:
+		DeleteVolume(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ListVolumes returns the data volumes of an owner with their disk usage
+// and the device using them.
+func ListVolumes(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("owner")
+	if owner == "" {
+		http.Error(w, "Owner is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	out, err := dockerOutput(ctx, "volume", "ls", "-q", "--filter", "label="+volumes.LabelOwner+"="+owner)
+	if err != nil {
+		log.Printf("Error listing volumes of %s: %s", owner, err)
+		http.Error(w, "Failed to list volumes", http.StatusInternalServerError)
+		return
+	}
+
+	list := []volumes.Volume{}
+	for _, name := range strings.Fields(out) {
+		dv, err := inspectVolume(ctx, name)
+		if errors.Is(err, volumes.ErrNotFound) {
+			continue
+		} else if err != nil {
+			log.Printf("Error inspecting volume %s: %s", name, err)
+			http.Error(w, "Failed to list volumes", http.StatusInternalServerError)
+			return
+		}
+		if dv.Labels[volumes.LabelOwner] != owner {
+			continue
+		}
+
+		volume, err := describeVolume(ctx, dv)
+		if err != nil {
+			log.Printf("Error describing volume %s: %s", name, err)
+			http.Error(w, "Failed to list volumes", http.StatusInternalServerError)
+			return
+		}
+		list = append(list, volume)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// DeleteVolume deletes a data volume of an owner that no device uses.
+func DeleteVolume(w http.ResponseWriter, r *http.Request) {
+	dv, unlock, ok := ownerVolume(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	if _, err := dockerOutput(r.Context(), "volume", "rm", dv.Name); err != nil {
+		log.Printf("Error deleting volume %s: %s", dv.Name, err)
+		http.Error(w, "Failed to delete volume", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Volume %s deleted", dv.Name)
+
+	fmt.Fprintf(w, "Volume deleted successfully")
+}
+
+// ResetVolume empties a data volume of an owner that no device uses, the
+// next device using it creates a new data partition.
+func ResetVolume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	dv, unlock, ok := ownerVolume(w, r)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	ctx := r.Context()
+
+	if out, err := exec.CommandContext(ctx, "sudo", "find", dv.Mountpoint, "-mindepth", "1", "-delete").CombinedOutput(); err != nil {
+		log.Printf("Error resetting volume %s: %s: %s", dv.Name, err, strings.TrimSpace(string(out)))
+		http.Error(w, "Failed to reset volume", http.StatusInternalServerError)
+		return
+	}
+
+	volume, err := describeVolume(ctx, dv)
+	if err != nil {
+		log.Printf("Error describing volume %s: %s", dv.Name, err)
+		http.Error(w, "Failed to reset volume", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Volume %s reset", dv.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(volume)
+}
+
+// ownerVolume returns the volume named in the request, locked so that no
+// device starts using it, and the function unlocking it. It answers the
+// request itself when the volume is missing or used by a device.
+func ownerVolume(w http.ResponseWriter, r *http.Request) (dockerVolume, func(), bool) {
+	query := r.URL.Query()
+	owner, androidAPI, name := query.Get("owner"), query.Get("androidAPI"), query.Get("name")
+	if owner == "" {
+		http.Error(w, "Owner is required", http.StatusBadRequest)
+		return dockerVolume{}, nil, false
+	}
+	if err := volumes.Check(name, androidAPI); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return dockerVolume{}, nil, false
+	}
+
+	dv, err := inspectVolume(r.Context(), volumes.DockerName(owner, androidAPI, name))
+	if errors.Is(err, volumes.ErrNotFound) {
+		http.Error(w, "Volume not found", http.StatusNotFound)
+		return dockerVolume{}, nil, false
+	} else if err != nil {
+		log.Printf("Error inspecting volume %s of %s: %s", name, owner, err)
+		http.Error(w, "Failed to get volume", http.StatusInternalServerError)
+		return dockerVolume{}, nil, false
+	}
+
+	unlock := lockVolume(dv.Name)
+	user, err := volumeUser(r.Context(), dv.Name)
+	if err != nil {
+		unlock()
+		log.Printf("Error finding the device using volume %s: %s", dv.Name, err)
+		http.Error(w, "Failed to get volume", http.StatusInternalServerError)
+		return dockerVolume{}, nil, false
+	}
+	if user != "" {
+		unlock()
+		http.Error(w, fmt.Sprintf("%s %s, delete the device first", volumes.ErrInUse, user), http.StatusConflict)
+		return dockerVolume{}, nil, false
+	}
+
+	return dv, unlock, true
+}
+
+// prepareVolume creates the data volume of a new device unless it exists
+// and returns the docker run arguments mounting it. The caller holds the
+// lock of the volume until the device runs.
+func prepareVolume(ctx context.Context, containerName string, owner string, androidAPI string, name string) ([]string, error) {
+	if err := volumes.Check(name, androidAPI); err != nil {
+		return nil, err
+	}
+
+	dockerName := volumes.DockerName(owner, androidAPI, name)
+	dv, err := inspectVolume(ctx, dockerName)
+	if errors.Is(err, volumes.ErrNotFound) {
+		if _, err := dockerOutput(ctx, "volume", "create",
+			"--label", volumes.LabelOwner+"="+owner,
+			"--label", volumes.LabelAndroidAPI+"="+androidAPI,
+			"--label", volumes.LabelName+"="+name,
+			dockerName); err != nil {
+			return nil, err
+		}
+		if dv, err = inspectVolume(ctx, dockerName); err != nil {
+			return nil, err
+		}
+		// The emulator does not run as root.
+		if out, err := exec.CommandContext(ctx, "sudo", "chown", VolumePolicy.OwnerID(), dv.Mountpoint).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	user, err := volumeUser(ctx, dockerName)
+	if err != nil {
+		return nil, err
+	}
+	if user != "" && user != containerName {
+		return nil, fmt.Errorf("%w %s", volumes.ErrInUse, user)
+	}
+
+	return []string{"-v", dockerName + ":" + VolumePolicy.MountPath()}, nil
+}
+
+func inspectVolume(ctx context.Context, name string) (dockerVolume, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sudo", "docker", "volume", "inspect", name)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(strings.ToLower(stderr.String()), "no such volume") {
+			return dockerVolume{}, volumes.ErrNotFound
+		}
+		return dockerVolume{}, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var list []dockerVolume
+	if err := json.Unmarshal(out, &list); err != nil {
+		return dockerVolume{}, err
+	}
+	if len(list) == 0 {
+		return dockerVolume{}, volumes.ErrNotFound
+	}
+	return list[0], nil
+}
+
+// describeVolume returns the volume with its disk usage and user.
+func describeVolume(ctx context.Context, dv dockerVolume) (volumes.Volume, error) {
+	volume := volumes.Volume{
+		Name:       dv.Labels[volumes.LabelName],
+		AndroidAPI: dv.Labels[volumes.LabelAndroidAPI],
+		CreatedAt:  dv.CreatedAt,
+	}
+
+	out, err := exec.CommandContext(ctx, "sudo", "du", "-sB1", dv.Mountpoint).Output()
+	if err != nil {
+		return volume, err
+	}
+	if fields := strings.Fields(string(out)); len(fields) > 0 {
+		volume.Size, _ = strconv.ParseInt(fields[0], 10, 64)
+	}
+
+	volume.InUseBy, err = volumeUser(ctx, dv.Name)
+	return volume, err
+}
+
+// volumeUser returns the container using a volume, if any.
+func volumeUser(ctx context.Context, name string) (string, error) {
+	out, err := dockerOutput(ctx, "ps", "-a", "--filter", "volume="+name, "--format", "{{.Names}}")
+	if err != nil {
+		return "", err
+	}
+	if fields := strings.Fields(out); len(fields) > 0 {
+		return fields[0], nil
+	}
+	return "", nil
+}
+
+func dockerOutput(ctx context.Context, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sudo", append([]string{"docker"}, args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
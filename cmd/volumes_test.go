@@ -0,0 +1,38 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockVolume(t *testing.T) {
+	unlock := lockVolume("bob_34_work")
+
+	// Another volume is not held up.
+	lockVolume("alice_34_work")()
+
+	var wg sync.WaitGroup
+	locked := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer lockVolume("bob_34_work")()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("the volume was locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	wg.Wait()
+
+	volumeLocks.mu.Lock()
+	defer volumeLocks.mu.Unlock()
+	if len(volumeLocks.locks) != 0 {
+		t.Errorf("%d locks left after unlocking, want 0", len(volumeLocks.locks))
+	}
+}
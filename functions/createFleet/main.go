@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/config"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/fleets"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/redis"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+type Response events.APIGatewayProxyResponse
+
+var UserService *redis.UserService
+var TokenMaker token.Maker
+var AndroidService *redis.AndroidService
+var AgentClient *agent.Client
+var FleetPolicy *fleets.Policy
+
+type fleet struct {
+	redis.Fleet
+	Readiness fleets.Readiness `json:"readiness"`
+}
+
+func Handler(request events.APIGatewayProxyRequest) (Response, error) {
+	head := request.Headers
+
+	ctx := context.Background()
+
+	// check Authorization in headers
+	auth, ok := head["Authorization"]
+	if !ok {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Missing Authorization header",
+		}, nil
+	}
+
+	// validate token
+	claims, err := TokenMaker.VerifyAccessToken(auth)
+	if err != nil {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Invalid access token",
+		}, nil
+	}
+
+	var spec fleets.Spec
+	if err := json.Unmarshal([]byte(request.Body), &spec); err != nil {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: Invalid JSON body",
+		}, nil
+	}
+
+	if err := spec.Validate(); err != nil {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: " + err.Error(),
+		}, nil
+	}
+
+	f := redis.Fleet{
+		ID:        fleets.NewID(),
+		Username:  claims.Username,
+		Spec:      spec,
+		CreatedAt: time.Now(),
+	}
+	for i, variant := range spec.Expand() {
+		port, err := AndroidService.GetRandomPort(ctx)
+		if err != nil {
+			freePorts(ctx, f.Devices)
+			return Response{
+				StatusCode: 500,
+				Body:       "Internal Server Error: Failed to get random port",
+			}, nil
+		}
+
+		f.Devices = append(f.Devices, redis.Android{
+			DeviceID:       fleets.DeviceID(claims.Username, f.ID, i),
+			Username:       claims.Username,
+			Port:           port,
+			StartTimestamp: time.Now().Unix(),
+			AndroidAPI:     variant.AndroidAPI,
+			DeviceName:     variant.DeviceName,
+			Locale:         variant.Locale,
+			Status:         "pending",
+		})
+	}
+
+	// the fleet is quota checked and billed as a whole, and saved with the
+	// charge
+	f.Cost = FleetPolicy.Cost(len(f.Devices))
+	err = AndroidService.CreateFleet(ctx, f, func(sizes []int, balance int) error {
+		return FleetPolicy.Check(sizes, len(f.Devices), balance)
+	})
+	if err != nil {
+		freePorts(ctx, f.Devices)
+
+		statusCode, body := 500, "Internal Server Error: Failed to create fleet"
+		switch {
+		case errors.Is(err, fleets.ErrInsufficientFund):
+			statusCode, body = 402, err.Error()
+		case errors.Is(err, fleets.ErrTooManyFleets), errors.Is(err, fleets.ErrTooManyDevices):
+			statusCode, body = 403, err.Error()
+		case errors.Is(err, redis.ErrConflict):
+			statusCode, body = 409, "Conflict: "+err.Error()
+		}
+		return Response{
+			StatusCode: statusCode,
+			Body:       body,
+		}, nil
+	}
+
+	// start the devices, the ones that failed to start are refunded
+	devices := map[string]redis.Android{}
+	for _, d := range f.Devices {
+		devices[d.DeviceID] = d
+	}
+	outcomes := fleets.Each(ctx, f.DeviceIDs(), func(ctx context.Context, deviceID string) (any, error) {
+		d := devices[deviceID]
+		return nil, AgentClient.RunEmulator(ctx, agent.EmulatorConfig{
+			ContainerName: d.DeviceID,
+			Port:          d.Port,
+			DeviceName:    d.DeviceName,
+			AndroidAPI:    d.AndroidAPI,
+			Owner:         d.Username,
+			Locale:        d.Locale,
+		})
+	})
+
+	var statuses []string
+	refund := 0
+	for i, outcome := range outcomes {
+		if !outcome.Success {
+			log.Printf("failed to start fleet device %s: %s", outcome.DeviceID, outcome.Error)
+			f.Devices[i].Status = fleets.StatusFailed
+			refund += FleetPolicy.DevicePrice()
+			if err := AndroidService.FreePort(ctx, f.Devices[i].Port); err != nil {
+				log.Printf("failed to free port of %s: %v", outcome.DeviceID, err)
+			}
+		}
+		statuses = append(statuses, f.Devices[i].Status)
+	}
+	if refund > 0 {
+		if err := UserService.UpdateUserBalance(ctx, claims.Username, refund); err != nil {
+			log.Printf("failed to refund fleet %s: %v", f.ID, err)
+		} else {
+			f.Cost -= refund
+		}
+		if err := AndroidService.SaveFleet(ctx, f); err != nil {
+			log.Printf("failed to save fleet %s: %v", f.ID, err)
+		}
+	}
+
+	res, err := json.Marshal(fleet{Fleet: f, Readiness: fleets.Aggregate(statuses)})
+	if err != nil {
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to marshal response",
+		}, nil
+	}
+
+	return Response{
+		StatusCode: 201,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(res),
+	}, nil
+}
+
+// freePorts frees the ports reserved for devices of a fleet that was not
+// created.
+func freePorts(ctx context.Context, devices []redis.Android) {
+	for _, d := range devices {
+		if err := AndroidService.FreePort(ctx, d.Port); err != nil {
+			log.Printf("failed to free port of %s: %v", d.DeviceID, err)
+		}
+	}
+}
+
+func main() {
+
+	config, err := config.InitConfig()
+	if err != nil {
+		log.Fatalf("failed to init config: %v", err)
+	}
+
+	redisClient := redis.NewUniversalRedisClient(config.Redis)
+	UserService = redis.NewUserService(redisClient)
+	AndroidService = redis.NewAndroidService(redisClient)
+	AgentClient = agent.NewClient(config.Agent)
+	FleetPolicy = fleets.NewPolicy(config.Fleets)
+
+	TokenMaker, err = token.NewPasetoMaker(config.Token.SecretKey)
+	if err != nil {
+		log.Fatalf("failed to init token maker: %v", err)
+	}
+
+	// log redis ping
+	_, err = redisClient.Ping(context.Background()).Result()
+	if err != nil {
+		log.Fatalf("failed to ping redis: %v", err)
+	} else {
+		log.Println("redis pinged successfully")
+	}
+
+	defer redisClient.Close()
+
+	lambda.Start(Handler)
+
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/config"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+type Response events.APIGatewayProxyResponse
+
+var TokenMaker token.Maker
+var AgentClient *agent.Client
+
+func Handler(request events.APIGatewayProxyRequest) (Response, error) {
+	head := request.Headers
+
+	ctx := context.Background()
+
+	// check Authorization in headers
+	auth, ok := head["Authorization"]
+	if !ok {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Missing Authorization header",
+		}, nil
+	}
+
+	// validate token
+	claims, err := TokenMaker.VerifyAccessToken(auth)
+	if err != nil {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Invalid access token",
+		}, nil
+	}
+
+	name := request.QueryStringParameters["name"]
+	androidAPI := request.QueryStringParameters["android_api"]
+	if name == "" || androidAPI == "" {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: Missing volume name or android_api",
+		}, nil
+	}
+
+	// delete the volume, which no device may use
+	err = AgentClient.DeleteVolume(ctx, claims.Username, androidAPI, name)
+	if err != nil {
+		var agentErr *agent.Error
+		if errors.As(err, &agentErr) && agentErr.StatusCode == http.StatusNotFound {
+			return Response{
+				StatusCode: 404,
+				Body:       "Not Found: Volume not found",
+			}, nil
+		}
+		if errors.As(err, &agentErr) && agentErr.StatusCode < 500 {
+			return Response{
+				StatusCode: agentErr.StatusCode,
+				Body:       agentErr.Message,
+			}, nil
+		}
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to delete volume",
+		}, nil
+	}
+
+	return Response{
+		StatusCode: 200,
+		Body:       "Success: Volume deleted",
+	}, nil
+}
+
+func main() {
+
+	config, err := config.InitConfig()
+	if err != nil {
+		log.Fatalf("failed to init config: %v", err)
+	}
+
+	AgentClient = agent.NewClient(config.Agent)
+
+	TokenMaker, err = token.NewPasetoMaker(config.Token.SecretKey)
+	if err != nil {
+		log.Fatalf("failed to init token maker: %v", err)
+	}
+
+	lambda.Start(Handler)
+
+}
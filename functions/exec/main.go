@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/config"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/redis"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+type Response events.APIGatewayProxyResponse
+
+var TokenMaker token.Maker
+var AndroidService *redis.AndroidService
+var AgentClient *agent.Client
+
+func Handler(request events.APIGatewayProxyRequest) (Response, error) {
+	head := request.Headers
+
+	ctx := context.Background()
+
+	// check Authorization in headers
+	auth, ok := head["Authorization"]
+	if !ok {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Missing Authorization header",
+		}, nil
+	}
+
+	// validate token
+	claims, err := TokenMaker.VerifyAccessToken(auth)
+	if err != nil {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Invalid access token",
+		}, nil
+	}
+
+	// get device from redis
+	android, err := AndroidService.GetAndroid(ctx, claims.Username+"-Device")
+	if err != nil {
+		if err == redis.ErrNotFound {
+			return Response{
+				StatusCode: 404,
+				Body:       "Not Found: Device not found",
+			}, nil
+		}
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to get device",
+		}, nil
+	}
+
+	var execRequest agent.ExecRequest
+	if err := json.Unmarshal([]byte(request.Body), &execRequest); err != nil {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: Invalid JSON body",
+		}, nil
+	}
+	execRequest.Owner = claims.Username
+	execRequest.Role = claims.Role
+
+	// run the command on the device
+	result, err := AgentClient.Exec(ctx, android.DeviceID, execRequest)
+	if err != nil {
+		var agentErr *agent.Error
+		if errors.As(err, &agentErr) && agentErr.StatusCode < 500 {
+			return Response{
+				StatusCode: agentErr.StatusCode,
+				Body:       agentErr.Message,
+			}, nil
+		}
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to run command",
+		}, nil
+	}
+
+	res, err := json.Marshal(result)
+	if err != nil {
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to marshal response",
+		}, nil
+	}
+
+	return Response{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(res),
+	}, nil
+}
+
+func main() {
+
+	config, err := config.InitConfig()
+	if err != nil {
+		log.Fatalf("failed to init config: %v", err)
+	}
+
+	redisClient := redis.NewUniversalRedisClient(config.Redis)
+	AndroidService = redis.NewAndroidService(redisClient)
+	AgentClient = agent.NewClient(config.Agent)
+
+	TokenMaker, err = token.NewPasetoMaker(config.Token.SecretKey)
+	if err != nil {
+		log.Fatalf("failed to init token maker: %v", err)
+	}
+
+	// log redis ping
+	_, err = redisClient.Ping(context.Background()).Result()
+	if err != nil {
+		log.Fatalf("failed to ping redis: %v", err)
+	} else {
+		log.Println("redis pinged successfully")
+	}
+
+	defer redisClient.Close()
+
+	lambda.Start(Handler)
+
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
 	"github.com/SajjadManafi/android-emulator-serverless/internal/config"
 	"github.com/SajjadManafi/android-emulator-serverless/internal/redis"
 	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
@@ -21,6 +22,13 @@ type Response events.APIGatewayProxyResponse
 var UserService *redis.UserService
 var TokenMaker token.Maker
 var AndroidService *redis.AndroidService
+var AgentClient *agent.Client
+
+// device is the device record with its simulated location.
+type device struct {
+	redis.Android
+	Location *agent.Location `json:"location,omitempty"`
+}
 
 func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	head := request.Headers
@@ -71,8 +79,18 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 
 	androidData.Status = status
 
+	response := device{Android: androidData}
+
+	// the location is only known once it was set
+	location, err := AgentClient.GetLocation(ctx, androidData.DeviceID)
+	if err != nil {
+		log.Printf("failed to get location of %s: %v", androidData.DeviceID, err)
+	} else if location.Mode != "" {
+		response.Location = &location
+	}
+
 	// marshal android data
-	androidDataJSON, err := json.Marshal(androidData)
+	androidDataJSON, err := json.Marshal(response)
 	if err != nil {
 		return Response{
 			StatusCode: 500,
@@ -100,6 +118,7 @@ func main() {
 	redisClient := redis.NewUniversalRedisClient(config.Redis)
 	UserService = redis.NewUserService(redisClient)
 	AndroidService = redis.NewAndroidService(redisClient)
+	AgentClient = agent.NewClient(config.Agent)
 
 	TokenMaker, err = token.NewPasetoMaker(config.Token.SecretKey)
 	if err != nil {
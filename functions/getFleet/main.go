@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/config"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/fleets"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/redis"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+type Response events.APIGatewayProxyResponse
+
+var TokenMaker token.Maker
+var AndroidService *redis.AndroidService
+var AgentClient *agent.Client
+
+type fleet struct {
+	redis.Fleet
+	Readiness fleets.Readiness `json:"readiness"`
+}
+
+func Handler(request events.APIGatewayProxyRequest) (Response, error) {
+	head := request.Headers
+
+	ctx := context.Background()
+
+	// check Authorization in headers
+	auth, ok := head["Authorization"]
+	if !ok {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Missing Authorization header",
+		}, nil
+	}
+
+	// validate token
+	claims, err := TokenMaker.VerifyAccessToken(auth)
+	if err != nil {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Invalid access token",
+		}, nil
+	}
+
+	if id := request.QueryStringParameters["id"]; id != "" {
+		f, err := AndroidService.GetFleet(ctx, claims.Username, id)
+		if err != nil {
+			if err == redis.ErrFleetNotFound {
+				return Response{
+					StatusCode: 404,
+					Body:       "Not Found: Fleet not found",
+				}, nil
+			}
+			return Response{
+				StatusCode: 500,
+				Body:       "Internal Server Error: Failed to get fleet",
+			}, nil
+		}
+
+		return marshal(withReadiness(ctx, f))
+	}
+
+	list, err := AndroidService.ListFleets(ctx, claims.Username)
+	if err != nil {
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to list fleets",
+		}, nil
+	}
+
+	response := make([]fleet, 0, len(list))
+	for _, f := range list {
+		response = append(response, withReadiness(ctx, f))
+	}
+
+	return marshal(response)
+}
+
+// withReadiness fills the status of each device of the fleet, as reported
+// by the device, and their aggregate readiness.
+func withReadiness(ctx context.Context, f redis.Fleet) fleet {
+	outcomes := fleets.Each(ctx, f.DeviceIDs(), func(ctx context.Context, deviceID string) (any, error) {
+		return AgentClient.DeviceStatus(ctx, deviceID)
+	})
+
+	var statuses []string
+	for i, outcome := range outcomes {
+		// a device that failed to start has no status of its own
+		if f.Devices[i].Status != fleets.StatusFailed {
+			if outcome.Success {
+				f.Devices[i].Status = outcome.Result.(string)
+			} else {
+				log.Printf("failed to get status of %s: %s", outcome.DeviceID, outcome.Error)
+				f.Devices[i].Status = fleets.StatusFailed
+			}
+		}
+		statuses = append(statuses, f.Devices[i].Status)
+	}
+
+	return fleet{Fleet: f, Readiness: fleets.Aggregate(statuses)}
+}
+
+func marshal(v any) (Response, error) {
+	res, err := json.Marshal(v)
+	if err != nil {
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to marshal response",
+		}, nil
+	}
+
+	return Response{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(res),
+	}, nil
+}
+
+func main() {
+
+	config, err := config.InitConfig()
+	if err != nil {
+		log.Fatalf("failed to init config: %v", err)
+	}
+
+	redisClient := redis.NewUniversalRedisClient(config.Redis)
+	AndroidService = redis.NewAndroidService(redisClient)
+	AgentClient = agent.NewClient(config.Agent)
+
+	TokenMaker, err = token.NewPasetoMaker(config.Token.SecretKey)
+	if err != nil {
+		log.Fatalf("failed to init token maker: %v", err)
+	}
+
+	// log redis ping
+	_, err = redisClient.Ping(context.Background()).Result()
+	if err != nil {
+		log.Fatalf("failed to ping redis: %v", err)
+	} else {
+		log.Println("redis pinged successfully")
+	}
+
+	defer redisClient.Close()
+
+	lambda.Start(Handler)
+
+}
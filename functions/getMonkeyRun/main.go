@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/config"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+type Response events.APIGatewayProxyResponse
+
+var TokenMaker token.Maker
+var AgentClient *agent.Client
+
+func Handler(request events.APIGatewayProxyRequest) (Response, error) {
+	head := request.Headers
+
+	ctx := context.Background()
+
+	// check Authorization in headers
+	auth, ok := head["Authorization"]
+	if !ok {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Missing Authorization header",
+		}, nil
+	}
+
+	// validate token
+	claims, err := TokenMaker.VerifyAccessToken(auth)
+	if err != nil {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Invalid token",
+		}, nil
+	}
+
+	// get the monkey run from the agent, without an id all the runs of the user
+	var runs any
+	if id := request.QueryStringParameters["id"]; id != "" {
+		runs, err = AgentClient.GetMonkeyRun(ctx, claims.Username, id)
+	} else {
+		runs, err = AgentClient.ListMonkeyRuns(ctx, claims.Username)
+	}
+	if err != nil {
+		var agentErr *agent.Error
+		if errors.As(err, &agentErr) && agentErr.StatusCode == 404 {
+			return Response{
+				StatusCode: 404,
+				Body:       "Not Found: Monkey run not found",
+			}, nil
+		}
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal server error",
+		}, nil
+	}
+
+	runsJSON, err := json.Marshal(runs)
+	if err != nil {
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal server error",
+		}, nil
+	}
+
+	return Response{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type":                  "application/json",
+			"Access-Control-Expose-Headers": "Authorization",
+		},
+		Body: string(runsJSON),
+	}, nil
+}
+
+func main() {
+
+	config, err := config.InitConfig()
+	if err != nil {
+		log.Fatalf("failed to init config: %v", err)
+	}
+
+	AgentClient = agent.NewClient(config.Agent)
+
+	TokenMaker, err = token.NewPasetoMaker(config.Token.SecretKey)
+	if err != nil {
+		log.Fatalf("failed to init token maker: %v", err)
+	}
+
+	lambda.Start(Handler)
+
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/config"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/redis"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/volumes"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+type Response events.APIGatewayProxyResponse
+
+var TokenMaker token.Maker
+var AndroidService *redis.AndroidService
+var AgentClient *agent.Client
+
+// defaultImportVolume names the volume of an imported device by default.
+const defaultImportVolume = "imported"
+
+// importRequest names the archive to create the device from, exported by
+// the user From, and the volume of the new device.
+type importRequest struct {
+	From       string `json:"from"`
+	ArtifactID string `json:"artifact_id"`
+	Volume     string `json:"volume,omitempty"`
+}
+
+func Handler(request events.APIGatewayProxyRequest) (Response, error) {
+	head := request.Headers
+
+	ctx := context.Background()
+
+	// check Authorization in headers
+	auth, ok := head["Authorization"]
+	if !ok {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Missing Authorization header",
+		}, nil
+	}
+
+	// validate token
+	claims, err := TokenMaker.VerifyAccessToken(auth)
+	if err != nil {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Invalid access token",
+		}, nil
+	}
+
+	var req importRequest
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: Invalid request body",
+		}, nil
+	}
+	if req.From == "" || req.ArtifactID == "" {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: Missing from or artifact_id",
+		}, nil
+	}
+	if req.Volume == "" {
+		req.Volume = defaultImportVolume
+	}
+
+	// the imported device replaces no device of the user
+	_, err = AndroidService.GetAndroid(ctx, claims.Username+"-Device")
+	if err == nil {
+		return Response{
+			StatusCode: 409,
+			Body:       "Conflict: android device already exists",
+		}, nil
+	} else if err != redis.ErrNotFound {
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to get device",
+		}, nil
+	}
+
+	// read the archive, which must be shared with the user
+	manifest, err := AgentClient.DeviceArchive(ctx, req.From, req.ArtifactID, claims.Username)
+	if err != nil {
+		return agentError(err, "Internal Server Error: Failed to read device state archive"), nil
+	}
+	if err := volumes.Check(req.Volume, manifest.AndroidAPI); err != nil {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: " + err.Error(),
+		}, nil
+	}
+
+	port, err := AndroidService.GetRandomPort(ctx)
+	if err != nil {
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to get random port",
+		}, nil
+	}
+
+	android := redis.Android{
+		DeviceID:       claims.Username + "-Device",
+		Username:       claims.Username,
+		Port:           port,
+		StartTimestamp: time.Now().Unix(),
+		AndroidAPI:     manifest.AndroidAPI,
+		DeviceName:     manifest.DeviceName,
+		Status:         "pending",
+		Volume:         req.Volume,
+	}
+
+	if err := AndroidService.RegisterAndroid(ctx, android); err != nil {
+		AndroidService.FreePort(ctx, port)
+		if err == redis.ErrAlreadyExists {
+			return Response{
+				StatusCode: 409,
+				Body:       "Conflict: android device already exists",
+			}, nil
+		}
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to register android device",
+		}, nil
+	}
+
+	// write the data into a new volume of the user and start the device
+	_, err = AgentClient.ImportDevice(ctx, req.From, req.ArtifactID, agent.ImportRequest{
+		ContainerName: android.DeviceID,
+		Port:          port,
+		DeviceName:    android.DeviceName,
+		Owner:         claims.Username,
+		Volume:        android.Volume,
+	})
+	if err != nil {
+		AndroidService.DeleteAndroid(ctx, android.DeviceID)
+		AndroidService.FreePort(ctx, port)
+		return agentError(err, "Internal Server Error: Failed to import device"), nil
+	}
+
+	res, err := json.Marshal(android)
+	if err != nil {
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to marshal response",
+		}, nil
+	}
+
+	return Response{
+		StatusCode: 201,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(res),
+	}, nil
+}
+
+// agentError passes the client errors of the agent through.
+func agentError(err error, message string) Response {
+	var agentErr *agent.Error
+	if errors.As(err, &agentErr) && agentErr.StatusCode < 500 {
+		return Response{
+			StatusCode: agentErr.StatusCode,
+			Body:       agentErr.Message,
+		}
+	}
+	return Response{
+		StatusCode: 500,
+		Body:       message,
+	}
+}
+
+func main() {
+
+	config, err := config.InitConfig()
+	if err != nil {
+		log.Fatalf("failed to init config: %v", err)
+	}
+
+	redisClient := redis.NewUniversalRedisClient(config.Redis)
+	AndroidService = redis.NewAndroidService(redisClient)
+	AgentClient = agent.NewClient(config.Agent)
+
+	TokenMaker, err = token.NewPasetoMaker(config.Token.SecretKey)
+	if err != nil {
+		log.Fatalf("failed to init token maker: %v", err)
+	}
+
+	// log redis ping
+	_, err = redisClient.Ping(context.Background()).Result()
+	if err != nil {
+		log.Fatalf("failed to ping redis: %v", err)
+	} else {
+		log.Println("redis pinged successfully")
+	}
+
+	defer redisClient.Close()
+
+	lambda.Start(Handler)
+
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/config"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/fleets"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/redis"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+type Response events.APIGatewayProxyResponse
+
+var TokenMaker token.Maker
+var AndroidService *redis.AndroidService
+var AgentClient *agent.Client
+
+func Handler(request events.APIGatewayProxyRequest) (Response, error) {
+	head := request.Headers
+
+	ctx := context.Background()
+
+	// check Authorization in headers
+	auth, ok := head["Authorization"]
+	if !ok {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Missing Authorization header",
+		}, nil
+	}
+
+	// validate token
+	claims, err := TokenMaker.VerifyAccessToken(auth)
+	if err != nil {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Invalid access token",
+		}, nil
+	}
+
+	id := request.QueryStringParameters["id"]
+	if id == "" {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: Missing fleet id",
+		}, nil
+	}
+
+	f, err := AndroidService.GetFleet(ctx, claims.Username, id)
+	if err != nil {
+		if err == redis.ErrFleetNotFound {
+			return Response{
+				StatusCode: 404,
+				Body:       "Not Found: Fleet not found",
+			}, nil
+		}
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to get fleet",
+		}, nil
+	}
+
+	// the APK is sent as the raw request body
+	app := []byte(request.Body)
+	if request.IsBase64Encoded {
+		app, err = base64.StdEncoding.DecodeString(request.Body)
+		if err != nil {
+			return Response{
+				StatusCode: 400,
+				Body:       "Bad Request: Invalid base64 body",
+			}, nil
+		}
+	}
+
+	if len(app) == 0 {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: Missing APK",
+		}, nil
+	}
+
+	opts := agent.ParseInstallOptions(queryValues(request.QueryStringParameters))
+
+	// install the app on all the devices of the fleet
+	outcomes := fleets.Each(ctx, f.DeviceIDs(), func(ctx context.Context, deviceID string) (any, error) {
+		result, err := AgentClient.InstallApp(ctx, deviceID, bytes.NewReader(app), opts)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Success {
+			return result, errors.New(result.Message)
+		}
+		return result, nil
+	})
+
+	res, err := json.Marshal(fleets.NewBulkResult(f.ID, outcomes))
+	if err != nil {
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to marshal response",
+		}, nil
+	}
+
+	return Response{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(res),
+	}, nil
+}
+
+func queryValues(params map[string]string) url.Values {
+	values := url.Values{}
+	for key, value := range params {
+		values[key] = []string{value}
+	}
+	return values
+}
+
+func main() {
+
+	config, err := config.InitConfig()
+	if err != nil {
+		log.Fatalf("failed to init config: %v", err)
+	}
+
+	redisClient := redis.NewUniversalRedisClient(config.Redis)
+	AndroidService = redis.NewAndroidService(redisClient)
+	AgentClient = agent.NewClient(config.Agent)
+
+	TokenMaker, err = token.NewPasetoMaker(config.Token.SecretKey)
+	if err != nil {
+		log.Fatalf("failed to init token maker: %v", err)
+	}
+
+	// log redis ping
+	_, err = redisClient.Ping(context.Background()).Result()
+	if err != nil {
+		log.Fatalf("failed to ping redis: %v", err)
+	} else {
+		log.Println("redis pinged successfully")
+	}
+
+	defer redisClient.Close()
+
+	lambda.Start(Handler)
+
+}
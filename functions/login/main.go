@@ -60,8 +60,16 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 		}, nil
 	}
 
+	stored, err := UserService.GetUser(context.Background(), user.UserName)
+	if err != nil {
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal server error. Please try again later.",
+		}, nil
+	}
+
 	// create token
-	token, err := TokenMaker.CreateAccessToken(user.UserName, user.Name)
+	token, err := TokenMaker.CreateAccessToken(user.UserName, user.Name, stored.Role)
 	if err != nil {
 		return Response{
 			StatusCode: 500,
@@ -31,8 +31,9 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 		}, nil
 	}
 
-	// set default balance
+	// set default balance and role
 	user.Balance = 150
+	user.Role = redis.RoleUser
 
 	err = UserService.RegisterUser(context.Background(), user)
 	if err != nil {
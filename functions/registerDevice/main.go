@@ -1,17 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
-	"net/http"
 	"time"
 
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
 	"github.com/SajjadManafi/android-emulator-serverless/internal/config"
 	"github.com/SajjadManafi/android-emulator-serverless/internal/redis"
 	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/volumes"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 )
@@ -21,6 +21,7 @@ type Response events.APIGatewayProxyResponse
 var UserService *redis.UserService
 var TokenMaker token.Maker
 var AndroidService *redis.AndroidService
+var AgentClient *agent.Client
 
 func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 	req := request.Body
@@ -57,6 +58,16 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 		}, nil
 	}
 
+	// a persistent volume is named, without one the device starts fresh
+	if android.Volume != "" {
+		if err := volumes.Check(android.Volume, android.AndroidAPI); err != nil {
+			return Response{
+				StatusCode: 400,
+				Body:       "Invalid request: " + err.Error(),
+			}, nil
+		}
+	}
+
 	// Get random port
 	port, err := AndroidService.GetRandomPort(ctx)
 	if err != nil {
@@ -76,11 +87,14 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 		AndroidAPI:     android.AndroidAPI,
 		DeviceName:     android.DeviceName,
 		Status:         "pending",
+		Volume:         android.Volume,
+		Appium:         android.Appium,
 	}
 
 	// Register android
 	err = AndroidService.RegisterAndroid(ctx, android)
 	if err != nil {
+		AndroidService.FreePort(ctx, port)
 		if err == redis.ErrAlreadyExists {
 			return Response{
 				StatusCode: 409,
@@ -103,13 +117,22 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 		}, nil
 	}
 
-	// Run docker
-	err = runDockerAndroidEmulator(android.DeviceID, port, android.DeviceName, android.AndroidAPI)
+	// Run docker, a device that did not start is forgotten so that it can
+	// be registered again
+	err = AgentClient.RunEmulator(ctx, agent.EmulatorConfig{
+		ContainerName: android.DeviceID,
+		Port:          android.Port,
+		DeviceName:    android.DeviceName,
+		AndroidAPI:    android.AndroidAPI,
+		Owner:         android.Username,
+		Volume:        android.Volume,
+		Appium:        android.Appium,
+	})
 	if err != nil {
-		return Response{
-			StatusCode: 500,
-			Body:       "Internal Server Error: failed to run docker android emulator",
-		}, nil
+		log.Printf("failed to run emulator of %s: %v", android.DeviceID, err)
+		AndroidService.DeleteAndroid(ctx, android.DeviceID)
+		AndroidService.FreePort(ctx, port)
+		return agentError(err, "Internal Server Error: failed to run docker android emulator"), nil
 	}
 
 	return Response{
@@ -122,6 +145,22 @@ func Handler(request events.APIGatewayProxyRequest) (Response, error) {
 
 }
 
+// agentError passes the client errors of the agent, as a volume in use,
+// through and hides the others.
+func agentError(err error, message string) Response {
+	var agentErr *agent.Error
+	if errors.As(err, &agentErr) && agentErr.StatusCode < 500 {
+		return Response{
+			StatusCode: agentErr.StatusCode,
+			Body:       agentErr.Message,
+		}
+	}
+	return Response{
+		StatusCode: 500,
+		Body:       message,
+	}
+}
+
 func main() {
 	config, err := config.InitConfig()
 	if err != nil {
@@ -131,6 +170,7 @@ func main() {
 	redisClient := redis.NewUniversalRedisClient(config.Redis)
 	UserService = redis.NewUserService(redisClient)
 	AndroidService = redis.NewAndroidService(redisClient)
+	AgentClient = agent.NewClient(config.Agent)
 
 	TokenMaker, err = token.NewPasetoMaker(config.Token.SecretKey)
 	if err != nil {
@@ -149,52 +189,3 @@ func main() {
 
 	lambda.Start(Handler)
 }
-
-// AndroidConfig represents the payload to start an Android emulator
-type AndroidConfig struct {
-	ContainerName string `json:"containerName"`
-	Port          int    `json:"port"`
-	DeviceName    string `json:"DeviceName"`
-	AndroidAPI    string `json:"AndroidAPI"`
-}
-
-func runDockerAndroidEmulator(ContainerName string, port int, deviceName string, androidAPI string) error {
-	config := AndroidConfig{
-		ContainerName: ContainerName,
-		Port:          port,
-		DeviceName:    deviceName,
-		AndroidAPI:    androidAPI,
-	}
-
-	// Convert config to JSON
-	data, err := json.Marshal(config)
-	if err != nil {
-		fmt.Println("Error marshalling JSON:", err)
-		return err
-	}
-
-	// Create a new request
-	url := "http://172.17.0.1:8080/run-emulator"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
-	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return err
-	}
-
-	// Set the content type to application/json
-	req.Header.Set("Content-Type", "application/json")
-
-	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error executing request:", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	log.Println("response Status:", resp.Status)
-	log.Println("emulator created successfully")
-
-	return nil
-}
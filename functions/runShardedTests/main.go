@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"strconv"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/config"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/redis"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+type Response events.APIGatewayProxyResponse
+
+var TokenMaker token.Maker
+var AndroidService *redis.AndroidService
+var AgentClient *agent.Client
+
+func Handler(request events.APIGatewayProxyRequest) (Response, error) {
+	head := request.Headers
+
+	ctx := context.Background()
+
+	// check Authorization in headers
+	auth, ok := head["Authorization"]
+	if !ok {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Missing Authorization header",
+		}, nil
+	}
+
+	// validate token
+	claims, err := TokenMaker.VerifyAccessToken(auth)
+	if err != nil {
+		return Response{
+			StatusCode: 401,
+			Body:       "Unauthorized: Invalid access token",
+		}, nil
+	}
+
+	params := request.QueryStringParameters
+	opts := agent.ShardOptions{
+		Strategy:   params["strategy"],
+		AndroidAPI: params["android_api"],
+		DeviceName: params["device_name"],
+	}
+	if opts.Shards, err = strconv.Atoi(params["shards"]); err != nil {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: shards must be an integer",
+		}, nil
+	}
+	if v := params["retries"]; v != "" {
+		if opts.Retries, err = strconv.Atoi(v); err != nil {
+			return Response{
+				StatusCode: 400,
+				Body:       "Bad Request: retries must be an integer",
+			}, nil
+		}
+	}
+
+	// the device of the user runs a shard unless use_device is false, the
+	// devices provisioned for the other shards default to its configuration
+	var containerName string
+	if useDevice, err := strconv.ParseBool(params["use_device"]); err != nil || useDevice {
+		android, err := AndroidService.GetAndroid(ctx, claims.Username+"-Device")
+		if err != nil && err != redis.ErrNotFound {
+			return Response{
+				StatusCode: 500,
+				Body:       "Internal Server Error: Failed to get device",
+			}, nil
+		}
+		if err == nil {
+			containerName = android.DeviceID
+			if opts.AndroidAPI == "" {
+				opts.AndroidAPI = android.AndroidAPI
+			}
+			if opts.DeviceName == "" {
+				opts.DeviceName = android.DeviceName
+			}
+		}
+	}
+	if containerName == "" && (opts.AndroidAPI == "" || opts.DeviceName == "") {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: android_api and device_name are required without a device",
+		}, nil
+	}
+
+	// the APKs and runner arguments are sent as a multipart form
+	form := []byte(request.Body)
+	if request.IsBase64Encoded {
+		form, err = base64.StdEncoding.DecodeString(request.Body)
+		if err != nil {
+			return Response{
+				StatusCode: 400,
+				Body:       "Bad Request: Invalid base64 body",
+			}, nil
+		}
+	}
+
+	contentType := head["Content-Type"]
+	if contentType == "" {
+		contentType = head["content-type"]
+	}
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "multipart/form-data" {
+		return Response{
+			StatusCode: 400,
+			Body:       "Bad Request: Expected a multipart/form-data body with the app and test APKs",
+		}, nil
+	}
+
+	// start the run, it is polled with getTestRun
+	run, err := AgentClient.RunShardedTests(ctx, containerName, claims.Username, opts, bytes.NewReader(form), contentType)
+	if err != nil {
+		var agentErr *agent.Error
+		if errors.As(err, &agentErr) && agentErr.StatusCode < 500 {
+			return Response{
+				StatusCode: agentErr.StatusCode,
+				Body:       agentErr.Message,
+			}, nil
+		}
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to run tests",
+		}, nil
+	}
+
+	res, err := json.Marshal(run)
+	if err != nil {
+		return Response{
+			StatusCode: 500,
+			Body:       "Internal Server Error: Failed to marshal response",
+		}, nil
+	}
+
+	return Response{
+		StatusCode: 202,
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+		Body: string(res),
+	}, nil
+}
+
+func main() {
+
+	config, err := config.InitConfig()
+	if err != nil {
+		log.Fatalf("failed to init config: %v", err)
+	}
+
+	redisClient := redis.NewUniversalRedisClient(config.Redis)
+	AndroidService = redis.NewAndroidService(redisClient)
+	AgentClient = agent.NewClient(config.Agent)
+
+	TokenMaker, err = token.NewPasetoMaker(config.Token.SecretKey)
+	if err != nil {
+		log.Fatalf("failed to init token maker: %v", err)
+	}
+
+	// log redis ping
+	_, err = redisClient.Ping(context.Background()).Result()
+	if err != nil {
+		log.Fatalf("failed to ping redis: %v", err)
+	} else {
+		log.Println("redis pinged successfully")
+	}
+
+	defer redisClient.Close()
+
+	lambda.Start(Handler)
+
+}
@@ -0,0 +1,184 @@
+package adb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// Address of the adb server, usually 127.0.0.1:5037.
+	ServerAddress string `mapstructure:"serverAddress"`
+}
+
+const dialTimeout = 5 * time.Second
+
+var ErrConnectFailed = errors.New("adb connect failed")
+
+// ServerError is returned when the adb server answers a request with FAIL.
+type ServerError struct {
+	Request string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("adb request %q failed: %s", e.Request, e.Message)
+}
+
+// Client talks to an adb server using the host protocol.
+type Client struct {
+	addr string
+}
+
+func NewClient(cfg *Config) *Client {
+	return &Client{addr: cfg.ServerAddress}
+}
+
+// dial opens a new connection to the adb server. The connection is closed
+// when ctx is done, which aborts any blocked read or write.
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return newConn(ctx, netConn), nil
+}
+
+// hostRequest sends a host service request and returns its length-prefixed answer.
+func (c *Client) hostRequest(ctx context.Context, request string) (string, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := conn.request(request); err != nil {
+		return "", conn.err(err)
+	}
+
+	answer, err := conn.readString()
+	if err != nil {
+		return "", conn.err(err)
+	}
+
+	return answer, nil
+}
+
+// Version returns the version of the adb server.
+func (c *Client) Version(ctx context.Context) (int, error) {
+	answer, err := c.hostRequest(ctx, "host:version")
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.ParseInt(answer, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid adb version %q", answer)
+	}
+
+	return int(version), nil
+}
+
+// Connect asks the adb server to connect to a device over TCP, serial is host:port.
+func (c *Client) Connect(ctx context.Context, serial string) error {
+	answer, err := c.hostRequest(ctx, "host:connect:"+serial)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(answer, "connected to") && !strings.HasPrefix(answer, "already connected to") {
+		return fmt.Errorf("%w: %s", ErrConnectFailed, answer)
+	}
+
+	return nil
+}
+
+// Disconnect asks the adb server to drop a TCP device.
+func (c *Client) Disconnect(ctx context.Context, serial string) error {
+	_, err := c.hostRequest(ctx, "host:disconnect:"+serial)
+	return err
+}
+
+// Device returns a handle to the device with the given serial.
+func (c *Client) Device(serial string) *Device {
+	return &Device{client: c, serial: serial}
+}
+
+// Device runs services on a single device through the adb server.
+type Device struct {
+	client *Client
+	serial string
+}
+
+// Serial returns the serial of the device.
+func (d *Device) Serial() string {
+	return d.serial
+}
+
+// open switches a new connection to the device and starts a service on it.
+func (d *Device) open(ctx context.Context, service string) (*conn, error) {
+	conn, err := d.client.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.request("host:transport:" + d.serial); err != nil {
+		conn.Close()
+		return nil, conn.err(err)
+	}
+
+	if err := conn.request(service); err != nil {
+		conn.Close()
+		return nil, conn.err(err)
+	}
+
+	return conn, nil
+}
+
+// Shell starts a shell command and returns its combined output as a
+// stream. Closing the stream or cancelling ctx terminates the command.
+func (d *Device) Shell(ctx context.Context, command string) (io.ReadCloser, error) {
+	return d.open(ctx, "shell:"+command)
+}
+
+// ExecOut starts a command without a pty and returns its raw stdout, which
+// makes it suitable for binary output such as screencap.
+func (d *Device) ExecOut(ctx context.Context, command string) (io.ReadCloser, error) {
+	return d.open(ctx, "exec:"+command)
+}
+
+// Reboot restarts the device. The answer comes before the device goes
+// down, so the device may still be online right after Reboot returns.
+func (d *Device) Reboot(ctx context.Context) error {
+	conn, err := d.open(ctx, "reboot:")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = io.Copy(io.Discard, conn)
+	return err
+}
+
+// RunShell runs a shell command and returns its whole output.
+func (d *Device) RunShell(ctx context.Context, command string) (string, error) {
+	stream, err := d.Shell(ctx, command)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	out, err := io.ReadAll(stream)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
@@ -0,0 +1,158 @@
+package adb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+const testSerial = "127.0.0.1:5555"
+
+func newFake(t *testing.T) (*FakeServer, *Client) {
+	t.Helper()
+
+	srv, err := NewFakeServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv, NewClient(&Config{ServerAddress: srv.Addr()})
+}
+
+func TestVersion(t *testing.T) {
+	_, c := newFake(t)
+
+	version, err := c.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0x29 {
+		t.Errorf("version = %d, want %d", version, 0x29)
+	}
+}
+
+func TestConnectAndTransport(t *testing.T) {
+	srv, c := newFake(t)
+	ctx := context.Background()
+
+	if err := c.Connect(ctx, testSerial); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Device(testSerial).RunShell(ctx, "true"); err != nil {
+		t.Fatalf("shell after connect: %v", err)
+	}
+
+	if err := c.Disconnect(ctx, testSerial); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Device(testSerial).RunShell(ctx, "true")
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Request != "host:transport:"+testSerial {
+		t.Fatalf("err = %v, want a ServerError of host:transport", err)
+	}
+
+	want := []string{
+		"host:connect:" + testSerial,
+		"host:transport:" + testSerial, "shell:true",
+		"host:disconnect:" + testSerial,
+		"host:transport:" + testSerial,
+	}
+	if got := srv.Commands(); !slices.Equal(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestShell(t *testing.T) {
+	srv, c := newFake(t)
+	srv.AddDevice(testSerial)
+	srv.HandleShell(func(serial string, command string) ([]byte, error) {
+		if serial != testSerial {
+			t.Errorf("serial = %q", serial)
+		}
+		if command == "getprop ro.build.version.sdk" {
+			return []byte("34\n"), nil
+		}
+		return nil, errors.New("/system/bin/sh: " + command + ": not found")
+	})
+	device := c.Device(testSerial)
+
+	out, err := device.RunShell(context.Background(), "getprop ro.build.version.sdk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "34\n" {
+		t.Errorf("out = %q", out)
+	}
+
+	// The shell service mixes errors into the output.
+	out, err = device.RunShell(context.Background(), "nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "/system/bin/sh: nope: not found\n" {
+		t.Errorf("out = %q", out)
+	}
+}
+
+func TestExecOut(t *testing.T) {
+	srv, c := newFake(t)
+	srv.AddDevice(testSerial)
+	png := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0xff}
+	srv.HandleShell(func(serial string, command string) ([]byte, error) {
+		return png, nil
+	})
+
+	stream, err := c.Device(testSerial).ExecOut(context.Background(), "screencap -p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	out, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(out, png) {
+		t.Errorf("out = %v, want the bytes unchanged", out)
+	}
+	if got := srv.Commands(); got[len(got)-1] != "exec:screencap -p" {
+		t.Errorf("service = %q, want exec:screencap -p", got[len(got)-1])
+	}
+}
+
+func TestReboot(t *testing.T) {
+	srv, c := newFake(t)
+	srv.AddDevice(testSerial)
+
+	if err := c.Device(testSerial).Reboot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Commands(); got[len(got)-1] != "reboot:" {
+		t.Errorf("service = %q, want reboot:", got[len(got)-1])
+	}
+}
+
+func TestShellCancelled(t *testing.T) {
+	srv, c := newFake(t)
+	srv.AddDevice(testSerial)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	srv.HandleShell(func(serial string, command string) ([]byte, error) {
+		<-release
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := c.Device(testSerial).Shell(ctx, "logcat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	cancel()
+	if _, err := io.ReadAll(stream); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,95 @@
+package adb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// conn is a connection to the adb server bound to a context.
+type conn struct {
+	net.Conn
+	ctx  context.Context
+	stop func() bool
+}
+
+func newConn(ctx context.Context, netConn net.Conn) *conn {
+	c := &conn{Conn: netConn, ctx: ctx}
+	c.stop = context.AfterFunc(ctx, func() {
+		netConn.Close()
+	})
+	return c
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil && err != io.EOF {
+		err = c.err(err)
+	}
+	return n, err
+}
+
+func (c *conn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// err reports the context error instead of the closed connection error it caused.
+func (c *conn) err(err error) error {
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// request sends a length-prefixed request and reads the OKAY/FAIL status.
+func (c *conn) request(request string) error {
+	if _, err := fmt.Fprintf(c, "%04x%s", len(request), request); err != nil {
+		return err
+	}
+
+	return c.readStatus(request)
+}
+
+// readStatus reads an OKAY or FAIL status, the latter followed by a message.
+func (c *conn) readStatus(request string) error {
+	status := make([]byte, 4)
+	if _, err := io.ReadFull(c, status); err != nil {
+		return err
+	}
+
+	switch string(status) {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		message, err := c.readString()
+		if err != nil {
+			return err
+		}
+		return &ServerError{Request: request, Message: message}
+	default:
+		return fmt.Errorf("unexpected adb status %q", status)
+	}
+}
+
+// readString reads a string prefixed with its length as 4 hex digits.
+func (c *conn) readString() (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c, header); err != nil {
+		return "", err
+	}
+
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid adb length %q", header)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c, data); err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,440 @@
+package adb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShellHandler answers a command run on a fake device. With the shell v2
+// protocol an error is written to stderr and exits with code 1, an
+// ExitError exits with its code and errKilled drops the connection before
+// the exit code.
+type ShellHandler func(serial string, command string) ([]byte, error)
+
+// ExitError makes a shell v2 command exit with Code.
+type ExitError struct {
+	Code   byte
+	Stderr string
+}
+
+func (e *ExitError) Error() string {
+	return e.Stderr
+}
+
+var errKilled = errors.New("killed")
+
+type fakeFile struct {
+	data    []byte
+	mode    uint32
+	modTime time.Time
+}
+
+// FakeServer is a local stand-in for the adb server. It implements the
+// host services used by Client, shell and exec commands through a
+// handler, and the sync protocol over an in-memory filesystem per device.
+type FakeServer struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	devices  map[string]map[string]*fakeFile
+	readOnly map[string][]string
+	shell    ShellHandler
+	commands []string
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewFakeServer starts a fake adb server on a random local port.
+func NewFakeServer() (*FakeServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FakeServer{
+		listener: ln,
+		devices:  map[string]map[string]*fakeFile{},
+		readOnly: map[string][]string{},
+		conns:    map[net.Conn]struct{}{},
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr returns the address the fake server listens on.
+func (s *FakeServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// AddDevice makes a device available to host:transport.
+func (s *FakeServer) AddDevice(serial string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.devices[serial] == nil {
+		s.devices[serial] = map[string]*fakeFile{}
+	}
+}
+
+// HandleShell sets the handler of shell and exec commands. Commands are
+// answered with no output when no handler is set.
+func (s *FakeServer) HandleShell(handler ShellHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shell = handler
+}
+
+// WriteFile stores a file on a fake device.
+func (s *FakeServer) WriteFile(serial string, name string, data []byte) {
+	s.AddDevice(serial)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[serial][name] = &fakeFile{data: data, mode: 0100644, modTime: time.Now()}
+}
+
+// SetReadOnly makes pushes under dir on a fake device fail, as they do on
+// a read-only partition.
+func (s *FakeServer) SetReadOnly(serial string, dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readOnly[serial] = append(s.readOnly[serial], strings.TrimSuffix(dir, "/")+"/")
+}
+
+func (s *FakeServer) isReadOnly(serial string, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, dir := range s.readOnly[serial] {
+		if strings.HasPrefix(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadFile returns a file stored on a fake device.
+func (s *FakeServer) ReadFile(serial string, name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.devices[serial][name]
+	if !ok {
+		return nil, false
+	}
+	return f.data, true
+}
+
+// Commands returns the service requests received so far.
+func (s *FakeServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Close stops the fake server and drops its connections.
+func (s *FakeServer) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *FakeServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func readRequest(conn net.Conn) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return "", err
+	}
+	request := make([]byte, length)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	return string(request), nil
+}
+
+func writeOkay(conn net.Conn, answer string) {
+	fmt.Fprintf(conn, "OKAY%04x%s", len(answer), answer)
+}
+
+func writeFail(conn net.Conn, message string) {
+	fmt.Fprintf(conn, "FAIL%04x%s", len(message), message)
+}
+
+func (s *FakeServer) serveConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	request, err := readRequest(conn)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.commands = append(s.commands, request)
+	s.mu.Unlock()
+
+	switch {
+	case request == "host:version":
+		writeOkay(conn, "0029")
+	case strings.HasPrefix(request, "host:connect:"):
+		serial := strings.TrimPrefix(request, "host:connect:")
+		s.AddDevice(serial)
+		writeOkay(conn, "connected to "+serial)
+	case strings.HasPrefix(request, "host:disconnect:"):
+		serial := strings.TrimPrefix(request, "host:disconnect:")
+		s.mu.Lock()
+		delete(s.devices, serial)
+		s.mu.Unlock()
+		writeOkay(conn, "disconnected "+serial)
+	case strings.HasPrefix(request, "host:transport:"):
+		serial := strings.TrimPrefix(request, "host:transport:")
+		s.mu.Lock()
+		_, ok := s.devices[serial]
+		s.mu.Unlock()
+		if !ok {
+			writeFail(conn, fmt.Sprintf("device '%s' not found", serial))
+			return
+		}
+		conn.Write([]byte("OKAY"))
+		s.serveDevice(conn, serial)
+	default:
+		writeFail(conn, "unknown host service")
+	}
+}
+
+// serveDevice answers the service request sent after host:transport.
+func (s *FakeServer) serveDevice(conn net.Conn, serial string) {
+	service, err := readRequest(conn)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.commands = append(s.commands, service)
+	handler := s.shell
+	s.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(service, "shell:") || strings.HasPrefix(service, "exec:"):
+		conn.Write([]byte("OKAY"))
+		if handler == nil {
+			return
+		}
+		_, command, _ := strings.Cut(service, ":")
+		out, err := handler(serial, command)
+		if err != nil {
+			fmt.Fprintf(conn, "%s\n", err)
+			return
+		}
+		conn.Write(out)
+	case strings.HasPrefix(service, "shell,v2,"):
+		conn.Write([]byte("OKAY"))
+		if !readStdin(conn) {
+			return
+		}
+		_, command, _ := strings.Cut(service, ":")
+		var out []byte
+		var err error
+		if handler != nil {
+			out, err = handler(serial, command)
+		}
+		if len(out) > 0 {
+			writeShellPacket(conn, shellStdout, out)
+		}
+		var exitErr *ExitError
+		switch {
+		case err == errKilled:
+			return
+		case errors.As(err, &exitErr):
+			if exitErr.Stderr != "" {
+				writeShellPacket(conn, shellStderr, []byte(exitErr.Stderr))
+			}
+			writeShellPacket(conn, shellExit, []byte{exitErr.Code})
+		case err != nil:
+			writeShellPacket(conn, shellStderr, []byte(err.Error()+"\n"))
+			writeShellPacket(conn, shellExit, []byte{1})
+		default:
+			writeShellPacket(conn, shellExit, []byte{0})
+		}
+	case service == "sync:":
+		conn.Write([]byte("OKAY"))
+		s.serveSync(conn, serial)
+	case service == "reboot:":
+		conn.Write([]byte("OKAY"))
+	default:
+		writeFail(conn, "unknown service")
+	}
+}
+
+// readStdin discards the input of a shell v2 command until it is closed.
+func readStdin(conn net.Conn) bool {
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return false
+		}
+		if header[0] == shellCloseStdin {
+			return true
+		}
+		if _, err := io.CopyN(io.Discard, conn, int64(binary.LittleEndian.Uint32(header[1:]))); err != nil {
+			return false
+		}
+	}
+}
+
+func writeShellPacket(conn net.Conn, id byte, data []byte) {
+	header := make([]byte, 5)
+	header[0] = id
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(data)))
+	conn.Write(append(header, data...))
+}
+
+func writeSync(conn net.Conn, id string, length uint32, data []byte) {
+	header := make([]byte, 8)
+	copy(header, id)
+	binary.LittleEndian.PutUint32(header[4:], length)
+	conn.Write(append(header, data...))
+}
+
+func (s *FakeServer) serveSync(conn net.Conn, serial string) {
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		id := string(header[:4])
+		arg := make([]byte, binary.LittleEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(conn, arg); err != nil {
+			return
+		}
+
+		switch id {
+		case "QUIT":
+			return
+		case "STAT":
+			s.mu.Lock()
+			f, ok := s.devices[serial][string(arg)]
+			s.mu.Unlock()
+			resp := make([]byte, 12)
+			if ok {
+				binary.LittleEndian.PutUint32(resp[0:], f.mode)
+				binary.LittleEndian.PutUint32(resp[4:], uint32(len(f.data)))
+				binary.LittleEndian.PutUint32(resp[8:], uint32(f.modTime.Unix()))
+			}
+			conn.Write(append([]byte("STAT"), resp...))
+		case "LIST":
+			dir := strings.TrimSuffix(string(arg), "/")
+			s.mu.Lock()
+			for name, f := range s.devices[serial] {
+				if path.Dir(name) != dir {
+					continue
+				}
+				base := path.Base(name)
+				entry := make([]byte, 16)
+				copy(entry, "DENT")
+				binary.LittleEndian.PutUint32(entry[4:], f.mode)
+				binary.LittleEndian.PutUint32(entry[8:], uint32(len(f.data)))
+				binary.LittleEndian.PutUint32(entry[12:], uint32(f.modTime.Unix()))
+				nameLen := make([]byte, 4)
+				binary.LittleEndian.PutUint32(nameLen, uint32(len(base)))
+				conn.Write(append(append(entry, nameLen...), base...))
+			}
+			s.mu.Unlock()
+			conn.Write(append([]byte("DONE"), make([]byte, 16)...))
+		case "RECV":
+			s.mu.Lock()
+			f, ok := s.devices[serial][string(arg)]
+			s.mu.Unlock()
+			if !ok {
+				message := "No such file or directory"
+				writeSync(conn, "FAIL", uint32(len(message)), []byte(message))
+				continue
+			}
+			for off := 0; off < len(f.data); off += maxSyncChunk {
+				chunk := f.data[off:min(off+maxSyncChunk, len(f.data))]
+				writeSync(conn, "DATA", uint32(len(chunk)), chunk)
+			}
+			writeSync(conn, "DONE", 0, nil)
+		case "SEND":
+			name, modeStr, _ := strings.Cut(string(arg), ",")
+			mode, _ := strconv.ParseUint(modeStr, 10, 32)
+			var data []byte
+			for {
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				length := binary.LittleEndian.Uint32(header[4:])
+				if string(header[:4]) == "DONE" {
+					if s.isReadOnly(serial, name) {
+						message := "couldn't create file: Read-only file system"
+						writeSync(conn, "FAIL", uint32(len(message)), []byte(message))
+						break
+					}
+					s.AddDevice(serial)
+					s.mu.Lock()
+					s.devices[serial][name] = &fakeFile{data: data, mode: uint32(mode), modTime: time.Unix(int64(length), 0)}
+					s.mu.Unlock()
+					writeSync(conn, "OKAY", 0, nil)
+					break
+				}
+				// adbd rejects the chunks larger than the sync protocol
+				// allows and drops the connection.
+				if string(header[:4]) != "DATA" || length > maxSyncChunk {
+					message := "invalid data message"
+					writeSync(conn, "FAIL", uint32(len(message)), []byte(message))
+					return
+				}
+				chunk := make([]byte, length)
+				if _, err := io.ReadFull(conn, chunk); err != nil {
+					return
+				}
+				data = append(data, chunk...)
+			}
+		default:
+			message := "unknown sync request"
+			writeSync(conn, "FAIL", uint32(len(message)), []byte(message))
+			return
+		}
+	}
+}
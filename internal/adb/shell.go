@@ -0,0 +1,68 @@
+package adb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Packet ids of the shell v2 protocol.
+const (
+	shellStdin      = 0
+	shellStdout     = 1
+	shellStderr     = 2
+	shellExit       = 3
+	shellCloseStdin = 4
+)
+
+// ErrNoExitCode is returned when the stream of a command ends before its
+// exit code, such as when the command is killed with its connection.
+var ErrNoExitCode = errors.New("adb: command ended without an exit code")
+
+// Exec runs a command with the shell v2 protocol, which keeps stdout and
+// stderr apart, and returns its exit code once it ends. The output is
+// written as it arrives; cancelling ctx terminates the command.
+func (d *Device) Exec(ctx context.Context, command string, stdout io.Writer, stderr io.Writer) (int, error) {
+	conn, err := d.open(ctx, "shell,v2,raw:"+command)
+	if err != nil {
+		return -1, err
+	}
+	defer conn.Close()
+
+	// The command gets no input.
+	if _, err := conn.Write([]byte{shellCloseStdin, 0, 0, 0, 0}); err != nil {
+		return -1, conn.err(err)
+	}
+
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			if err == io.EOF {
+				return -1, ErrNoExitCode
+			}
+			return -1, err
+		}
+
+		length := int64(binary.LittleEndian.Uint32(header[1:]))
+		payload := io.LimitReader(conn, length)
+
+		switch header[0] {
+		case shellStdout:
+			_, err = io.Copy(stdout, payload)
+		case shellStderr:
+			_, err = io.Copy(stderr, payload)
+		case shellExit:
+			code := make([]byte, length)
+			if _, err := io.ReadFull(payload, code); err != nil || length < 1 {
+				return -1, ErrNoExitCode
+			}
+			return int(code[0]), nil
+		default:
+			_, err = io.Copy(io.Discard, payload)
+		}
+		if err != nil {
+			return -1, err
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExec(t *testing.T) {
+	srv, c := newFake(t)
+	srv.AddDevice(testSerial)
+	big := strings.Repeat("x", 3*maxSyncChunk)
+	srv.HandleShell(func(serial string, command string) ([]byte, error) {
+		switch command {
+		case "pm list packages":
+			return []byte("package:com.example\n"), nil
+		case "ls /missing":
+			return nil, &ExitError{Code: 2, Stderr: "ls: /missing: No such file or directory\n"}
+		case "cat big":
+			return []byte(big), nil
+		case "sh -c broken":
+			return []byte("partial"), errors.New("sh: broken: not found")
+		case "sleep 100":
+			return nil, errKilled
+		}
+		return nil, nil
+	})
+	device := c.Device(testSerial)
+
+	tests := []struct {
+		command string
+		code    int
+		stdout  string
+		stderr  string
+	}{
+		{"pm list packages", 0, "package:com.example\n", ""},
+		{"ls /missing", 2, "", "ls: /missing: No such file or directory\n"},
+		{"cat big", 0, big, ""},
+		{"sh -c broken", 1, "partial", "sh: broken: not found\n"},
+		{"true", 0, "", ""},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code, err := device.Exec(context.Background(), tt.command, &stdout, &stderr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.command, err)
+			continue
+		}
+		if code != tt.code || stdout.String() != tt.stdout || stderr.String() != tt.stderr {
+			t.Errorf("%s: code %d, stdout %d bytes, stderr %q; want %d, %d bytes, %q",
+				tt.command, code, stdout.Len(), stderr.String(), tt.code, len(tt.stdout), tt.stderr)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if _, err := device.Exec(context.Background(), "sleep 100", &stdout, &stderr); !errors.Is(err, ErrNoExitCode) {
+		t.Errorf("killed command: err = %v, want ErrNoExitCode", err)
+	}
+
+	if got := srv.Commands(); got[len(got)-1] != "shell,v2,raw:sleep 100" {
+		t.Errorf("service = %q, want shell,v2,raw:sleep 100", got[len(got)-1])
+	}
+}
@@ -0,0 +1,266 @@
+package adb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// maxSyncChunk is the largest DATA payload the sync protocol accepts.
+const maxSyncChunk = 64 * 1024
+
+var ErrNotExist = errors.New("remote file does not exist")
+
+// FileInfo describes a file on the device.
+type FileInfo struct {
+	Name    string      `json:"name"`
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mod_time"`
+}
+
+// IsDir reports whether the file is a directory.
+func (fi FileInfo) IsDir() bool {
+	return fi.Mode.IsDir()
+}
+
+// syncConn is a connection switched to the sync: service.
+type syncConn struct {
+	*conn
+}
+
+func (d *Device) openSync(ctx context.Context) (*syncConn, error) {
+	conn, err := d.open(ctx, "sync:")
+	if err != nil {
+		return nil, err
+	}
+	return &syncConn{conn}, nil
+}
+
+// send writes a sync request: a 4 byte id and a little-endian length followed by data.
+func (s *syncConn) send(id string, data []byte) error {
+	header := make([]byte, 8)
+	copy(header, id)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	if _, err := s.Write(header); err != nil {
+		return err
+	}
+	_, err := s.Write(data)
+	return err
+}
+
+// readHeader reads a sync response id and its length argument.
+func (s *syncConn) readHeader() (string, uint32, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(s, header); err != nil {
+		return "", 0, err
+	}
+	return string(header[:4]), binary.LittleEndian.Uint32(header[4:]), nil
+}
+
+// readFail reads the message of a FAIL response.
+func (s *syncConn) readFail(request string, length uint32) error {
+	message := make([]byte, length)
+	if _, err := io.ReadFull(s, message); err != nil {
+		return err
+	}
+	return &ServerError{Request: request, Message: string(message)}
+}
+
+func (s *syncConn) quit() {
+	s.send("QUIT", nil)
+	s.Close()
+}
+
+// Push writes the content of r to remotePath on the device.
+func (d *Device) Push(ctx context.Context, r io.Reader, remotePath string, mode os.FileMode, modTime time.Time) error {
+	s, err := d.openSync(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.quit()
+
+	if err := s.send("SEND", []byte(fmt.Sprintf("%s,%d", remotePath, mode.Perm()|0100000))); err != nil {
+		return s.err(err)
+	}
+
+	buf := make([]byte, maxSyncChunk)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			if err := s.send("DATA", buf[:n]); err != nil {
+				return s.err(err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+
+	done := make([]byte, 8)
+	copy(done, "DONE")
+	binary.LittleEndian.PutUint32(done[4:], uint32(modTime.Unix()))
+	if _, err := s.Write(done); err != nil {
+		return s.err(err)
+	}
+
+	id, length, err := s.readHeader()
+	if err != nil {
+		return s.err(err)
+	}
+
+	switch id {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		return s.readFail("push "+remotePath, length)
+	default:
+		return fmt.Errorf("unexpected sync response %q", id)
+	}
+}
+
+// Pull copies remotePath from the device to w and returns the bytes copied.
+func (d *Device) Pull(ctx context.Context, remotePath string, w io.Writer) (int64, error) {
+	s, err := d.openSync(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer s.quit()
+
+	if err := s.send("RECV", []byte(remotePath)); err != nil {
+		return 0, s.err(err)
+	}
+
+	var written int64
+	for {
+		id, length, err := s.readHeader()
+		if err != nil {
+			return written, s.err(err)
+		}
+
+		switch id {
+		case "DATA":
+			n, err := io.CopyN(w, s, int64(length))
+			written += n
+			if err != nil {
+				return written, s.err(err)
+			}
+		case "DONE":
+			return written, nil
+		case "FAIL":
+			return written, s.readFail("pull "+remotePath, length)
+		default:
+			return written, fmt.Errorf("unexpected sync response %q", id)
+		}
+	}
+}
+
+// Stat returns information about remotePath on the device.
+func (d *Device) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	s, err := d.openSync(ctx)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer s.quit()
+
+	if err := s.send("STAT", []byte(remotePath)); err != nil {
+		return FileInfo{}, s.err(err)
+	}
+
+	resp := make([]byte, 16)
+	if _, err := io.ReadFull(s, resp); err != nil {
+		return FileInfo{}, s.err(err)
+	}
+	if string(resp[:4]) != "STAT" {
+		return FileInfo{}, fmt.Errorf("unexpected sync response %q", resp[:4])
+	}
+
+	mode := binary.LittleEndian.Uint32(resp[4:])
+	size := binary.LittleEndian.Uint32(resp[8:])
+	mtime := binary.LittleEndian.Uint32(resp[12:])
+
+	// adbd answers all zeros for missing files.
+	if mode == 0 && size == 0 && mtime == 0 {
+		return FileInfo{}, ErrNotExist
+	}
+
+	return FileInfo{
+		Name:    remotePath,
+		Mode:    unixMode(mode),
+		Size:    int64(size),
+		ModTime: time.Unix(int64(mtime), 0),
+	}, nil
+}
+
+// List returns the entries of the directory remotePath on the device.
+func (d *Device) List(ctx context.Context, remotePath string) ([]FileInfo, error) {
+	s, err := d.openSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.quit()
+
+	if err := s.send("LIST", []byte(remotePath)); err != nil {
+		return nil, s.err(err)
+	}
+
+	var entries []FileInfo
+	for {
+		resp := make([]byte, 20)
+		if _, err := io.ReadFull(s, resp); err != nil {
+			return nil, s.err(err)
+		}
+
+		switch string(resp[:4]) {
+		case "DENT":
+		case "DONE":
+			return entries, nil
+		case "FAIL":
+			return nil, s.readFail("list "+remotePath, binary.LittleEndian.Uint32(resp[4:]))
+		default:
+			return nil, fmt.Errorf("unexpected sync response %q", resp[:4])
+		}
+
+		name := make([]byte, binary.LittleEndian.Uint32(resp[16:]))
+		if _, err := io.ReadFull(s, name); err != nil {
+			return nil, s.err(err)
+		}
+		if string(name) == "." || string(name) == ".." {
+			continue
+		}
+
+		entries = append(entries, FileInfo{
+			Name:    string(name),
+			Mode:    unixMode(binary.LittleEndian.Uint32(resp[4:])),
+			Size:    int64(binary.LittleEndian.Uint32(resp[8:])),
+			ModTime: time.Unix(int64(binary.LittleEndian.Uint32(resp[12:])), 0),
+		})
+	}
+}
+
+// unixMode converts a st_mode value to an os.FileMode.
+func unixMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	switch mode & 0170000 {
+	case 0040000:
+		m |= os.ModeDir
+	case 0120000:
+		m |= os.ModeSymlink
+	case 0010000:
+		m |= os.ModeNamedPipe
+	case 0140000:
+		m |= os.ModeSocket
+	case 0020000:
+		m |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		m |= os.ModeDevice
+	}
+	return m
+}
@@ -0,0 +1,146 @@
+package adb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushAndPull(t *testing.T) {
+	srv, c := newFake(t)
+	srv.AddDevice(testSerial)
+	device := c.Device(testSerial)
+	ctx := context.Background()
+
+	// Three chunks, the last one short; the fake fails a chunk over the
+	// protocol limit.
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*maxSyncChunk+100)/16)
+	modTime := time.Unix(1700000000, 0)
+	if err := device.Push(ctx, bytes.NewReader(data), "/sdcard/app.apk", 0640, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, ok := srv.ReadFile(testSerial, "/sdcard/app.apk")
+	if !ok || !bytes.Equal(stored, data) {
+		t.Fatalf("stored %d bytes, want %d", len(stored), len(data))
+	}
+
+	var pulled bytes.Buffer
+	n, err := device.Pull(ctx, "/sdcard/app.apk", &pulled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(pulled.Bytes(), data) {
+		t.Fatalf("pulled %d bytes, want %d", n, len(data))
+	}
+
+	info, err := device.Stat(ctx, "/sdcard/app.apk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FileInfo{Name: "/sdcard/app.apk", Mode: 0640, Size: int64(len(data)), ModTime: modTime}
+	if info != want {
+		t.Errorf("stat = %+v, want %+v", info, want)
+	}
+}
+
+func TestPushEmpty(t *testing.T) {
+	srv, c := newFake(t)
+	srv.AddDevice(testSerial)
+
+	if err := c.Device(testSerial).Push(context.Background(), strings.NewReader(""), "/sdcard/empty", 0644, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := srv.ReadFile(testSerial, "/sdcard/empty"); !ok || len(data) != 0 {
+		t.Errorf("stored %q, %v", data, ok)
+	}
+}
+
+func TestPushFail(t *testing.T) {
+	srv, c := newFake(t)
+	srv.AddDevice(testSerial)
+	srv.SetReadOnly(testSerial, "/system")
+
+	err := c.Device(testSerial).Push(context.Background(), strings.NewReader("x"), "/system/x", 0644, time.Now())
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) {
+		t.Fatalf("err = %v, want a ServerError", err)
+	}
+	if serverErr.Request != "push /system/x" || !strings.Contains(serverErr.Message, "Read-only") {
+		t.Errorf("err = %+v", serverErr)
+	}
+	if _, ok := srv.ReadFile(testSerial, "/system/x"); ok {
+		t.Error("file stored despite the failure")
+	}
+}
+
+func TestPullFail(t *testing.T) {
+	srv, c := newFake(t)
+	srv.AddDevice(testSerial)
+
+	var buf bytes.Buffer
+	_, err := c.Device(testSerial).Pull(context.Background(), "/sdcard/missing", &buf)
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Request != "pull /sdcard/missing" {
+		t.Fatalf("err = %v, want a ServerError of the pull", err)
+	}
+}
+
+func TestStatMissing(t *testing.T) {
+	srv, c := newFake(t)
+	srv.AddDevice(testSerial)
+
+	if _, err := c.Device(testSerial).Stat(context.Background(), "/sdcard/missing"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("err = %v, want ErrNotExist", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	srv, c := newFake(t)
+	srv.WriteFile(testSerial, "/sdcard/a.txt", []byte("a"))
+	srv.WriteFile(testSerial, "/sdcard/b.txt", []byte("bb"))
+	srv.WriteFile(testSerial, "/sdcard/Download/c.txt", []byte("ccc"))
+
+	entries, err := c.Device(testSerial).List(context.Background(), "/sdcard/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(entries, func(a, b FileInfo) int { return strings.Compare(a.Name, b.Name) })
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+		if e.Mode != 0644 || e.IsDir() {
+			t.Errorf("%s: mode = %v", e.Name, e.Mode)
+		}
+	}
+	if want := []string{"a.txt", "b.txt"}; !slices.Equal(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+	if entries[1].Size != 2 {
+		t.Errorf("size of b.txt = %d", entries[1].Size)
+	}
+}
+
+func TestUnixMode(t *testing.T) {
+	tests := []struct {
+		mode uint32
+		want os.FileMode
+	}{
+		{0100644, 0644},
+		{0040755, os.ModeDir | 0755},
+		{0120777, os.ModeSymlink | 0777},
+		{0020666, os.ModeDevice | os.ModeCharDevice | 0666},
+		{0060660, os.ModeDevice | 0660},
+	}
+	for _, tt := range tests {
+		if got := unixMode(tt.mode); got != tt.want {
+			t.Errorf("unixMode(%o) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+)
+
+// ListArtifacts returns the artifacts of owner with signed download URLs.
+func (c *Client) ListArtifacts(ctx context.Context, owner string) ([]artifacts.Artifact, error) {
+	var list []artifacts.Artifact
+	err := c.do(ctx, http.MethodGet, "/artifacts", url.Values{"owner": {owner}}, nil, "", &list)
+	return list, err
+}
+
+// DeleteArtifact deletes an artifact of owner.
+func (c *Client) DeleteArtifact(ctx context.Context, owner string, id string) error {
+	return c.do(ctx, http.MethodDelete, "/artifacts", url.Values{"owner": {owner}, "id": {id}}, nil, "", nil)
+}
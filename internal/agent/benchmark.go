@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/benchmark"
+)
+
+// Statuses of a benchmark.
+const (
+	BenchmarkRunning   = "RUNNING"
+	BenchmarkCompleted = "COMPLETED"
+	// BenchmarkError is a benchmark that could not time a launch of a mode.
+	BenchmarkError = "ERROR"
+)
+
+// Benchmark is a benchmark of the startup of an activity on a device.
+type Benchmark struct {
+	ID         string            `json:"id"`
+	DeviceID   string            `json:"device_id"`
+	Owner      string            `json:"owner"`
+	Status     string            `json:"status"`
+	Options    benchmark.Options `json:"options"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Result     *benchmark.Result `json:"result,omitempty"`
+	// Report is the stored result, kept to compare builds over time.
+	Report *artifacts.Artifact `json:"report,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// RunBenchmark starts a benchmark on the emulator running in containerName,
+// it returns once the benchmark started.
+func (c *Client) RunBenchmark(ctx context.Context, containerName string, owner string, opts benchmark.Options) (Benchmark, error) {
+	body, err := json.Marshal(opts)
+	if err != nil {
+		return Benchmark{}, err
+	}
+
+	var b Benchmark
+	err = c.do(ctx, http.MethodPost, "/benchmark", url.Values{"containerName": {containerName}, "owner": {owner}}, bytes.NewReader(body), "application/json", &b)
+	return b, err
+}
+
+// GetBenchmark returns a benchmark of owner.
+func (c *Client) GetBenchmark(ctx context.Context, owner string, id string) (Benchmark, error) {
+	var b Benchmark
+	err := c.do(ctx, http.MethodGet, "/benchmarks", url.Values{"owner": {owner}, "id": {id}}, nil, "", &b)
+	return b, err
+}
+
+// ListBenchmarks returns the recent benchmarks of owner, newest first.
+func (c *Client) ListBenchmarks(ctx context.Context, owner string) ([]Benchmark, error) {
+	var bs []Benchmark
+	err := c.do(ctx, http.MethodGet, "/benchmarks", url.Values{"owner": {owner}}, nil, "", &bs)
+	return bs, err
+}
+
+// BenchmarkHistory returns the summaries of the stored benchmarks of owner,
+// oldest first, of an activity when it is set.
+func (c *Client) BenchmarkHistory(ctx context.Context, owner string, activity string) ([]benchmark.Summary, error) {
+	query := url.Values{"owner": {owner}}
+	if activity != "" {
+		query.Set("activity", activity)
+	}
+
+	var summaries []benchmark.Summary
+	err := c.do(ctx, http.MethodGet, "/benchmark-history", query, nil, "", &summaries)
+	return summaries, err
+}
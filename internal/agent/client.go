@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type Config struct {
+	// Address of the docker handler running the emulators.
+	Address string `mapstructure:"address"`
+}
+
+// Error is returned when the agent answers with a non-2xx status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("agent returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Client calls the docker handler on behalf of the functions.
+type Client struct {
+	address    string
+	httpClient *http.Client
+}
+
+func NewClient(cfg *Config) *Client {
+	return &Client{
+		address:    strings.TrimSuffix(cfg.Address, "/"),
+		httpClient: &http.Client{},
+	}
+}
+
+// do sends a request to the agent and decodes its JSON answer into out
+// when out is not nil.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body io.Reader, contentType string, out any) error {
+	resp, err := c.send(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends a request to the agent and returns the response of a
+// successful call, the caller must close its body.
+func (c *Client) send(ctx context.Context, method string, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := c.address + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(resp.Body)
+		return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/devicestate"
+)
+
+// ImportRequest describes the device created from a device state archive,
+// in the form the agent runs emulators with.
+type ImportRequest struct {
+	ContainerName string `json:"containerName"`
+	Port          int    `json:"port"`
+	DeviceName    string `json:"DeviceName,omitempty"`
+	Owner         string `json:"owner"`
+	Volume        string `json:"volume"`
+}
+
+// ExportDevice stores the state of the emulator running in containerName
+// as an archive of owner.
+func (c *Client) ExportDevice(ctx context.Context, containerName string, owner string, req devicestate.ExportRequest) (artifacts.Artifact, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return artifacts.Artifact{}, err
+	}
+
+	var a artifacts.Artifact
+	err = c.do(ctx, http.MethodPost, "/export-device", url.Values{"containerName": {containerName}, "owner": {owner}}, bytes.NewReader(body), "application/json", &a)
+	return a, err
+}
+
+// DeviceArchive returns the manifest of an archive of owner that user may
+// import.
+func (c *Client) DeviceArchive(ctx context.Context, owner string, id string, user string) (devicestate.Manifest, error) {
+	var manifest devicestate.Manifest
+	err := c.do(ctx, http.MethodGet, "/device-archive", url.Values{"owner": {owner}, "id": {id}, "user": {user}}, nil, "", &manifest)
+	return manifest, err
+}
+
+// ImportDevice creates a device of req.Owner from an archive of owner and
+// starts it.
+func (c *Client) ImportDevice(ctx context.Context, owner string, id string, req ImportRequest) (devicestate.Manifest, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return devicestate.Manifest{}, err
+	}
+
+	var manifest devicestate.Manifest
+	err = c.do(ctx, http.MethodPost, "/import-device", url.Values{"owner": {owner}, "id": {id}, "user": {req.Owner}}, bytes.NewReader(body), "application/json", &manifest)
+	return manifest, err
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// EmulatorConfig is the configuration of the emulator of a device.
+type EmulatorConfig struct {
+	ContainerName string `json:"containerName"`
+	Port          int    `json:"port"`
+	DeviceName    string `json:"DeviceName"`
+	AndroidAPI    string `json:"AndroidAPI"`
+	Owner         string `json:"owner,omitempty"`
+	Volume        string `json:"volume,omitempty"`
+	Appium        bool   `json:"appium,omitempty"`
+	// Locale is the locale the device boots with, such as fr-FR.
+	Locale string `json:"locale,omitempty"`
+}
+
+// RunEmulator starts the emulator of a device, it returns before the
+// device booted.
+func (c *Client) RunEmulator(ctx context.Context, cfg EmulatorConfig) error {
+	body, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodPost, "/run-emulator", nil, bytes.NewReader(body), "application/json", nil)
+}
+
+// StopEmulator stops and deletes the emulator running in containerName.
+func (c *Client) StopEmulator(ctx context.Context, containerName string) error {
+	body, err := json.Marshal(EmulatorConfig{ContainerName: containerName})
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodPost, "/stop-emulator", nil, bytes.NewReader(body), "application/json", nil)
+}
+
+// DeviceStatus returns the status reported by the emulator running in
+// containerName.
+func (c *Client) DeviceStatus(ctx context.Context, containerName string) (string, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/device-status", url.Values{"containerName": {containerName}}, nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	status, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimPrefix(string(status), "Device Status:")), nil
+}
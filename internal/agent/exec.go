@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// ExecRequest asks to run a shell command on a device. Owner and Role are
+// filled in from the caller's token, Timeout is in seconds.
+type ExecRequest struct {
+	Command string `json:"command"`
+	Timeout int    `json:"timeout,omitempty"`
+	Owner   string `json:"owner,omitempty"`
+	Role    string `json:"role,omitempty"`
+}
+
+// ExecResult is the outcome of a command. Truncated tells that stdout or
+// stderr went over the output limit and were cut.
+type ExecResult struct {
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitCode   int    `json:"exit_code"`
+	TimedOut   bool   `json:"timed_out,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Exec runs a shell command on the emulator running in containerName.
+func (c *Client) Exec(ctx context.Context, containerName string, req ExecRequest) (ExecResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	var result ExecResult
+	err = c.do(ctx, http.MethodPost, "/exec", url.Values{"containerName": {containerName}}, bytes.NewReader(body), "application/json", &result)
+	return result, err
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/files"
+)
+
+// PushFile writes the content of r to devicePath on the emulator running
+// in containerName. mode is an octal permission such as "644", empty
+// keeps the default.
+func (c *Client) PushFile(ctx context.Context, containerName string, owner string, devicePath string, mode string, r io.Reader) (files.Entry, error) {
+	query := url.Values{"containerName": {containerName}, "owner": {owner}, "path": {devicePath}}
+	if mode != "" {
+		query.Set("mode", mode)
+	}
+
+	var entry files.Entry
+	err := c.do(ctx, http.MethodPost, "/push-file", query, r, "application/octet-stream", &entry)
+	return entry, err
+}
+
+// PullFile returns the content of devicePath on the emulator running in
+// containerName, or of a path in the data directory of pkg when pkg is
+// set. The caller must close the stream.
+func (c *Client) PullFile(ctx context.Context, containerName string, owner string, devicePath string, pkg string) (io.ReadCloser, error) {
+	query := url.Values{"containerName": {containerName}, "owner": {owner}, "path": {devicePath}}
+	if pkg != "" {
+		query.Set("package", pkg)
+	}
+
+	resp, err := c.send(ctx, http.MethodGet, "/pull-file", query, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// ListFiles returns the entries of the directory devicePath on the
+// emulator running in containerName.
+func (c *Client) ListFiles(ctx context.Context, containerName string, owner string, devicePath string) ([]files.Entry, error) {
+	query := url.Values{"containerName": {containerName}, "owner": {owner}, "path": {devicePath}}
+
+	var entries []files.Entry
+	err := c.do(ctx, http.MethodGet, "/list-files", query, nil, "", &entries)
+	return entries, err
+}
@@ -0,0 +1,182 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// Input action types.
+const (
+	ActionTap       = "tap"
+	ActionLongPress = "long_press"
+	ActionSwipe     = "swipe"
+	ActionText      = "text"
+	ActionKeyEvent  = "keyevent"
+	ActionGesture   = "gesture"
+)
+
+// Action result statuses.
+const (
+	ActionOK      = "ok"
+	ActionFailed  = "failed"
+	ActionInvalid = "invalid"
+	ActionSkipped = "skipped"
+)
+
+const (
+	MaxInputActions        = 100
+	MaxActionDurationMs    = 60000
+	MaxGesturePointers     = 10
+	DefaultLongPressMs     = 800
+	DefaultSwipeDurationMs = 300
+	DefaultGestureMs       = 500
+	maxTextLength          = 1000
+)
+
+var keyCodeRe = regexp.MustCompile(`^(KEYCODE_)?[A-Z0-9_]+$|^[0-9]+$`)
+
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Action is a single input step. Tap and long_press use X and Y, swipe
+// goes from X, Y to ToX, ToY, gesture moves each pointer along its path
+// of points, text types Text and keyevent presses Key.
+type Action struct {
+	Type       string    `json:"type"`
+	X          int       `json:"x,omitempty"`
+	Y          int       `json:"y,omitempty"`
+	ToX        int       `json:"to_x,omitempty"`
+	ToY        int       `json:"to_y,omitempty"`
+	DurationMs int       `json:"duration_ms,omitempty"`
+	Text       string    `json:"text,omitempty"`
+	Key        string    `json:"key,omitempty"`
+	LongPress  bool      `json:"long_press,omitempty"`
+	Pointers   [][]Point `json:"pointers,omitempty"`
+}
+
+// InputRequest is a batch of actions run in order. Unless ContinueOnError
+// is set, the actions after a failed one are skipped.
+type InputRequest struct {
+	Actions         []Action `json:"actions"`
+	ContinueOnError bool     `json:"continue_on_error,omitempty"`
+}
+
+type ActionResult struct {
+	Index      int    `json:"index"`
+	Type       string `json:"type"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// InputResult holds the screen size the actions were checked against and
+// the result of each action.
+type InputResult struct {
+	Width   int            `json:"width"`
+	Height  int            `json:"height"`
+	Results []ActionResult `json:"results"`
+}
+
+// Success reports whether every action succeeded.
+func (r InputResult) Success() bool {
+	for _, result := range r.Results {
+		if result.Status != ActionOK {
+			return false
+		}
+	}
+	return true
+}
+
+// Normalize fills in the default durations of the action.
+func (a *Action) Normalize() {
+	if a.DurationMs != 0 {
+		return
+	}
+	switch a.Type {
+	case ActionLongPress:
+		a.DurationMs = DefaultLongPressMs
+	case ActionSwipe:
+		a.DurationMs = DefaultSwipeDurationMs
+	case ActionGesture:
+		a.DurationMs = DefaultGestureMs
+	}
+}
+
+// Validate checks the action against a screen of the given size.
+func (a Action) Validate(width int, height int) error {
+	inScreen := func(x, y int) error {
+		if x < 0 || y < 0 || x >= width || y >= height {
+			return fmt.Errorf("point (%d, %d) is outside the %dx%d screen", x, y, width, height)
+		}
+		return nil
+	}
+
+	if a.DurationMs < 0 || a.DurationMs > MaxActionDurationMs {
+		return fmt.Errorf("duration_ms must be between 0 and %d", MaxActionDurationMs)
+	}
+
+	switch a.Type {
+	case ActionTap, ActionLongPress:
+		return inScreen(a.X, a.Y)
+	case ActionSwipe:
+		if err := inScreen(a.X, a.Y); err != nil {
+			return err
+		}
+		return inScreen(a.ToX, a.ToY)
+	case ActionText:
+		if a.Text == "" || len(a.Text) > maxTextLength {
+			return fmt.Errorf("text must have between 1 and %d characters", maxTextLength)
+		}
+		for _, c := range a.Text {
+			if c < 0x20 || c > 0x7e {
+				return errors.New("text can only contain printable ASCII characters")
+			}
+		}
+		return nil
+	case ActionKeyEvent:
+		if !keyCodeRe.MatchString(a.Key) {
+			return fmt.Errorf("invalid key %q", a.Key)
+		}
+		return nil
+	case ActionGesture:
+		if len(a.Pointers) == 0 || len(a.Pointers) > MaxGesturePointers {
+			return fmt.Errorf("a gesture needs between 1 and %d pointers", MaxGesturePointers)
+		}
+		for _, path := range a.Pointers {
+			if len(path) == 0 {
+				return errors.New("each pointer needs at least one point")
+			}
+			for _, p := range path {
+				if err := inScreen(p.X, p.Y); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case "":
+		return errors.New("action type is required")
+	default:
+		return fmt.Errorf("unknown action type %q", a.Type)
+	}
+}
+
+// Input runs a batch of input actions on the emulator running in
+// containerName and returns the result of each action.
+func (c *Client) Input(ctx context.Context, containerName string, req InputRequest) (InputResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return InputResult{}, err
+	}
+
+	var result InputResult
+	err = c.do(ctx, http.MethodPost, "/input", url.Values{"containerName": {containerName}}, bytes.NewReader(body), "application/json", &result)
+	return result, err
+}
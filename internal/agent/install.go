@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/apk"
+)
+
+// InstallOptions are the pm install flags.
+type InstallOptions struct {
+	Replace          bool `json:"replace"`
+	GrantPermissions bool `json:"grant_permissions"`
+	Downgrade        bool `json:"downgrade"`
+	// AllowTest allows test only APKs, as built for the tests of an app.
+	AllowTest bool `json:"allow_test"`
+}
+
+// InstallResult is the outcome of an APK install.
+type InstallResult struct {
+	apk.Manifest
+	Splits  []string `json:"splits,omitempty"`
+	Success bool     `json:"success"`
+	Message string   `json:"message"`
+}
+
+// Query encodes the options as query parameters.
+func (o InstallOptions) Query() url.Values {
+	return url.Values{
+		"replace":          {strconv.FormatBool(o.Replace)},
+		"grantPermissions": {strconv.FormatBool(o.GrantPermissions)},
+		"downgrade":        {strconv.FormatBool(o.Downgrade)},
+		"allowTest":        {strconv.FormatBool(o.AllowTest)},
+	}
+}
+
+// ParseInstallOptions decodes the options from query parameters.
+func ParseInstallOptions(query url.Values) InstallOptions {
+	replace, _ := strconv.ParseBool(query.Get("replace"))
+	grant, _ := strconv.ParseBool(query.Get("grantPermissions"))
+	downgrade, _ := strconv.ParseBool(query.Get("downgrade"))
+	allowTest, _ := strconv.ParseBool(query.Get("allowTest"))
+
+	return InstallOptions{
+		Replace:          replace,
+		GrantPermissions: grant,
+		Downgrade:        downgrade,
+		AllowTest:        allowTest,
+	}
+}
+
+// InstallApp streams an APK, or a zip of split APKs, to the agent and
+// installs it on the emulator running in containerName.
+func (c *Client) InstallApp(ctx context.Context, containerName string, app io.Reader, opts InstallOptions) (InstallResult, error) {
+	query := opts.Query()
+	query.Set("containerName", containerName)
+
+	var result InstallResult
+	err := c.do(ctx, http.MethodPost, "/install-app", query, app, "application/vnd.android.package-archive", &result)
+	return result, err
+}
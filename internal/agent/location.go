@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/geo"
+)
+
+// Location modes.
+const (
+	LocationFixed = "fixed"
+	LocationRoute = "route"
+)
+
+// Route replay states.
+const (
+	RoutePlaying  = "playing"
+	RoutePaused   = "paused"
+	RouteStopped  = "stopped"
+	RouteFinished = "finished"
+	RouteFailed   = "failed"
+)
+
+// Route replay controls.
+const (
+	RoutePlay  = "play"
+	RoutePause = "pause"
+	RouteStop  = "stop"
+)
+
+// Location is the simulated position of a device and, for a route, how far
+// its replay went.
+type Location struct {
+	Mode       string     `json:"mode,omitempty"`
+	Position   *geo.Point `json:"position,omitempty"`
+	State      string     `json:"state,omitempty"`
+	Points     int        `json:"points,omitempty"`
+	DurationMs int64      `json:"duration_ms,omitempty"`
+	ElapsedMs  int64      `json:"elapsed_ms,omitempty"`
+	Progress   float64    `json:"progress,omitempty"`
+	Loop       bool       `json:"loop,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// RouteRequest is a route to replay, given as points or as the content of
+// a GPX or KML file in Data. Speed, in meters per second, applies between
+// points without timestamps or speed of their own.
+type RouteRequest struct {
+	Format string      `json:"format,omitempty"`
+	Data   string      `json:"data,omitempty"`
+	Points []geo.Point `json:"points,omitempty"`
+	Speed  float64     `json:"speed,omitempty"`
+	Loop   bool        `json:"loop,omitempty"`
+}
+
+// ParseRouteQuery reads a route uploaded as a GPX or KML file in body,
+// with the format, speed and loop options in the query.
+func ParseRouteQuery(query url.Values, body string) (RouteRequest, error) {
+	req := RouteRequest{
+		Format: strings.ToLower(query.Get("format")),
+		Data:   body,
+		Loop:   query.Get("loop") == "true",
+	}
+	if req.Format != geo.FormatGPX && req.Format != geo.FormatKML {
+		return RouteRequest{}, fmt.Errorf("format must be %s or %s", geo.FormatGPX, geo.FormatKML)
+	}
+	if v := query.Get("speed"); v != "" {
+		speed, err := strconv.ParseFloat(v, 64)
+		if err != nil || speed <= 0 {
+			return RouteRequest{}, fmt.Errorf("speed must be a positive number of meters per second")
+		}
+		req.Speed = speed
+	}
+	return req, nil
+}
+
+// Route parses and times the points of the request.
+func (r RouteRequest) Route() (*geo.Route, error) {
+	points := r.Points
+	if r.Format != "" && r.Format != geo.FormatPoints {
+		var err error
+		points, err = geo.Parse(r.Format, strings.NewReader(r.Data))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return geo.NewRoute(points, r.Speed)
+}
+
+// SetLocation places the emulator running in containerName at a fixed
+// position, stopping any route replay.
+func (c *Client) SetLocation(ctx context.Context, containerName string, p geo.Point) (Location, error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return Location{}, err
+	}
+
+	var location Location
+	err = c.do(ctx, http.MethodPost, "/location", url.Values{"containerName": {containerName}}, bytes.NewReader(body), "application/json", &location)
+	return location, err
+}
+
+// ReplayRoute starts moving the emulator running in containerName along
+// a route, replacing any route replaying.
+func (c *Client) ReplayRoute(ctx context.Context, containerName string, req RouteRequest) (Location, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Location{}, err
+	}
+
+	var location Location
+	err = c.do(ctx, http.MethodPost, "/route", url.Values{"containerName": {containerName}}, bytes.NewReader(body), "application/json", &location)
+	return location, err
+}
+
+// ControlRoute plays, pauses or stops the route replay of the emulator
+// running in containerName.
+func (c *Client) ControlRoute(ctx context.Context, containerName string, action string) (Location, error) {
+	var location Location
+	err := c.do(ctx, http.MethodPost, "/route-control", url.Values{"containerName": {containerName}, "action": {action}}, nil, "", &location)
+	return location, err
+}
+
+// GetLocation returns the simulated position of the emulator running in
+// containerName.
+func (c *Client) GetLocation(ctx context.Context, containerName string) (Location, error) {
+	var location Location
+	err := c.do(ctx, http.MethodGet, "/location", url.Values{"containerName": {containerName}}, nil, "", &location)
+	return location, err
+}
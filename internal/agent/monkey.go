@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/monkey"
+)
+
+// Statuses of a monkey run.
+const (
+	MonkeyRunning = "RUNNING"
+	// MonkeyPassed is a run that sent its events without a crash or an ANR.
+	MonkeyPassed = "PASSED"
+	MonkeyFailed = "FAILED"
+	// MonkeyError is a run that could not run the monkey.
+	MonkeyError = "ERROR"
+)
+
+// MonkeyRequest starts a monkey run with its options, or with the options
+// and seed of an earlier run of the owner when ReplayOf is set.
+type MonkeyRequest struct {
+	monkey.Options
+	ReplayOf string `json:"replay_of,omitempty"`
+}
+
+// MonkeyRun is a run of the monkey against a package on a device.
+type MonkeyRun struct {
+	ID       string `json:"id"`
+	DeviceID string `json:"device_id"`
+	Owner    string `json:"owner"`
+	Status   string `json:"status"`
+	// Options are the options of the run with its seed, they replay it.
+	Options    monkey.Options `json:"options"`
+	ReplayOf   string         `json:"replay_of,omitempty"`
+	Command    string         `json:"command"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Result     *monkey.Result `json:"result,omitempty"`
+	// Artifacts are the structured result, the monkey output, the logcat
+	// and the thread dumps of the ANRs.
+	Artifacts []artifacts.Artifact `json:"artifacts,omitempty"`
+	Error     string               `json:"error,omitempty"`
+}
+
+// RunMonkey starts a monkey run on the emulator running in containerName,
+// it returns once the run started.
+func (c *Client) RunMonkey(ctx context.Context, containerName string, owner string, req MonkeyRequest) (MonkeyRun, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return MonkeyRun{}, err
+	}
+
+	var run MonkeyRun
+	err = c.do(ctx, http.MethodPost, "/monkey", url.Values{"containerName": {containerName}, "owner": {owner}}, bytes.NewReader(body), "application/json", &run)
+	return run, err
+}
+
+// GetMonkeyRun returns a monkey run of owner.
+func (c *Client) GetMonkeyRun(ctx context.Context, owner string, id string) (MonkeyRun, error) {
+	var run MonkeyRun
+	err := c.do(ctx, http.MethodGet, "/monkey-runs", url.Values{"owner": {owner}, "id": {id}}, nil, "", &run)
+	return run, err
+}
+
+// ListMonkeyRuns returns the monkey runs of owner, newest first.
+func (c *Client) ListMonkeyRuns(ctx context.Context, owner string) ([]MonkeyRun, error) {
+	var runs []MonkeyRun
+	err := c.do(ctx, http.MethodGet, "/monkey-runs", url.Values{"owner": {owner}}, nil, "", &runs)
+	return runs, err
+}
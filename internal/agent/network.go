@@ -0,0 +1,24 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/network"
+)
+
+// SetNetwork applies a network profile to the emulator running in
+// containerName and returns the profile applied.
+func (c *Client) SetNetwork(ctx context.Context, containerName string, req network.Request) (network.Profile, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return network.Profile{}, err
+	}
+
+	var profile network.Profile
+	err = c.do(ctx, http.MethodPost, "/network", url.Values{"containerName": {containerName}}, bytes.NewReader(body), "application/json", &profile)
+	return profile, err
+}
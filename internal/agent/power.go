@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Power operations on a running device. Reboot restarts Android, ColdBoot
+// restarts the emulator without its quick boot snapshot and WipeData also
+// resets the data partition to the one of a new device.
+const (
+	PowerReboot   = "reboot"
+	PowerColdBoot = "coldBoot"
+	PowerWipeData = "wipeData"
+)
+
+var PowerOperations = []string{PowerReboot, PowerColdBoot, PowerWipeData}
+
+// Device statuses reported while a power operation runs and when it failed.
+const (
+	StatusRebooting   = "REBOOTING"
+	StatusColdBooting = "COLD_BOOTING"
+	StatusWipingData  = "WIPING_DATA"
+	StatusPowerFailed = "POWER_OPERATION_FAILED"
+)
+
+// PowerStatuses maps the power operations to the status of the device
+// while they run.
+var PowerStatuses = map[string]string{
+	PowerReboot:   StatusRebooting,
+	PowerColdBoot: StatusColdBooting,
+	PowerWipeData: StatusWipingData,
+}
+
+// PowerOperation is a power operation on a device, it is done once the
+// device booted again.
+type PowerOperation struct {
+	Operation  string     `json:"operation"`
+	DeviceID   string     `json:"device_id"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Power starts a power operation on the emulator running in containerName
+// and returns without waiting for the device to boot, the device status
+// tells when it is ready again.
+func (c *Client) Power(ctx context.Context, containerName string, operation string) (PowerOperation, error) {
+	var op PowerOperation
+	err := c.do(ctx, http.MethodPost, "/power", url.Values{"containerName": {containerName}, "operation": {operation}}, nil, "", &op)
+	return op, err
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+)
+
+var videoSizeRe = regexp.MustCompile(`^[1-9][0-9]*x[1-9][0-9]*$`)
+
+// RecordingOptions configure screenrecord and the owner of the stored video.
+type RecordingOptions struct {
+	// BitRate of the video in bits per second, 0 keeps the device default.
+	BitRate int `json:"bit_rate"`
+	// Size of the video as WIDTHxHEIGHT, empty keeps the screen size.
+	Size    string `json:"size"`
+	Owner   string `json:"owner"`
+	Session string `json:"session"`
+}
+
+// Recording is a screen recording in progress.
+type Recording struct {
+	ID        string    `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Owner     string    `json:"owner"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func (o RecordingOptions) Query() url.Values {
+	query := url.Values{}
+	if o.BitRate > 0 {
+		query.Set("bitRate", strconv.Itoa(o.BitRate))
+	}
+	if o.Size != "" {
+		query.Set("size", o.Size)
+	}
+	query.Set("owner", o.Owner)
+	query.Set("session", o.Session)
+	return query
+}
+
+// ParseRecordingOptions decodes and validates the options from query parameters.
+func ParseRecordingOptions(query url.Values) (RecordingOptions, error) {
+	opts := RecordingOptions{
+		Size:    query.Get("size"),
+		Owner:   query.Get("owner"),
+		Session: query.Get("session"),
+	}
+
+	if v := query.Get("bitRate"); v != "" {
+		var err error
+		if opts.BitRate, err = strconv.Atoi(v); err != nil || opts.BitRate <= 0 {
+			return opts, errors.New("bitRate must be a positive integer")
+		}
+	}
+	if opts.Size != "" && !videoSizeRe.MatchString(opts.Size) {
+		return opts, errors.New("size must be WIDTHxHEIGHT")
+	}
+
+	return opts, nil
+}
+
+// StartRecording starts recording the screen of the emulator running in containerName.
+func (c *Client) StartRecording(ctx context.Context, containerName string, opts RecordingOptions) (Recording, error) {
+	query := opts.Query()
+	query.Set("containerName", containerName)
+
+	var recording Recording
+	err := c.do(ctx, http.MethodPost, "/start-recording", query, nil, "", &recording)
+	return recording, err
+}
+
+// StopRecording stops the recording of the emulator running in
+// containerName and returns the stored video.
+func (c *Client) StopRecording(ctx context.Context, containerName string) (artifacts.Artifact, error) {
+	var artifact artifacts.Artifact
+	err := c.do(ctx, http.MethodPost, "/stop-recording", url.Values{"containerName": {containerName}}, nil, "", &artifact)
+	return artifact, err
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+)
+
+// Screenshot image formats.
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+)
+
+// ScreenshotOptions select the format and size of a screenshot, and
+// whether it is stored as an artifact of Owner.
+type ScreenshotOptions struct {
+	Format string `json:"format"`
+	// Width scales the image to the given width, keeping the aspect ratio.
+	Width int `json:"width"`
+	// Scale scales the image by a factor in (0, 1], ignored when Width is set.
+	Scale   float64 `json:"scale"`
+	Quality int     `json:"quality"`
+	Store   bool    `json:"store"`
+	Owner   string  `json:"owner"`
+	Session string  `json:"session"`
+}
+
+// Screenshot is either the captured image or, when it was stored, the artifact.
+type Screenshot struct {
+	Image       []byte
+	ContentType string
+	Artifact    *artifacts.Artifact
+}
+
+// ContentType returns the MIME type of the format.
+func ContentType(format string) string {
+	switch format {
+	case FormatJPEG:
+		return "image/jpeg"
+	case FormatWebP:
+		return "image/webp"
+	default:
+		return "image/png"
+	}
+}
+
+func (o ScreenshotOptions) Query() url.Values {
+	query := url.Values{}
+	query.Set("format", o.Format)
+	if o.Width > 0 {
+		query.Set("width", strconv.Itoa(o.Width))
+	}
+	if o.Scale > 0 {
+		query.Set("scale", strconv.FormatFloat(o.Scale, 'f', -1, 64))
+	}
+	if o.Quality > 0 {
+		query.Set("quality", strconv.Itoa(o.Quality))
+	}
+	if o.Store {
+		query.Set("store", "true")
+		query.Set("owner", o.Owner)
+		query.Set("session", o.Session)
+	}
+	return query
+}
+
+// ParseScreenshotOptions decodes and validates the options from query parameters.
+func ParseScreenshotOptions(query url.Values) (ScreenshotOptions, error) {
+	opts := ScreenshotOptions{
+		Format:  query.Get("format"),
+		Owner:   query.Get("owner"),
+		Session: query.Get("session"),
+	}
+
+	switch opts.Format {
+	case "":
+		opts.Format = FormatPNG
+	case "jpg":
+		opts.Format = FormatJPEG
+	case FormatPNG, FormatJPEG, FormatWebP:
+	default:
+		return opts, fmt.Errorf("unsupported format %q", opts.Format)
+	}
+
+	var err error
+	if v := query.Get("width"); v != "" {
+		if opts.Width, err = strconv.Atoi(v); err != nil || opts.Width <= 0 {
+			return opts, errors.New("width must be a positive integer")
+		}
+	}
+	if v := query.Get("scale"); v != "" {
+		if opts.Scale, err = strconv.ParseFloat(v, 64); err != nil || opts.Scale <= 0 || opts.Scale > 1 {
+			return opts, errors.New("scale must be in (0, 1]")
+		}
+	}
+	if v := query.Get("quality"); v != "" {
+		if opts.Quality, err = strconv.Atoi(v); err != nil || opts.Quality < 1 || opts.Quality > 100 {
+			return opts, errors.New("quality must be between 1 and 100")
+		}
+	}
+	opts.Store, _ = strconv.ParseBool(query.Get("store"))
+
+	return opts, nil
+}
+
+// TakeScreenshot captures the screen of the emulator running in containerName.
+func (c *Client) TakeScreenshot(ctx context.Context, containerName string, opts ScreenshotOptions) (Screenshot, error) {
+	query := opts.Query()
+	query.Set("containerName", containerName)
+
+	resp, err := c.send(ctx, http.MethodGet, "/screenshot", query, nil, "")
+	if err != nil {
+		return Screenshot{}, err
+	}
+	defer resp.Body.Close()
+
+	if opts.Store {
+		var artifact artifacts.Artifact
+		if err := json.NewDecoder(resp.Body).Decode(&artifact); err != nil {
+			return Screenshot{}, err
+		}
+		return Screenshot{Artifact: &artifact}, nil
+	}
+
+	image, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Screenshot{}, err
+	}
+
+	return Screenshot{Image: image, ContentType: resp.Header.Get("Content-Type")}, nil
+}
@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// Battery statuses and health values of the emulator console.
+var (
+	BatteryStatuses = []string{"unknown", "charging", "discharging", "not-charging", "full"}
+	BatteryHealths  = []string{"unknown", "good", "overheat", "dead", "overvoltage", "failure"}
+)
+
+// Orientations of the screen, in the order of their rotation.
+var Orientations = []string{"portrait", "landscape", "reverse_portrait", "reverse_landscape"}
+
+// OrientationAuto lets the accelerometer rotate the screen.
+const OrientationAuto = "auto"
+
+// Sensors maps the emulator sensors that can be set to their number of values.
+var Sensors = map[string]int{
+	"acceleration":   3,
+	"gyroscope":      3,
+	"magnetic-field": 3,
+	"proximity":      1,
+	"light":          1,
+	"temperature":    1,
+	"pressure":       1,
+	"humidity":       1,
+}
+
+const (
+	MaxFingerID          = 10
+	DefaultFingerTouchMs = 500
+	maxFingerTouchMs     = 10000
+	maxSensorValue       = 1e6
+)
+
+// Battery is the simulated battery. Charging plugs or unplugs the charger
+// and, without Status, sets the matching status.
+type Battery struct {
+	Level    *int   `json:"level,omitempty"`
+	Charging *bool  `json:"charging,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Health   string `json:"health,omitempty"`
+}
+
+// SensorsRequest changes the battery, orientation and sensor values given,
+// leaving the others as they are.
+type SensorsRequest struct {
+	Battery     *Battery             `json:"battery,omitempty"`
+	Orientation string               `json:"orientation,omitempty"`
+	Sensors     map[string][]float64 `json:"sensors,omitempty"`
+}
+
+// SensorsState holds the current simulated values of a device.
+type SensorsState struct {
+	Battery     Battery              `json:"battery"`
+	Orientation string               `json:"orientation,omitempty"`
+	Sensors     map[string][]float64 `json:"sensors"`
+}
+
+// FingerprintRequest touches the fingerprint sensor with an enrolled
+// finger for DurationMs.
+type FingerprintRequest struct {
+	FingerID   int `json:"finger_id,omitempty"`
+	DurationMs int `json:"duration_ms,omitempty"`
+}
+
+// Validate checks the values of the request.
+func (r SensorsRequest) Validate() error {
+	if r.Battery == nil && r.Orientation == "" && len(r.Sensors) == 0 {
+		return errors.New("nothing to set, give battery, orientation or sensors")
+	}
+
+	if b := r.Battery; b != nil {
+		if b.Level != nil && (*b.Level < 0 || *b.Level > 100) {
+			return errors.New("battery level must be between 0 and 100")
+		}
+		if b.Status != "" && !slices.Contains(BatteryStatuses, b.Status) {
+			return fmt.Errorf("battery status must be one of %v", BatteryStatuses)
+		}
+		if b.Health != "" && !slices.Contains(BatteryHealths, b.Health) {
+			return fmt.Errorf("battery health must be one of %v", BatteryHealths)
+		}
+	}
+
+	if r.Orientation != "" && r.Orientation != OrientationAuto && !slices.Contains(Orientations, r.Orientation) {
+		return fmt.Errorf("orientation must be %s or one of %v", OrientationAuto, Orientations)
+	}
+
+	for name, values := range r.Sensors {
+		count, ok := Sensors[name]
+		if !ok {
+			return fmt.Errorf("unknown sensor %q", name)
+		}
+		if len(values) != count {
+			return fmt.Errorf("sensor %s takes %d values", name, count)
+		}
+		for _, v := range values {
+			if math.IsNaN(v) || math.Abs(v) > maxSensorValue {
+				return fmt.Errorf("invalid value %v for sensor %s", v, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Normalize fills in the default finger and duration.
+func (r *FingerprintRequest) Normalize() {
+	if r.FingerID == 0 {
+		r.FingerID = 1
+	}
+	if r.DurationMs == 0 {
+		r.DurationMs = DefaultFingerTouchMs
+	}
+}
+
+// Validate checks the finger and duration of the touch.
+func (r FingerprintRequest) Validate() error {
+	if r.FingerID < 1 || r.FingerID > MaxFingerID {
+		return fmt.Errorf("finger_id must be between 1 and %d", MaxFingerID)
+	}
+	if r.DurationMs < 0 || r.DurationMs > maxFingerTouchMs {
+		return fmt.Errorf("duration_ms must be between 0 and %d", maxFingerTouchMs)
+	}
+	return nil
+}
+
+// SetSensors changes the simulated battery, orientation and sensors of the
+// emulator running in containerName and returns the values it then has.
+func (c *Client) SetSensors(ctx context.Context, containerName string, req SensorsRequest) (SensorsState, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return SensorsState{}, err
+	}
+
+	var state SensorsState
+	err = c.do(ctx, http.MethodPost, "/sensors", url.Values{"containerName": {containerName}}, bytes.NewReader(body), "application/json", &state)
+	return state, err
+}
+
+// GetSensors returns the simulated battery, orientation and sensor values
+// of the emulator running in containerName.
+func (c *Client) GetSensors(ctx context.Context, containerName string) (SensorsState, error) {
+	var state SensorsState
+	err := c.do(ctx, http.MethodGet, "/sensors", url.Values{"containerName": {containerName}}, nil, "", &state)
+	return state, err
+}
+
+// TouchFingerprint touches the fingerprint sensor of the emulator running
+// in containerName.
+func (c *Client) TouchFingerprint(ctx context.Context, containerName string, req FingerprintRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodPost, "/fingerprint", url.Values{"containerName": {containerName}}, bytes.NewReader(body), "application/json", nil)
+}
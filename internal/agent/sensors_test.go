@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSensorsRequestValidate(t *testing.T) {
+	level := func(v int) *Battery { return &Battery{Level: &v} }
+	charging := true
+
+	tests := []struct {
+		name string
+		req  SensorsRequest
+		// err is a part of the error message, empty when valid.
+		err string
+	}{
+		{"nothing", SensorsRequest{}, "nothing to set"},
+		{"empty sensors", SensorsRequest{Sensors: map[string][]float64{}}, "nothing to set"},
+		{"battery", SensorsRequest{Battery: &Battery{Charging: &charging, Status: "full", Health: "overheat"}}, ""},
+		{"empty battery", SensorsRequest{Battery: &Battery{}}, ""},
+		{"battery empty", SensorsRequest{Battery: level(0)}, ""},
+		{"battery full", SensorsRequest{Battery: level(100)}, ""},
+		{"battery over", SensorsRequest{Battery: level(101)}, "battery level"},
+		{"battery negative", SensorsRequest{Battery: level(-1)}, "battery level"},
+		{"battery status", SensorsRequest{Battery: &Battery{Status: "draining"}}, "battery status"},
+		{"battery health", SensorsRequest{Battery: &Battery{Health: "cold"}}, "battery health"},
+		{"orientation", SensorsRequest{Orientation: "reverse_landscape"}, ""},
+		{"orientation auto", SensorsRequest{Orientation: OrientationAuto}, ""},
+		{"unknown orientation", SensorsRequest{Orientation: "upside_down"}, "orientation must be"},
+		{"sensors", SensorsRequest{Sensors: map[string][]float64{
+			"acceleration": {0, 9.81, 0},
+			"light":        {-40000},
+			"proximity":    {0},
+		}}, ""},
+		{"unknown sensor", SensorsRequest{Sensors: map[string][]float64{"heart-rate": {72}}}, `unknown sensor "heart-rate"`},
+		{"too few values", SensorsRequest{Sensors: map[string][]float64{"gyroscope": {0, 0}}}, "gyroscope takes 3 values"},
+		{"too many values", SensorsRequest{Sensors: map[string][]float64{"temperature": {20, 21}}}, "temperature takes 1 values"},
+		{"NaN", SensorsRequest{Sensors: map[string][]float64{"pressure": {math.NaN()}}}, "invalid value"},
+		{"infinite", SensorsRequest{Sensors: map[string][]float64{"humidity": {math.Inf(-1)}}}, "invalid value"},
+		{"too large", SensorsRequest{Sensors: map[string][]float64{"magnetic-field": {0, 1e6 + 1, 0}}}, "invalid value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Validate = %v, want an error with %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFingerprintRequest(t *testing.T) {
+	tests := []struct {
+		req   FingerprintRequest
+		want  FingerprintRequest
+		valid bool
+	}{
+		{FingerprintRequest{}, FingerprintRequest{FingerID: 1, DurationMs: DefaultFingerTouchMs}, true},
+		{FingerprintRequest{FingerID: MaxFingerID, DurationMs: 10000}, FingerprintRequest{FingerID: MaxFingerID, DurationMs: 10000}, true},
+		{FingerprintRequest{FingerID: MaxFingerID + 1}, FingerprintRequest{FingerID: MaxFingerID + 1, DurationMs: DefaultFingerTouchMs}, false},
+		{FingerprintRequest{FingerID: -1}, FingerprintRequest{FingerID: -1, DurationMs: DefaultFingerTouchMs}, false},
+		{FingerprintRequest{DurationMs: -5}, FingerprintRequest{FingerID: 1, DurationMs: -5}, false},
+		{FingerprintRequest{DurationMs: 10001}, FingerprintRequest{FingerID: 1, DurationMs: 10001}, false},
+	}
+
+	for _, tt := range tests {
+		req := tt.req
+		req.Normalize()
+		if req != tt.want {
+			t.Errorf("Normalize(%+v) = %+v, want %+v", tt.req, req, tt.want)
+		}
+		if err := req.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v", req, err)
+		}
+	}
+}
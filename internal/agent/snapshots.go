@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/snapshots"
+)
+
+// SaveSnapshot saves the state of the emulator running in containerName as
+// a snapshot of owner, replacing a snapshot of the same name.
+func (c *Client) SaveSnapshot(ctx context.Context, containerName string, owner string, req snapshots.Request) (snapshots.Snapshot, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return snapshots.Snapshot{}, err
+	}
+
+	var snapshot snapshots.Snapshot
+	err = c.do(ctx, http.MethodPost, "/snapshots", url.Values{"containerName": {containerName}, "owner": {owner}}, bytes.NewReader(body), "application/json", &snapshot)
+	return snapshot, err
+}
+
+// ListSnapshots returns the snapshots of owner, oldest first.
+func (c *Client) ListSnapshots(ctx context.Context, owner string) ([]snapshots.Snapshot, error) {
+	var list []snapshots.Snapshot
+	err := c.do(ctx, http.MethodGet, "/snapshots", url.Values{"owner": {owner}}, nil, "", &list)
+	return list, err
+}
+
+// RestoreSnapshot loads a snapshot of owner into the emulator running in
+// containerName, which keeps running.
+func (c *Client) RestoreSnapshot(ctx context.Context, containerName string, owner string, req snapshots.Request) (snapshots.Snapshot, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return snapshots.Snapshot{}, err
+	}
+
+	var snapshot snapshots.Snapshot
+	err = c.do(ctx, http.MethodPost, "/restore-snapshot", url.Values{"containerName": {containerName}, "owner": {owner}}, bytes.NewReader(body), "application/json", &snapshot)
+	return snapshot, err
+}
+
+// DeleteSnapshot deletes a snapshot of owner, and its copy in the emulator
+// running in containerName when one is given.
+func (c *Client) DeleteSnapshot(ctx context.Context, containerName string, owner string, name string) error {
+	query := url.Values{"owner": {owner}, "name": {name}}
+	if containerName != "" {
+		query.Set("containerName", containerName)
+	}
+	return c.do(ctx, http.MethodDelete, "/snapshots", query, nil, "", nil)
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Call actions, each sent to the emulator modem for a number.
+const (
+	CallIncoming = "incoming"
+	CallAccept   = "accept"
+	CallEnd      = "end"
+	CallBusy     = "busy"
+	CallHold     = "hold"
+)
+
+const maxSMSLength = 1000
+
+var (
+	phoneNumberRe = regexp.MustCompile(`^\+?[0-9]{1,20}$`)
+
+	// callCommands maps the call actions to the console gsm commands.
+	callCommands = map[string]string{
+		CallIncoming: "call",
+		CallAccept:   "accept",
+		CallEnd:      "cancel",
+		CallBusy:     "busy",
+		CallHold:     "hold",
+	}
+)
+
+// SMSRequest delivers an SMS to the device.
+type SMSRequest struct {
+	From    string `json:"from"`
+	Message string `json:"message"`
+}
+
+// CallRequest places, accepts or ends a call from Number.
+type CallRequest struct {
+	Action string `json:"action"`
+	Number string `json:"number"`
+}
+
+// Calls lists the calls of the device modem as the console reports them,
+// such as "inbound from 5551234 : active".
+type Calls struct {
+	Calls []string `json:"calls"`
+}
+
+func (r SMSRequest) Validate() error {
+	if !phoneNumberRe.MatchString(r.From) {
+		return fmt.Errorf("invalid phone number %q", r.From)
+	}
+	if r.Message == "" || len(r.Message) > maxSMSLength {
+		return fmt.Errorf("message must have between 1 and %d characters", maxSMSLength)
+	}
+	if strings.ContainsAny(r.Message, "\r\n") {
+		return errors.New("message must be a single line")
+	}
+	return nil
+}
+
+// Command returns the console command delivering the SMS.
+func (r SMSRequest) Command() string {
+	return fmt.Sprintf("sms send %s %s", r.From, r.Message)
+}
+
+func (r CallRequest) Validate() error {
+	if _, ok := callCommands[r.Action]; !ok {
+		return fmt.Errorf("action must be %s, %s, %s, %s or %s", CallIncoming, CallAccept, CallEnd, CallBusy, CallHold)
+	}
+	if !phoneNumberRe.MatchString(r.Number) {
+		return fmt.Errorf("invalid phone number %q", r.Number)
+	}
+	return nil
+}
+
+// Command returns the console command of the call action.
+func (r CallRequest) Command() string {
+	return fmt.Sprintf("gsm %s %s", callCommands[r.Action], r.Number)
+}
+
+// SendSMS delivers an SMS to the emulator running in containerName.
+func (c *Client) SendSMS(ctx context.Context, containerName string, req SMSRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodPost, "/sms", url.Values{"containerName": {containerName}}, bytes.NewReader(body), "application/json", nil)
+}
+
+// Call applies a call action to the emulator running in containerName and
+// returns the calls it then has.
+func (c *Client) Call(ctx context.Context, containerName string, req CallRequest) (Calls, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Calls{}, err
+	}
+
+	var calls Calls
+	err = c.do(ctx, http.MethodPost, "/call", url.Values{"containerName": {containerName}}, bytes.NewReader(body), "application/json", &calls)
+	return calls, err
+}
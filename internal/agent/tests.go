@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/instrument"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/sharding"
+)
+
+// Statuses of a test run.
+const (
+	TestRunRunning = "RUNNING"
+	TestRunPassed  = "PASSED"
+	TestRunFailed  = "FAILED"
+	// TestRunError is a run that could not install or run the tests, or
+	// whose runner did not complete.
+	TestRunError = "ERROR"
+)
+
+// TestRun is a run of the instrumentation tests of an app on a device.
+type TestRun struct {
+	ID              string             `json:"id"`
+	DeviceID        string             `json:"device_id"`
+	Owner           string             `json:"owner"`
+	Status          string             `json:"status"`
+	AppPackage      string             `json:"app_package,omitempty"`
+	TestPackage     string             `json:"test_package,omitempty"`
+	Instrumentation string             `json:"instrumentation,omitempty"`
+	Args            map[string]string  `json:"args,omitempty"`
+	StartedAt       time.Time          `json:"started_at"`
+	FinishedAt      *time.Time         `json:"finished_at,omitempty"`
+	Result          *instrument.Result `json:"result,omitempty"`
+	// Artifacts are the JUnit XML report, the logcat and the screenshots
+	// of failed tests.
+	Artifacts []artifacts.Artifact `json:"artifacts,omitempty"`
+	Error     string               `json:"error,omitempty"`
+	// Strategy and Shards describe a sharded run, DeviceID is then the
+	// device of the user it uses, if any.
+	Strategy string      `json:"strategy,omitempty"`
+	Shards   []TestShard `json:"shards,omitempty"`
+	// Warnings tell what a sharded run could not do as asked, as retries
+	// skipped when a single device was ready.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// TestShard is the part of a sharded run on one device.
+type TestShard struct {
+	Index    int    `json:"index"`
+	DeviceID string `json:"device_id"`
+	// Provisioned tells whether the device was started for the run, it is
+	// deleted with the run.
+	Provisioned bool                `json:"provisioned"`
+	Status      string              `json:"status"`
+	Tests       int                 `json:"tests"`
+	Summary     *instrument.Summary `json:"summary,omitempty"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// ShardOptions describe how a sharded run splits the suite and on which
+// devices. Devices are provisioned with AndroidAPI and DeviceName next to
+// the device of the user, when it is used.
+type ShardOptions struct {
+	Shards     int    `json:"shards"`
+	Strategy   string `json:"strategy"`
+	Retries    int    `json:"retries"`
+	AndroidAPI string `json:"android_api"`
+	DeviceName string `json:"device_name"`
+}
+
+// Query encodes the options as query parameters.
+func (o ShardOptions) Query() url.Values {
+	return url.Values{
+		"shards":     {strconv.Itoa(o.Shards)},
+		"strategy":   {o.Strategy},
+		"retries":    {strconv.Itoa(o.Retries)},
+		"androidAPI": {o.AndroidAPI},
+		"deviceName": {o.DeviceName},
+	}
+}
+
+// ParseShardOptions decodes and validates the options from query
+// parameters.
+func ParseShardOptions(query url.Values) (ShardOptions, error) {
+	opts := ShardOptions{
+		Strategy:   query.Get("strategy"),
+		AndroidAPI: query.Get("androidAPI"),
+		DeviceName: query.Get("deviceName"),
+	}
+
+	var err error
+	if opts.Shards, err = strconv.Atoi(query.Get("shards")); err != nil || opts.Shards < 1 {
+		return opts, errors.New("shards must be a positive integer")
+	}
+	if v := query.Get("retries"); v != "" {
+		if opts.Retries, err = strconv.Atoi(v); err != nil || opts.Retries < 0 {
+			return opts, errors.New("retries must be a non-negative integer")
+		}
+	}
+	switch opts.Strategy {
+	case "":
+		opts.Strategy = sharding.ByIndex
+	case sharding.ByIndex, sharding.ByDuration:
+	default:
+		return opts, fmt.Errorf("strategy must be one of %v", sharding.Strategies)
+	}
+
+	return opts, nil
+}
+
+// RunTests streams a multipart form with the app and test APKs, in the app
+// and test parts, and the runner and arg fields to the agent, which runs
+// the tests on the emulator running in containerName. It returns once the
+// run started.
+func (c *Client) RunTests(ctx context.Context, containerName string, owner string, form io.Reader, contentType string) (TestRun, error) {
+	var run TestRun
+	err := c.do(ctx, http.MethodPost, "/run-tests", url.Values{"containerName": {containerName}, "owner": {owner}}, form, contentType, &run)
+	return run, err
+}
+
+// RunShardedTests streams the same form as RunTests to the agent, which
+// splits the tests into shards run in parallel on the emulator running in
+// containerName, when it is set, and on devices provisioned for the run.
+// It returns once the run started.
+func (c *Client) RunShardedTests(ctx context.Context, containerName string, owner string, opts ShardOptions, form io.Reader, contentType string) (TestRun, error) {
+	query := opts.Query()
+	query.Set("owner", owner)
+	if containerName != "" {
+		query.Set("containerName", containerName)
+	}
+
+	var run TestRun
+	err := c.do(ctx, http.MethodPost, "/run-sharded-tests", query, form, contentType, &run)
+	return run, err
+}
+
+// GetTestRun returns a test run of owner.
+func (c *Client) GetTestRun(ctx context.Context, owner string, id string) (TestRun, error) {
+	var run TestRun
+	err := c.do(ctx, http.MethodGet, "/test-runs", url.Values{"owner": {owner}, "id": {id}}, nil, "", &run)
+	return run, err
+}
+
+// ListTestRuns returns the test runs of owner, newest first.
+func (c *Client) ListTestRuns(ctx context.Context, owner string) ([]TestRun, error) {
+	var runs []TestRun
+	err := c.do(ctx, http.MethodGet, "/test-runs", url.Values{"owner": {owner}}, nil, "", &runs)
+	return runs, err
+}
@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/volumes"
+)
+
+// ListVolumes returns the persistent data volumes of owner.
+func (c *Client) ListVolumes(ctx context.Context, owner string) ([]volumes.Volume, error) {
+	var list []volumes.Volume
+	err := c.do(ctx, http.MethodGet, "/volumes", url.Values{"owner": {owner}}, nil, "", &list)
+	return list, err
+}
+
+// DeleteVolume deletes a data volume of owner that no device uses.
+func (c *Client) DeleteVolume(ctx context.Context, owner string, androidAPI string, name string) error {
+	return c.do(ctx, http.MethodDelete, "/volumes", volumeQuery(owner, androidAPI, name), nil, "", nil)
+}
+
+// ResetVolume empties a data volume of owner that no device uses, the next
+// device using it starts as a new one.
+func (c *Client) ResetVolume(ctx context.Context, owner string, androidAPI string, name string) (volumes.Volume, error) {
+	var volume volumes.Volume
+	err := c.do(ctx, http.MethodPost, "/reset-volume", volumeQuery(owner, androidAPI, name), nil, "", &volume)
+	return volume, err
+}
+
+func volumeQuery(owner string, androidAPI string, name string) url.Values {
+	return url.Values{"owner": {owner}, "androidAPI": {androidAPI}, "name": {name}}
+}
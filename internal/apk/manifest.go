@@ -0,0 +1,290 @@
+package apk
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Chunk types of the Android binary XML format.
+const (
+	chunkStringPool   = 0x0001
+	chunkXML          = 0x0003
+	chunkResourceMap  = 0x0180
+	chunkStartElement = 0x0102
+)
+
+// Typed value types used by manifest attributes.
+const (
+	typeString = 0x03
+	typeIntDec = 0x10
+	typeIntHex = 0x11
+)
+
+// Resource ids of the android: attributes read from the manifest, used
+// when the attribute names were stripped by an obfuscator.
+const (
+	attrVersionCode = 0x0101021b
+	attrVersionName = 0x0101021c
+)
+
+var (
+	ErrNoManifest      = errors.New("apk has no AndroidManifest.xml")
+	ErrInvalidManifest = errors.New("invalid binary manifest")
+)
+
+// Manifest holds the identity of an APK read from its manifest.
+type Manifest struct {
+	Package     string `json:"package"`
+	VersionCode int64  `json:"version_code"`
+	VersionName string `json:"version_name"`
+	// Split is the name of the split, empty for a base APK.
+	Split string `json:"split,omitempty"`
+}
+
+// ReadManifest reads the manifest of the APK in r.
+func ReadManifest(r io.ReaderAt, size int64) (Manifest, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	return readManifest(zr)
+}
+
+func readManifest(zr *zip.Reader) (Manifest, error) {
+	for _, f := range zr.File {
+		if f.Name != "AndroidManifest.xml" {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return Manifest{}, err
+		}
+		defer rc.Close()
+
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			return Manifest{}, err
+		}
+
+		return ParseManifest(data)
+	}
+
+	return Manifest{}, ErrNoManifest
+}
+
+// ParseManifest parses a compiled AndroidManifest.xml.
+func ParseManifest(data []byte) (Manifest, error) {
+	if len(data) < 8 || binary.LittleEndian.Uint16(data) != chunkXML {
+		return Manifest{}, ErrInvalidManifest
+	}
+
+	var strings []string
+	var resourceIDs []uint32
+
+	offset := int(binary.LittleEndian.Uint16(data[2:]))
+	for offset+8 <= len(data) {
+		chunkType := binary.LittleEndian.Uint16(data[offset:])
+		chunkSize := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		if chunkSize < 8 || offset+chunkSize > len(data) {
+			return Manifest{}, ErrInvalidManifest
+		}
+		chunk := data[offset : offset+chunkSize]
+
+		switch chunkType {
+		case chunkStringPool:
+			var err error
+			strings, err = parseStringPool(chunk)
+			if err != nil {
+				return Manifest{}, err
+			}
+		case chunkResourceMap:
+			headerSize := int(binary.LittleEndian.Uint16(chunk[2:]))
+			for i := headerSize; i+4 <= len(chunk); i += 4 {
+				resourceIDs = append(resourceIDs, binary.LittleEndian.Uint32(chunk[i:]))
+			}
+		case chunkStartElement:
+			// The first element of a manifest is <manifest>.
+			return parseManifestElement(chunk, strings, resourceIDs)
+		}
+
+		offset += chunkSize
+	}
+
+	return Manifest{}, ErrInvalidManifest
+}
+
+func parseManifestElement(chunk []byte, strings []string, resourceIDs []uint32) (Manifest, error) {
+	headerSize := int(binary.LittleEndian.Uint16(chunk[2:]))
+	if len(chunk) < headerSize+20 {
+		return Manifest{}, ErrInvalidManifest
+	}
+
+	ext := chunk[headerSize:]
+	if lookup(strings, binary.LittleEndian.Uint32(ext[4:])) != "manifest" {
+		return Manifest{}, fmt.Errorf("%w: root element is not manifest", ErrInvalidManifest)
+	}
+
+	attrStart := int(binary.LittleEndian.Uint16(ext[8:]))
+	attrSize := int(binary.LittleEndian.Uint16(ext[10:]))
+	attrCount := int(binary.LittleEndian.Uint16(ext[12:]))
+	if len(ext) < attrStart+attrSize*attrCount || attrSize < 20 {
+		return Manifest{}, ErrInvalidManifest
+	}
+
+	var manifest Manifest
+	for i := 0; i < attrCount; i++ {
+		attr := ext[attrStart+i*attrSize:]
+		nameIndex := binary.LittleEndian.Uint32(attr[4:])
+		rawValue := binary.LittleEndian.Uint32(attr[8:])
+		dataType := attr[15]
+		value := binary.LittleEndian.Uint32(attr[16:])
+
+		name := lookup(strings, nameIndex)
+		if int(nameIndex) < len(resourceIDs) {
+			switch resourceIDs[nameIndex] {
+			case attrVersionCode:
+				name = "versionCode"
+			case attrVersionName:
+				name = "versionName"
+			}
+		}
+
+		var str string
+		switch dataType {
+		case typeString:
+			str = lookup(strings, value)
+		case typeIntDec, typeIntHex:
+			str = strconv.FormatUint(uint64(value), 10)
+		default:
+			str = lookup(strings, rawValue)
+		}
+
+		switch name {
+		case "package":
+			manifest.Package = str
+		case "versionCode":
+			manifest.VersionCode, _ = strconv.ParseInt(str, 10, 64)
+		case "versionName":
+			manifest.VersionName = str
+		case "split":
+			manifest.Split = str
+		}
+	}
+
+	if manifest.Package == "" {
+		return Manifest{}, fmt.Errorf("%w: missing package name", ErrInvalidManifest)
+	}
+
+	return manifest, nil
+}
+
+// lookup returns the string at index, or an empty string for the 0xffffffff
+// "no string" index.
+func lookup(strings []string, index uint32) string {
+	if int64(index) >= int64(len(strings)) {
+		return ""
+	}
+	return strings[index]
+}
+
+func parseStringPool(chunk []byte) ([]string, error) {
+	if len(chunk) < 28 {
+		return nil, ErrInvalidManifest
+	}
+
+	headerSize := int(binary.LittleEndian.Uint16(chunk[2:]))
+	count := int(binary.LittleEndian.Uint32(chunk[8:]))
+	flags := binary.LittleEndian.Uint32(chunk[16:])
+	stringsStart := int(binary.LittleEndian.Uint32(chunk[20:]))
+	utf8 := flags&0x100 != 0
+
+	if headerSize+count*4 > len(chunk) || stringsStart > len(chunk) {
+		return nil, ErrInvalidManifest
+	}
+
+	strings := make([]string, count)
+	for i := range strings {
+		offset := stringsStart + int(binary.LittleEndian.Uint32(chunk[headerSize+i*4:]))
+		if offset >= len(chunk) {
+			return nil, ErrInvalidManifest
+		}
+
+		var err error
+		if utf8 {
+			strings[i], err = decodeUTF8(chunk[offset:])
+		} else {
+			strings[i], err = decodeUTF16(chunk[offset:])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return strings, nil
+}
+
+// decodeUTF8 decodes a string pool entry: the UTF-16 length and the UTF-8
+// length, each on one or two bytes, followed by the bytes.
+func decodeUTF8(b []byte) (string, error) {
+	_, n := utf8Length(b)
+	if n == 0 {
+		return "", ErrInvalidManifest
+	}
+	b = b[n:]
+
+	length, n := utf8Length(b)
+	if n == 0 || n+length > len(b) {
+		return "", ErrInvalidManifest
+	}
+
+	return string(b[n : n+length]), nil
+}
+
+func utf8Length(b []byte) (int, int) {
+	if len(b) < 1 {
+		return 0, 0
+	}
+	if b[0]&0x80 == 0 {
+		return int(b[0]), 1
+	}
+	if len(b) < 2 {
+		return 0, 0
+	}
+	return int(b[0]&0x7f)<<8 | int(b[1]), 2
+}
+
+// decodeUTF16 decodes a string pool entry: the length in code units on one
+// or two uint16 followed by the code units.
+func decodeUTF16(b []byte) (string, error) {
+	if len(b) < 2 {
+		return "", ErrInvalidManifest
+	}
+
+	length := int(binary.LittleEndian.Uint16(b))
+	b = b[2:]
+	if length&0x8000 != 0 {
+		if len(b) < 2 {
+			return "", ErrInvalidManifest
+		}
+		length = (length&0x7fff)<<16 | int(binary.LittleEndian.Uint16(b))
+		b = b[2:]
+	}
+
+	if length*2 > len(b) {
+		return "", ErrInvalidManifest
+	}
+
+	units := make([]uint16, length)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+
+	return string(utf16.Decode(units)), nil
+}
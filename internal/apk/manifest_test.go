@@ -0,0 +1,316 @@
+package apk
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixtures are binary manifests with the chunk layout aapt2 writes: a
+// UTF-16 string pool for the base APK, and for the split a UTF-8 pool whose
+// android: attribute names were stripped, as obfuscators do, so that they
+// are only known by their resource ids.
+var (
+	baseManifest  = Manifest{Package: "com.example.app", VersionCode: 20401, VersionName: "2.4.1-β"}
+	splitManifest = Manifest{Package: "com.example.app", VersionCode: 20401, VersionName: "2.4.1-β", Split: "config.arm64_v8a"}
+)
+
+func readFixture(t *testing.T, dir string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", dir, "AndroidManifest.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// chunkOffset returns the offset of the first chunk of the given type in
+// a binary XML document.
+func chunkOffset(t *testing.T, data []byte, chunkType uint16) int {
+	t.Helper()
+	for offset := int(binary.LittleEndian.Uint16(data[2:])); offset+8 <= len(data); offset += int(binary.LittleEndian.Uint32(data[offset+4:])) {
+		if binary.LittleEndian.Uint16(data[offset:]) == chunkType {
+			return offset
+		}
+	}
+	t.Fatalf("no chunk of type %#x", chunkType)
+	return 0
+}
+
+func TestParseManifest(t *testing.T) {
+	for dir, want := range map[string]Manifest{"base": baseManifest, "split": splitManifest} {
+		got, err := ParseManifest(readFixture(t, dir))
+		if err != nil {
+			t.Errorf("%s: %v", dir, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: ParseManifest = %+v, want %+v", dir, got, want)
+		}
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	apk := func(files map[string][]byte) *bytes.Reader {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, data := range files {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(data)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return bytes.NewReader(buf.Bytes())
+	}
+
+	r := apk(map[string][]byte{
+		"classes.dex":         []byte("dex\n035\x00"),
+		"AndroidManifest.xml": readFixture(t, "base"),
+		"res/layout/main.xml": readFixture(t, "split"),
+	})
+	if got, err := ReadManifest(r, r.Size()); err != nil || got != baseManifest {
+		t.Errorf("ReadManifest = %+v, %v, want %+v", got, err, baseManifest)
+	}
+
+	r = apk(map[string][]byte{"classes.dex": []byte("dex\n035\x00")})
+	if _, err := ReadManifest(r, r.Size()); !errors.Is(err, ErrNoManifest) {
+		t.Errorf("ReadManifest of an archive without manifest = %v, want ErrNoManifest", err)
+	}
+
+	r = bytes.NewReader([]byte("not a zip"))
+	if _, err := ReadManifest(r, r.Size()); err == nil {
+		t.Error("ReadManifest of a file that is not a zip succeeded")
+	}
+}
+
+func TestParseManifestCorrupt(t *testing.T) {
+	u16 := func(b []byte, v uint16) { binary.LittleEndian.PutUint16(b, v) }
+	u32 := func(b []byte, v uint32) { binary.LittleEndian.PutUint32(b, v) }
+
+	tests := []struct {
+		name    string
+		fixture string
+		corrupt func(t *testing.T, data []byte) []byte
+	}{
+		{
+			name:    "empty",
+			fixture: "base",
+			corrupt: func(t *testing.T, data []byte) []byte { return nil },
+		},
+		{
+			name:    "text manifest",
+			fixture: "base",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				return []byte(`<?xml version="1.0" encoding="utf-8"?><manifest package="com.example.app"/>`)
+			},
+		},
+		{
+			name:    "chunk past the end",
+			fixture: "base",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				u32(data[chunkOffset(t, data, chunkStringPool)+4:], uint32(len(data)))
+				return data
+			},
+		},
+		{
+			name:    "chunk smaller than its header",
+			fixture: "split",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				u32(data[chunkOffset(t, data, chunkResourceMap)+4:], 4)
+				return data
+			},
+		},
+		{
+			name:    "string offset past the pool",
+			fixture: "base",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				pool := chunkOffset(t, data, chunkStringPool)
+				u32(data[pool+28+3*4:], 0x7fffffff)
+				return data
+			},
+		},
+		{
+			name:    "string count past the pool",
+			fixture: "split",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				u32(data[chunkOffset(t, data, chunkStringPool)+8:], 1<<20)
+				return data
+			},
+		},
+		{
+			name:    "UTF-8 string longer than the pool",
+			fixture: "split",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				pool := chunkOffset(t, data, chunkStringPool)
+				start := int(binary.LittleEndian.Uint32(data[pool+20:]))
+				last := int(binary.LittleEndian.Uint32(data[pool+28+4*13:]))
+				// hasCode, the last string, claims two-byte lengths of 0x7fff.
+				data[pool+start+last], data[pool+start+last+1] = 0xff, 0xff
+				data[pool+start+last+2], data[pool+start+last+3] = 0xff, 0xff
+				return data
+			},
+		},
+		{
+			name:    "UTF-16 string longer than the pool",
+			fixture: "base",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				pool := chunkOffset(t, data, chunkStringPool)
+				start := int(binary.LittleEndian.Uint32(data[pool+20:]))
+				u16(data[pool+start:], 0x8fff)
+				return data
+			},
+		},
+		{
+			name:    "root element is not manifest",
+			fixture: "base",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				// <uses-sdk> is string 12.
+				u32(data[chunkOffset(t, data, chunkStartElement)+16+4:], 12)
+				return data
+			},
+		},
+		{
+			name:    "attributes past the element",
+			fixture: "split",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				u16(data[chunkOffset(t, data, chunkStartElement)+16+12:], 200)
+				return data
+			},
+		},
+		{
+			name:    "attributes smaller than an attribute",
+			fixture: "base",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				u16(data[chunkOffset(t, data, chunkStartElement)+16+10:], 8)
+				return data
+			},
+		},
+		{
+			name:    "no package",
+			fixture: "base",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				// The package attribute, the third one, gets the name of the
+				// fourth.
+				element := chunkOffset(t, data, chunkStartElement)
+				u32(data[element+16+20+2*20+4:], 8)
+				return data
+			},
+		},
+		{
+			name:    "no element",
+			fixture: "base",
+			corrupt: func(t *testing.T, data []byte) []byte {
+				return data[:chunkOffset(t, data, chunkStartElement)]
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.corrupt(t, readFixture(t, tt.fixture))
+			if m, err := ParseManifest(data); !errors.Is(err, ErrInvalidManifest) {
+				t.Errorf("ParseManifest = %+v, %v, want ErrInvalidManifest", m, err)
+			}
+		})
+	}
+}
+
+// A split attribute naming no string leaves a base APK, it does not fail
+// the install.
+func TestParseManifestSplitWithoutString(t *testing.T) {
+	data := readFixture(t, "split")
+	element := chunkOffset(t, data, chunkStartElement)
+	// The split attribute is the fourth one, its value is the string index.
+	binary.LittleEndian.PutUint32(data[element+16+20+3*20+16:], 0xffffffff)
+
+	want := splitManifest
+	want.Split = ""
+	if got, err := ParseManifest(data); err != nil || got != want {
+		t.Errorf("ParseManifest = %+v, %v, want %+v", got, err, want)
+	}
+}
+
+// Any prefix of a manifest either fails or, once the <manifest> element was
+// read whole, parses as the full manifest; any byte may be garbage without
+// a panic.
+func TestParseManifestDamaged(t *testing.T) {
+	for dir, want := range map[string]Manifest{"base": baseManifest, "split": splitManifest} {
+		data := readFixture(t, dir)
+
+		for n := 0; n < len(data); n++ {
+			got, err := ParseManifest(data[:n])
+			if err != nil && !errors.Is(err, ErrInvalidManifest) {
+				t.Errorf("%s cut at %d: error %v is not ErrInvalidManifest", dir, n, err)
+			}
+			if err == nil && got != want {
+				t.Errorf("%s cut at %d: ParseManifest = %+v, want %+v", dir, n, got, want)
+			}
+		}
+
+		for i := range data {
+			for _, b := range []byte{0x00, 0x7f, 0x80, 0xff} {
+				damaged := bytes.Clone(data)
+				damaged[i] = b
+				if _, err := ParseManifest(damaged); err != nil && !errors.Is(err, ErrInvalidManifest) {
+					t.Errorf("%s with byte %d set to %#x: error %v is not ErrInvalidManifest", dir, i, b, err)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "bundletool set",
+			files: []string{"toc.pb", "standalones/standalone-arm64_v8a.apk", "splits/base-master.apk", "splits/base-arm64_v8a.apk"},
+			want:  []string{"splits/base-master.apk", "splits/base-arm64_v8a.apk"},
+		},
+		{
+			name:  "zip of APKs",
+			files: []string{"base.apk", "README.txt", "split_config.xxhdpi.apk", "nested/"},
+			want:  []string{"base.apk", "split_config.xxhdpi.apk"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			for _, name := range tt.files {
+				if _, err := zw.Create(name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			zw.Close()
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if IsAPK(zr) {
+				t.Error("IsAPK of a set of APKs = true")
+			}
+			var got []string
+			for _, f := range SplitEntries(zr) {
+				got = append(got, f.Name)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("SplitEntries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
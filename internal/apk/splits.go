@@ -0,0 +1,38 @@
+package apk
+
+import (
+	"archive/zip"
+	"path"
+	"strings"
+)
+
+// IsAPK reports whether the archive is an APK rather than a set of APKs.
+func IsAPK(zr *zip.Reader) bool {
+	for _, f := range zr.File {
+		if f.Name == "AndroidManifest.xml" {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitEntries returns the APKs to install from a split APK set. Sets built
+// by bundletool keep the splits under splits/ next to standalone APKs for
+// old devices, which are skipped. Any other zip of APKs is taken as is.
+func SplitEntries(zr *zip.Reader) []*zip.File {
+	var splits, all []*zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || path.Ext(f.Name) != ".apk" {
+			continue
+		}
+		all = append(all, f)
+		if strings.HasPrefix(f.Name, "splits/") {
+			splits = append(splits, f)
+		}
+	}
+
+	if len(splits) > 0 {
+		return splits
+	}
+	return all
+}
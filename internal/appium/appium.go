@@ -0,0 +1,234 @@
+package appium
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPort              = 4723
+	defaultBasePath          = "/wd/hub"
+	defaultNewCommandTimeout = 5 * time.Minute
+
+	// udid is the serial of the emulator as seen by the Appium server
+	// running next to it in the container.
+	udid = "emulator-5554"
+)
+
+// Capabilities prefilled in new sessions.
+const (
+	CapPlatformName      = "platformName"
+	CapAutomationName    = "appium:automationName"
+	CapUDID              = "appium:udid"
+	CapDeviceName        = "appium:deviceName"
+	CapNewCommandTimeout = "appium:newCommandTimeout"
+)
+
+type Config struct {
+	// Port is the port the Appium server listens on in the container.
+	Port int `mapstructure:"port"`
+	// BasePath is the path the Appium server serves WebDriver under.
+	BasePath string `mapstructure:"basePath"`
+	// NewCommandTimeout is how long a session may wait for a command before
+	// Appium ends it.
+	NewCommandTimeout time.Duration `mapstructure:"newCommandTimeout"`
+}
+
+var ErrInvalidSession = errors.New("new session request must be a JSON object")
+
+// Session is a WebDriver session opened on the Appium server of a device.
+type Session struct {
+	ID           string         `json:"id"`
+	DeviceID     string         `json:"device_id"`
+	Username     string         `json:"username"`
+	Capabilities map[string]any `json:"capabilities"`
+	CreatedAt    time.Time      `json:"created_at"`
+	LastUsedAt   time.Time      `json:"last_used_at"`
+}
+
+// Policy holds where the Appium servers listen and how long their sessions
+// live.
+type Policy struct {
+	port              int
+	basePath          string
+	newCommandTimeout time.Duration
+}
+
+func NewPolicy(cfg *Config) *Policy {
+	p := &Policy{
+		port:              cfg.Port,
+		basePath:          path.Clean("/" + cfg.BasePath),
+		newCommandTimeout: cfg.NewCommandTimeout,
+	}
+	if p.port <= 0 {
+		p.port = defaultPort
+	}
+	if cfg.BasePath == "" {
+		p.basePath = defaultBasePath
+	}
+	if p.newCommandTimeout <= 0 {
+		p.newCommandTimeout = defaultNewCommandTimeout
+	}
+	return p
+}
+
+// Port returns the port of the Appium server in the container.
+func (p *Policy) Port() int {
+	return p.port
+}
+
+// BasePath returns the path WebDriver is served under.
+func (p *Policy) BasePath() string {
+	return p.basePath
+}
+
+// NewCommandTimeout returns how long an idle session lives.
+func (p *Policy) NewCommandTimeout() time.Duration {
+	return p.newCommandTimeout
+}
+
+// DockerArgs returns the docker run arguments starting the Appium server of
+// the emulator image next to the emulator.
+func (p *Policy) DockerArgs() []string {
+	return []string{
+		"-e", "APPIUM=true",
+		"-e", "APPIUM_ADDITIONAL_ARGS=--base-path " + p.basePath,
+	}
+}
+
+// Capabilities returns the capabilities a session on the device starts
+// with.
+func (p *Policy) Capabilities(deviceName string) map[string]any {
+	return map[string]any{
+		CapPlatformName:      "Android",
+		CapAutomationName:    "UiAutomator2",
+		CapUDID:              udid,
+		CapDeviceName:        deviceName,
+		CapNewCommandTimeout: int(p.newCommandTimeout.Seconds()),
+	}
+}
+
+// fixed are the capabilities a client may not change, they pin the
+// session to the device.
+var fixed = []string{CapPlatformName, CapAutomationName, CapUDID}
+
+// PrefillSession adds the capabilities of the device to a new session
+// request: the W3C alwaysMatch capabilities and the legacy desired
+// capabilities when the client sends them. Values set by the client are
+// kept except those pinning the session to the device.
+func PrefillSession(body []byte, caps map[string]any) ([]byte, error) {
+	var req map[string]any
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil || req == nil {
+			return nil, ErrInvalidSession
+		}
+	}
+	if req == nil {
+		req = map[string]any{}
+	}
+
+	w3c, _ := req["capabilities"].(map[string]any)
+	if w3c == nil {
+		w3c = map[string]any{}
+		req["capabilities"] = w3c
+	}
+	always, _ := w3c["alwaysMatch"].(map[string]any)
+	if always == nil {
+		always = map[string]any{}
+		w3c["alwaysMatch"] = always
+	}
+	prefill(always, caps)
+
+	// firstMatch entries must not repeat alwaysMatch keys.
+	if first, ok := w3c["firstMatch"].([]any); ok {
+		for _, entry := range first {
+			if m, ok := entry.(map[string]any); ok {
+				for k := range caps {
+					delete(m, k)
+				}
+			}
+		}
+	}
+
+	if desired, ok := req["desiredCapabilities"].(map[string]any); ok {
+		legacy := map[string]any{}
+		for k, v := range caps {
+			legacy[legacyName(k)] = v
+		}
+		prefill(desired, legacy)
+	}
+
+	return json.Marshal(req)
+}
+
+func prefill(dst map[string]any, caps map[string]any) {
+	for k, v := range caps {
+		if _, ok := dst[k]; !ok {
+			dst[k] = v
+		}
+	}
+	// caps are named as in the W3C or the legacy protocol.
+	for _, k := range fixed {
+		for _, name := range []string{k, legacyName(k)} {
+			if v, ok := caps[name]; ok {
+				dst[name] = v
+			}
+		}
+	}
+}
+
+// legacyName returns the name of a capability in the legacy protocol,
+// which has no vendor prefixes.
+func legacyName(cap string) string {
+	return strings.TrimPrefix(cap, "appium:")
+}
+
+// NewSessionResponse is the part of a new session response used here, in
+// the W3C or the legacy form.
+type NewSessionResponse struct {
+	SessionID string `json:"sessionId"`
+	Value     struct {
+		SessionID    string         `json:"sessionId"`
+		Capabilities map[string]any `json:"capabilities"`
+	} `json:"value"`
+}
+
+// ParseNewSession returns the id and capabilities of a created session.
+func ParseNewSession(body []byte) (string, map[string]any, error) {
+	var resp NewSessionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", nil, err
+	}
+	if resp.Value.SessionID != "" {
+		return resp.Value.SessionID, resp.Value.Capabilities, nil
+	}
+	if resp.SessionID != "" {
+		// The legacy value holds the capabilities themselves.
+		var legacy struct {
+			Value map[string]any `json:"value"`
+		}
+		json.Unmarshal(body, &legacy)
+		return resp.SessionID, legacy.Value, nil
+	}
+	return "", nil, errors.New("no session id in the response")
+}
+
+// Error is a WebDriver error response.
+type Error struct {
+	Value ErrorValue `json:"value"`
+}
+
+type ErrorValue struct {
+	Error      string `json:"error"`
+	Message    string `json:"message"`
+	Stacktrace string `json:"stacktrace"`
+}
+
+// NewError returns a WebDriver error with the given code, as in the W3C
+// specification, and message.
+func NewError(code string, message string) Error {
+	return Error{Value: ErrorValue{Error: code, Message: message}}
+}
@@ -0,0 +1,163 @@
+package appium
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func deviceCaps() map[string]any {
+	return NewPolicy(&Config{NewCommandTimeout: 90 * time.Second}).Capabilities("Samsung Galaxy S10")
+}
+
+// decoded returns the JSON of s as decoded by encoding/json, numbers as
+// float64.
+func decoded(t *testing.T, s string) map[string]any {
+	t.Helper()
+
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestPrefillSession(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "empty body",
+			body: ``,
+			want: `{"capabilities": {"alwaysMatch": {
+				"platformName": "Android", "appium:automationName": "UiAutomator2", "appium:udid": "emulator-5554",
+				"appium:deviceName": "Samsung Galaxy S10", "appium:newCommandTimeout": 90}}}`,
+		},
+		{
+			// The client keeps its own values except the ones pinning the
+			// session to the device.
+			name: "client values",
+			body: `{"capabilities": {"alwaysMatch": {
+				"platformName": "iOS", "appium:udid": "emulator-5556", "appium:deviceName": "Pixel",
+				"appium:newCommandTimeout": 0, "appium:appPackage": "com.example"}}}`,
+			want: `{"capabilities": {"alwaysMatch": {
+				"platformName": "Android", "appium:automationName": "UiAutomator2", "appium:udid": "emulator-5554",
+				"appium:deviceName": "Pixel", "appium:newCommandTimeout": 0, "appium:appPackage": "com.example"}}}`,
+		},
+		{
+			// firstMatch entries may not repeat a key of alwaysMatch.
+			name: "first match",
+			body: `{"capabilities": {"firstMatch": [
+				{"appium:udid": "emulator-5556", "appium:app": "/tmp/a.apk"},
+				{"appium:automationName": "Espresso"}]}}`,
+			want: `{"capabilities": {
+				"alwaysMatch": {
+					"platformName": "Android", "appium:automationName": "UiAutomator2", "appium:udid": "emulator-5554",
+					"appium:deviceName": "Samsung Galaxy S10", "appium:newCommandTimeout": 90},
+				"firstMatch": [{"appium:app": "/tmp/a.apk"}, {}]}}`,
+		},
+		{
+			// Legacy clients get the capabilities without vendor prefixes.
+			name: "desired capabilities",
+			body: `{"desiredCapabilities": {"platformName": "Android", "udid": "emulator-5558", "app": "/tmp/a.apk"}}`,
+			want: `{
+				"desiredCapabilities": {
+					"platformName": "Android", "automationName": "UiAutomator2", "udid": "emulator-5554",
+					"deviceName": "Samsung Galaxy S10", "newCommandTimeout": 90, "app": "/tmp/a.apk"},
+				"capabilities": {"alwaysMatch": {
+					"platformName": "Android", "appium:automationName": "UiAutomator2", "appium:udid": "emulator-5554",
+					"appium:deviceName": "Samsung Galaxy S10", "appium:newCommandTimeout": 90}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := PrefillSession([]byte(tt.body), deviceCaps())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := decoded(t, string(out)), decoded(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("PrefillSession = %s\nwant %v", out, want)
+			}
+		})
+	}
+}
+
+func TestPrefillSessionInvalid(t *testing.T) {
+	for _, body := range []string{`null`, `[]`, `"capabilities"`, `{"capabilities":`} {
+		if _, err := PrefillSession([]byte(body), deviceCaps()); !errors.Is(err, ErrInvalidSession) {
+			t.Errorf("PrefillSession(%s) = %v, want %v", body, err, ErrInvalidSession)
+		}
+	}
+}
+
+func TestParseNewSession(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		id   string
+		caps map[string]any
+		err  bool
+	}{
+		{
+			name: "w3c",
+			body: `{"value": {"sessionId": "1c2f", "capabilities": {"platformName": "Android", "appium:udid": "emulator-5554"}}}`,
+			id:   "1c2f",
+			caps: map[string]any{"platformName": "Android", "appium:udid": "emulator-5554"},
+		},
+		{
+			name: "legacy",
+			body: `{"status": 0, "sessionId": "9a8b", "value": {"platformName": "Android", "deviceName": "emulator-5554"}}`,
+			id:   "9a8b",
+			caps: map[string]any{"platformName": "Android", "deviceName": "emulator-5554"},
+		},
+		{
+			name: "webdriver error",
+			body: `{"value": {"error": "session not created", "message": "An unknown server-side error occurred"}}`,
+			err:  true,
+		},
+		{name: "not json", body: `<html>Bad Gateway</html>`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, caps, err := ParseNewSession([]byte(tt.body))
+			if tt.err {
+				if err == nil {
+					t.Errorf("ParseNewSession = %q, want an error", id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.id || !reflect.DeepEqual(caps, tt.caps) {
+				t.Errorf("ParseNewSession = %q, %v, want %q, %v", id, caps, tt.id, tt.caps)
+			}
+		})
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		cfg      Config
+		port     int
+		basePath string
+		timeout  time.Duration
+	}{
+		{Config{}, 4723, "/wd/hub", 5 * time.Minute},
+		{Config{Port: 4724, BasePath: "appium/", NewCommandTimeout: time.Minute}, 4724, "/appium", time.Minute},
+		{Config{BasePath: "/"}, 4723, "/", 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		p := NewPolicy(&tt.cfg)
+		if p.Port() != tt.port || p.BasePath() != tt.basePath || p.NewCommandTimeout() != tt.timeout {
+			t.Errorf("NewPolicy(%+v) = %d, %q, %v", tt.cfg, p.Port(), p.BasePath(), p.NewCommandTimeout())
+		}
+	}
+}
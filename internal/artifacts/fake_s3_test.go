@@ -0,0 +1,186 @@
+package artifacts
+
+import (
+	"crypto/hmac"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+// FakeS3Server is a local stand-in for an S3-compatible service such as
+// MinIO. It serves a single bucket in memory with the object and
+// ListObjectsV2 calls used by S3Storage, rejects unsigned requests and
+// checks the signature and expiry of presigned URLs.
+type FakeS3Server struct {
+	bucket   string
+	cfg      S3Config
+	listener net.Listener
+	server   *http.Server
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+// NewFakeS3Server starts a fake S3 service on a random local port, with
+// the credentials and region of cfg. The endpoint of cfg is ignored.
+func NewFakeS3Server(cfg S3Config) (*FakeS3Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FakeS3Server{
+		bucket:   cfg.Bucket,
+		cfg:      cfg,
+		listener: ln,
+		objects:  map[string]fakeObject{},
+	}
+	s.server = &http.Server{Handler: s}
+
+	go s.server.Serve(ln)
+
+	return s, nil
+}
+
+// Endpoint returns the URL to configure S3Storage with.
+func (s *FakeS3Server) Endpoint() string {
+	return "http://" + s.listener.Addr().String()
+}
+
+// Close stops the fake service.
+func (s *FakeS3Server) Close() error {
+	return s.server.Close()
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: code})
+}
+
+func (s *FakeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	signed := strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") ||
+		r.URL.Query().Get("X-Amz-Signature") != ""
+	if !signed {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	if r.URL.Query().Get("X-Amz-Signature") != "" && !s.validPresigned(r) {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != s.bucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	if key == "" && r.Method == http.MethodGet {
+		s.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.mu.Lock()
+		s.objects[key] = fakeObject{data: data, contentType: r.Header.Get("Content-Type"), modTime: time.Now()}
+		s.mu.Unlock()
+	case http.MethodGet, http.MethodHead:
+		s.mu.Lock()
+		obj, ok := s.objects[key]
+		s.mu.Unlock()
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+		http.ServeContent(w, r, key, obj.modTime, strings.NewReader(string(obj.data)))
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *FakeS3Server) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	}
+
+	s.mu.Lock()
+	var contents []content
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			contents = append(contents, content{Key: key, Size: int64(len(obj.data)), LastModified: obj.modTime.UTC()})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(contents, func(i, j int) bool { return contents[i].Key < contents[j].Key })
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(struct {
+		XMLName     xml.Name  `xml:"ListBucketResult"`
+		Name        string    `xml:"Name"`
+		Prefix      string    `xml:"Prefix"`
+		IsTruncated bool      `xml:"IsTruncated"`
+		Contents    []content `xml:"Contents"`
+	}{Name: s.bucket, Prefix: prefix, Contents: contents})
+}
+
+// validPresigned checks a presigned GET has not expired and is signed with
+// the secret key of the service.
+func (s *FakeS3Server) validPresigned(r *http.Request) bool {
+	query := r.URL.Query()
+	date, err := time.Parse(amzDateFormat, query.Get("X-Amz-Date"))
+	if err != nil || r.Method != http.MethodGet {
+		return false
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || time.Now().After(date.Add(time.Duration(expires)*time.Second)) {
+		return false
+	}
+
+	signature := query.Get("X-Amz-Signature")
+	query.Del("X-Amz-Signature")
+	canonical := strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		canonicalQuery(query),
+		"host:" + r.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	signer := &S3Storage{region: s.cfg.Region, secretKey: s.cfg.SecretKey}
+	return hmac.Equal([]byte(signature), []byte(signer.signature(date, canonical)))
+}
@@ -0,0 +1,212 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type LocalConfig struct {
+	// Root is the directory the objects are stored in.
+	Root string `mapstructure:"root"`
+	// BaseURL is the public address of the agent serving downloads.
+	BaseURL string `mapstructure:"baseURL"`
+	// SigningKey signs the download URLs.
+	SigningKey string `mapstructure:"signingKey"`
+}
+
+// DownloadPath is the path the agent serves signed local downloads on.
+const DownloadPath = "/artifacts/download"
+
+// LocalStorage stores objects as files under a root directory.
+type LocalStorage struct {
+	root       string
+	baseURL    string
+	signingKey []byte
+}
+
+func NewLocalStorage(cfg *LocalConfig) (*LocalStorage, error) {
+	if cfg.SigningKey == "" {
+		return nil, errors.New("local artifact storage requires a signing key")
+	}
+
+	if err := os.MkdirAll(cfg.Root, 0750); err != nil {
+		return nil, err
+	}
+
+	return &LocalStorage{
+		root:       cfg.Root,
+		baseURL:    strings.TrimSuffix(cfg.BaseURL, "/"),
+		signingKey: []byte(cfg.SigningKey),
+	}, nil
+}
+
+// path maps a key to a file under the root, refusing keys that escape it.
+func (s *LocalStorage) path(key string) (string, error) {
+	if key == "" || !fs.ValidPath(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0750); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see partial objects.
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *LocalStorage) Stat(ctx context.Context, key string) (Object, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+
+	info, err := os.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Object{}, ErrNotFound
+	} else if err != nil {
+		return Object{}, err
+	}
+
+	return Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]Object, error) {
+	// Only walk the directory the prefix points into.
+	start := s.root
+	if dir := path.Dir(prefix); prefix != "" && dir != "." {
+		if !fs.ValidPath(dir) {
+			return nil, ErrInvalidKey
+		}
+		start = filepath.Join(s.root, filepath.FromSlash(dir))
+	}
+
+	var objects []Object
+	err := filepath.WalkDir(start, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	return objects, err
+}
+
+func (s *LocalStorage) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+
+	query := url.Values{}
+	query.Set("key", key)
+	query.Set("expires", expiresAt)
+	query.Set("signature", s.sign(key, expiresAt))
+
+	return s.baseURL + DownloadPath + "?" + query.Encode(), nil
+}
+
+// VerifyURL checks the query of a signed download URL and returns the key it grants.
+func (s *LocalStorage) VerifyURL(query url.Values) (string, error) {
+	key := query.Get("key")
+	expiresAt := query.Get("expires")
+
+	expires, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", ErrInvalidURL
+	}
+
+	expected := s.sign(key, expiresAt)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return "", ErrInvalidURL
+	}
+
+	return key, nil
+}
+
+func (s *LocalStorage) sign(key string, expiresAt string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(key + "\n" + expiresAt))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,72 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+type Config struct {
+	// Backend is either "local" or "s3".
+	Backend    string                   `mapstructure:"backend"`
+	Local      *LocalConfig             `mapstructure:"local"`
+	S3         *S3Config                `mapstructure:"s3"`
+	QuotaBytes int64                    `mapstructure:"quotaBytes"`
+	URLExpiry  time.Duration            `mapstructure:"urlExpiry"`
+	Retention  map[string]time.Duration `mapstructure:"retention"`
+}
+
+var (
+	ErrNotFound      = errors.New("artifact not found")
+	ErrQuotaExceeded = errors.New("artifact quota exceeded")
+	ErrInvalidKey    = errors.New("invalid artifact key")
+	ErrInvalidURL    = errors.New("invalid or expired download URL")
+)
+
+// Object describes a stored object.
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage is a flat object store addressed by slash separated keys.
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any previous object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Get opens the object stored under key.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Stat returns the object stored under key.
+	Stat(ctx context.Context, key string) (Object, error)
+
+	// Delete removes the object stored under key, it is not an error if it does not exist.
+	Delete(ctx context.Context, key string) error
+
+	// List returns the objects whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]Object, error)
+
+	// SignedURL returns a URL anyone can use to download key until it expires.
+	SignedURL(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// NewStorage creates the storage backend selected by the config.
+func NewStorage(cfg *Config) (Storage, error) {
+	switch cfg.Backend {
+	case "", "local":
+		if cfg.Local == nil {
+			return nil, errors.New("missing local artifact storage config")
+		}
+		return NewLocalStorage(cfg.Local)
+	case "s3":
+		if cfg.S3 == nil {
+			return nil, errors.New("missing s3 artifact storage config")
+		}
+		return NewS3Storage(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown artifact storage backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,336 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultURLExpiry = time.Hour
+
+// Artifact kinds stored by the device features.
+const (
+	KindAPK          = "apk"
+	KindScreenshot   = "screenshot"
+	KindVideo        = "video"
+	KindLog          = "log"
+	KindSnapshot     = "snapshot"
+	KindDeviceState  = "device-state"
+	KindTestReport   = "test-report"
+	KindMonkeyReport = "monkey-report"
+	KindBenchmark    = "benchmark"
+)
+
+// Artifact is a file produced or uploaded for a user.
+type Artifact struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`
+	DeviceID    string    `json:"device_id,omitempty"`
+	Session     string    `json:"session,omitempty"`
+	Kind        string    `json:"kind"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+	URL         string    `json:"url,omitempty"`
+	// Metadata describes the content for the feature that stored it.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Store keeps artifacts in a Storage. Contents are stored once per owner
+// by hash, quotas apply to the unique contents of an owner and expired
+// artifacts are removed according to the retention of their kind.
+type Store struct {
+	storage   Storage
+	quota     int64
+	urlExpiry time.Duration
+	retention map[string]time.Duration
+}
+
+func NewStore(cfg *Config) (*Store, error) {
+	storage, err := NewStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	urlExpiry := cfg.URLExpiry
+	if urlExpiry == 0 {
+		urlExpiry = defaultURLExpiry
+	}
+
+	return &Store{
+		storage:   storage,
+		quota:     cfg.QuotaBytes,
+		urlExpiry: urlExpiry,
+		retention: cfg.Retention,
+	}, nil
+}
+
+// Storage returns the backend of the store.
+func (s *Store) Storage() Storage {
+	return s.storage
+}
+
+func userPrefix(owner string) string {
+	return "users/" + owner + "/"
+}
+
+func blobKey(owner string, sum string) string {
+	return userPrefix(owner) + "blobs/" + sum
+}
+
+func metaKey(owner string, id string) string {
+	return userPrefix(owner) + "artifacts/" + id + ".json"
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
+}
+
+func newArtifactID(now time.Time) string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return strconv.FormatInt(now.UnixNano(), 36) + hex.EncodeToString(b)
+}
+
+// Save stores the content read from r as a new artifact of a.Owner and
+// returns it with its id, size and hash filled in.
+func (s *Store) Save(ctx context.Context, a Artifact, r io.Reader) (Artifact, error) {
+	if !validName(a.Owner) {
+		return Artifact{}, ErrInvalidKey
+	}
+
+	// Spool the content to learn its size and hash before storing it.
+	tmp, err := os.CreateTemp("", "artifact-*")
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return Artifact{}, err
+	}
+
+	now := time.Now()
+	a.ID = newArtifactID(now)
+	a.Size = size
+	a.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	a.CreatedAt = now
+	a.URL = ""
+	if a.ContentType == "" {
+		a.ContentType = "application/octet-stream"
+	}
+
+	key := blobKey(a.Owner, a.SHA256)
+	_, err = s.storage.Stat(ctx, key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if err := s.checkQuota(ctx, a.Owner, size); err != nil {
+			return Artifact{}, err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return Artifact{}, err
+		}
+		if err := s.storage.Put(ctx, key, tmp, size, a.ContentType); err != nil {
+			return Artifact{}, err
+		}
+	case err != nil:
+		return Artifact{}, err
+	}
+
+	if err := s.putMeta(ctx, a); err != nil {
+		return Artifact{}, err
+	}
+
+	return a, nil
+}
+
+func (s *Store) checkQuota(ctx context.Context, owner string, size int64) error {
+	if s.quota <= 0 {
+		return nil
+	}
+
+	usage, err := s.Usage(ctx, owner)
+	if err != nil {
+		return err
+	}
+	if usage+size > s.quota {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+func (s *Store) putMeta(ctx context.Context, a Artifact) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return s.storage.Put(ctx, metaKey(a.Owner, a.ID), bytes.NewReader(data), int64(len(data)), "application/json")
+}
+
+// Get returns the artifact with the given id of owner.
+func (s *Store) Get(ctx context.Context, owner string, id string) (Artifact, error) {
+	if !validName(owner) || !validName(id) {
+		return Artifact{}, ErrNotFound
+	}
+
+	rc, err := s.storage.Get(ctx, metaKey(owner, id))
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer rc.Close()
+
+	var a Artifact
+	if err := json.NewDecoder(rc).Decode(&a); err != nil {
+		return Artifact{}, err
+	}
+
+	return a, nil
+}
+
+// Open returns the artifact with the given id of owner and its content.
+func (s *Store) Open(ctx context.Context, owner string, id string) (Artifact, io.ReadCloser, error) {
+	a, err := s.Get(ctx, owner, id)
+	if err != nil {
+		return Artifact{}, nil, err
+	}
+
+	rc, err := s.storage.Get(ctx, blobKey(owner, a.SHA256))
+	if err != nil {
+		return Artifact{}, nil, err
+	}
+
+	return a, rc, nil
+}
+
+// List returns the artifacts of owner, oldest first.
+func (s *Store) List(ctx context.Context, owner string) ([]Artifact, error) {
+	if !validName(owner) {
+		return nil, ErrInvalidKey
+	}
+
+	objects, err := s.storage.List(ctx, userPrefix(owner)+"artifacts/")
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts := []Artifact{}
+	for _, obj := range objects {
+		id := strings.TrimSuffix(obj.Key[strings.LastIndex(obj.Key, "/")+1:], ".json")
+		a, err := s.Get(ctx, owner, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].CreatedAt.Before(artifacts[j].CreatedAt)
+	})
+
+	return artifacts, nil
+}
+
+// Delete removes an artifact, and its content once no other artifact of
+// the owner refers to it.
+func (s *Store) Delete(ctx context.Context, owner string, id string) error {
+	a, err := s.Get(ctx, owner, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.Delete(ctx, metaKey(owner, id)); err != nil {
+		return err
+	}
+
+	remaining, err := s.List(ctx, owner)
+	if err != nil {
+		return err
+	}
+	for _, other := range remaining {
+		if other.SHA256 == a.SHA256 {
+			return nil
+		}
+	}
+
+	return s.storage.Delete(ctx, blobKey(owner, a.SHA256))
+}
+
+// Usage returns the bytes stored for owner.
+func (s *Store) Usage(ctx context.Context, owner string) (int64, error) {
+	objects, err := s.storage.List(ctx, userPrefix(owner)+"blobs/")
+	if err != nil {
+		return 0, err
+	}
+
+	var usage int64
+	for _, obj := range objects {
+		usage += obj.Size
+	}
+	return usage, nil
+}
+
+// Quota returns the bytes each owner may store, 0 means unlimited.
+func (s *Store) Quota() int64 {
+	return s.quota
+}
+
+// DownloadURL returns a signed URL to download the artifact.
+func (s *Store) DownloadURL(ctx context.Context, a Artifact) (string, error) {
+	return s.storage.SignedURL(ctx, blobKey(a.Owner, a.SHA256), s.urlExpiry)
+}
+
+// ApplyRetention deletes the artifacts older than the retention of their
+// kind and returns how many were deleted.
+func (s *Store) ApplyRetention(ctx context.Context, now time.Time) (int, error) {
+	if len(s.retention) == 0 {
+		return 0, nil
+	}
+
+	objects, err := s.storage.List(ctx, "users/")
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, obj := range objects {
+		parts := strings.Split(obj.Key, "/")
+		if len(parts) != 4 || parts[2] != "artifacts" {
+			continue
+		}
+
+		a, err := s.Get(ctx, parts[1], strings.TrimSuffix(parts[3], ".json"))
+		if err != nil {
+			continue
+		}
+
+		retention, ok := s.retention[a.Kind]
+		if !ok || retention <= 0 || now.Sub(a.CreatedAt) < retention {
+			continue
+		}
+
+		if err := s.Delete(ctx, a.Owner, a.ID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
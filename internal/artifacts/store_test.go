@@ -0,0 +1,265 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backends returns a config of each backend, the s3 one served by a fake.
+func backends(t *testing.T) map[string]*Config {
+	t.Helper()
+
+	s3 := S3Config{Region: "eu-west-1", Bucket: "artifacts", AccessKey: "minio", SecretKey: "minio-secret"}
+	srv, err := NewFakeS3Server(s3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	s3.Endpoint = srv.Endpoint()
+
+	return map[string]*Config{
+		"local": {
+			Backend: "local",
+			Local:   &LocalConfig{Root: t.TempDir(), BaseURL: "http://agent.test", SigningKey: "signing-key"},
+		},
+		"s3": {Backend: "s3", S3: &s3},
+	}
+}
+
+// eachBackend runs test against a store on each backend, configured by
+// configure.
+func eachBackend(t *testing.T, configure func(*Config), test func(t *testing.T, s *Store)) {
+	for name, cfg := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if configure != nil {
+				configure(cfg)
+			}
+			s, err := NewStore(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test(t, s)
+		})
+	}
+}
+
+func save(t *testing.T, s *Store, kind string, content string) Artifact {
+	t.Helper()
+
+	a, err := s.Save(context.Background(), Artifact{Owner: "bob", Kind: kind, Name: kind + ".txt", ContentType: "text/plain"}, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	return a
+}
+
+func read(t *testing.T, s *Store, id string) string {
+	t.Helper()
+
+	_, rc, err := s.Open(context.Background(), "bob", id)
+	if err != nil {
+		t.Fatalf("open %s: %v", id, err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func usage(t *testing.T, s *Store) int64 {
+	t.Helper()
+
+	n, err := s.Usage(context.Background(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSaveDedupes(t *testing.T) {
+	eachBackend(t, nil, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		first := save(t, s, KindLog, "same content")
+		second := save(t, s, KindLog, "same content")
+
+		if first.ID == second.ID {
+			t.Fatal("both saves got the same id")
+		}
+		if first.SHA256 != second.SHA256 || first.Size != 12 {
+			t.Fatalf("first = %+v, second = %+v", first, second)
+		}
+		if n := usage(t, s); n != 12 {
+			t.Errorf("usage = %d, want the content counted once", n)
+		}
+
+		list, err := s.List(ctx, "bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 || list[0].ID != first.ID || list[1].ID != second.ID {
+			t.Errorf("list = %+v, want both oldest first", list)
+		}
+
+		// The content stays while an artifact refers to it.
+		if err := s.Delete(ctx, "bob", first.ID); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, s, second.ID); got != "same content" {
+			t.Errorf("content = %q", got)
+		}
+		if _, err := s.Get(ctx, "bob", first.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("get deleted: err = %v, want ErrNotFound", err)
+		}
+
+		if err := s.Delete(ctx, "bob", second.ID); err != nil {
+			t.Fatal(err)
+		}
+		if n := usage(t, s); n != 0 {
+			t.Errorf("usage = %d after deleting every artifact", n)
+		}
+	})
+}
+
+func TestSaveQuota(t *testing.T) {
+	eachBackend(t, func(cfg *Config) { cfg.QuotaBytes = 10 }, func(t *testing.T, s *Store) {
+		save(t, s, KindLog, "12345678")
+		// Content already stored does not count again.
+		save(t, s, KindLog, "12345678")
+
+		_, err := s.Save(context.Background(), Artifact{Owner: "bob", Kind: KindLog, Name: "more.txt"}, strings.NewReader("abc"))
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("err = %v, want ErrQuotaExceeded", err)
+		}
+		if n := usage(t, s); n != 8 {
+			t.Errorf("usage = %d, want 8", n)
+		}
+
+		list, _ := s.List(context.Background(), "bob")
+		if len(list) != 2 {
+			t.Errorf("%d artifacts, want the rejected one not recorded", len(list))
+		}
+	})
+}
+
+func TestApplyRetention(t *testing.T) {
+	retention := map[string]time.Duration{KindScreenshot: time.Hour, KindVideo: 48 * time.Hour}
+	eachBackend(t, func(cfg *Config) { cfg.Retention = retention }, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		screenshot := save(t, s, KindScreenshot, "png")
+		video := save(t, s, KindVideo, "mp4")
+		log := save(t, s, KindLog, "log")
+
+		deleted, err := s.ApplyRetention(ctx, time.Now().Add(30*time.Minute))
+		if err != nil || deleted != 0 {
+			t.Fatalf("deleted = %d, %v, want nothing expired yet", deleted, err)
+		}
+
+		deleted, err = s.ApplyRetention(ctx, time.Now().Add(2*time.Hour))
+		if err != nil || deleted != 1 {
+			t.Fatalf("deleted = %d, %v, want the screenshot", deleted, err)
+		}
+		if _, err := s.Get(ctx, "bob", screenshot.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("screenshot: err = %v, want ErrNotFound", err)
+		}
+
+		// A kind without a retention is kept.
+		deleted, err = s.ApplyRetention(ctx, time.Now().Add(72*time.Hour))
+		if err != nil || deleted != 1 {
+			t.Fatalf("deleted = %d, %v, want the video", deleted, err)
+		}
+		if _, err := s.Get(ctx, "bob", video.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("video: err = %v, want ErrNotFound", err)
+		}
+		if got := read(t, s, log.ID); got != "log" {
+			t.Errorf("log content = %q", got)
+		}
+	})
+}
+
+// download fetches a signed URL the way a client does, through the agent
+// for the local backend.
+func download(t *testing.T, s *Store, rawURL string) (string, error) {
+	t.Helper()
+
+	if local, ok := s.Storage().(*LocalStorage); ok {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := local.VerifyURL(u.Query())
+		if err != nil {
+			return "", err
+		}
+		rc, err := local.Get(context.Background(), key)
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		return string(data), err
+	}
+
+	resp, err := http.Get(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", ErrInvalidURL
+	}
+	return string(data), nil
+}
+
+func TestDownloadURL(t *testing.T) {
+	eachBackend(t, nil, func(t *testing.T, s *Store) {
+		a := save(t, s, KindScreenshot, "image")
+
+		signed, err := s.DownloadURL(context.Background(), a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := download(t, s, signed); err != nil || got != "image" {
+			t.Fatalf("download = %q, %v", got, err)
+		}
+
+		// Another key under the same signature is refused.
+		other := save(t, s, KindScreenshot, "other image")
+		tampered := strings.Replace(signed, a.SHA256, other.SHA256, 1)
+		if _, err := download(t, s, tampered); !errors.Is(err, ErrInvalidURL) {
+			t.Errorf("tampered: err = %v, want ErrInvalidURL", err)
+		}
+	})
+}
+
+func TestDownloadURLExpired(t *testing.T) {
+	eachBackend(t, func(cfg *Config) { cfg.URLExpiry = -time.Minute }, func(t *testing.T, s *Store) {
+		a := save(t, s, KindScreenshot, "image")
+
+		signed, err := s.DownloadURL(context.Background(), a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := download(t, s, signed); !errors.Is(err, ErrInvalidURL) {
+			t.Fatalf("err = %v, want ErrInvalidURL", err)
+		}
+	})
+}
+
+func TestSaveInvalidOwner(t *testing.T) {
+	eachBackend(t, nil, func(t *testing.T, s *Store) {
+		_, err := s.Save(context.Background(), Artifact{Owner: "../alice", Name: "x"}, strings.NewReader("x"))
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("err = %v, want ErrInvalidKey", err)
+		}
+	})
+}
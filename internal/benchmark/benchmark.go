@@ -0,0 +1,405 @@
+package benchmark
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxIterations = 50
+
+// Start modes of a launch. Cold starts the process, warm starts the
+// activity in the running process after it was finished with back, and hot
+// brings the activity back after it was sent to the background with home.
+const (
+	ModeCold = "cold"
+	ModeWarm = "warm"
+	ModeHot  = "hot"
+)
+
+var Modes = []string{ModeCold, ModeWarm, ModeHot}
+
+var (
+	ErrInvalidActivity   = errors.New("activity must be package/activity, such as com.example/.MainActivity")
+	ErrInvalidIterations = fmt.Errorf("iterations must be between 1 and %d", MaxIterations)
+	ErrInvalidMode       = fmt.Errorf("modes must be some of %v", Modes)
+	ErrInvalidLabel      = errors.New("label must be at most 64 letters, digits, '.', '_', '+' or '-'")
+)
+
+var (
+	activityRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)+/[A-Za-z0-9_.$]+$`)
+	labelRe    = regexp.MustCompile(`^[A-Za-z0-9_.+-]{0,64}$`)
+	// 50th percentile: 8ms
+	percentileRe = regexp.MustCompile(`^(\d+)th percentile: (\d+)ms`)
+	// Janky frames: 10 (8.33%)
+	jankyRe = regexp.MustCompile(`^Janky frames: (\d+)`)
+	// TOTAL PSS:    52345            TOTAL RSS: ...
+	totalPSSRe = regexp.MustCompile(`TOTAL PSS:\s+(\d+)`)
+	// TOTAL    52345    41234 ...
+	totalRowRe = regexp.MustCompile(`^TOTAL\s+(\d+)`)
+	// versionName=1.2.3
+	versionNameRe = regexp.MustCompile(`versionName=(\S+)`)
+)
+
+// Options describe a benchmark of the startup of an activity.
+type Options struct {
+	Activity   string   `json:"activity"`
+	Iterations int      `json:"iterations"`
+	Modes      []string `json:"modes,omitempty"`
+	// Label names the build benchmarked, such as a release, to compare
+	// builds over time.
+	Label string `json:"label,omitempty"`
+}
+
+// Validate checks the options and fills the default modes.
+func (o *Options) Validate() error {
+	if !activityRe.MatchString(o.Activity) {
+		return ErrInvalidActivity
+	}
+	if o.Iterations < 1 || o.Iterations > MaxIterations {
+		return ErrInvalidIterations
+	}
+	if !labelRe.MatchString(o.Label) {
+		return ErrInvalidLabel
+	}
+	if len(o.Modes) == 0 {
+		o.Modes = []string{ModeCold, ModeWarm}
+	}
+	for i, mode := range o.Modes {
+		if !slices.Contains(Modes, mode) || slices.Contains(o.Modes[:i], mode) {
+			return ErrInvalidMode
+		}
+	}
+	return nil
+}
+
+// Package returns the package of the activity.
+func (o Options) Package() string {
+	pkg, _, _ := strings.Cut(o.Activity, "/")
+	return pkg
+}
+
+// Launch is a timed start of the activity, with the frames and memory of
+// the app sampled after it.
+type Launch struct {
+	Iteration int `json:"iteration"`
+	// LaunchState is the start the system reports, COLD, WARM or HOT.
+	LaunchState string  `json:"launch_state,omitempty"`
+	TotalTimeMs int64   `json:"total_time_ms"`
+	WaitTimeMs  int64   `json:"wait_time_ms"`
+	Frames      *Frames `json:"frames,omitempty"`
+	Memory      *Memory `json:"memory,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// Frames are the frame statistics of dumpsys gfxinfo.
+type Frames struct {
+	Total int   `json:"total"`
+	Janky int   `json:"janky"`
+	P50Ms int64 `json:"p50_ms"`
+	P90Ms int64 `json:"p90_ms"`
+	P99Ms int64 `json:"p99_ms"`
+}
+
+// Memory is the memory of dumpsys meminfo.
+type Memory struct {
+	TotalPSSKB int64 `json:"total_pss_kb"`
+}
+
+// Stats summarize a series of measures.
+type Stats struct {
+	Count  int     `json:"count"`
+	Min    int64   `json:"min"`
+	Median int64   `json:"median"`
+	P90    int64   `json:"p90"`
+	Max    int64   `json:"max"`
+	Mean   float64 `json:"mean"`
+}
+
+// ModeResult is the outcome of the launches of a mode.
+type ModeResult struct {
+	Mode     string   `json:"mode"`
+	Launches []Launch `json:"launches"`
+	// Failed is the number of launches that failed, they are left out of
+	// the statistics.
+	Failed    int    `json:"failed"`
+	TotalTime Stats  `json:"total_time_ms"`
+	WaitTime  Stats  `json:"wait_time_ms"`
+	FrameP90  *Stats `json:"frame_p90_ms,omitempty"`
+	TotalPSS  *Stats `json:"total_pss_kb,omitempty"`
+	// Frames and JankyFrames add up the frames of the launches.
+	Frames      int `json:"frames"`
+	JankyFrames int `json:"janky_frames"`
+}
+
+// Result is the outcome of a benchmark.
+type Result struct {
+	Activity    string       `json:"activity"`
+	Label       string       `json:"label,omitempty"`
+	VersionName string       `json:"version_name,omitempty"`
+	Modes       []ModeResult `json:"modes"`
+	DurationMs  int64        `json:"duration_ms"`
+}
+
+// NewStats summarizes values. The median of an even count is the mean of
+// the two middle values, rounded, the other percentiles are nearest rank.
+func NewStats(values []int64) Stats {
+	if len(values) == 0 {
+		return Stats{}
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	var sum int64
+	for _, v := range sorted {
+		sum += v
+	}
+	return Stats{
+		Count:  len(sorted),
+		Min:    sorted[0],
+		Median: median(sorted),
+		P90:    percentile(sorted, 90),
+		Max:    sorted[len(sorted)-1],
+		Mean:   math.Round(float64(sum)/float64(len(sorted))*100) / 100,
+	}
+}
+
+func median(sorted []int64) int64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return int64(math.Round(float64(sorted[n/2-1]+sorted[n/2]) / 2))
+}
+
+func percentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(0, rank-1)]
+}
+
+// NewModeResult computes the statistics of the launches of a mode.
+func NewModeResult(mode string, launches []Launch) ModeResult {
+	result := ModeResult{Mode: mode, Launches: launches}
+
+	var total, wait, frameP90, pss []int64
+	for _, l := range launches {
+		if l.Error != "" {
+			result.Failed++
+			continue
+		}
+		total = append(total, l.TotalTimeMs)
+		wait = append(wait, l.WaitTimeMs)
+		if l.Frames != nil {
+			frameP90 = append(frameP90, l.Frames.P90Ms)
+			result.Frames += l.Frames.Total
+			result.JankyFrames += l.Frames.Janky
+		}
+		if l.Memory != nil {
+			pss = append(pss, l.Memory.TotalPSSKB)
+		}
+	}
+
+	result.TotalTime = NewStats(total)
+	result.WaitTime = NewStats(wait)
+	if len(frameP90) > 0 {
+		s := NewStats(frameP90)
+		result.FrameP90 = &s
+	}
+	if len(pss) > 0 {
+		s := NewStats(pss)
+		result.TotalPSS = &s
+	}
+	return result
+}
+
+// StartCommand returns the command timing a start of the activity.
+func StartCommand(activity string) string {
+	return "am start -W -n " + activity
+}
+
+// ParseStart parses the output of am start -W into a launch.
+func ParseStart(out string) (Launch, error) {
+	var launch Launch
+	var status string
+	var timed bool
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Status":
+			status = value
+		case "LaunchState":
+			launch.LaunchState = value
+		case "TotalTime":
+			launch.TotalTimeMs, _ = strconv.ParseInt(value, 10, 64)
+			timed = true
+		case "WaitTime":
+			launch.WaitTimeMs, _ = strconv.ParseInt(value, 10, 64)
+		case "Error":
+			return launch, errors.New(value)
+		}
+	}
+
+	if status != "" && status != "ok" {
+		return launch, fmt.Errorf("start status %s", status)
+	}
+	if !timed {
+		return launch, errors.New("the start was not timed, the activity may already be in front")
+	}
+	return launch, nil
+}
+
+// ParseGfxInfo parses the frame statistics of dumpsys gfxinfo, it returns
+// nil when the app rendered no frame.
+func ParseGfxInfo(out string) *Frames {
+	var frames Frames
+	found := false
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if v, ok := strings.CutPrefix(line, "Total frames rendered: "); ok {
+			frames.Total, _ = strconv.Atoi(strings.TrimSpace(v))
+			found = true
+		} else if m := jankyRe.FindStringSubmatch(line); m != nil {
+			frames.Janky, _ = strconv.Atoi(m[1])
+		} else if m := percentileRe.FindStringSubmatch(line); m != nil {
+			ms, _ := strconv.ParseInt(m[2], 10, 64)
+			switch m[1] {
+			case "50":
+				frames.P50Ms = ms
+			case "90":
+				frames.P90Ms = ms
+			case "99":
+				frames.P99Ms = ms
+			}
+		}
+		// Only the statistics of the app, not the ones of each window
+		// that follow them, are read.
+		if found && strings.HasPrefix(line, "Profile data in ms:") {
+			break
+		}
+	}
+
+	if !found || frames.Total == 0 {
+		return nil
+	}
+	return &frames
+}
+
+// ParseMeminfo parses the total PSS of dumpsys meminfo, it returns nil when
+// the app does not run.
+func ParseMeminfo(out string) *Memory {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		m := totalPSSRe.FindStringSubmatch(line)
+		if m == nil {
+			m = totalRowRe.FindStringSubmatch(line)
+		}
+		if m != nil {
+			pss, _ := strconv.ParseInt(m[1], 10, 64)
+			return &Memory{TotalPSSKB: pss}
+		}
+	}
+	return nil
+}
+
+// ParseVersionName returns the version name of dumpsys package.
+func ParseVersionName(out string) string {
+	if m := versionNameRe.FindStringSubmatch(out); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Summary is the summary of a stored benchmark, kept in the metadata of
+// its report so the history is read without the reports.
+type Summary struct {
+	ReportID    string               `json:"report_id"`
+	RunID       string               `json:"run_id"`
+	Activity    string               `json:"activity"`
+	Label       string               `json:"label,omitempty"`
+	VersionName string               `json:"version_name,omitempty"`
+	CreatedAt   time.Time            `json:"created_at"`
+	Modes       map[string]ModeTimes `json:"modes"`
+}
+
+// ModeTimes are the startup times of a mode, and their change from the
+// previous benchmark of the activity in a history.
+type ModeTimes struct {
+	TotalMedian int64 `json:"total_time_median_ms"`
+	TotalP90    int64 `json:"total_time_p90_ms"`
+	WaitMedian  int64 `json:"wait_time_median_ms"`
+	WaitP90     int64 `json:"wait_time_p90_ms"`
+	// MedianChange is the change of the median total time, in ms.
+	MedianChange *int64 `json:"total_time_median_change_ms,omitempty"`
+}
+
+// Metadata returns the metadata of the report of a result.
+func Metadata(result Result) map[string]string {
+	metadata := map[string]string{
+		"activity":     result.Activity,
+		"label":        result.Label,
+		"version_name": result.VersionName,
+	}
+	for _, m := range result.Modes {
+		if m.TotalTime.Count == 0 {
+			continue
+		}
+		metadata[m.Mode+"_total_median"] = strconv.FormatInt(m.TotalTime.Median, 10)
+		metadata[m.Mode+"_total_p90"] = strconv.FormatInt(m.TotalTime.P90, 10)
+		metadata[m.Mode+"_wait_median"] = strconv.FormatInt(m.WaitTime.Median, 10)
+		metadata[m.Mode+"_wait_p90"] = strconv.FormatInt(m.WaitTime.P90, 10)
+	}
+	return metadata
+}
+
+// ParseMetadata returns the summary kept in the metadata of a report.
+func ParseMetadata(metadata map[string]string) Summary {
+	s := Summary{
+		Activity:    metadata["activity"],
+		Label:       metadata["label"],
+		VersionName: metadata["version_name"],
+		Modes:       map[string]ModeTimes{},
+	}
+	for _, mode := range Modes {
+		total, err := strconv.ParseInt(metadata[mode+"_total_median"], 10, 64)
+		if err != nil {
+			continue
+		}
+		times := ModeTimes{TotalMedian: total}
+		times.TotalP90, _ = strconv.ParseInt(metadata[mode+"_total_p90"], 10, 64)
+		times.WaitMedian, _ = strconv.ParseInt(metadata[mode+"_wait_median"], 10, 64)
+		times.WaitP90, _ = strconv.ParseInt(metadata[mode+"_wait_p90"], 10, 64)
+		s.Modes[mode] = times
+	}
+	return s
+}
+
+// History orders summaries oldest first and sets the change of each from
+// the previous one with the same activity and mode.
+func History(summaries []Summary) []Summary {
+	sort.SliceStable(summaries, func(i, j int) bool { return summaries[i].CreatedAt.Before(summaries[j].CreatedAt) })
+
+	previous := map[string]int64{}
+	for _, s := range summaries {
+		for mode, times := range s.Modes {
+			key := s.Activity + " " + mode
+			if before, ok := previous[key]; ok {
+				change := times.TotalMedian - before
+				times.MedianChange = &change
+				s.Modes[mode] = times
+			}
+			previous[key] = times.TotalMedian
+		}
+	}
+	return summaries
+}
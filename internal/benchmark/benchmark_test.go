@@ -0,0 +1,307 @@
+package benchmark
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewStats(t *testing.T) {
+	tests := []struct {
+		values []int64
+		want   Stats
+	}{
+		{nil, Stats{}},
+		{[]int64{420}, Stats{Count: 1, Min: 420, Median: 420, P90: 420, Max: 420, Mean: 420}},
+		{[]int64{200, 100}, Stats{Count: 2, Min: 100, Median: 150, P90: 200, Max: 200, Mean: 150}},
+		{[]int64{301, 300, 100}, Stats{Count: 3, Min: 100, Median: 300, P90: 301, Max: 301, Mean: 233.67}},
+		// The middle values average to 250.5 and round up.
+		{[]int64{400, 250, 251, 100}, Stats{Count: 4, Min: 100, Median: 251, P90: 400, Max: 400, Mean: 250.25}},
+		{
+			[]int64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1},
+			Stats{Count: 10, Min: 1, Median: 6, P90: 9, Max: 10, Mean: 5.5},
+		},
+		{
+			[]int64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 100},
+			Stats{Count: 11, Min: 1, Median: 6, P90: 10, Max: 100, Mean: 14.09},
+		},
+	}
+
+	for _, tt := range tests {
+		values := append([]int64(nil), tt.values...)
+		if got := NewStats(tt.values); got != tt.want {
+			t.Errorf("NewStats(%v) = %+v, want %+v", values, got, tt.want)
+		}
+		if !reflect.DeepEqual(tt.values, values) {
+			t.Errorf("NewStats sorted its values to %v", tt.values)
+		}
+	}
+}
+
+func TestParseStart(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want Launch
+		err  string
+	}{
+		{
+			name: "ok",
+			out: `Starting: Intent { cmp=com.example/.MainActivity }
+Status: ok
+LaunchState: COLD
+Activity: com.example/.MainActivity
+TotalTime: 812
+WaitTime: 830
+Complete
+`,
+			want: Launch{LaunchState: "COLD", TotalTimeMs: 812, WaitTimeMs: 830},
+		},
+		{
+			// Older releases report ThisTime and no LaunchState.
+			name: "without launch state",
+			out: `Starting: Intent { cmp=com.example/.MainActivity }
+Status: ok
+Activity: com.example/.MainActivity
+ThisTime: 512
+TotalTime: 512
+WaitTime: 540
+Complete
+`,
+			want: Launch{TotalTimeMs: 512, WaitTimeMs: 540},
+		},
+		{
+			name: "error",
+			out: `Starting: Intent { cmp=com.example/.Missing }
+Error type 3
+Error: Activity class {com.example/com.example.Missing} does not exist.
+`,
+			err: "Activity class {com.example/com.example.Missing} does not exist.",
+		},
+		{
+			name: "timeout",
+			out: `Starting: Intent { cmp=com.example/.MainActivity }
+Status: timeout
+LaunchState: COLD
+Activity: com.example/.MainActivity
+WaitTime: 10021
+Complete
+`,
+			err: "start status timeout",
+		},
+		{
+			name: "not timed",
+			out: `Starting: Intent { cmp=com.example/.MainActivity }
+Warning: Activity not started, intent has been delivered to currently running top-most instance.
+Status: ok
+LaunchState: UNKNOWN (0)
+Activity: com.example/.MainActivity
+WaitTime: 3
+Complete
+`,
+			err: "the start was not timed, the activity may already be in front",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStart(tt.out)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseStart = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+const gfxinfo = `Applications Graphics Acceleration Info:
+Uptime: 1204391 Realtime: 1204391
+
+** Graphics info for pid 4242 [com.example] **
+
+Stats since: 1203012345ns
+Total frames rendered: 120
+Janky frames: 10 (8.33%)
+50th percentile: 8ms
+90th percentile: 21ms
+95th percentile: 32ms
+99th percentile: 57ms
+Number Missed Vsync: 2
+Profile data in ms:
+
+	com.example/com.example.MainActivity/android.view.ViewRootImpl@5d3c1a2 (visibility=0)
+Stats since: 1203012345ns
+Total frames rendered: 96
+Janky frames: 9 (9.38%)
+50th percentile: 9ms
+90th percentile: 23ms
+99th percentile: 61ms
+View hierarchy:
+`
+
+func TestParseGfxInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want *Frames
+	}{
+		{
+			// The statistics of the window after the profile data are
+			// left out.
+			name: "app",
+			out:  gfxinfo,
+			want: &Frames{Total: 120, Janky: 10, P50Ms: 8, P90Ms: 21, P99Ms: 57},
+		},
+		{
+			name: "no frame",
+			out:  strings.Replace(gfxinfo, "Total frames rendered: 120", "Total frames rendered: 0", 1),
+		},
+		{
+			name: "not running",
+			out:  "Applications Graphics Acceleration Info:\nUptime: 1204391 Realtime: 1204391\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseGfxInfo(tt.out); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseGfxInfo = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMeminfo(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want *Memory
+	}{
+		{
+			name: "summary",
+			out: `Applications Memory Usage (in Kilobytes):
+Uptime: 1204391 Realtime: 1204391
+
+** MEMINFO in pid 4242 [com.example] **
+                   Pss  Private  Private  SwapPss      Rss     Heap     Heap     Heap
+                 Total    Dirty    Clean    Dirty    Total     Size    Alloc     Free
+ App Summary
+                       Pss(KB)                        Rss(KB)
+                        ------                         ------
+           Java Heap:     8412                          21064
+         Total:    52345            TOTAL RSS:    98765       TOTAL SWAP PSS:       12
+
+           TOTAL PSS:    52345            TOTAL RSS:    98765       TOTAL SWAP PSS:       12
+`,
+			want: &Memory{TotalPSSKB: 52345},
+		},
+		{
+			// Older releases only have the TOTAL row of the table.
+			name: "table",
+			out: `Applications Memory Usage (in Kilobytes):
+Uptime: 1204391 Realtime: 1204391
+
+** MEMINFO in pid 4242 [com.example] **
+                   Pss  Private  Private  Swapped     Heap     Heap     Heap
+                 Total    Dirty    Clean    Dirty     Size    Alloc     Free
+                ------   ------   ------   ------   ------   ------   ------
+  Native Heap    10240    10180        0        0    16384    12001     4383
+        TOTAL    41234    33456     4012        0    26624    20413     6211
+`,
+			want: &Memory{TotalPSSKB: 41234},
+		},
+		{
+			name: "not running",
+			out:  "No process found for: com.example\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseMeminfo(tt.out); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMeminfo = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2024, 5, d, 12, 0, 0, 0, time.UTC)
+	}
+	summary := func(id string, activity string, created time.Time, cold int64, warm int64) Summary {
+		s := Summary{ReportID: id, Activity: activity, CreatedAt: created, Modes: map[string]ModeTimes{}}
+		if cold > 0 {
+			s.Modes[ModeCold] = ModeTimes{TotalMedian: cold}
+		}
+		if warm > 0 {
+			s.Modes[ModeWarm] = ModeTimes{TotalMedian: warm}
+		}
+		return s
+	}
+
+	history := History([]Summary{
+		summary("c", "com.example/.MainActivity", day(3), 700, 0),
+		summary("a", "com.example/.MainActivity", day(1), 800, 300),
+		summary("x", "com.example/.SettingsActivity", day(2), 500, 200),
+		summary("b", "com.example/.MainActivity", day(2), 900, 250),
+	})
+
+	var order []string
+	for _, s := range history {
+		order = append(order, s.ReportID)
+	}
+	if want := []string{"a", "x", "b", "c"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+
+	change := func(id string, mode string) *int64 {
+		for _, s := range history {
+			if s.ReportID == id {
+				return s.Modes[mode].MedianChange
+			}
+		}
+		t.Fatalf("no summary %s", id)
+		return nil
+	}
+
+	tests := []struct {
+		id   string
+		mode string
+		want *int64
+	}{
+		// The first of an activity has nothing to compare with.
+		{"a", ModeCold, nil},
+		{"x", ModeCold, nil},
+		{"b", ModeCold, ptr(100)},
+		{"b", ModeWarm, ptr(-50)},
+		// A mode compares with the last summary having it.
+		{"c", ModeCold, ptr(-200)},
+	}
+	for _, tt := range tests {
+		got := change(tt.id, tt.mode)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("change of %s %s = %v, want %v", tt.id, tt.mode, deref(got), deref(tt.want))
+		}
+	}
+}
+
+func ptr(v int64) *int64 {
+	return &v
+}
+
+func deref(v *int64) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
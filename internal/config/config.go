@@ -5,8 +5,19 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/SajjadManafi/android-emulator-serverless/internal/adb"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/agent"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/appium"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/files"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/fleets"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/network"
 	"github.com/SajjadManafi/android-emulator-serverless/internal/redis"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/sharding"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/shell"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/snapshots"
 	"github.com/SajjadManafi/android-emulator-serverless/internal/token"
+	"github.com/SajjadManafi/android-emulator-serverless/internal/volumes"
 
 	"github.com/spf13/viper"
 )
@@ -18,10 +29,21 @@ func init() {
 }
 
 type Config struct {
-	ServiceName string        `mapstructure:"serviceName"`
-	Development bool          `mapstructure:"development"`
-	Token       *token.Config `mapstructure:"auth"`
-	Redis       *redis.Config `mapstructure:"redis"`
+	ServiceName string            `mapstructure:"serviceName"`
+	Development bool              `mapstructure:"development"`
+	Token       *token.Config     `mapstructure:"auth"`
+	Redis       *redis.Config     `mapstructure:"redis"`
+	ADB         *adb.Config       `mapstructure:"adb"`
+	Agent       *agent.Config     `mapstructure:"agent"`
+	Artifacts   *artifacts.Config `mapstructure:"artifacts"`
+	Shell       *shell.Config     `mapstructure:"shell"`
+	Files       *files.Config     `mapstructure:"files"`
+	Network     *network.Config   `mapstructure:"network"`
+	Snapshots   *snapshots.Config `mapstructure:"snapshots"`
+	Volumes     *volumes.Config   `mapstructure:"volumes"`
+	Appium      *appium.Config    `mapstructure:"appium"`
+	Sharding    *sharding.Config  `mapstructure:"sharding"`
+	Fleets      *fleets.Config    `mapstructure:"fleets"`
 }
 
 func InitConfig() (*Config, error) {
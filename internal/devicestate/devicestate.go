@@ -0,0 +1,225 @@
+package devicestate
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+)
+
+const (
+	// Version of the archive layout.
+	Version = 1
+
+	manifestName = "manifest.json"
+	dataDir      = "data/"
+)
+
+// Sources of the data partition in an archive.
+const (
+	SourceVolume = "volume"
+	SourceAVD    = "avd"
+)
+
+// Metadata keys of the artifacts holding archives.
+const (
+	MetaAndroidAPI = "android_api"
+	MetaDeviceName = "device_name"
+	MetaSharedWith = "shared_with"
+)
+
+var (
+	ErrInvalidArchive = errors.New("invalid device state archive")
+	ErrNotShared      = errors.New("device state archive is not shared with this user")
+)
+
+// Manifest describes the device an archive was exported from.
+type Manifest struct {
+	Version    int       `json:"version"`
+	DeviceID   string    `json:"device_id"`
+	AndroidAPI string    `json:"android_api"`
+	DeviceName string    `json:"device_name"`
+	Packages   []string  `json:"packages"`
+	Source     string    `json:"source"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// ExportRequest describes the device to export and the users who may
+// import it.
+type ExportRequest struct {
+	AndroidAPI string   `json:"android_api,omitempty"`
+	DeviceName string   `json:"device_name,omitempty"`
+	Volume     string   `json:"volume,omitempty"`
+	SharedWith []string `json:"share_with,omitempty"`
+}
+
+// Write writes an archive of the manifest followed by the files of the
+// data tar stream, as a gzipped tar.
+func Write(w io.Writer, manifest Manifest, data io.Reader) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    manifestName,
+		Mode:    0o644,
+		Size:    int64(len(body)),
+		ModTime: manifest.ExportedAt,
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(body); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(data)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+
+		name := strings.TrimPrefix(hdr.Name, "./")
+		if name == "" {
+			continue
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: hdr.Typeflag,
+			Name:     dataDir + name,
+			Mode:     hdr.Mode,
+			Size:     hdr.Size,
+			ModTime:  hdr.ModTime,
+		}); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Reader reads an archive written by Write.
+type Reader struct {
+	gz *gzip.Reader
+	tr *tar.Reader
+}
+
+// NewReader opens an archive and reads its manifest, which comes first.
+func NewReader(r io.Reader) (*Reader, Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, Manifest{}, fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+	}
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestName {
+		return nil, Manifest{}, fmt.Errorf("%w: missing manifest", ErrInvalidArchive)
+	}
+
+	var manifest Manifest
+	if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&manifest); err != nil {
+		return nil, Manifest{}, fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+	}
+	if manifest.Version != Version {
+		return nil, Manifest{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, manifest.Version)
+	}
+
+	return &Reader{gz: gz, tr: tr}, manifest, nil
+}
+
+// WriteData writes the files of the data partition as a tar stream with
+// paths relative to the data directory.
+func (r *Reader) WriteData(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := r.tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+		}
+
+		name, ok := strings.CutPrefix(hdr.Name, dataDir)
+		if !ok || !safeName(name) {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: hdr.Typeflag,
+			Name:     name,
+			Mode:     hdr.Mode,
+			Size:     hdr.Size,
+			ModTime:  hdr.ModTime,
+		}); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, r.tr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// safeName tells whether name stays in the directory the data is written
+// to. Names such as userdata-qemu.img..bak are fine, a .. element is not.
+func safeName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return false
+	}
+	return !slices.Contains(strings.Split(name, "/"), "..")
+}
+
+// Metadata returns the artifact metadata of an archive.
+func Metadata(manifest Manifest, sharedWith []string) map[string]string {
+	return map[string]string{
+		MetaAndroidAPI: manifest.AndroidAPI,
+		MetaDeviceName: manifest.DeviceName,
+		MetaSharedWith: strings.Join(sharedWith, ","),
+	}
+}
+
+// CanImport tells whether user may import the archive stored in a.
+func CanImport(a artifacts.Artifact, user string) bool {
+	if a.Kind != artifacts.KindDeviceState || user == "" {
+		return false
+	}
+	if a.Owner == user {
+		return true
+	}
+	return slices.Contains(strings.Split(a.Metadata[MetaSharedWith], ","), user)
+}
+
+// ParsePackages reads the output of pm list packages.
+func ParsePackages(out string) []string {
+	packages := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if pkg, ok := strings.CutPrefix(strings.TrimSpace(line), "package:"); ok && pkg != "" {
+			packages = append(packages, pkg)
+		}
+	}
+	slices.Sort(packages)
+	return packages
+}
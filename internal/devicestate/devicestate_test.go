@@ -0,0 +1,268 @@
+package devicestate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+)
+
+// entry is a file of a tar stream, a directory when its type says so.
+type entry struct {
+	typeflag byte
+	name     string
+	body     string
+	linkname string
+}
+
+func tarStream(t *testing.T, entries []entry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Typeflag: e.typeflag,
+			Name:     e.name,
+			Linkname: e.linkname,
+			Mode:     0o644,
+			ModTime:  time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC),
+		}
+		if e.typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readData returns the files WriteData writes for an archive, by name.
+func readData(t *testing.T, archive []byte) (Manifest, map[string]string) {
+	t.Helper()
+
+	r, manifest, err := NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data bytes.Buffer
+	if err := r.WriteData(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+	tr := tar.NewReader(&data)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(tr)
+		if hdr.Typeflag == tar.TypeDir {
+			files[hdr.Name] = "dir"
+		} else {
+			files[hdr.Name] = string(body)
+		}
+	}
+	return manifest, files
+}
+
+func TestRoundTrip(t *testing.T) {
+	manifest := Manifest{
+		Version:    Version,
+		DeviceID:   "alice-Device",
+		AndroidAPI: "emulator_13.0",
+		DeviceName: "Samsung Galaxy S10",
+		Packages:   []string{"com.example", "org.mozilla.firefox"},
+		Source:     SourceVolume,
+		ExportedAt: time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC),
+	}
+	// As tar cf - -C dir . lists a volume.
+	data := tarStream(t, []entry{
+		{typeflag: tar.TypeDir, name: "./"},
+		{typeflag: tar.TypeReg, name: "./userdata-qemu.img", body: "partition"},
+		{typeflag: tar.TypeReg, name: "./userdata-qemu.img.qcow2", body: "overlay"},
+		{typeflag: tar.TypeDir, name: "./snapshots/"},
+		{typeflag: tar.TypeReg, name: "./snapshots/default_boot/ram.bin", body: "ram"},
+		// Links could point out of the volume, they are not exported.
+		{typeflag: tar.TypeSymlink, name: "./cache", linkname: "/etc"},
+		{typeflag: tar.TypeLink, name: "./hard", linkname: "userdata-qemu.img"},
+	})
+
+	var archive bytes.Buffer
+	if err := Write(&archive, manifest, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, files := readData(t, archive.Bytes())
+	if !reflect.DeepEqual(got, manifest) {
+		t.Errorf("manifest = %+v, want %+v", got, manifest)
+	}
+	want := map[string]string{
+		"userdata-qemu.img":              "partition",
+		"userdata-qemu.img.qcow2":        "overlay",
+		"snapshots/":                     "dir",
+		"snapshots/default_boot/ram.bin": "ram",
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+}
+
+func TestWriteDataSkipsUnsafeEntries(t *testing.T) {
+	archive := gzipped(t, tarStream(t, []entry{
+		{typeflag: tar.TypeReg, name: manifestName, body: `{"version": 1, "device_id": "alice-Device"}`},
+		{typeflag: tar.TypeReg, name: "data/userdata-qemu.img", body: "partition"},
+		{typeflag: tar.TypeReg, name: "data/userdata-qemu.img..bak", body: "backup"},
+		{typeflag: tar.TypeReg, name: "data/../../etc/passwd", body: "root"},
+		{typeflag: tar.TypeReg, name: "data/snapshots/../../escape", body: "escape"},
+		{typeflag: tar.TypeReg, name: "data/..", body: "parent"},
+		{typeflag: tar.TypeReg, name: "data//etc/cron.d/job", body: "absolute"},
+		{typeflag: tar.TypeReg, name: "outside.img", body: "outside"},
+		{typeflag: tar.TypeSymlink, name: "data/link", linkname: "/etc"},
+		{typeflag: tar.TypeLink, name: "data/hard", linkname: "/etc/shadow"},
+		{typeflag: tar.TypeChar, name: "data/kvm"},
+		{typeflag: tar.TypeFifo, name: "data/fifo"},
+	}))
+
+	_, files := readData(t, archive)
+	want := map[string]string{
+		"userdata-qemu.img":      "partition",
+		"userdata-qemu.img..bak": "backup",
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+}
+
+func TestNewReaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive func(t *testing.T) []byte
+	}{
+		{"not gzip", func(t *testing.T) []byte {
+			return tarStream(t, []entry{{typeflag: tar.TypeReg, name: manifestName, body: `{"version": 1}`}})
+		}},
+		{"empty", func(t *testing.T) []byte {
+			return gzipped(t, tarStream(t, nil))
+		}},
+		{"data first", func(t *testing.T) []byte {
+			return gzipped(t, tarStream(t, []entry{
+				{typeflag: tar.TypeReg, name: "data/userdata-qemu.img", body: "partition"},
+				{typeflag: tar.TypeReg, name: manifestName, body: `{"version": 1}`},
+			}))
+		}},
+		{"manifest not json", func(t *testing.T) []byte {
+			return gzipped(t, tarStream(t, []entry{{typeflag: tar.TypeReg, name: manifestName, body: "version=1"}}))
+		}},
+		{"no version", func(t *testing.T) []byte {
+			return gzipped(t, tarStream(t, []entry{{typeflag: tar.TypeReg, name: manifestName, body: `{"device_id": "alice-Device"}`}}))
+		}},
+		{"newer version", func(t *testing.T) []byte {
+			return gzipped(t, tarStream(t, []entry{{typeflag: tar.TypeReg, name: manifestName, body: `{"version": 2}`}}))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := NewReader(bytes.NewReader(tt.archive(t))); !errors.Is(err, ErrInvalidArchive) {
+				t.Errorf("err = %v, want %v", err, ErrInvalidArchive)
+			}
+		})
+	}
+}
+
+func TestWriteDataTruncated(t *testing.T) {
+	var archive bytes.Buffer
+	data := tarStream(t, []entry{{typeflag: tar.TypeReg, name: "userdata-qemu.img", body: strings.Repeat("partition", 1000)}})
+	if err := Write(&archive, Manifest{Version: Version}, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The gzip stream ends within the data.
+	r, _, err := NewReader(bytes.NewReader(archive.Bytes()[:archive.Len()-40]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WriteData(io.Discard); err == nil {
+		t.Error("WriteData of a truncated archive succeeded")
+	}
+}
+
+func TestCanImport(t *testing.T) {
+	archive := func(kind string, sharedWith ...string) artifacts.Artifact {
+		return artifacts.Artifact{
+			Owner:    "alice",
+			Kind:     kind,
+			Metadata: Metadata(Manifest{AndroidAPI: "emulator_13.0"}, sharedWith),
+		}
+	}
+
+	tests := []struct {
+		name string
+		a    artifacts.Artifact
+		user string
+		want bool
+	}{
+		{"owner", archive(artifacts.KindDeviceState), "alice", true},
+		{"shared", archive(artifacts.KindDeviceState, "bob", "carol"), "carol", true},
+		{"not shared", archive(artifacts.KindDeviceState, "bob", "carol"), "mallory", false},
+		{"prefix of a shared user", archive(artifacts.KindDeviceState, "bobby"), "bob", false},
+		{"shared with nobody", archive(artifacts.KindDeviceState), "bob", false},
+		{"no user", archive(artifacts.KindDeviceState), "", false},
+		{"other kind", archive(artifacts.KindScreenshot, "bob"), "bob", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanImport(tt.a, tt.user); got != tt.want {
+				t.Errorf("CanImport(%s) = %t, want %t", tt.user, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePackages(t *testing.T) {
+	tests := []struct {
+		out  string
+		want []string
+	}{
+		{"", []string{}},
+		{"package:org.mozilla.firefox\r\npackage:com.example\r\n", []string{"com.example", "org.mozilla.firefox"}},
+		{"package:com.example\n\npackage:\nWARNING: linker: unused DT entry\n  package:com.example.debug  \n", []string{"com.example", "com.example.debug"}},
+	}
+
+	for _, tt := range tests {
+		if got := ParsePackages(tt.out); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePackages(%q) = %q, want %q", tt.out, got, tt.want)
+		}
+	}
+}
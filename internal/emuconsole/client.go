@@ -0,0 +1,221 @@
+package emuconsole
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPort is the console port of the first emulator instance.
+const DefaultPort = 5554
+
+const (
+	defaultTimeout = 10 * time.Second
+	dialTimeout    = 5 * time.Second
+)
+
+var (
+	ErrAuthFailed = errors.New("console authentication failed")
+	ErrClosed     = errors.New("console connection closed")
+)
+
+// CommandError is returned when the console answers a command with KO.
+type CommandError struct {
+	Command string
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("console command %q failed: %s", e.Command, e.Message)
+}
+
+// Client is a connection to an Android emulator console.
+type Client struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	closed  bool
+}
+
+// Dial connects to the console at addr and authenticates with authToken
+// when the console asks for it.
+func Dial(ctx context.Context, addr string, authToken string) (*Client, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newClient(conn)
+
+	if err := c.handshake(ctx, authToken); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func newClient(conn net.Conn) *Client {
+	return &Client{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: defaultTimeout,
+	}
+}
+
+// SetTimeout sets the maximum duration of a single command.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeout = timeout
+}
+
+// handshake reads the banner and authenticates if required.
+func (c *Client) handshake(ctx context.Context, authToken string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stop := c.watch(ctx)
+	defer stop()
+
+	banner, err := c.readResponse()
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(strings.Join(banner, "\n"), "Authentication required") {
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(c.conn, "auth %s\n", authToken); err != nil {
+		return err
+	}
+
+	if _, err := c.readResponse(); err != nil {
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) {
+			return ErrAuthFailed
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Command sends a command and returns the lines printed before OK.
+func (c *Client) Command(ctx context.Context, command string) ([]string, error) {
+	if strings.ContainsAny(command, "\r\n") {
+		return nil, fmt.Errorf("console command must be a single line")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	stop := c.watch(ctx)
+	defer stop()
+
+	if _, err := fmt.Fprintf(c.conn, "%s\n", command); err != nil {
+		return nil, c.fail(ctx, err)
+	}
+
+	lines, err := c.readResponse()
+	if err != nil {
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) {
+			cmdErr.Command = command
+			return nil, cmdErr
+		}
+		return nil, c.fail(ctx, err)
+	}
+
+	return lines, nil
+}
+
+// Commandf formats and sends a command.
+func (c *Client) Commandf(ctx context.Context, format string, args ...any) ([]string, error) {
+	return c.Command(ctx, fmt.Sprintf(format, args...))
+}
+
+// Close closes the console connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	// Be polite, the console closes its side on quit.
+	fmt.Fprintf(c.conn, "quit\n")
+	return c.conn.Close()
+}
+
+// watch applies the command timeout and context cancellation to the
+// connection. The returned function must be called when the command is done.
+func (c *Client) watch(ctx context.Context) func() {
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Now())
+	})
+
+	return func() {
+		stop()
+		c.conn.SetDeadline(time.Time{})
+	}
+}
+
+// fail closes the connection after an i/o error, since a late answer would
+// be mistaken for the answer of the next command. It reports the context
+// error instead of the i/o timeout it caused.
+func (c *Client) fail(ctx context.Context, err error) error {
+	c.closed = true
+	c.conn.Close()
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	// The connection deadline may pass just before the one of ctx is seen.
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// readResponse reads lines up to and including the OK or KO terminator.
+func (c *Client) readResponse() ([]string, error) {
+	var lines []string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "OK":
+			return lines, nil
+		case strings.HasPrefix(line, "KO"):
+			message := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(line, "KO"), ":"))
+			return nil, &CommandError{Message: message}
+		default:
+			lines = append(lines, line)
+		}
+	}
+}
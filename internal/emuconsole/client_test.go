@@ -0,0 +1,146 @@
+package emuconsole
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func dialFake(t *testing.T, authToken string, token string) (*FakeServer, *Client) {
+	t.Helper()
+
+	srv, err := NewFakeServer(authToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	c, err := Dial(context.Background(), srv.Addr(), token)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return srv, c
+}
+
+func TestDialAuthenticates(t *testing.T) {
+	srv, c := dialFake(t, "secret", "secret")
+
+	if _, err := c.Command(context.Background(), "power ac on"); err != nil {
+		t.Fatalf("command after auth: %v", err)
+	}
+	if got := srv.Commands(); !slices.Equal(got, []string{"power ac on"}) {
+		t.Errorf("commands = %q, want the command only", got)
+	}
+}
+
+func TestDialWithoutAuth(t *testing.T) {
+	_, c := dialFake(t, "", "ignored")
+
+	if _, err := c.Command(context.Background(), "help"); err != nil {
+		t.Fatalf("command: %v", err)
+	}
+}
+
+func TestDialAuthFailed(t *testing.T) {
+	srv, err := NewFakeServer("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	_, err = Dial(context.Background(), srv.Addr(), "wrong")
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("err = %v, want ErrAuthFailed", err)
+	}
+}
+
+func TestCommandKO(t *testing.T) {
+	srv, c := dialFake(t, "", "")
+	srv.Handle("geo", func(args []string) ([]string, error) {
+		return nil, errors.New("bad longitude")
+	})
+
+	_, err := c.Command(context.Background(), "geo fix x 1")
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("err = %v, want a CommandError", err)
+	}
+	if cmdErr.Command != "geo fix x 1" || cmdErr.Message != "bad longitude" {
+		t.Errorf("err = %+v", cmdErr)
+	}
+
+	// A KO answer keeps the connection usable.
+	if _, err := c.Command(context.Background(), "power ac on"); err != nil {
+		t.Errorf("command after KO: %v", err)
+	}
+}
+
+func TestCommandMultiLine(t *testing.T) {
+	srv, c := dialFake(t, "", "")
+	srv.Handle("avd", func(args []string) ([]string, error) {
+		return []string{"Snapshots:", "  boot", "  clean"}, nil
+	})
+
+	lines, err := c.Commandf(context.Background(), "avd snapshot %s", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Snapshots:", "  boot", "  clean"}; !slices.Equal(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+}
+
+func TestCommandRejectsNewlines(t *testing.T) {
+	srv, c := dialFake(t, "", "")
+
+	if _, err := c.Command(context.Background(), "sms send 1 hi\nkill"); err == nil {
+		t.Fatal("expected an error for a multi-line command")
+	}
+	if got := srv.Commands(); len(got) != 0 {
+		t.Errorf("commands = %q, want none sent", got)
+	}
+}
+
+func TestCommandDeadlineClosesConnection(t *testing.T) {
+	srv, c := dialFake(t, "", "")
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	srv.Handle("slow", func(args []string) ([]string, error) {
+		<-release
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Command(ctx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The late answer of slow must not be read as the answer of the next
+	// command.
+	if _, err := c.Command(context.Background(), "power ac on"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("err = %v, want ErrClosed", err)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	srv, c := dialFake(t, "", "")
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	srv.Handle("slow", func(args []string) ([]string, error) {
+		<-release
+		return nil, nil
+	})
+
+	c.SetTimeout(50 * time.Millisecond)
+	_, err := c.Command(context.Background(), "slow")
+	if err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want an i/o timeout", err)
+	}
+	if _, err := c.Command(context.Background(), "power ac on"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("err = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,162 @@
+package emuconsole
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// HandlerFunc answers a console command. The returned lines are printed
+// before OK, a non-nil error is reported as KO.
+type HandlerFunc func(args []string) ([]string, error)
+
+// FakeServer is a local stand-in for the emulator console. It speaks the
+// same banner, auth and OK/KO framing so code using Client can be
+// exercised without a running emulator.
+type FakeServer struct {
+	authToken string
+	listener  net.Listener
+
+	mu       sync.Mutex
+	handlers map[string]HandlerFunc
+	commands []string
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewFakeServer starts a fake console on a random local port. An empty
+// authToken disables authentication.
+func NewFakeServer(authToken string) (*FakeServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FakeServer{
+		authToken: authToken,
+		listener:  ln,
+		handlers:  map[string]HandlerFunc{},
+		conns:     map[net.Conn]struct{}{},
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr returns the address the fake console listens on.
+func (s *FakeServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Handle registers the handler for a command name, for example "geo" or "sms".
+// Commands without a handler are answered with OK.
+func (s *FakeServer) Handle(name string, handler HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[name] = handler
+}
+
+// Commands returns the commands received so far, excluding auth and quit.
+func (s *FakeServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// Close stops the fake console and drops its connections.
+func (s *FakeServer) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *FakeServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *FakeServer) serveConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	authenticated := s.authToken == ""
+	if authenticated {
+		fmt.Fprintf(conn, "Android Console: type 'help' for a list of commands\r\nOK\r\n")
+	} else {
+		fmt.Fprintf(conn, "Android Console: Authentication required\r\n"+
+			"Android Console: type 'auth <auth_token>' to authenticate\r\nOK\r\n")
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "quit" || fields[0] == "exit":
+			return
+		case fields[0] == "auth":
+			if len(fields) == 2 && fields[1] == s.authToken {
+				authenticated = true
+				fmt.Fprintf(conn, "Android Console: type 'help' for a list of commands\r\nOK\r\n")
+			} else {
+				fmt.Fprintf(conn, "KO: authentication token does not match ~/.emulator_console_auth_token\r\n")
+			}
+			continue
+		case !authenticated:
+			fmt.Fprintf(conn, "KO: unknown command, try 'help'\r\n")
+			continue
+		}
+
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(fields, " "))
+		handler := s.handlers[fields[0]]
+		s.mu.Unlock()
+
+		if handler == nil {
+			fmt.Fprintf(conn, "OK\r\n")
+			continue
+		}
+
+		lines, err := handler(fields[1:])
+		if err != nil {
+			fmt.Fprintf(conn, "KO: %s\r\n", err)
+			continue
+		}
+		for _, line := range lines {
+			fmt.Fprintf(conn, "%s\r\n", line)
+		}
+		fmt.Fprintf(conn, "OK\r\n")
+	}
+}
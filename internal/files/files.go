@@ -0,0 +1,124 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxPushBytes = 512 << 20
+	defaultMaxPullBytes = 512 << 20
+)
+
+type Config struct {
+	// AllowedPaths are the directories of the device users may read and
+	// write, along with everything below them.
+	AllowedPaths []string `mapstructure:"allowedPaths"`
+	MaxPushBytes int64    `mapstructure:"maxPushBytes"`
+	MaxPullBytes int64    `mapstructure:"maxPullBytes"`
+}
+
+var (
+	ErrPathNotAllowed = errors.New("path is not allowed")
+	ErrInvalidPackage = errors.New("invalid package name")
+)
+
+var packageRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)+$`)
+
+// Entry describes a file on the device.
+type Entry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	IsDir   bool      `json:"is_dir"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// NewEntry describes the file at p.
+func NewEntry(p string, mode os.FileMode, size int64, modTime time.Time) Entry {
+	return Entry{
+		Name:    path.Base(p),
+		Path:    p,
+		Size:    size,
+		Mode:    mode.String(),
+		IsDir:   mode.IsDir(),
+		ModTime: modTime,
+	}
+}
+
+// Policy restricts the paths and sizes of transfers.
+type Policy struct {
+	allowed      []string
+	maxPushBytes int64
+	maxPullBytes int64
+}
+
+func NewPolicy(cfg *Config) *Policy {
+	p := &Policy{
+		maxPushBytes: cfg.MaxPushBytes,
+		maxPullBytes: cfg.MaxPullBytes,
+	}
+	if p.maxPushBytes <= 0 {
+		p.maxPushBytes = defaultMaxPushBytes
+	}
+	if p.maxPullBytes <= 0 {
+		p.maxPullBytes = defaultMaxPullBytes
+	}
+	for _, dir := range cfg.AllowedPaths {
+		p.allowed = append(p.allowed, path.Clean(dir))
+	}
+	return p
+}
+
+// MaxPushBytes returns the largest file that may be pushed.
+func (p *Policy) MaxPushBytes() int64 {
+	return p.maxPushBytes
+}
+
+// MaxPullBytes returns the largest file that may be pulled.
+func (p *Policy) MaxPullBytes() int64 {
+	return p.maxPullBytes
+}
+
+// CheckPath cleans an absolute device path and checks it is inside one of
+// the allowed directories.
+func (p *Policy) CheckPath(devicePath string) (string, error) {
+	if !path.IsAbs(devicePath) {
+		return "", fmt.Errorf("%w: %q is not absolute", ErrPathNotAllowed, devicePath)
+	}
+
+	clean := path.Clean(devicePath)
+	for _, dir := range p.allowed {
+		if clean == dir || strings.HasPrefix(clean, dir+"/") || dir == "/" {
+			return clean, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %q is outside %s", ErrPathNotAllowed, devicePath, strings.Join(p.allowed, ", "))
+}
+
+// CheckAppPath checks a path relative to the data directory of an app,
+// such as databases/app.db, read with run-as.
+func CheckAppPath(pkg string, appPath string) (string, error) {
+	if !packageRe.MatchString(pkg) {
+		return "", ErrInvalidPackage
+	}
+
+	clean := path.Clean(appPath)
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%w: %q must stay inside the app data directory", ErrPathNotAllowed, appPath)
+	}
+
+	return clean, nil
+}
+
+// Quote quotes a path for the device shell.
+func Quote(p string) string {
+	return "'" + strings.ReplaceAll(p, "'", `'\''`) + "'"
+}
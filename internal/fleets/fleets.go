@@ -0,0 +1,278 @@
+package fleets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultMaxDevices  = 8
+	defaultMaxFleets   = 2
+	defaultDevicePrice = 10
+)
+
+// Statuses of the devices of a fleet, as aggregated in its readiness.
+const (
+	StatusReady   = "READY"
+	StatusBooting = "BOOTING"
+	StatusFailed  = "FAILED"
+)
+
+type Config struct {
+	// MaxDevices is the number of devices a user may have in all fleets.
+	MaxDevices int `mapstructure:"maxDevices"`
+	// MaxFleets is the number of fleets a user may have.
+	MaxFleets int `mapstructure:"maxFleets"`
+	// DevicePrice is charged to the balance of the user for each device of
+	// a fleet when it is created.
+	DevicePrice int `mapstructure:"devicePrice"`
+}
+
+var (
+	ErrEmptyMatrix      = errors.New("at least one android api and one device name are required")
+	ErrInvalidAPI       = errors.New("android apis must be 1 to 64 letters, digits, '.', '_' or '-'")
+	ErrInvalidDevice    = errors.New("device names must be 1 to 64 printable characters")
+	ErrInvalidLocale    = errors.New("locales must be a language, optionally with a region, such as en or fr-FR")
+	ErrDuplicate        = errors.New("matrix values must be unique")
+	ErrTooManyDevices   = errors.New("fleet device quota exceeded")
+	ErrTooManyFleets    = errors.New("fleet quota exceeded")
+	ErrInsufficientFund = errors.New("insufficient balance")
+)
+
+var (
+	apiRe    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+	deviceRe = regexp.MustCompile(`^[[:print:]]{1,64}$`)
+	localeRe = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+)
+
+// Spec is the matrix of a fleet, it has a device for each combination of
+// android api, device name and locale.
+type Spec struct {
+	Name        string   `json:"name,omitempty"`
+	AndroidAPIs []string `json:"android_apis"`
+	DeviceNames []string `json:"device_names"`
+	// Locales are optional, the devices keep the locale of the image
+	// without them.
+	Locales []string `json:"locales,omitempty"`
+}
+
+// Variant is a combination of the matrix of a fleet.
+type Variant struct {
+	AndroidAPI string `json:"android_api"`
+	DeviceName string `json:"device_name"`
+	Locale     string `json:"locale,omitempty"`
+}
+
+// Validate checks the values of the matrix.
+func (s Spec) Validate() error {
+	if len(s.AndroidAPIs) == 0 || len(s.DeviceNames) == 0 {
+		return ErrEmptyMatrix
+	}
+	for _, check := range []struct {
+		values []string
+		re     *regexp.Regexp
+		err    error
+	}{
+		{s.AndroidAPIs, apiRe, ErrInvalidAPI},
+		{s.DeviceNames, deviceRe, ErrInvalidDevice},
+		{s.Locales, localeRe, ErrInvalidLocale},
+	} {
+		seen := map[string]bool{}
+		for _, v := range check.values {
+			if !check.re.MatchString(v) {
+				return check.err
+			}
+			if seen[v] {
+				return ErrDuplicate
+			}
+			seen[v] = true
+		}
+	}
+	return nil
+}
+
+// Size returns the number of devices of the matrix.
+func (s Spec) Size() int {
+	return len(s.AndroidAPIs) * len(s.DeviceNames) * max(1, len(s.Locales))
+}
+
+// Expand returns the combinations of the matrix, by android api, then
+// device name, then locale.
+func (s Spec) Expand() []Variant {
+	locales := s.Locales
+	if len(locales) == 0 {
+		locales = []string{""}
+	}
+
+	variants := make([]Variant, 0, s.Size())
+	for _, api := range s.AndroidAPIs {
+		for _, device := range s.DeviceNames {
+			for _, locale := range locales {
+				variants = append(variants, Variant{AndroidAPI: api, DeviceName: device, Locale: locale})
+			}
+		}
+	}
+	return variants
+}
+
+// Policy holds the quotas and price of the fleets.
+type Policy struct {
+	maxDevices  int
+	maxFleets   int
+	devicePrice int
+}
+
+func NewPolicy(cfg *Config) *Policy {
+	p := &Policy{
+		maxDevices:  cfg.MaxDevices,
+		maxFleets:   cfg.MaxFleets,
+		devicePrice: cfg.DevicePrice,
+	}
+	if p.maxDevices <= 0 {
+		p.maxDevices = defaultMaxDevices
+	}
+	if p.maxFleets <= 0 {
+		p.maxFleets = defaultMaxFleets
+	}
+	if p.devicePrice <= 0 {
+		p.devicePrice = defaultDevicePrice
+	}
+	return p
+}
+
+// Cost returns the price of a fleet of n devices.
+func (p *Policy) Cost(n int) int {
+	return n * p.devicePrice
+}
+
+// DevicePrice returns the price of a device of a fleet.
+func (p *Policy) DevicePrice() int {
+	return p.devicePrice
+}
+
+// Check checks that a user with fleets of the given sizes and balance may
+// create a fleet of n devices.
+func (p *Policy) Check(sizes []int, n int, balance int) error {
+	if len(sizes) >= p.maxFleets {
+		return fmt.Errorf("%w: at most %d fleets are allowed", ErrTooManyFleets, p.maxFleets)
+	}
+	total := n
+	for _, size := range sizes {
+		total += size
+	}
+	if total > p.maxDevices {
+		return fmt.Errorf("%w: at most %d devices are allowed in all fleets", ErrTooManyDevices, p.maxDevices)
+	}
+	if cost := p.Cost(n); cost > balance {
+		return fmt.Errorf("%w: the fleet costs %d, the balance is %d", ErrInsufficientFund, cost, balance)
+	}
+	return nil
+}
+
+// Readiness aggregates the statuses of the devices of a fleet.
+type Readiness struct {
+	Total   int `json:"total"`
+	Ready   int `json:"ready"`
+	Booting int `json:"booting"`
+	Failed  int `json:"failed"`
+	// AllReady tells whether every device of the fleet is ready.
+	AllReady bool `json:"all_ready"`
+}
+
+// Aggregate returns the readiness of devices with the given statuses, a
+// status is classified with Classify.
+func Aggregate(statuses []string) Readiness {
+	r := Readiness{Total: len(statuses)}
+	for _, status := range statuses {
+		switch Classify(status) {
+		case StatusReady:
+			r.Ready++
+		case StatusFailed:
+			r.Failed++
+		default:
+			r.Booting++
+		}
+	}
+	r.AllReady = r.Total > 0 && r.Ready == r.Total
+	return r
+}
+
+// Classify maps the status reported by a device to the status of a fleet
+// device.
+func Classify(status string) string {
+	switch s := strings.ToUpper(status); {
+	case strings.Contains(s, "FAIL") || strings.Contains(s, "ERROR"):
+		return StatusFailed
+	case strings.Contains(s, "READY"):
+		return StatusReady
+	default:
+		return StatusBooting
+	}
+}
+
+// DeviceID returns the id of the device of a fleet at index.
+func DeviceID(owner string, fleetID string, index int) string {
+	return fmt.Sprintf("%s-Fleet-%s-%d", owner, fleetID, index)
+}
+
+// NewID returns a new fleet id.
+func NewID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Outcome is the outcome of a bulk operation on a device of a fleet.
+type Outcome struct {
+	DeviceID string `json:"device_id"`
+	Success  bool   `json:"success"`
+	Result   any    `json:"result,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Each runs op on the devices in parallel and returns the outcomes in the
+// order of the devices.
+func Each(ctx context.Context, deviceIDs []string, op func(ctx context.Context, deviceID string) (any, error)) []Outcome {
+	outcomes := make([]Outcome, len(deviceIDs))
+	var wg sync.WaitGroup
+	for i, id := range deviceIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := op(ctx, id)
+			outcomes[i] = Outcome{DeviceID: id, Success: err == nil, Result: result}
+			if err != nil {
+				outcomes[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// BulkResult is the outcome of a bulk operation on the devices of a fleet.
+type BulkResult struct {
+	FleetID   string    `json:"fleet_id"`
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+	Devices   []Outcome `json:"devices"`
+}
+
+// NewBulkResult counts the outcomes of a bulk operation.
+func NewBulkResult(fleetID string, outcomes []Outcome) BulkResult {
+	result := BulkResult{FleetID: fleetID, Devices: outcomes}
+	for _, outcome := range outcomes {
+		if outcome.Success {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+	}
+	return result
+}
@@ -0,0 +1,199 @@
+package fleets
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpecValidate(t *testing.T) {
+	valid := Spec{
+		AndroidAPIs: []string{"emulator_11.0", "emulator_13.0"},
+		DeviceNames: []string{"Samsung Galaxy S10", "Nexus 5"},
+		Locales:     []string{"en", "fr-FR", "fil"},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(s *Spec)
+		err    error
+	}{
+		{"valid", func(s *Spec) {}, nil},
+		{"without locales", func(s *Spec) { s.Locales = nil }, nil},
+		{"no api", func(s *Spec) { s.AndroidAPIs = nil }, ErrEmptyMatrix},
+		{"no device", func(s *Spec) { s.DeviceNames = []string{} }, ErrEmptyMatrix},
+		{"api", func(s *Spec) { s.AndroidAPIs = []string{"emulator 13"} }, ErrInvalidAPI},
+		{"api option", func(s *Spec) { s.AndroidAPIs = []string{"--rm"} }, ErrInvalidAPI},
+		{"empty device", func(s *Spec) { s.DeviceNames = []string{""} }, ErrInvalidDevice},
+		{"device newline", func(s *Spec) { s.DeviceNames = []string{"Nexus 5\nPixel"} }, ErrInvalidDevice},
+		{"long device", func(s *Spec) { s.DeviceNames = []string{strings.Repeat("x", 65)} }, ErrInvalidDevice},
+		{"locale", func(s *Spec) { s.Locales = []string{"en_US"} }, ErrInvalidLocale},
+		{"locale case", func(s *Spec) { s.Locales = []string{"fr-fr"} }, ErrInvalidLocale},
+		{"duplicate api", func(s *Spec) { s.AndroidAPIs = []string{"emulator_13.0", "emulator_13.0"} }, ErrDuplicate},
+		{"duplicate locale", func(s *Spec) { s.Locales = []string{"en", "fr", "en"} }, ErrDuplicate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			tt.modify(&s)
+			if err := s.Validate(); !errors.Is(err, tt.err) {
+				t.Errorf("Validate = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSpecExpand(t *testing.T) {
+	tests := []struct {
+		name string
+		spec Spec
+		want []Variant
+	}{
+		{
+			name: "without locales",
+			spec: Spec{AndroidAPIs: []string{"emulator_13.0"}, DeviceNames: []string{"Nexus 5", "Samsung Galaxy S10"}},
+			want: []Variant{
+				{AndroidAPI: "emulator_13.0", DeviceName: "Nexus 5"},
+				{AndroidAPI: "emulator_13.0", DeviceName: "Samsung Galaxy S10"},
+			},
+		},
+		{
+			name: "full matrix",
+			spec: Spec{
+				AndroidAPIs: []string{"emulator_11.0", "emulator_13.0"},
+				DeviceNames: []string{"Nexus 5"},
+				Locales:     []string{"en", "fr-FR"},
+			},
+			want: []Variant{
+				{AndroidAPI: "emulator_11.0", DeviceName: "Nexus 5", Locale: "en"},
+				{AndroidAPI: "emulator_11.0", DeviceName: "Nexus 5", Locale: "fr-FR"},
+				{AndroidAPI: "emulator_13.0", DeviceName: "Nexus 5", Locale: "en"},
+				{AndroidAPI: "emulator_13.0", DeviceName: "Nexus 5", Locale: "fr-FR"},
+			},
+		},
+		{
+			name: "empty",
+			spec: Spec{DeviceNames: []string{"Nexus 5"}, Locales: []string{"en"}},
+			want: []Variant{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.spec.Expand()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expand = %+v, want %+v", got, tt.want)
+			}
+			if tt.spec.Size() != len(tt.want) {
+				t.Errorf("Size = %d, want %d", tt.spec.Size(), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p := NewPolicy(&Config{MaxDevices: 8, MaxFleets: 3, DevicePrice: 15})
+
+	tests := []struct {
+		name    string
+		sizes   []int
+		n       int
+		balance int
+		err     error
+	}{
+		{"first fleet", nil, 4, 60, nil},
+		{"up to the device quota", []int{2, 2}, 4, 60, nil},
+		{"over the device quota", []int{2, 2}, 5, 1000, ErrTooManyDevices},
+		{"alone over the device quota", nil, 9, 1000, ErrTooManyDevices},
+		{"fleet quota", []int{1, 1, 1}, 1, 1000, ErrTooManyFleets},
+		{"balance short by one", nil, 4, 59, ErrInsufficientFund},
+		{"no balance", nil, 1, 0, ErrInsufficientFund},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.Check(tt.sizes, tt.n, tt.balance); !errors.Is(err, tt.err) {
+				t.Errorf("Check = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestPolicyCost(t *testing.T) {
+	tests := []struct {
+		cfg   Config
+		n     int
+		price int
+		cost  int
+	}{
+		{Config{}, 3, defaultDevicePrice, 3 * defaultDevicePrice},
+		{Config{DevicePrice: 25}, 4, 25, 100},
+		{Config{DevicePrice: -5}, 2, defaultDevicePrice, 2 * defaultDevicePrice},
+		{Config{DevicePrice: 25}, 0, 25, 0},
+	}
+
+	for _, tt := range tests {
+		p := NewPolicy(&tt.cfg)
+		if p.DevicePrice() != tt.price || p.Cost(tt.n) != tt.cost {
+			t.Errorf("NewPolicy(%+v): DevicePrice, Cost(%d) = %d, %d, want %d, %d",
+				tt.cfg, tt.n, p.DevicePrice(), p.Cost(tt.n), tt.price, tt.cost)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		statuses []string
+		want     Readiness
+	}{
+		{nil, Readiness{}},
+		{[]string{"READY", "ready"}, Readiness{Total: 2, Ready: 2, AllReady: true}},
+		{
+			[]string{"READY", "BOOTING", "REBOOTING", "", "POWER_OPERATION_FAILED: device did not boot", "Error: container exited"},
+			Readiness{Total: 6, Ready: 1, Booting: 3, Failed: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := Aggregate(tt.statuses); got != tt.want {
+			t.Errorf("Aggregate(%q) = %+v, want %+v", tt.statuses, got, tt.want)
+		}
+	}
+}
+
+func TestEach(t *testing.T) {
+	ids := []string{"alice-Fleet-f1-0", "alice-Fleet-f1-1", "alice-Fleet-f1-2"}
+
+	// The outcomes keep the order of the devices, not of completion.
+	outcomes := Each(context.Background(), ids, func(ctx context.Context, id string) (any, error) {
+		switch id {
+		case ids[0]:
+			time.Sleep(20 * time.Millisecond)
+			return "rebooted", nil
+		case ids[1]:
+			return nil, errors.New("device not found")
+		}
+		return "rebooted", nil
+	})
+
+	want := []Outcome{
+		{DeviceID: ids[0], Success: true, Result: "rebooted"},
+		{DeviceID: ids[1], Error: "device not found"},
+		{DeviceID: ids[2], Success: true, Result: "rebooted"},
+	}
+	if !reflect.DeepEqual(outcomes, want) {
+		t.Errorf("Each = %+v, want %+v", outcomes, want)
+	}
+
+	result := NewBulkResult("f1", outcomes)
+	if result.FleetID != "f1" || result.Succeeded != 2 || result.Failed != 1 {
+		t.Errorf("NewBulkResult = %+v", result)
+	}
+	if DeviceID("alice", "f1", 2) != ids[2] {
+		t.Errorf("DeviceID = %q, want %q", DeviceID("alice", "f1", 2), ids[2])
+	}
+}
@@ -0,0 +1,64 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const earthRadius = 6371000.0
+
+var ErrInvalidPoint = errors.New("invalid point")
+
+// Point is a position on the earth. Time and Speed, in meters per second,
+// are only used by the points of a route.
+type Point struct {
+	Lat   float64    `json:"lat"`
+	Lon   float64    `json:"lon"`
+	Alt   float64    `json:"alt,omitempty"`
+	Time  *time.Time `json:"time,omitempty"`
+	Speed float64    `json:"speed,omitempty"`
+}
+
+// Validate checks the point is a valid position.
+func (p Point) Validate() error {
+	if math.IsNaN(p.Lat) || p.Lat < -90 || p.Lat > 90 {
+		return fmt.Errorf("%w: latitude %v is not between -90 and 90", ErrInvalidPoint, p.Lat)
+	}
+	if math.IsNaN(p.Lon) || p.Lon < -180 || p.Lon > 180 {
+		return fmt.Errorf("%w: longitude %v is not between -180 and 180", ErrInvalidPoint, p.Lon)
+	}
+	if math.IsNaN(p.Alt) || math.IsInf(p.Alt, 0) {
+		return fmt.Errorf("%w: altitude %v", ErrInvalidPoint, p.Alt)
+	}
+	if math.IsNaN(p.Speed) || p.Speed < 0 {
+		return fmt.Errorf("%w: speed must not be negative", ErrInvalidPoint)
+	}
+	return nil
+}
+
+// Position returns the point without its route timing.
+func (p Point) Position() Point {
+	return Point{Lat: p.Lat, Lon: p.Lon, Alt: p.Alt}
+}
+
+// FixCommand returns the console command placing the emulator at p. The
+// console takes the longitude first.
+func FixCommand(p Point) string {
+	return fmt.Sprintf("geo fix %.6f %.6f %.1f", p.Lon, p.Lat, p.Alt)
+}
+
+// Distance returns the great-circle distance between a and b in meters.
+func Distance(a Point, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat := lat2 - lat1
+	dLon := radians(b.Lon - a.Lon)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
@@ -0,0 +1,212 @@
+package geo
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route file formats.
+const (
+	FormatPoints = "points"
+	FormatGPX    = "gpx"
+	FormatKML    = "kml"
+)
+
+var ErrUnknownFormat = errors.New("unknown route format")
+
+// Parse reads the points of a GPX or KML file.
+func Parse(format string, r io.Reader) ([]Point, error) {
+	var points []Point
+	var err error
+
+	switch format {
+	case FormatGPX:
+		points, err = parseGPX(r)
+	case FormatKML:
+		points, err = parseKML(r)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s file: %v", ErrInvalidRoute, format, err)
+	}
+	if len(points) > MaxPoints {
+		return nil, fmt.Errorf("%w: more than %d points", ErrInvalidRoute, MaxPoints)
+	}
+
+	return points, nil
+}
+
+// parseGPX reads the track points of a GPX file, or its route points, or
+// its waypoints when it has neither.
+func parseGPX(r io.Reader) ([]Point, error) {
+	found := map[string][]Point{}
+
+	var current *Point
+	var kind string
+	var text strings.Builder
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			text.Reset()
+			switch t.Name.Local {
+			case "trkpt", "rtept", "wpt":
+				current = &Point{}
+				kind = t.Name.Local
+				for _, attr := range t.Attr {
+					var err error
+					switch attr.Name.Local {
+					case "lat":
+						current.Lat, err = strconv.ParseFloat(attr.Value, 64)
+					case "lon":
+						current.Lon, err = strconv.ParseFloat(attr.Value, 64)
+					}
+					if err != nil {
+						return nil, fmt.Errorf("%s: %v", kind, err)
+					}
+				}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if current == nil {
+				continue
+			}
+			value := strings.TrimSpace(text.String())
+			switch t.Name.Local {
+			case "ele":
+				alt, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, fmt.Errorf("ele: %v", err)
+				}
+				current.Alt = alt
+			case "time":
+				ts, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					return nil, fmt.Errorf("time: %v", err)
+				}
+				current.Time = &ts
+			case kind:
+				found[kind] = append(found[kind], *current)
+				current = nil
+			}
+		}
+	}
+
+	for _, kind := range []string{"trkpt", "rtept", "wpt"} {
+		if len(found[kind]) > 0 {
+			return found[kind], nil
+		}
+	}
+	return nil, errors.New("no track, route or waypoints")
+}
+
+// parseKML reads the coordinates of a KML file, or the timed coordinates
+// of its gx:Track elements when it has them.
+func parseKML(r io.Reader) ([]Point, error) {
+	var points, track []Point
+	var when []time.Time
+	var text strings.Builder
+	// The when elements of a TimeStamp are not times of the track.
+	inTrack := false
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			text.Reset()
+			if t.Name.Local == "Track" {
+				inTrack = true
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			switch t.Name.Local {
+			case "Track":
+				inTrack = false
+			case "coordinates":
+				// Tuples of lon,lat[,alt] separated by whitespace.
+				for _, tuple := range strings.Fields(value) {
+					p, err := parseCoord(strings.Split(tuple, ","))
+					if err != nil {
+						return nil, fmt.Errorf("coordinates: %v", err)
+					}
+					points = append(points, p)
+				}
+			case "when":
+				if !inTrack {
+					continue
+				}
+				ts, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					return nil, fmt.Errorf("when: %v", err)
+				}
+				when = append(when, ts)
+			case "coord":
+				p, err := parseCoord(strings.Fields(value))
+				if err != nil {
+					return nil, fmt.Errorf("coord: %v", err)
+				}
+				track = append(track, p)
+			}
+		}
+	}
+
+	if len(track) > 0 {
+		if len(when) != len(track) {
+			return nil, fmt.Errorf("track has %d coordinates and %d times", len(track), len(when))
+		}
+		for i := range track {
+			track[i].Time = &when[i]
+		}
+		return track, nil
+	}
+	if len(points) == 0 {
+		return nil, errors.New("no coordinates")
+	}
+	return points, nil
+}
+
+// parseCoord reads a longitude, latitude and optional altitude.
+func parseCoord(fields []string) (Point, error) {
+	if len(fields) < 2 || len(fields) > 3 {
+		return Point{}, fmt.Errorf("invalid coordinate %q", strings.Join(fields, " "))
+	}
+
+	values := make([]float64, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return Point{}, err
+		}
+		values[i] = v
+	}
+
+	p := Point{Lon: values[0], Lat: values[1]}
+	if len(values) == 3 {
+		p.Alt = values[2]
+	}
+	return p, nil
+}
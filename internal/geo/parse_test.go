@@ -0,0 +1,174 @@
+package geo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseFile(t *testing.T, format string, name string) []Point {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	points, err := Parse(format, f)
+	if err != nil {
+		t.Fatalf("Parse(%s): %v", name, err)
+	}
+	return points
+}
+
+func at(value string) *time.Time {
+	ts, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return &ts
+}
+
+func equalPoints(t *testing.T, got []Point, want []Point) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d points %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Lat != w.Lat || g.Lon != w.Lon || g.Alt != w.Alt {
+			t.Errorf("point %d = %v,%v,%v, want %v,%v,%v", i, g.Lat, g.Lon, g.Alt, w.Lat, w.Lon, w.Alt)
+		}
+		switch {
+		case w.Time == nil && g.Time != nil:
+			t.Errorf("point %d is timed %v, want no time", i, *g.Time)
+		case w.Time != nil && g.Time == nil:
+			t.Errorf("point %d has no time, want %v", i, *w.Time)
+		case w.Time != nil && !g.Time.Equal(*w.Time):
+			t.Errorf("point %d is timed %v, want %v", i, *g.Time, *w.Time)
+		}
+	}
+}
+
+func TestParseGPX(t *testing.T) {
+	tests := []struct {
+		file string
+		want []Point
+	}{
+		{
+			// The track points of both segments, not the start waypoint
+			// nor the time of the metadata.
+			file: "track.gpx",
+			want: []Point{
+				{Lat: 52.52, Lon: 13.405, Alt: 34, Time: at("2024-05-01T07:00:00Z")},
+				{Lat: 52.521, Lon: 13.407, Alt: 36.5, Time: at("2024-05-01T07:00:20Z")},
+				{Lat: 52.5225, Lon: 13.41, Alt: 35, Time: at("2024-05-01T07:01:00Z")},
+			},
+		},
+		{
+			// The route points rather than the waypoint.
+			file: "route.gpx",
+			want: []Point{
+				{Lat: 48.8584, Lon: 2.2945},
+				{Lat: 48.8606, Lon: 2.3376},
+				{Lat: 48.853, Lon: 2.3499},
+			},
+		},
+		{
+			file: "waypoints.gpx",
+			want: []Point{
+				{Lat: -33.8568, Lon: 151.2153, Alt: 5, Time: at("2024-05-01T07:00:00Z")},
+				{Lat: -33.8523, Lon: 151.2108},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			equalPoints(t, parseFile(t, FormatGPX, tt.file), tt.want)
+		})
+	}
+}
+
+func TestParseKML(t *testing.T) {
+	tests := []struct {
+		file string
+		want []Point
+	}{
+		{
+			// Tuples are longitude first and may span lines.
+			file: "path.kml",
+			want: []Point{
+				{Lat: 37.7749, Lon: -122.4194, Alt: 16},
+				{Lat: 37.7835, Lon: -122.408, Alt: 12.5},
+				{Lat: 37.7935, Lon: -122.3986},
+			},
+		},
+		{
+			// The track rather than the start placemark, whose TimeStamp
+			// is not a time of the track.
+			file: "track.kml",
+			want: []Point{
+				{Lat: 35.6812, Lon: 139.7671, Alt: 40, Time: at("2024-05-01T07:00:00Z")},
+				{Lat: 35.683, Lon: 139.77, Alt: 42.5, Time: at("2024-05-01T07:00:30Z")},
+				{Lat: 35.6851, Lon: 139.7745, Alt: 41, Time: at("2024-05-01T07:02:00Z")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			equalPoints(t, parseFile(t, FormatKML, tt.file), tt.want)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+	}{
+		{"empty gpx", FormatGPX, `<gpx><metadata><name>Empty</name></metadata></gpx>`},
+		{"gpx latitude", FormatGPX, `<gpx><wpt lat="north" lon="13.4"/></gpx>`},
+		{"gpx elevation", FormatGPX, `<gpx><wpt lat="52.5" lon="13.4"><ele>high</ele></wpt></gpx>`},
+		{"gpx time", FormatGPX, `<gpx><trk><trkseg><trkpt lat="52.5" lon="13.4"><time>07:00</time></trkpt></trkseg></trk></gpx>`},
+		{"gpx truncated", FormatGPX, `<gpx><trk><trkseg><trkpt lat="52.5" lon="13.4">`},
+		{"kml without coordinates", FormatKML, `<kml><Document><name>Empty</name></Document></kml>`},
+		{"kml tuple", FormatKML, `<kml><LineString><coordinates>13.4</coordinates></LineString></kml>`},
+		{"kml tuple altitude", FormatKML, `<kml><LineString><coordinates>13.4,52.5,1,2</coordinates></LineString></kml>`},
+		{"kml track times", FormatKML, `<kml><Track><when>2024-05-01T07:00:00Z</when><coord>13.4 52.5</coord><coord>13.5 52.6</coord></Track></kml>`},
+		{"kml track coord", FormatKML, `<kml><Track><when>2024-05-01T07:00:00Z</when><coord>13.4,52.5</coord></Track></kml>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.format, strings.NewReader(tt.data))
+			if !errors.Is(err, ErrInvalidRoute) {
+				t.Errorf("err = %v, want %v", err, ErrInvalidRoute)
+			}
+		})
+	}
+
+	if _, err := Parse("geojson", strings.NewReader(`{}`)); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownFormat)
+	}
+}
+
+func TestParseMaxPoints(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("<kml><LineString><coordinates>")
+	for i := 0; i <= MaxPoints; i++ {
+		b.WriteString("13.4,52.5 ")
+	}
+	b.WriteString("</coordinates></LineString></kml>")
+
+	if _, err := Parse(FormatKML, strings.NewReader(b.String())); !errors.Is(err, ErrInvalidRoute) {
+		t.Errorf("err = %v, want %v", err, ErrInvalidRoute)
+	}
+}
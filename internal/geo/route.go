@@ -0,0 +1,109 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultSpeed is used between points without timestamps or speed,
+	// 50 km/h in meters per second.
+	DefaultSpeed = 13.9
+	MaxPoints    = 100000
+)
+
+var ErrInvalidRoute = errors.New("invalid route")
+
+// Route is a path of points with the time each one is reached at.
+type Route struct {
+	points  []Point
+	offsets []time.Duration
+}
+
+// NewRoute times the points of a route. Between two points with timestamps
+// the route takes the time between them, otherwise it moves at the speed
+// of the first point, or at speed when the point has none.
+func NewRoute(points []Point, speed float64) (*Route, error) {
+	if len(points) < 2 || len(points) > MaxPoints {
+		return nil, fmt.Errorf("%w: a route needs between 2 and %d points", ErrInvalidRoute, MaxPoints)
+	}
+	if speed < 0 {
+		return nil, fmt.Errorf("%w: speed must not be negative", ErrInvalidRoute)
+	}
+	if speed == 0 {
+		speed = DefaultSpeed
+	}
+
+	r := &Route{
+		points:  make([]Point, len(points)),
+		offsets: make([]time.Duration, len(points)),
+	}
+
+	for i, p := range points {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("point %d: %w", i, err)
+		}
+		r.points[i] = p
+
+		if i == 0 {
+			continue
+		}
+
+		prev := points[i-1]
+		var step time.Duration
+		if prev.Time != nil && p.Time != nil {
+			step = p.Time.Sub(*prev.Time)
+			if step < 0 {
+				return nil, fmt.Errorf("%w: point %d is timed before the point preceding it", ErrInvalidRoute, i)
+			}
+		} else {
+			v := prev.Speed
+			if v == 0 {
+				v = speed
+			}
+			step = time.Duration(Distance(prev, p) / v * float64(time.Second))
+		}
+		r.offsets[i] = r.offsets[i-1] + step
+	}
+
+	return r, nil
+}
+
+// Len returns the number of points of the route.
+func (r *Route) Len() int {
+	return len(r.points)
+}
+
+// Duration returns the time the route takes.
+func (r *Route) Duration() time.Duration {
+	return r.offsets[len(r.offsets)-1]
+}
+
+// At returns the position reached after elapsed, interpolated between the
+// points around it.
+func (r *Route) At(elapsed time.Duration) Point {
+	if elapsed <= 0 {
+		return r.points[0].Position()
+	}
+	if elapsed >= r.Duration() {
+		return r.points[len(r.points)-1].Position()
+	}
+
+	// The first point reached after elapsed.
+	i := sort.Search(len(r.offsets), func(i int) bool { return r.offsets[i] > elapsed })
+	from, to := r.points[i-1], r.points[i]
+
+	span := r.offsets[i] - r.offsets[i-1]
+	if span == 0 {
+		return to.Position()
+	}
+	frac := float64(elapsed-r.offsets[i-1]) / float64(span)
+
+	return Point{
+		Lat: from.Lat + (to.Lat-from.Lat)*frac,
+		Lon: from.Lon + (to.Lon-from.Lon)*frac,
+		Alt: from.Alt + (to.Alt-from.Alt)*frac,
+	}
+}
@@ -0,0 +1,191 @@
+package geo
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// degree is the length of a degree of latitude in meters.
+var degree = earthRadius * math.Pi / 180
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b Point
+		want float64
+	}{
+		{Point{Lat: 10, Lon: 20}, Point{Lat: 10, Lon: 20}, 0},
+		{Point{Lat: 0, Lon: 0}, Point{Lat: 1, Lon: 0}, degree},
+		{Point{Lat: 0, Lon: 0}, Point{Lat: 0, Lon: 1}, degree},
+		// The great circle is shorter than half a degree along the parallel.
+		{Point{Lat: 60, Lon: 0}, Point{Lat: 60, Lon: 1}, 55596.9},
+		{Point{Lat: 0, Lon: 179.5}, Point{Lat: 0, Lon: -179.5}, degree},
+		{Point{Lat: 90, Lon: 0}, Point{Lat: -90, Lon: 0}, math.Pi * earthRadius},
+	}
+
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); math.Abs(got-tt.want) > 0.1 {
+			t.Errorf("Distance(%v, %v) = %.1f, want %.1f", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFixCommand(t *testing.T) {
+	got := FixCommand(Point{Lat: 52.52, Lon: -13.405, Alt: 34.25})
+	if want := "geo fix -13.405000 52.520000 34.2"; got != want {
+		t.Errorf("FixCommand = %q, want %q", got, want)
+	}
+}
+
+func TestNewRouteTiming(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []Point
+		speed  float64
+		want   []time.Duration
+	}{
+		{
+			name: "timestamps",
+			points: []Point{
+				{Lat: 0, Time: at("2024-05-01T07:00:00Z")},
+				{Lat: 1, Time: at("2024-05-01T07:00:20Z")},
+				// Standing still keeps the time between the points.
+				{Lat: 1, Time: at("2024-05-01T07:01:00Z")},
+			},
+			want: []time.Duration{0, 20 * time.Second, time.Minute},
+		},
+		{
+			name:   "default speed",
+			points: []Point{{Lat: 0}, {Lat: 1}},
+			want:   []time.Duration{0, time.Duration(degree / DefaultSpeed * float64(time.Second))},
+		},
+		{
+			name:   "route speed",
+			points: []Point{{Lat: 0}, {Lat: 1}, {Lat: 3}},
+			speed:  degree / 100,
+			want:   []time.Duration{0, 100 * time.Second, 300 * time.Second},
+		},
+		{
+			// A point moves at its own speed to the next one.
+			name:   "point speed",
+			points: []Point{{Lat: 0, Speed: degree / 10}, {Lat: 1}, {Lat: 2}},
+			speed:  degree / 100,
+			want:   []time.Duration{0, 10 * time.Second, 110 * time.Second},
+		},
+		{
+			// Only the pairs of timed points take their times.
+			name: "partly timed",
+			points: []Point{
+				{Lat: 0, Time: at("2024-05-01T07:00:00Z")},
+				{Lat: 1, Time: at("2024-05-01T07:00:05Z")},
+				{Lat: 2},
+				{Lat: 3, Time: at("2024-05-01T07:00:00Z")},
+			},
+			speed: degree / 50,
+			want:  []time.Duration{0, 5 * time.Second, 55 * time.Second, 105 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRoute(tt.points, tt.speed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := r.offsets
+			for i, want := range tt.want {
+				if d := got[i] - want; d < -time.Millisecond || d > time.Millisecond {
+					t.Errorf("point %d reached at %v, want %v", i, got[i], want)
+				}
+			}
+			if r.Len() != len(tt.points) || r.Duration() != got[len(got)-1] {
+				t.Errorf("route has %d points and takes %v", r.Len(), r.Duration())
+			}
+		})
+	}
+}
+
+func TestNewRouteFromFile(t *testing.T) {
+	r, err := NewRoute(parseFile(t, FormatKML, "track.kml"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.Duration(), 2*time.Minute; got != want {
+		t.Errorf("track takes %v, want %v", got, want)
+	}
+
+	r, err = NewRoute(parseFile(t, FormatGPX, "route.gpx"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := parseFile(t, FormatGPX, "route.gpx")
+	meters := Distance(points[0], points[1]) + Distance(points[1], points[2])
+	want := time.Duration(meters / DefaultSpeed * float64(time.Second))
+	if d := r.Duration() - want; d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("route takes %v, want %v", r.Duration(), want)
+	}
+}
+
+func TestNewRouteErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []Point
+		speed  float64
+		err    error
+	}{
+		{"single point", []Point{{Lat: 1}}, 0, ErrInvalidRoute},
+		{"negative speed", []Point{{Lat: 0}, {Lat: 1}}, -1, ErrInvalidRoute},
+		{"latitude", []Point{{Lat: 0}, {Lat: 91}}, 0, ErrInvalidPoint},
+		{"longitude", []Point{{Lon: -181}, {Lat: 1}}, 0, ErrInvalidPoint},
+		{"point speed", []Point{{Lat: 0, Speed: -2}, {Lat: 1}}, 0, ErrInvalidPoint},
+		{"backwards", []Point{
+			{Lat: 0, Time: at("2024-05-01T07:00:10Z")},
+			{Lat: 1, Time: at("2024-05-01T07:00:00Z")},
+		}, 0, ErrInvalidRoute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRoute(tt.points, tt.speed); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRouteAt(t *testing.T) {
+	r, err := NewRoute([]Point{
+		{Lat: 10, Lon: 20, Alt: 100, Time: at("2024-05-01T07:00:00Z")},
+		{Lat: 12, Lon: 24, Alt: 200, Time: at("2024-05-01T07:00:10Z")},
+		// Reached at the same time, the route jumps to it.
+		{Lat: 13, Lon: 24, Alt: 200, Time: at("2024-05-01T07:00:10Z")},
+		{Lat: 13, Lon: 25, Alt: 0, Time: at("2024-05-01T07:00:30Z")},
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		want    Point
+	}{
+		{-time.Second, Point{Lat: 10, Lon: 20, Alt: 100}},
+		{0, Point{Lat: 10, Lon: 20, Alt: 100}},
+		{5 * time.Second, Point{Lat: 11, Lon: 22, Alt: 150}},
+		{10 * time.Second, Point{Lat: 13, Lon: 24, Alt: 200}},
+		{25 * time.Second, Point{Lat: 13, Lon: 24.75, Alt: 50}},
+		{30 * time.Second, Point{Lat: 13, Lon: 25}},
+		{time.Hour, Point{Lat: 13, Lon: 25}},
+	}
+
+	for _, tt := range tests {
+		got := r.At(tt.elapsed)
+		if math.Abs(got.Lat-tt.want.Lat) > 1e-9 || math.Abs(got.Lon-tt.want.Lon) > 1e-9 || math.Abs(got.Alt-tt.want.Alt) > 1e-9 {
+			t.Errorf("At(%v) = %+v, want %+v", tt.elapsed, got, tt.want)
+		}
+		if got.Time != nil {
+			t.Errorf("At(%v) keeps the time of the route", tt.elapsed)
+		}
+	}
+}
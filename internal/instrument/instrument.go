@@ -0,0 +1,345 @@
+package instrument
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Results of a test.
+const (
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+	StatusError   = "error"
+	StatusSkipped = "skipped"
+)
+
+// Status codes reported by the runner for a test, as in
+// android.app.Instrumentation and AndroidJUnitRunner.
+const (
+	codeStart             = 1
+	codeInProgress        = 2
+	codeOK                = 0
+	codeError             = -1
+	codeFailure           = -2
+	codeIgnored           = -3
+	codeAssumptionFailure = -4
+)
+
+// resultOK is the code of an instrumentation that ran to its end,
+// Activity.RESULT_OK.
+const resultOK = -1
+
+// Line prefixes of the raw instrumentation output.
+const (
+	prefixStatus     = "INSTRUMENTATION_STATUS: "
+	prefixStatusCode = "INSTRUMENTATION_STATUS_CODE: "
+	prefixResult     = "INSTRUMENTATION_RESULT: "
+	prefixCode       = "INSTRUMENTATION_CODE: "
+	prefixFailed     = "INSTRUMENTATION_FAILED: "
+	prefixAborted    = "INSTRUMENTATION_ABORTED: "
+)
+
+var (
+	ErrInvalidArg             = errors.New("runner arguments must be key=value with a key of letters, digits, '_' or '.'")
+	ErrInvalidInstrumentation = errors.New("invalid instrumentation, expected package/runner")
+	ErrNoInstrumentation      = errors.New("test APK has no instrumentation")
+)
+
+var (
+	argKeyRe          = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.]*$`)
+	instrumentationRe = regexp.MustCompile(`^[A-Za-z0-9_.]+/[A-Za-z0-9_.$]+$`)
+	// instrumentation:com.example.test/androidx.test.runner.AndroidJUnitRunner (target=com.example)
+	listInstrumentationRe = regexp.MustCompile(`^instrumentation:(\S+) \(target=([^)]*)\)`)
+)
+
+// TestResult is the result of a test method.
+type TestResult struct {
+	Class      string `json:"class"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	DurationMs int64  `json:"duration_ms"`
+	// Stack is the stack trace of a failed test.
+	Stack string `json:"stack,omitempty"`
+	// Screenshot is the id of the artifact of the screen captured when the
+	// test failed.
+	Screenshot string `json:"screenshot,omitempty"`
+	// DeviceID is the device the test ran on in a sharded run, Attempts
+	// how many times it ran and Flaky whether it passed after failing.
+	DeviceID string `json:"device_id,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+	Flaky    bool   `json:"flaky,omitempty"`
+}
+
+// FullName returns the class and method of the test.
+func (t TestResult) FullName() string {
+	return t.Class + "#" + t.Name
+}
+
+// Summary counts the results of a run.
+type Summary struct {
+	Tests   int `json:"tests"`
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Errors  int `json:"errors"`
+	Skipped int `json:"skipped"`
+	// Flaky counts the passed tests which failed before.
+	Flaky int `json:"flaky,omitempty"`
+}
+
+// Add counts a result.
+func (s *Summary) Add(status string) {
+	s.Tests++
+	switch status {
+	case StatusPassed:
+		s.Passed++
+	case StatusFailed:
+		s.Failed++
+	case StatusError:
+		s.Errors++
+	case StatusSkipped:
+		s.Skipped++
+	}
+}
+
+// Result is the outcome of an instrumentation run.
+type Result struct {
+	Summary Summary      `json:"summary"`
+	Tests   []TestResult `json:"tests"`
+	// Completed tells whether the runner ran to its end, a crash of the
+	// app under test aborts it.
+	Completed bool `json:"completed"`
+	// Message is the message of the runner when it did not complete.
+	Message    string `json:"message,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Instrumentation is an instrumentation installed on a device.
+type Instrumentation struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+}
+
+// Package returns the package of the test APK declaring the
+// instrumentation.
+func (i Instrumentation) Package() string {
+	pkg, _, _ := strings.Cut(i.Name, "/")
+	return pkg
+}
+
+// ParseInstrumentations parses the output of pm list instrumentation.
+func ParseInstrumentations(out string) []Instrumentation {
+	var list []Instrumentation
+	for _, line := range strings.Split(out, "\n") {
+		if m := listInstrumentationRe.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			list = append(list, Instrumentation{Name: m[1], Target: m[2]})
+		}
+	}
+	return list
+}
+
+// ParseArgs parses runner arguments given as key=value.
+func ParseArgs(values []string) (map[string]string, error) {
+	args := map[string]string{}
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || !argKeyRe.MatchString(key) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidArg, v)
+		}
+		args[key] = value
+	}
+	return args, nil
+}
+
+// Command returns the shell command running an instrumentation with the
+// runner arguments and raw output.
+func Command(instrumentation string, args map[string]string) (string, error) {
+	if !instrumentationRe.MatchString(instrumentation) {
+		return "", ErrInvalidInstrumentation
+	}
+
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		if !argKeyRe.MatchString(k) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidArg, k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("am instrument -r -w")
+	for _, k := range keys {
+		fmt.Fprintf(&b, " -e %s %s", k, quote(args[k]))
+	}
+	b.WriteString(" " + instrumentation)
+	return b.String(), nil
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Parser reads the raw output of am instrument -r line by line.
+type Parser struct {
+	startedAt time.Time
+	tests     []TestResult
+	started   map[string]time.Time
+
+	status map[string]string
+	result map[string]string
+	// values is the bundle the last key was read into, key the key whose
+	// value continues on the next lines.
+	values map[string]string
+	key    string
+
+	code     *int
+	failed   string
+	finished time.Time
+}
+
+// NewParser returns a parser of a run started at the given time.
+func NewParser(startedAt time.Time) *Parser {
+	return &Parser{
+		startedAt: startedAt,
+		started:   map[string]time.Time{},
+		status:    map[string]string{},
+		result:    map[string]string{},
+	}
+}
+
+// Line parses a line of output read at the given time, and returns the
+// result of a test and true when the line ended one.
+func (p *Parser) Line(line string, at time.Time) (TestResult, bool) {
+	line = strings.TrimRight(line, "\r")
+	p.finished = at
+
+	switch {
+	case strings.HasPrefix(line, prefixStatus):
+		p.set(p.status, strings.TrimPrefix(line, prefixStatus))
+	case strings.HasPrefix(line, prefixStatusCode):
+		code, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, prefixStatusCode)))
+		status := p.status
+		p.status, p.values, p.key = map[string]string{}, nil, ""
+		return p.endStatus(status, code, at)
+	case strings.HasPrefix(line, prefixResult):
+		p.set(p.result, strings.TrimPrefix(line, prefixResult))
+	case strings.HasPrefix(line, prefixCode):
+		code, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, prefixCode)))
+		if err == nil {
+			p.code = &code
+		}
+		p.values, p.key = nil, ""
+	case strings.HasPrefix(line, prefixFailed):
+		p.failed = strings.TrimPrefix(line, prefixFailed)
+		p.values, p.key = nil, ""
+	case strings.HasPrefix(line, prefixAborted):
+		p.failed = strings.TrimPrefix(line, prefixAborted)
+		p.values, p.key = nil, ""
+	case p.values != nil:
+		p.values[p.key] += "\n" + line
+	}
+	return TestResult{}, false
+}
+
+func (p *Parser) set(values map[string]string, kv string) {
+	key, value, _ := strings.Cut(kv, "=")
+	values[key] = value
+	p.values, p.key = values, key
+}
+
+func (p *Parser) endStatus(status map[string]string, code int, at time.Time) (TestResult, bool) {
+	class, name := status["class"], status["test"]
+	if class == "" || name == "" {
+		return TestResult{}, false
+	}
+	id := class + "#" + name
+
+	switch code {
+	case codeStart:
+		p.started[id] = at
+		return TestResult{}, false
+	case codeInProgress:
+		return TestResult{}, false
+	}
+
+	t := TestResult{Class: class, Name: name, Stack: strings.TrimSpace(status["stack"])}
+	switch code {
+	case codeOK:
+		t.Status = StatusPassed
+	case codeFailure:
+		t.Status = StatusFailed
+	case codeIgnored, codeAssumptionFailure:
+		t.Status = StatusSkipped
+	default:
+		t.Status = StatusError
+	}
+	if started, ok := p.started[id]; ok {
+		t.DurationMs = at.Sub(started).Milliseconds()
+		delete(p.started, id)
+	}
+
+	p.tests = append(p.tests, t)
+	return t, true
+}
+
+// SetScreenshot records the screenshot of the last result of a test.
+func (p *Parser) SetScreenshot(test string, artifactID string) {
+	for i := len(p.tests) - 1; i >= 0; i-- {
+		if p.tests[i].FullName() == test {
+			p.tests[i].Screenshot = artifactID
+			return
+		}
+	}
+}
+
+// Result returns the result of the run once the output ended. Tests that
+// started without ending are errors of the crash that aborted the run.
+func (p *Parser) Result() Result {
+	result := Result{
+		Tests:      p.tests,
+		Completed:  p.code != nil && *p.code == resultOK && p.failed == "",
+		DurationMs: p.finished.Sub(p.startedAt).Milliseconds(),
+	}
+	if result.DurationMs < 0 {
+		result.DurationMs = 0
+	}
+
+	if !result.Completed {
+		result.Message = p.failed
+		if result.Message == "" {
+			result.Message = strings.TrimSpace(p.result["shortMsg"] + " " + p.result["longMsg"])
+		}
+		if result.Message == "" {
+			result.Message = "instrumentation did not complete"
+		}
+	}
+
+	ids := make([]string, 0, len(p.started))
+	for id := range p.started {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		class, name, _ := strings.Cut(id, "#")
+		result.Tests = append(result.Tests, TestResult{
+			Class:      class,
+			Name:       name,
+			Status:     StatusError,
+			DurationMs: p.finished.Sub(p.started[id]).Milliseconds(),
+			Stack:      result.Message,
+		})
+	}
+
+	if result.Tests == nil {
+		result.Tests = []TestResult{}
+	}
+	for _, t := range result.Tests {
+		result.Summary.Add(t.Status)
+	}
+	return result
+}
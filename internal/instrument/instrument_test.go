@@ -0,0 +1,248 @@
+package instrument
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var runStartedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// parseFixture feeds testdata/name to a parser, a line every 10ms, and
+// returns the tests it ended line by line and the result of the run.
+func parseFixture(t *testing.T, name string) ([]TestResult, Result) {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p := NewParser(runStartedAt)
+	var ended []TestResult
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan(); i++ {
+		if test, ok := p.Line(scanner.Text(), runStartedAt.Add(time.Duration(i)*10*time.Millisecond)); ok {
+			ended = append(ended, test)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ended, p.Result()
+}
+
+func TestParserFailures(t *testing.T) {
+	ended, result := parseFixture(t, "failures.txt")
+
+	if !result.Completed || result.Message != "" {
+		t.Errorf("Completed, Message = %v, %q, want true, \"\"", result.Completed, result.Message)
+	}
+	if want := (Summary{Tests: 3, Passed: 1, Failed: 1, Errors: 1}); result.Summary != want {
+		t.Errorf("Summary = %+v, want %+v", result.Summary, want)
+	}
+	if result.DurationMs != 750 {
+		t.Errorf("DurationMs = %d, want 750", result.DurationMs)
+	}
+	if !reflect.DeepEqual(ended, result.Tests) {
+		t.Errorf("ended tests %+v, want %+v", ended, result.Tests)
+	}
+
+	want := []TestResult{
+		{Class: "com.example.LoginTest", Name: "validLogin", Status: StatusPassed, DurationMs: 70},
+		{Class: "com.example.LoginTest", Name: "invalidLogin", Status: StatusFailed, DurationMs: 200, Stack: strings.Join([]string{
+			"java.lang.AssertionError: expected:<Welcome> but was:<Invalid password>",
+			"\tat org.junit.Assert.fail(Assert.java:89)",
+			"\tat org.junit.Assert.failNotEquals(Assert.java:835)",
+			"\tat org.junit.Assert.assertEquals(Assert.java:120)",
+			"\tat com.example.LoginTest.invalidLogin(LoginTest.java:42)",
+			"\tat java.lang.reflect.Method.invoke(Native Method)",
+			"\tat androidx.test.runner.AndroidJUnitRunner.onStart(AndroidJUnitRunner.java:444)",
+		}, "\n")},
+		{Class: "com.example.SettingsTest", Name: "openSettings", Status: StatusError, DurationMs: 130, Stack: strings.Join([]string{
+			"java.lang.IllegalStateException: No activity",
+			"\tat com.example.SettingsTest.openSettings(SettingsTest.java:17)",
+		}, "\n")},
+	}
+	if !reflect.DeepEqual(result.Tests, want) {
+		t.Errorf("Tests = %+v, want %+v", result.Tests, want)
+	}
+}
+
+func TestParserSkipped(t *testing.T) {
+	_, result := parseFixture(t, "skipped.txt")
+
+	if !result.Completed {
+		t.Errorf("Completed = false, want true")
+	}
+	if want := (Summary{Tests: 3, Passed: 1, Skipped: 2}); result.Summary != want {
+		t.Errorf("Summary = %+v, want %+v", result.Summary, want)
+	}
+
+	want := []TestResult{
+		{Class: "com.example.FeatureTest", Name: "needsTablet", Status: StatusSkipped, DurationMs: 120, Stack: strings.Join([]string{
+			"org.junit.AssumptionViolatedException: got: <false>, expected: is <true>",
+			"\tat org.junit.Assume.assumeThat(Assume.java:106)",
+			"\tat org.junit.Assume.assumeTrue(Assume.java:50)",
+			"\tat com.example.FeatureTest.needsTablet(FeatureTest.java:21)",
+		}, "\n")},
+		// An ignored test is reported without being started.
+		{Class: "com.example.FeatureTest", Name: "notReady", Status: StatusSkipped},
+		{Class: "com.example.FeatureTest", Name: "works", Status: StatusPassed, DurationMs: 70},
+	}
+	if !reflect.DeepEqual(result.Tests, want) {
+		t.Errorf("Tests = %+v, want %+v", result.Tests, want)
+	}
+}
+
+func TestParserCrash(t *testing.T) {
+	ended, result := parseFixture(t, "crash.txt")
+
+	if result.Completed {
+		t.Errorf("Completed = true, want false")
+	}
+	if result.Message != "Process crashed." {
+		t.Errorf("Message = %q, want %q", result.Message, "Process crashed.")
+	}
+	if want := (Summary{Tests: 2, Passed: 1, Errors: 1}); result.Summary != want {
+		t.Errorf("Summary = %+v, want %+v", result.Summary, want)
+	}
+	if len(ended) != 1 {
+		t.Errorf("%d tests ended, want 1", len(ended))
+	}
+
+	want := []TestResult{
+		{Class: "com.example.CheckoutTest", Name: "addToCart", Status: StatusPassed, DurationMs: 70},
+		// The test running when the app crashed ends with the run.
+		{Class: "com.example.CheckoutTest", Name: "pay", Status: StatusError, DurationMs: 20, Stack: "Process crashed."},
+	}
+	if !reflect.DeepEqual(result.Tests, want) {
+		t.Errorf("Tests = %+v, want %+v", result.Tests, want)
+	}
+}
+
+func TestParserIncomplete(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		message string
+	}{
+		{
+			name:    "failed",
+			output:  "INSTRUMENTATION_FAILED: com.example.test/androidx.test.runner.AndroidJUnitRunner\nINSTRUMENTATION_CODE: 0",
+			message: "com.example.test/androidx.test.runner.AndroidJUnitRunner",
+		},
+		{
+			name:    "aborted",
+			output:  "INSTRUMENTATION_ABORTED: System has crashed.",
+			message: "System has crashed.",
+		},
+		{
+			name:    "long message",
+			output:  "INSTRUMENTATION_RESULT: shortMsg=java.lang.RuntimeException\nINSTRUMENTATION_RESULT: longMsg=java.lang.RuntimeException: boom\nINSTRUMENTATION_CODE: 0",
+			message: "java.lang.RuntimeException java.lang.RuntimeException: boom",
+		},
+		{
+			name:    "no output",
+			output:  "",
+			message: "instrumentation did not complete",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(runStartedAt)
+			for _, line := range strings.Split(tt.output, "\n") {
+				p.Line(line, runStartedAt)
+			}
+			result := p.Result()
+			if result.Completed || result.Message != tt.message {
+				t.Errorf("Completed, Message = %v, %q, want false, %q", result.Completed, result.Message, tt.message)
+			}
+			if result.Tests == nil || len(result.Tests) != 0 {
+				t.Errorf("Tests = %#v, want empty", result.Tests)
+			}
+		})
+	}
+}
+
+func TestParserCRLF(t *testing.T) {
+	p := NewParser(runStartedAt)
+	for _, line := range []string{
+		"INSTRUMENTATION_STATUS: class=com.example.A\r",
+		"INSTRUMENTATION_STATUS: test=a\r",
+		"INSTRUMENTATION_STATUS_CODE: 1\r",
+		"INSTRUMENTATION_STATUS: class=com.example.A\r",
+		"INSTRUMENTATION_STATUS: test=a\r",
+		"INSTRUMENTATION_STATUS_CODE: 0\r",
+		"INSTRUMENTATION_CODE: -1\r",
+	} {
+		p.Line(line, runStartedAt)
+	}
+	result := p.Result()
+	if !result.Completed || len(result.Tests) != 1 || result.Tests[0].Class != "com.example.A" || result.Tests[0].Status != StatusPassed {
+		t.Errorf("Result = %+v, want a completed run of com.example.A#a passed", result)
+	}
+}
+
+func TestSetScreenshot(t *testing.T) {
+	p := NewParser(runStartedAt)
+	for _, line := range []string{
+		"INSTRUMENTATION_STATUS: class=com.example.A",
+		"INSTRUMENTATION_STATUS: test=a",
+		"INSTRUMENTATION_STATUS: stack=java.lang.AssertionError",
+		"INSTRUMENTATION_STATUS_CODE: -2",
+	} {
+		p.Line(line, runStartedAt)
+	}
+	p.SetScreenshot("com.example.A#a", "artifact-1")
+	p.SetScreenshot("com.example.A#b", "artifact-2")
+
+	if got := p.Result().Tests[0].Screenshot; got != "artifact-1" {
+		t.Errorf("Screenshot = %q, want artifact-1", got)
+	}
+}
+
+func TestParseInstrumentations(t *testing.T) {
+	out := "instrumentation:com.example.test/androidx.test.runner.AndroidJUnitRunner (target=com.example)\r\n" +
+		"instrumentation:com.android.shell/.Runner (target=android)\n" +
+		"garbage\n"
+	want := []Instrumentation{
+		{Name: "com.example.test/androidx.test.runner.AndroidJUnitRunner", Target: "com.example"},
+		{Name: "com.android.shell/.Runner", Target: "android"},
+	}
+	got := ParseInstrumentations(out)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseInstrumentations = %+v, want %+v", got, want)
+	}
+	if pkg := got[0].Package(); pkg != "com.example.test" {
+		t.Errorf("Package = %q, want com.example.test", pkg)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	args, err := ParseArgs([]string{"class=com.example.LoginTest", "message=it's=ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := Command("com.example.test/androidx.test.runner.AndroidJUnitRunner", args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `am instrument -r -w -e class 'com.example.LoginTest' -e message 'it'\''s=ok' com.example.test/androidx.test.runner.AndroidJUnitRunner`
+	if cmd != want {
+		t.Errorf("Command = %q, want %q", cmd, want)
+	}
+
+	if _, err := ParseArgs([]string{"class;reboot=x"}); !errors.Is(err, ErrInvalidArg) {
+		t.Errorf("ParseArgs error = %v, want ErrInvalidArg", err)
+	}
+	if _, err := Command("com.example.test/Runner; reboot", nil); !errors.Is(err, ErrInvalidInstrumentation) {
+		t.Errorf("Command error = %v, want ErrInvalidInstrumentation", err)
+	}
+}
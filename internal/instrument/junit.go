@@ -0,0 +1,161 @@
+package instrument
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Hostname  string          `xml:"hostname,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Stack   string `xml:",cdata"`
+}
+
+// WriteJUnit writes the result as a JUnit XML report, with a test suite
+// per test class, of a run named name on the device hostname.
+func WriteJUnit(w io.Writer, name string, hostname string, startedAt time.Time, result Result) error {
+	report := junitSuites{
+		Name:     name,
+		Tests:    result.Summary.Tests,
+		Failures: result.Summary.Failed,
+		Errors:   result.Summary.Errors,
+		Skipped:  result.Summary.Skipped,
+		Time:     seconds(result.DurationMs),
+	}
+
+	suites := map[string]int{}
+	var durations []int64
+	for _, t := range result.Tests {
+		i, ok := suites[t.Class]
+		if !ok {
+			i = len(report.Suites)
+			suites[t.Class] = i
+			report.Suites = append(report.Suites, junitSuite{
+				Name:      t.Class,
+				Timestamp: startedAt.UTC().Format("2006-01-02T15:04:05"),
+				Hostname:  hostname,
+			})
+			durations = append(durations, 0)
+		}
+		suite := &report.Suites[i]
+
+		c := junitTestCase{ClassName: t.Class, Name: t.Name, Time: seconds(t.DurationMs)}
+		switch t.Status {
+		case StatusFailed:
+			c.Failure = failure(t.Stack)
+			suite.Failures++
+		case StatusError:
+			c.Error = failure(t.Stack)
+			suite.Errors++
+		case StatusSkipped:
+			c.Skipped = &struct{}{}
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, c)
+		durations[i] += t.DurationMs
+		suite.Time = seconds(durations[i])
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// failure returns the failure of a stack trace, its first line holds the
+// exception and its message.
+func failure(stack string) *junitFailure {
+	first, _, _ := strings.Cut(stack, "\n")
+	f := &junitFailure{Message: first, Stack: stack}
+	if exception, message, ok := strings.Cut(first, ": "); ok && !strings.Contains(exception, " ") {
+		f.Type, f.Message = exception, message
+	} else if !strings.Contains(first, " ") {
+		f.Type = first
+	}
+	return f
+}
+
+func seconds(ms int64) string {
+	return fmt.Sprintf("%.3f", float64(ms)/1000)
+}
+
+// ReadJUnit reads the test results of a JUnit XML report.
+func ReadJUnit(r io.Reader) (Result, error) {
+	var report junitSuites
+	if err := xml.NewDecoder(r).Decode(&report); err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Tests: []TestResult{}, Completed: true}
+	for _, suite := range report.Suites {
+		for _, c := range suite.Cases {
+			t := TestResult{Class: c.ClassName, Name: c.Name, Status: StatusPassed}
+			t.DurationMs = milliseconds(c.Time)
+			switch {
+			case c.Failure != nil:
+				t.Status, t.Stack = StatusFailed, c.Failure.Stack
+			case c.Error != nil:
+				t.Status, t.Stack = StatusError, c.Error.Stack
+			case c.Skipped != nil:
+				t.Status = StatusSkipped
+			}
+			result.Tests = append(result.Tests, t)
+			result.Summary.Add(t.Status)
+		}
+	}
+	result.DurationMs = milliseconds(report.Time)
+	return result, nil
+}
+
+// milliseconds parses a time in seconds, rounded as 1.005 is not exact.
+func milliseconds(seconds string) int64 {
+	s, err := strconv.ParseFloat(seconds, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(math.Round(s * 1000))
+}
@@ -0,0 +1,128 @@
+package instrument
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJUnitRoundTrip(t *testing.T) {
+	_, parsed := parseFixture(t, "failures.txt")
+	_, crashed := parseFixture(t, "crash.txt")
+
+	tests := []struct {
+		name   string
+		result Result
+	}{
+		{name: "failures", result: parsed},
+		{name: "crash", result: crashed},
+		{name: "empty", result: Result{Tests: []TestResult{}, Completed: true}},
+		{name: "durations", result: Result{
+			Tests: []TestResult{
+				{Class: "com.example.A", Name: "a", Status: StatusPassed, DurationMs: 1234},
+				{Class: "com.example.A", Name: "b", Status: StatusPassed, DurationMs: 1005},
+				{Class: "com.example.A", Name: "c", Status: StatusSkipped},
+			},
+			Summary:    Summary{Tests: 3, Passed: 2, Skipped: 1},
+			Completed:  true,
+			DurationMs: 4567,
+		}},
+		{name: "cdata end in stack", result: Result{
+			Tests: []TestResult{
+				{Class: "com.example.A", Name: "a", Status: StatusFailed, Stack: "java.lang.AssertionError: expected:<[a]]> but was:<<b>&>\n\tat com.example.A.a(A.java:1)"},
+			},
+			Summary:   Summary{Tests: 1, Failed: 1},
+			Completed: true,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteJUnit(&buf, "run", "emulator-5554", runStartedAt, tt.result); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadJUnit(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A report only tells whether the run completed through its
+			// errors, and JUnit keeps no reason of a skipped test.
+			want := tt.result
+			want.Completed, want.Message = true, ""
+			want.Tests = append([]TestResult{}, tt.result.Tests...)
+			for i := range want.Tests {
+				if want.Tests[i].Status == StatusSkipped {
+					want.Tests[i].Stack = ""
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ReadJUnit(WriteJUnit(result)) = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	_, result := parseFixture(t, "failures.txt")
+
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, "run", "emulator-5554", runStartedAt, result); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), xml.Header) {
+		t.Errorf("report does not start with the XML header:\n%s", buf.String())
+	}
+
+	var report junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Name != "run" || report.Tests != 3 || report.Failures != 1 || report.Errors != 1 || report.Time != "0.750" {
+		t.Errorf("testsuites = %+v", report)
+	}
+	if len(report.Suites) != 2 {
+		t.Fatalf("%d test suites, want one per class", len(report.Suites))
+	}
+
+	login := report.Suites[0]
+	if login.Name != "com.example.LoginTest" || login.Tests != 2 || login.Failures != 1 || login.Time != "0.270" ||
+		login.Hostname != "emulator-5554" || login.Timestamp != "2024-05-01T12:00:00" {
+		t.Errorf("testsuite = %+v", login)
+	}
+	f := login.Cases[1].Failure
+	if f == nil {
+		t.Fatal("invalidLogin has no failure")
+	}
+	if f.Type != "java.lang.AssertionError" || f.Message != "expected:<Welcome> but was:<Invalid password>" {
+		t.Errorf("failure type, message = %q, %q", f.Type, f.Message)
+	}
+
+	settings := report.Suites[1]
+	if settings.Errors != 1 || settings.Cases[0].Error == nil || settings.Cases[0].Error.Type != "java.lang.IllegalStateException" {
+		t.Errorf("testsuite = %+v", settings)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	tests := []struct {
+		stack   string
+		typ     string
+		message string
+	}{
+		{stack: "java.lang.AssertionError: boom\n\tat A.a(A.java:1)", typ: "java.lang.AssertionError", message: "boom"},
+		{stack: "java.lang.NullPointerException\n\tat A.a(A.java:1)", typ: "java.lang.NullPointerException", message: "java.lang.NullPointerException"},
+		{stack: "Process crashed.", typ: "", message: "Process crashed."},
+		{stack: "Test timed out: after 60s", typ: "", message: "Test timed out: after 60s"},
+	}
+
+	for _, tt := range tests {
+		f := failure(tt.stack)
+		if f.Type != tt.typ || f.Message != tt.message || f.Stack != tt.stack {
+			t.Errorf("failure(%q) = %+v, want type %q, message %q", tt.stack, f, tt.typ, tt.message)
+		}
+	}
+}
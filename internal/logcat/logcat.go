@@ -0,0 +1,330 @@
+package logcat
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Priority is the level of a log entry, from Verbose to Fatal.
+type Priority int
+
+const (
+	Verbose Priority = iota + 2
+	Debug
+	Info
+	Warn
+	Error
+	Fatal
+)
+
+var priorityLetters = "??VDIWEF"
+
+func (p Priority) String() string {
+	if p < Verbose || p > Fatal {
+		return "?"
+	}
+	return priorityLetters[p : p+1]
+}
+
+func (p Priority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// ParsePriority accepts a priority letter or name such as "W" or "warn".
+func ParsePriority(s string) (Priority, error) {
+	switch strings.ToUpper(s) {
+	case "V", "VERBOSE":
+		return Verbose, nil
+	case "D", "DEBUG":
+		return Debug, nil
+	case "I", "INFO":
+		return Info, nil
+	case "W", "WARN", "WARNING":
+		return Warn, nil
+	case "E", "ERROR":
+		return Error, nil
+	case "F", "FATAL", "A", "ASSERT":
+		return Fatal, nil
+	}
+	return 0, fmt.Errorf("unknown priority %q", s)
+}
+
+// Entry is a line of logcat.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	PID      int       `json:"pid"`
+	TID      int       `json:"tid"`
+	Priority Priority  `json:"priority"`
+	Tag      string    `json:"tag"`
+	Message  string    `json:"message"`
+}
+
+var ErrInvalidLine = errors.New("logcat: invalid line")
+
+// Command returns the logcat command line printing entries in the format
+// ParseLine reads, starting at since when it is set and otherwise with the
+// last tail entries of the buffer.
+func Command(minPriority Priority, since time.Time, tail int) string {
+	command := "logcat -v epoch -v threadtime"
+	switch {
+	case !since.IsZero():
+		command += " -T " + FormatTime(since)
+	case tail > 0:
+		command += " -T " + strconv.Itoa(tail)
+	}
+	if minPriority > Verbose {
+		command += " '*:" + minPriority.String() + "'"
+	}
+	return command
+}
+
+// FormatTime formats t as the seconds.milliseconds accepted by logcat -T.
+func FormatTime(t time.Time) string {
+	return fmt.Sprintf("%d.%03d", t.Unix(), t.Nanosecond()/int(time.Millisecond))
+}
+
+// ParseTime parses a time as seconds.milliseconds or RFC 3339.
+func ParseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+
+	sec, frac, _ := strings.Cut(s, ".")
+	secs, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+
+	var nanos int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		n, err := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", s)
+		}
+		nanos = n
+	}
+
+	return time.Unix(secs, nanos).UTC(), nil
+}
+
+// ParseLine parses a line printed with "-v epoch -v threadtime":
+//
+//	1697712345.123  1234  1256 I ActivityManager: Start proc
+func ParseLine(line string) (Entry, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return Entry{}, ErrInvalidLine
+	}
+
+	t, err := ParseTime(fields[0])
+	if err != nil {
+		return Entry{}, ErrInvalidLine
+	}
+	pid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Entry{}, ErrInvalidLine
+	}
+	tid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return Entry{}, ErrInvalidLine
+	}
+	priority, err := ParsePriority(fields[3])
+	if err != nil || len(fields[3]) != 1 {
+		return Entry{}, ErrInvalidLine
+	}
+
+	// The tag runs up to the first ": " after the priority, and may be
+	// padded with spaces or contain some itself.
+	i := strings.Index(line, " "+fields[3]+" ")
+	if i < 0 {
+		return Entry{}, ErrInvalidLine
+	}
+	rest := line[i+3:]
+	tag, message, ok := strings.Cut(rest, ": ")
+	if !ok {
+		tag, ok = strings.CutSuffix(rest, ":")
+		if !ok {
+			return Entry{}, ErrInvalidLine
+		}
+	}
+
+	return Entry{
+		Time:     t,
+		PID:      pid,
+		TID:      tid,
+		Priority: priority,
+		Tag:      strings.TrimSpace(tag),
+		Message:  message,
+	}, nil
+}
+
+// Filter selects the entries streamed to a client.
+type Filter struct {
+	Tags        []string
+	MinPriority Priority
+	PIDs        []int
+	Package     string
+	Regexp      *regexp.Regexp
+}
+
+// ParseFilter decodes a filter from the tag, priority, pid, package and
+// regex query parameters. Tags and PIDs may be repeated or comma separated.
+func ParseFilter(query url.Values) (Filter, error) {
+	filter := Filter{
+		MinPriority: Verbose,
+		Package:     query.Get("package"),
+	}
+
+	filter.Tags = splitValues(query["tag"])
+
+	for _, v := range splitValues(query["pid"]) {
+		pid, err := strconv.Atoi(v)
+		if err != nil || pid <= 0 {
+			return filter, fmt.Errorf("invalid pid %q", v)
+		}
+		filter.PIDs = append(filter.PIDs, pid)
+	}
+
+	if v := query.Get("priority"); v != "" {
+		priority, err := ParsePriority(v)
+		if err != nil {
+			return filter, err
+		}
+		filter.MinPriority = priority
+	}
+
+	if v := query.Get("regex"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid regex: %v", err)
+		}
+		filter.Regexp = re
+	}
+
+	return filter, nil
+}
+
+func splitValues(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// Match reports whether the entry passes the filter. The PIDs of Package
+// are resolved by the caller and given in pids.
+func (f Filter) Match(e Entry, pids map[int]bool) bool {
+	if e.Priority < f.MinPriority {
+		return false
+	}
+
+	if len(f.Tags) > 0 {
+		found := false
+		for _, tag := range f.Tags {
+			if tag == e.Tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.PIDs) > 0 || f.Package != "" {
+		found := pids[e.PID]
+		for _, pid := range f.PIDs {
+			if pid == e.PID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.Regexp != nil && !f.Regexp.MatchString(e.Message) {
+		return false
+	}
+
+	return true
+}
+
+// Cursor identifies an entry in the stream so a client can resume after
+// it. Entries logged in the same millisecond are told apart by Seq.
+type Cursor struct {
+	Time time.Time
+	Seq  int
+}
+
+func (c Cursor) String() string {
+	return FormatTime(c.Time) + "-" + strconv.Itoa(c.Seq)
+}
+
+// ParseCursor parses a cursor, or a bare time which resumes at that time.
+func ParseCursor(s string) (Cursor, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return Cursor{Time: t, Seq: -1}, nil
+	}
+
+	ts, seq, hasSeq := strings.Cut(s, "-")
+	t, err := ParseTime(ts)
+	if err != nil {
+		return Cursor{}, err
+	}
+	if !hasSeq {
+		return Cursor{Time: t, Seq: -1}, nil
+	}
+
+	n, err := strconv.Atoi(seq)
+	if err != nil || n < 0 {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	return Cursor{Time: t, Seq: n}, nil
+}
+
+// Tracker assigns cursors to the entries of a stream and drops the ones a
+// resumed client has already received.
+type Tracker struct {
+	last  Cursor
+	after Cursor
+}
+
+// NewTracker returns a tracker resuming after the given cursor, which may
+// be the zero Cursor.
+func NewTracker(after Cursor) *Tracker {
+	return &Tracker{after: after}
+}
+
+// Next returns the cursor of the entry and whether it is new to the client.
+func (t *Tracker) Next(e Entry) (Cursor, bool) {
+	ms := e.Time.Truncate(time.Millisecond)
+	if ms.Equal(t.last.Time) {
+		t.last.Seq++
+	} else {
+		t.last = Cursor{Time: ms}
+	}
+
+	if !t.after.Time.IsZero() {
+		after := t.after.Time.Truncate(time.Millisecond)
+		if ms.Before(after) || (ms.Equal(after) && t.last.Seq <= t.after.Seq) {
+			return t.last, false
+		}
+	}
+
+	return t.last, true
+}
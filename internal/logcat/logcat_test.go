@@ -0,0 +1,234 @@
+package logcat
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	at := time.Unix(1697712345, 123000000).UTC()
+
+	tests := []struct {
+		line string
+		want Entry
+		err  error
+	}{
+		{
+			line: "1697712345.123  1234  1256 I ActivityManager: Start proc 4242:com.example.app/u0a123",
+			want: Entry{Time: at, PID: 1234, TID: 1256, Priority: Info, Tag: "ActivityManager", Message: "Start proc 4242:com.example.app/u0a123"},
+		},
+		{
+			// Short tags are padded before the colon.
+			line: "1697712345.123   612   640 W adbd    : timeout: 5s",
+			want: Entry{Time: at, PID: 612, TID: 640, Priority: Warn, Tag: "adbd", Message: "timeout: 5s"},
+		},
+		{
+			line: "1697712345.123  4242  4242 D My Tag With Spaces: hello",
+			want: Entry{Time: at, PID: 4242, TID: 4242, Priority: Debug, Tag: "My Tag With Spaces", Message: "hello"},
+		},
+		{
+			line: "1697712345.123  4242  4242 V Choreographer:",
+			want: Entry{Time: at, PID: 4242, TID: 4242, Priority: Verbose, Tag: "Choreographer"},
+		},
+		{
+			// A multi-line message is printed with a header on every line,
+			// the indentation of a stack frame is kept.
+			line: "1697712345.123  4242  4242 E AndroidRuntime: \tat com.example.app.MainActivity.onClick(MainActivity.java:42)",
+			want: Entry{Time: at, PID: 4242, TID: 4242, Priority: Error, Tag: "AndroidRuntime", Message: "\tat com.example.app.MainActivity.onClick(MainActivity.java:42)"},
+		},
+		{
+			line: "1697712345.1  4242  4242 F libc: Fatal signal 11 (SIGSEGV)",
+			want: Entry{Time: time.Unix(1697712345, 100000000).UTC(), PID: 4242, TID: 4242, Priority: Fatal, Tag: "libc", Message: "Fatal signal 11 (SIGSEGV)"},
+		},
+		{line: "--------- beginning of main", err: ErrInvalidLine},
+		{line: "--------- switch to crash", err: ErrInvalidLine},
+		{line: "10-19 12:05:45.123  1234  1256 I ActivityManager: threadtime without epoch", err: ErrInvalidLine},
+		{line: "1697712345.123  1234  1256 Info ActivityManager: long priority", err: ErrInvalidLine},
+		{line: "1697712345.123  1234  1256 X ActivityManager: unknown priority", err: ErrInvalidLine},
+		{line: "1697712345.123  pid  1256 I ActivityManager: bad pid", err: ErrInvalidLine},
+		{line: "1697712345.123  1234  1256 I no colon at all", err: ErrInvalidLine},
+		{line: "", err: ErrInvalidLine},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLine(tt.line)
+		if err != tt.err || got != tt.want {
+			t.Errorf("ParseLine(%q) = %+v, %v, want %+v, %v", tt.line, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	entries := []Entry{
+		{PID: 100, Priority: Debug, Tag: "OkHttp", Message: "--> GET https://example.com/api"},
+		{PID: 100, Priority: Error, Tag: "AndroidRuntime", Message: "FATAL EXCEPTION: main"},
+		{PID: 200, Priority: Warn, Tag: "OkHttp", Message: "<-- HTTP FAILED: timeout"},
+		{PID: 300, Priority: Info, Tag: "ActivityManager", Message: "Start proc 100:com.example.app"},
+	}
+
+	tests := []struct {
+		query string
+		pids  map[int]bool
+		want  []int
+	}{
+		{query: "", want: []int{0, 1, 2, 3}},
+		{query: "priority=warn", want: []int{1, 2}},
+		{query: "priority=E", want: []int{1}},
+		{query: "tag=OkHttp", want: []int{0, 2}},
+		{query: "tag=OkHttp,AndroidRuntime&priority=W", want: []int{1, 2}},
+		{query: "tag=OkHttp&tag=ActivityManager", want: []int{0, 2, 3}},
+		{query: "pid=200,300", want: []int{2, 3}},
+		{query: "regex=%5E%3C--+HTTP", want: []int{2}},
+		{query: "regex=(?i)fatal", want: []int{1}},
+		{query: "package=com.example.app", pids: map[int]bool{100: true}, want: []int{0, 1}},
+		// The pids of the package and the given ones add up.
+		{query: "package=com.example.app&pid=300", pids: map[int]bool{100: true}, want: []int{0, 1, 3}},
+		// A package without a running process matches nothing.
+		{query: "package=com.example.app", want: nil},
+	}
+
+	for _, tt := range tests {
+		query, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := ParseFilter(query)
+		if err != nil {
+			t.Errorf("ParseFilter(%q) = %v", tt.query, err)
+			continue
+		}
+
+		var got []int
+		for i, e := range entries {
+			if filter.Match(e, tt.pids) {
+				got = append(got, i)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("entries matching %q = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, query := range []string{"pid=0", "pid=abc", "pid=12,-3", "priority=loud", "regex=(unclosed"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := ParseFilter(values); err == nil {
+			t.Errorf("ParseFilter(%q) succeeded", query)
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	since := time.Unix(1697712345, 123456789)
+	tests := []struct {
+		priority Priority
+		since    time.Time
+		tail     int
+		want     string
+	}{
+		{Verbose, time.Time{}, 0, "logcat -v epoch -v threadtime"},
+		{Verbose, time.Time{}, 100, "logcat -v epoch -v threadtime -T 100"},
+		{Warn, since, 100, "logcat -v epoch -v threadtime -T 1697712345.123 '*:W'"},
+	}
+	for _, tt := range tests {
+		if got := Command(tt.priority, tt.since, tt.tail); got != tt.want {
+			t.Errorf("Command(%v, %v, %d) = %q, want %q", tt.priority, tt.since, tt.tail, got, tt.want)
+		}
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	at := time.Unix(1697712345, 123000000).UTC()
+	tests := []struct {
+		s    string
+		want Cursor
+		ok   bool
+	}{
+		{s: "1697712345.123-2", want: Cursor{Time: at, Seq: 2}, ok: true},
+		{s: "1697712345.123", want: Cursor{Time: at, Seq: -1}, ok: true},
+		{s: "2023-10-19T10:45:45.123Z", want: Cursor{Time: at, Seq: -1}, ok: true},
+		{s: "1697712345.123-x"},
+		{s: "1697712345.123--1"},
+		{s: "yesterday"},
+	}
+	for _, tt := range tests {
+		got, err := ParseCursor(tt.s)
+		if (err == nil) != tt.ok || (tt.ok && (!got.Time.Equal(tt.want.Time) || got.Seq != tt.want.Seq)) {
+			t.Errorf("ParseCursor(%q) = %+v, %v, want %+v", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+// logcat -T replays the entries from the millisecond of the cursor on, so
+// a client resuming with the cursor of its last entry receives each entry
+// once, even when it reconnected in the middle of a millisecond.
+func TestTrackerResume(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(`
+1697712345.120  100  100 I A: one
+1697712345.123  100  100 I A: two
+1697712345.123  100  101 I A: three
+1697712345.123  100  102 I A: four
+1697712345.124  100  100 I A: five
+1697712345.124  100  100 I A: six
+1697712346.000  100  100 I A: seven`), "\n")
+
+	var entries []Entry
+	for _, line := range lines {
+		e, err := ParseLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+
+	for disconnect := 1; disconnect < len(entries); disconnect++ {
+		var received []string
+
+		first := NewTracker(Cursor{})
+		var last Cursor
+		for _, e := range entries[:disconnect] {
+			if cursor, fresh := first.Next(e); fresh {
+				received = append(received, e.Message)
+				last = cursor
+			}
+		}
+
+		after, err := ParseCursor(last.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		second := NewTracker(after)
+		for _, e := range entries {
+			if e.Time.Before(after.Time) {
+				continue
+			}
+			if _, fresh := second.Next(e); fresh {
+				received = append(received, e.Message)
+			}
+		}
+
+		if got := strings.Join(received, " "); got != "one two three four five six seven" {
+			t.Errorf("disconnected after %d entries, received %q", disconnect, got)
+		}
+	}
+}
+
+// A bare time resumes with every entry from that time on.
+func TestTrackerResumeAtTime(t *testing.T) {
+	after, err := ParseCursor("1697712345.123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(after)
+
+	for i, want := range []bool{false, true, true} {
+		e := Entry{Time: time.Unix(1697712345, int64(122+i)*int64(time.Millisecond))}
+		if _, fresh := tracker.Next(e); fresh != want {
+			t.Errorf("entry %d fresh = %v, want %v", i, fresh, want)
+		}
+	}
+}
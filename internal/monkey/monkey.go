@@ -0,0 +1,292 @@
+package monkey
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MaxEvents   = 1000000
+	MaxThrottle = 10 * time.Second
+)
+
+// Types of the issues reported by the monkey.
+const (
+	IssueCrash = "crash"
+	IssueANR   = "anr"
+)
+
+// Events are the event types of the monkey mix, each maps to its --pct-
+// option.
+var Events = []string{
+	"touch", "motion", "pinchzoom", "trackball", "rotation", "nav",
+	"majornav", "syskeys", "appswitch", "flip", "anyevent",
+}
+
+var (
+	ErrInvalidPackage  = errors.New("invalid package name")
+	ErrInvalidEvents   = fmt.Errorf("events must be between 1 and %d", MaxEvents)
+	ErrInvalidThrottle = fmt.Errorf("throttle must be between 0 and %d ms", MaxThrottle.Milliseconds())
+	ErrInvalidMix      = errors.New("event mix percentages must be between 0 and 100 and add up to at most 100")
+)
+
+var (
+	packageRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)+$`)
+	// // CRASH: com.example (pid 4242)
+	crashRe = regexp.MustCompile(`^// CRASH: (\S+) \(pid (\d+)\)`)
+	// // NOT RESPONDING: com.example (pid 4242)
+	anrRe = regexp.MustCompile(`^// NOT RESPONDING: (\S+) \(pid (\d+)\)`)
+	// :Monkey: seed=42 count=500
+	seedRe = regexp.MustCompile(`^:Monkey: seed=(-?\d+) count=(\d+)`)
+)
+
+// Options describe a monkey run, the same options and seed replay the same
+// events.
+type Options struct {
+	Package string `json:"package"`
+	// Seed seeds the event generator, 0 picks a random seed which is
+	// recorded with the run.
+	Seed       int64 `json:"seed"`
+	Events     int   `json:"events"`
+	ThrottleMs int64 `json:"throttle_ms"`
+	// Mix is the percentage of each event type, the monkey spreads the
+	// rest over the types without one.
+	Mix map[string]int `json:"mix,omitempty"`
+	// IgnoreErrors keeps the monkey running after a crash or an ANR.
+	IgnoreErrors bool `json:"ignore_errors,omitempty"`
+	// ClearData clears the data of the package before the run.
+	ClearData bool `json:"clear_data,omitempty"`
+}
+
+// Validate checks the options.
+func (o Options) Validate() error {
+	if !packageRe.MatchString(o.Package) {
+		return ErrInvalidPackage
+	}
+	if o.Events < 1 || o.Events > MaxEvents {
+		return ErrInvalidEvents
+	}
+	if o.ThrottleMs < 0 || o.ThrottleMs > MaxThrottle.Milliseconds() {
+		return ErrInvalidThrottle
+	}
+
+	total := 0
+	for event, pct := range o.Mix {
+		if !isEvent(event) {
+			return fmt.Errorf("unknown event type %q, expected one of %v", event, Events)
+		}
+		if pct < 0 || pct > 100 {
+			return ErrInvalidMix
+		}
+		total += pct
+	}
+	if total > 100 {
+		return ErrInvalidMix
+	}
+	return nil
+}
+
+func isEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Command returns the monkey command of the options, with a seed set.
+func Command(o Options) string {
+	args := []string{"monkey", "-p", o.Package, "-s", strconv.FormatInt(o.Seed, 10), "--throttle", strconv.FormatInt(o.ThrottleMs, 10)}
+
+	events := make([]string, 0, len(o.Mix))
+	for event := range o.Mix {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	for _, event := range events {
+		args = append(args, "--pct-"+event, strconv.Itoa(o.Mix[event]))
+	}
+
+	if o.IgnoreErrors {
+		args = append(args, "--ignore-crashes", "--ignore-timeouts")
+	}
+	args = append(args, "-v", strconv.Itoa(o.Events))
+	return strings.Join(args, " ")
+}
+
+// Issue is a crash or an ANR reported by the monkey.
+type Issue struct {
+	Type    string `json:"type"`
+	Package string `json:"package"`
+	PID     int    `json:"pid"`
+	// Event is the number of events sent before the issue.
+	Event    int    `json:"event"`
+	ShortMsg string `json:"short_msg,omitempty"`
+	LongMsg  string `json:"long_msg,omitempty"`
+	// Reason is why the system reported an ANR.
+	Reason string `json:"reason,omitempty"`
+	// Stack is the stack trace of a crash, or the ANR report of the system.
+	Stack string `json:"stack,omitempty"`
+	// Traces is the id of the artifact of the thread dumps of an ANR.
+	Traces string `json:"traces,omitempty"`
+}
+
+// Result is the outcome of a monkey run.
+type Result struct {
+	Seed int64 `json:"seed"`
+	// Events is the number of events requested and Injected the number
+	// sent.
+	Events   int     `json:"events"`
+	Injected int     `json:"injected"`
+	Crashes  int     `json:"crashes"`
+	ANRs     int     `json:"anrs"`
+	Issues   []Issue `json:"issues"`
+	// Completed tells whether the monkey sent all its events, it stops at
+	// the first issue unless errors are ignored.
+	Completed bool `json:"completed"`
+	// Message is why the monkey aborted, when it did.
+	Message    string `json:"message,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Parser reads the verbose output of the monkey line by line.
+type Parser struct {
+	startedAt time.Time
+	result    Result
+	sent      int
+	// issue is the issue whose report is being read, if any.
+	issue *Issue
+	stack []string
+}
+
+func NewParser(startedAt time.Time) *Parser {
+	return &Parser{startedAt: startedAt, result: Result{Issues: []Issue{}}}
+}
+
+// Line parses a line of the output, it returns an issue once its report
+// ended.
+func (p *Parser) Line(line string) (Issue, bool) {
+	line = strings.TrimRight(line, "\r")
+
+	if p.issue != nil {
+		if p.reportLine(line) {
+			return Issue{}, false
+		}
+		// The line ending a report is a line of its own.
+		issue := p.endIssue()
+		p.Line(line)
+		return issue, true
+	}
+
+	switch {
+	case strings.HasPrefix(line, ":Sending "):
+		p.sent++
+	case strings.HasPrefix(line, "Events injected: "):
+		p.result.Injected, _ = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Events injected: ")))
+	case strings.HasPrefix(line, "// Monkey finished"):
+		p.result.Completed = true
+	case strings.HasPrefix(line, "** "):
+		p.result.Message = strings.TrimPrefix(line, "** ")
+	default:
+		if m := seedRe.FindStringSubmatch(line); m != nil {
+			p.result.Seed, _ = strconv.ParseInt(m[1], 10, 64)
+			p.result.Events, _ = strconv.Atoi(m[2])
+		} else if m := crashRe.FindStringSubmatch(line); m != nil {
+			p.startIssue(IssueCrash, m[1], m[2])
+		} else if m := anrRe.FindStringSubmatch(line); m != nil {
+			p.startIssue(IssueANR, m[1], m[2])
+		}
+	}
+	return Issue{}, false
+}
+
+func (p *Parser) startIssue(kind string, pkg string, pid string) {
+	p.issue = &Issue{Type: kind, Package: pkg, Event: p.sent}
+	p.issue.PID, _ = strconv.Atoi(pid)
+	p.stack = nil
+}
+
+// reportLine reads a line of the report of the current issue, it returns
+// false once the report ended.
+func (p *Parser) reportLine(line string) bool {
+	// The report of an issue the monkey cut short ends with the next one.
+	if crashRe.MatchString(line) || anrRe.MatchString(line) {
+		return false
+	}
+
+	if p.issue.Type == IssueCrash {
+		// The report of a crash is commented out and ends with an empty
+		// comment.
+		text, ok := strings.CutPrefix(line, "// ")
+		if !ok || strings.TrimSpace(text) == "" {
+			return false
+		}
+		switch {
+		case strings.HasPrefix(text, "Short Msg: "):
+			p.issue.ShortMsg = strings.TrimPrefix(text, "Short Msg: ")
+		case strings.HasPrefix(text, "Long Msg: "):
+			p.issue.LongMsg = strings.TrimPrefix(text, "Long Msg: ")
+		case strings.HasPrefix(text, "Build "):
+		default:
+			p.stack = append(p.stack, text)
+		}
+		return true
+	}
+
+	// The report of an ANR is the one of the system, it ends with the
+	// next monkey line.
+	if strings.HasPrefix(line, "//") || strings.HasPrefix(line, ":") || strings.HasPrefix(line, "**") {
+		return false
+	}
+	if reason, ok := strings.CutPrefix(line, "Reason: "); ok {
+		p.issue.Reason = reason
+	}
+	p.stack = append(p.stack, line)
+	return true
+}
+
+func (p *Parser) endIssue() Issue {
+	issue := *p.issue
+	issue.Stack = strings.TrimSpace(strings.Join(p.stack, "\n"))
+	p.issue, p.stack = nil, nil
+
+	p.result.Issues = append(p.result.Issues, issue)
+	if issue.Type == IssueCrash {
+		p.result.Crashes++
+	} else {
+		p.result.ANRs++
+	}
+	return issue
+}
+
+// SetTraces links the thread dumps of the ANR at index to an artifact.
+func (p *Parser) SetTraces(index int, id string) {
+	if index >= 0 && index < len(p.result.Issues) {
+		p.result.Issues[index].Traces = id
+	}
+}
+
+// Issues returns the number of issues parsed so far.
+func (p *Parser) Issues() int {
+	return len(p.result.Issues)
+}
+
+// Result returns the outcome of the run, ending the report of an issue
+// the output ended in.
+func (p *Parser) Result() Result {
+	if p.issue != nil {
+		p.endIssue()
+	}
+	result := p.result
+	if result.Injected == 0 {
+		result.Injected = p.sent
+	}
+	result.DurationMs = time.Since(p.startedAt).Milliseconds()
+	return result
+}
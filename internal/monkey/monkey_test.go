@@ -0,0 +1,295 @@
+package monkey
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func output(t *testing.T, name string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		// The fields of the result besides the issues and the duration.
+		want Result
+	}{
+		{
+			name:  "crash aborts",
+			lines: output(t, "crash.txt"),
+			want: Result{Seed: 1234, Events: 500, Injected: 3, Crashes: 1,
+				Message: "System appears to have crashed at event 3 of 500 using seed 1234"},
+		},
+		{
+			name:  "anr aborts",
+			lines: output(t, "anr.txt"),
+			want: Result{Seed: 99, Events: 200, Injected: 2, ANRs: 1,
+				Message: "System appears to have crashed at event 2 of 200 using seed 99"},
+		},
+		{
+			name:  "errors ignored",
+			lines: output(t, "ignore_crashes.txt"),
+			want:  Result{Seed: 7, Events: 6, Injected: 6, Crashes: 2, Completed: true},
+		},
+		{
+			name:  "interleaved",
+			lines: output(t, "interleaved.txt"),
+			want:  Result{Seed: -8071, Events: 5, Injected: 5, Crashes: 2, ANRs: 2, Completed: true},
+		},
+		{
+			name:  "nothing to run",
+			lines: output(t, "aborted.txt"),
+			want:  Result{Seed: 42, Events: 100, Message: "No activities found to run, monkey aborted."},
+		},
+		{
+			// The device went away before the monkey summed up, the events
+			// sent are counted from the output.
+			name: "output cut",
+			lines: []string{
+				":Monkey: seed=3 count=1000\r",
+				":Sending Touch (ACTION_DOWN): 0:(1.0,2.0)\r",
+				":Sending Touch (ACTION_UP): 0:(1.0,2.0)\r",
+				"    // Allowing start of Intent { cmp=com.example.app/.MainActivity } in package com.example.app\r",
+				":Sending Key (ACTION_DOWN): 4    // KEYCODE_BACK\r",
+			},
+			want: Result{Seed: 3, Events: 1000, Injected: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser(time.Now())
+			for _, line := range tt.lines {
+				p.Line(line)
+			}
+			got := p.Result()
+			if len(got.Issues) != got.Crashes+got.ANRs {
+				t.Errorf("%d issues for %d crashes and %d ANRs", len(got.Issues), got.Crashes, got.ANRs)
+			}
+			got.Issues, got.DurationMs = nil, 0
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Result = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestInterleavedIssues checks each report ends on the line after it, so
+// that the thread dumps of an ANR are pulled while the monkey goes on, and
+// that a report cut short by the next one keeps none of it.
+func TestInterleavedIssues(t *testing.T) {
+	lines := output(t, "interleaved.txt")
+
+	type ending struct {
+		line    int
+		kind    string
+		pkg     string
+		pid     int
+		event   int
+		summary string
+	}
+	want := []ending{
+		// The crash report ends with an empty comment.
+		{17, IssueCrash, "com.example.sync", 3120, 1, "database is locked"},
+		// The ANR report of the system ends with the crash it led to.
+		{24, IssueANR, "com.example.app", 3001, 2, "Input dispatching timed out"},
+		// The crash report lacks its empty comment.
+		{29, IssueCrash, "com.example.app", 3001, 2, "Fragment not attached"},
+		{33, IssueANR, "com.example.sync", 3188, 2, "executing service"},
+	}
+
+	p := NewParser(time.Now())
+	var got []ending
+	for i, line := range lines {
+		issue, ok := p.Line(line)
+		if !ok {
+			continue
+		}
+		summary := issue.Reason
+		if issue.Type == IssueCrash {
+			summary = issue.LongMsg
+		}
+		got = append(got, ending{i + 1, issue.Type, issue.Package, issue.PID, issue.Event, summary})
+		if p.Issues() != len(got) {
+			t.Errorf("line %d: Issues = %d, want %d", i+1, p.Issues(), len(got))
+		}
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("issues ended = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.line != w.line || g.kind != w.kind || g.pkg != w.pkg || g.pid != w.pid || g.event != w.event || !strings.Contains(g.summary, w.summary) {
+			t.Errorf("issue %d = %+v, want %+v", i, g, w)
+		}
+	}
+
+	issues := p.Result().Issues
+	for i, issue := range issues {
+		if strings.Contains(issue.Stack, "CRASH:") || strings.Contains(issue.Stack, "NOT RESPONDING:") || strings.Contains(issue.Stack, ":Sending") {
+			t.Errorf("issue %d has lines of another in its stack:\n%s", i, issue.Stack)
+		}
+	}
+	if want := "java.lang.IllegalStateException: Fragment not attached\n\tat com.example.app.DetailFragment.onResume(DetailFragment.java:88)"; issues[2].Stack != want {
+		t.Errorf("stack of the cut crash = %q, want %q", issues[2].Stack, want)
+	}
+	if want := "ANR in com.example.sync\nPID: 3188\nReason: executing service com.example.sync/.SyncService"; issues[3].Stack != want {
+		t.Errorf("report of the last ANR = %q, want %q", issues[3].Stack, want)
+	}
+}
+
+func TestCrashReport(t *testing.T) {
+	var issues []Issue
+	p := NewParser(time.Now())
+	for _, line := range output(t, "crash.txt") {
+		if issue, ok := p.Line(line); ok {
+			issues = append(issues, issue)
+		}
+	}
+
+	if len(issues) != 1 {
+		t.Fatalf("%d issues ended, want 1", len(issues))
+	}
+	want := Issue{
+		Type:     IssueCrash,
+		Package:  "com.example.app",
+		PID:      4242,
+		Event:    3,
+		ShortMsg: "java.lang.NullPointerException",
+		LongMsg:  "java.lang.NullPointerException: Attempt to invoke virtual method 'int java.lang.String.length()' on a null object reference",
+		// The build lines are left out, the trace keeps its tabs.
+		Stack: "java.lang.NullPointerException: Attempt to invoke virtual method 'int java.lang.String.length()' on a null object reference\n" +
+			"\tat com.example.app.MainActivity.onClick(MainActivity.java:42)\n" +
+			"\tat android.view.View.performClick(View.java:7506)\n" +
+			"\tat android.os.Handler.handleCallback(Handler.java:942)",
+	}
+	if issues[0] != want {
+		t.Errorf("issue = %+v, want %+v", issues[0], want)
+	}
+}
+
+func TestANRReport(t *testing.T) {
+	p := NewParser(time.Now())
+	for _, line := range output(t, "anr.txt") {
+		if issue, ok := p.Line(line); ok {
+			// As the agent does once the ANR ended.
+			p.SetTraces(p.Issues()-1, "traces-"+issue.Package)
+		}
+	}
+	// An index past the issues is ignored.
+	p.SetTraces(1, "traces-other")
+
+	issues := p.Result().Issues
+	if len(issues) != 1 {
+		t.Fatalf("%d issues, want 1", len(issues))
+	}
+	anr := issues[0]
+	if anr.Type != IssueANR || anr.PID != 5150 || anr.Event != 2 || anr.Traces != "traces-com.example.app" {
+		t.Errorf("issue = %+v", anr)
+	}
+	if want := "Input dispatching timed out (5e2a1b0 com.example.app/com.example.app.MainActivity (server) is not responding. Waited 5000ms for MotionEvent)"; anr.Reason != want {
+		t.Errorf("Reason = %q, want %q", anr.Reason, want)
+	}
+	// The report of the system is kept whole, blank lines included, up to
+	// the meminfo line of the monkey.
+	if !strings.HasPrefix(anr.Stack, "ANR in com.example.app (com.example.app/.MainActivity)\nPID: 5150\n") ||
+		!strings.Contains(anr.Stack, "\n\n----- Output from /proc/pressure/memory -----\n") ||
+		!strings.HasSuffix(anr.Stack, "57% TOTAL: 48% user + 9% kernel") {
+		t.Errorf("Stack = %q", anr.Stack)
+	}
+}
+
+func TestReportCutByEndOfOutput(t *testing.T) {
+	p := NewParser(time.Now())
+	for _, line := range []string{
+		":Monkey: seed=5 count=10",
+		":Sending Touch (ACTION_DOWN): 0:(1.0,2.0)",
+		"// CRASH: com.example.app (pid 12)",
+		"// Short Msg: java.lang.RuntimeException",
+		"// java.lang.RuntimeException: boom",
+	} {
+		if _, ok := p.Line(line); ok {
+			t.Fatalf("Line(%q) ended an issue", line)
+		}
+	}
+
+	result := p.Result()
+	if len(result.Issues) != 1 || result.Crashes != 1 {
+		t.Fatalf("Issues = %+v, want the crash being reported", result.Issues)
+	}
+	if issue := result.Issues[0]; issue.Event != 1 || issue.Stack != "java.lang.RuntimeException: boom" {
+		t.Errorf("issue = %+v", issue)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	valid := Options{Package: "com.example.app", Seed: 42, Events: 500, ThrottleMs: 100}
+
+	tests := []struct {
+		name    string
+		modify  func(o *Options)
+		err     error
+		command string
+	}{
+		{
+			name:    "defaults",
+			modify:  func(o *Options) {},
+			command: "monkey -p com.example.app -s 42 --throttle 100 -v 500",
+		},
+		{
+			// The mix is sorted so the same options replay the same run.
+			name: "mix",
+			modify: func(o *Options) {
+				o.Mix = map[string]int{"touch": 50, "nav": 20, "appswitch": 0}
+				o.IgnoreErrors = true
+			},
+			command: "monkey -p com.example.app -s 42 --throttle 100 --pct-appswitch 0 --pct-nav 20 --pct-touch 50 --ignore-crashes --ignore-timeouts -v 500",
+		},
+		{
+			name:    "negative seed",
+			modify:  func(o *Options) { o.Seed = -8071; o.ThrottleMs = 0; o.Events = MaxEvents },
+			command: "monkey -p com.example.app -s -8071 --throttle 0 -v 1000000",
+		},
+		{name: "package", modify: func(o *Options) { o.Package = "com.example;reboot" }, err: ErrInvalidPackage},
+		{name: "no events", modify: func(o *Options) { o.Events = 0 }, err: ErrInvalidEvents},
+		{name: "too many events", modify: func(o *Options) { o.Events = MaxEvents + 1 }, err: ErrInvalidEvents},
+		{name: "throttle", modify: func(o *Options) { o.ThrottleMs = MaxThrottle.Milliseconds() + 1 }, err: ErrInvalidThrottle},
+		{name: "mix over 100", modify: func(o *Options) { o.Mix = map[string]int{"touch": 60, "nav": 50} }, err: ErrInvalidMix},
+		{name: "negative mix", modify: func(o *Options) { o.Mix = map[string]int{"touch": -1} }, err: ErrInvalidMix},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := valid
+			tt.modify(&o)
+			if err := o.Validate(); !errors.Is(err, tt.err) {
+				t.Fatalf("Validate = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil {
+				if got := Command(o); got != tt.command {
+					t.Errorf("Command = %q, want %q", got, tt.command)
+				}
+			}
+		})
+	}
+
+	o := valid
+	o.Mix = map[string]int{"keys": 10}
+	if err := o.Validate(); err == nil {
+		t.Errorf("Validate of an unknown event type = nil, want an error")
+	}
+}
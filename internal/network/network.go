@@ -0,0 +1,213 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// Profile is a network condition applied to a device. Speed and Delay are
+// emulator console presets or custom values, in kbit/s as up:down and in
+// milliseconds as min:max. Loss is the percentage of packets dropped.
+type Profile struct {
+	Name         string  `json:"name" mapstructure:"-"`
+	Speed        string  `json:"speed" mapstructure:"speed"`
+	Delay        string  `json:"delay" mapstructure:"delay"`
+	Loss         float64 `json:"loss" mapstructure:"loss"`
+	AirplaneMode bool    `json:"airplane_mode" mapstructure:"airplaneMode"`
+}
+
+type Config struct {
+	// Interface of the emulator containers packet loss is applied to.
+	Interface string             `mapstructure:"interface"`
+	Presets   map[string]Profile `mapstructure:"presets"`
+}
+
+// Request selects a preset, custom values or a preset with some of its
+// values replaced.
+type Request struct {
+	Preset       string   `json:"preset,omitempty"`
+	Speed        string   `json:"speed,omitempty"`
+	Delay        string   `json:"delay,omitempty"`
+	Loss         *float64 `json:"loss,omitempty"`
+	AirplaneMode *bool    `json:"airplane_mode,omitempty"`
+}
+
+const (
+	// Custom names a profile that is not a preset, or a modified one.
+	Custom = "custom"
+
+	defaultInterface = "eth0"
+
+	// MaxLoss bounds the packet loss, a device dropping every packet is
+	// cut off as in airplane mode.
+	MaxLoss = 100
+)
+
+var (
+	ErrUnknownPreset  = errors.New("unknown network preset")
+	ErrInvalidProfile = errors.New("invalid network profile")
+)
+
+var (
+	// Presets of the emulator console network commands.
+	speedPresets = []string{"gsm", "hscsd", "gprs", "edge", "umts", "hsdpa", "lte", "evdo", "full"}
+	delayPresets = []string{"gsm", "gprs", "edge", "umts", "none"}
+
+	rangeRe = regexp.MustCompile(`^\d{1,7}(:\d{1,7})?$`)
+)
+
+// Presets are the named profiles of the config.
+type Presets struct {
+	iface    string
+	profiles map[string]Profile
+}
+
+func NewPresets(cfg *Config) *Presets {
+	p := &Presets{
+		iface:    cfg.Interface,
+		profiles: map[string]Profile{},
+	}
+	if p.iface == "" {
+		p.iface = defaultInterface
+	}
+	for name, profile := range cfg.Presets {
+		profile.Name = name
+		p.profiles[name] = profile.normalize()
+	}
+	return p
+}
+
+// Interface returns the container interface packet loss is applied to.
+func (p *Presets) Interface() string {
+	return p.iface
+}
+
+// List returns the presets sorted by name.
+func (p *Presets) List() []Profile {
+	list := make([]Profile, 0, len(p.profiles))
+	for _, profile := range p.profiles {
+		list = append(list, profile)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Resolve returns the profile selected by the request and checks it.
+func (p *Presets) Resolve(req Request) (Profile, error) {
+	profile := Profile{Name: Custom}
+	if req.Preset != "" {
+		preset, ok := p.profiles[req.Preset]
+		if !ok {
+			return Profile{}, fmt.Errorf("%w %q", ErrUnknownPreset, req.Preset)
+		}
+		profile = preset
+	}
+
+	modified := false
+	if req.Speed != "" {
+		profile.Speed = req.Speed
+		modified = true
+	}
+	if req.Delay != "" {
+		profile.Delay = req.Delay
+		modified = true
+	}
+	if req.Loss != nil {
+		profile.Loss = *req.Loss
+		modified = true
+	}
+	if req.AirplaneMode != nil {
+		profile.AirplaneMode = *req.AirplaneMode
+		modified = true
+	}
+	if modified && req.Preset != "" {
+		profile.Name = Custom
+	}
+
+	profile = profile.normalize()
+	return profile, profile.Validate()
+}
+
+// normalize fills in the unrestricted speed and delay.
+func (p Profile) normalize() Profile {
+	if p.Speed == "" {
+		p.Speed = "full"
+	}
+	if p.Delay == "" {
+		p.Delay = "none"
+	}
+	return p
+}
+
+// Validate checks the values of the profile.
+func (p Profile) Validate() error {
+	if !slices.Contains(speedPresets, p.Speed) && !rangeRe.MatchString(p.Speed) {
+		return fmt.Errorf("%w: speed must be one of %v or up:down in kbit/s", ErrInvalidProfile, speedPresets)
+	}
+	if !slices.Contains(delayPresets, p.Delay) && !rangeRe.MatchString(p.Delay) {
+		return fmt.Errorf("%w: delay must be one of %v or min:max in milliseconds", ErrInvalidProfile, delayPresets)
+	}
+	if math.IsNaN(p.Loss) || p.Loss < 0 || p.Loss >= MaxLoss {
+		return fmt.Errorf("%w: loss must be a percentage from 0 to below %d, airplane mode cuts the network", ErrInvalidProfile, MaxLoss)
+	}
+	return nil
+}
+
+// SpeedCommand and DelayCommand return the console commands applying
+// the profile.
+func (p Profile) SpeedCommand() string {
+	return "network speed " + p.Speed
+}
+
+func (p Profile) DelayCommand() string {
+	return "network delay " + p.Delay
+}
+
+// LossCommands returns the tc commands dropping the packets of the
+// profile on iface, or none when there is no loss. The packets sent from
+// the exempt ports, the services of the container the agent talks to, are
+// queued in a band without loss so that the device stays reachable. The
+// commands are meant to run after ClearLossArgs.
+func (p Profile) LossCommands(iface string, exempt []int) [][]string {
+	if p.Loss == 0 {
+		return nil
+	}
+
+	// Band 1:1 holds the exempt packets, every priority maps to the lossy
+	// band 1:2.
+	prio := []string{"qdisc", "add", "dev", iface, "root", "handle", "1:", "prio", "bands", "2", "priomap"}
+	for i := 0; i < 16; i++ {
+		prio = append(prio, "1")
+	}
+	commands := [][]string{
+		prio,
+		{"qdisc", "add", "dev", iface, "parent", "1:2", "handle", "20:", "netem", "loss", fmt.Sprintf("%g%%", p.Loss)},
+	}
+	for _, port := range exempt {
+		commands = append(commands, []string{"filter", "add", "dev", iface, "parent", "1:", "protocol", "ip", "prio", "1",
+			"u32", "match", "ip", "sport", strconv.Itoa(port), "0xffff", "flowid", "1:1"})
+	}
+	return commands
+}
+
+// ClearLossArgs returns the tc arguments removing the loss from iface.
+func ClearLossArgs(iface string) []string {
+	return []string{"qdisc", "del", "dev", iface, "root"}
+}
+
+// AirplaneModeCommand returns the shell command switching airplane mode.
+// Recent releases have a command for it, older ones take the setting and
+// the broadcast announcing it.
+func (p Profile) AirplaneModeCommand() string {
+	state, setting := "disable", 0
+	if p.AirplaneMode {
+		state, setting = "enable", 1
+	}
+	return fmt.Sprintf("cmd connectivity airplane-mode %s 2>/dev/null || { settings put global airplane_mode_on %d && am broadcast -a android.intent.action.AIRPLANE_MODE --ez state %t; }",
+		state, setting, p.AirplaneMode)
+}
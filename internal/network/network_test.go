@@ -0,0 +1,150 @@
+package network
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func presets() *Presets {
+	return NewPresets(&Config{Presets: map[string]Profile{
+		"3g":      {Speed: "umts", Delay: "umts", Loss: 1},
+		"offline": {AirplaneMode: true},
+		"lossy":   {Speed: "1000:5000", Delay: "100:300", Loss: 12.5},
+	}})
+}
+
+func loss(v float64) *float64 {
+	return &v
+}
+
+func TestResolve(t *testing.T) {
+	on := true
+
+	tests := []struct {
+		name string
+		req  Request
+		want Profile
+		err  error
+	}{
+		{
+			name: "unrestricted",
+			want: Profile{Name: Custom, Speed: "full", Delay: "none"},
+		},
+		{
+			name: "preset",
+			req:  Request{Preset: "3g"},
+			want: Profile{Name: "3g", Speed: "umts", Delay: "umts", Loss: 1},
+		},
+		{
+			name: "preset filled in",
+			req:  Request{Preset: "offline"},
+			want: Profile{Name: "offline", Speed: "full", Delay: "none", AirplaneMode: true},
+		},
+		{
+			// A preset with a value replaced is no longer the preset.
+			name: "preset modified",
+			req:  Request{Preset: "3g", Loss: loss(0)},
+			want: Profile{Name: Custom, Speed: "umts", Delay: "umts"},
+		},
+		{
+			name: "custom",
+			req:  Request{Speed: "128:512", Delay: "20", Loss: loss(2.5), AirplaneMode: &on},
+			want: Profile{Name: Custom, Speed: "128:512", Delay: "20", Loss: 2.5, AirplaneMode: true},
+		},
+		{name: "unknown preset", req: Request{Preset: "5g"}, err: ErrUnknownPreset},
+		{name: "speed", req: Request{Speed: "fast"}, err: ErrInvalidProfile},
+		{name: "speed range", req: Request{Speed: "1:2:3"}, err: ErrInvalidProfile},
+		{name: "delay", req: Request{Delay: "-5"}, err: ErrInvalidProfile},
+		{name: "delay preset of speed", req: Request{Delay: "lte"}, err: ErrInvalidProfile},
+		{name: "negative loss", req: Request{Loss: loss(-1)}, err: ErrInvalidProfile},
+		{name: "no loss above", req: Request{Loss: loss(MaxLoss)}, err: ErrInvalidProfile},
+		{name: "NaN loss", req: Request{Loss: loss(math.NaN())}, err: ErrInvalidProfile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := presets().Resolve(tt.req)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && got != tt.want {
+				t.Errorf("Resolve = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresets(t *testing.T) {
+	p := presets()
+	if p.Interface() != "eth0" {
+		t.Errorf("Interface = %q, want eth0", p.Interface())
+	}
+
+	var names []string
+	for _, profile := range p.List() {
+		names = append(names, profile.Name)
+	}
+	if want := []string{"3g", "lossy", "offline"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("presets = %v, want %v", names, want)
+	}
+
+	p = NewPresets(&Config{Interface: "eth1"})
+	if p.Interface() != "eth1" || len(p.List()) != 0 {
+		t.Errorf("Interface, presets = %q, %v, want eth1 and none", p.Interface(), p.List())
+	}
+}
+
+func TestLossCommands(t *testing.T) {
+	if got := (Profile{}).LossCommands("eth0", []int{5555}); got != nil {
+		t.Errorf("LossCommands without loss = %q, want none", got)
+	}
+
+	got := Profile{Loss: 12.5}.LossCommands("eth0", []int{5555, 6080})
+	want := []string{
+		"qdisc add dev eth0 root handle 1: prio bands 2 priomap 1 1 1 1 1 1 1 1 1 1 1 1 1 1 1 1",
+		"qdisc add dev eth0 parent 1:2 handle 20: netem loss 12.5%",
+		"filter add dev eth0 parent 1: protocol ip prio 1 u32 match ip sport 5555 0xffff flowid 1:1",
+		"filter add dev eth0 parent 1: protocol ip prio 1 u32 match ip sport 6080 0xffff flowid 1:1",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("LossCommands = %q, want %q", got, want)
+	}
+	for i := range want {
+		if strings.Join(got[i], " ") != want[i] {
+			t.Errorf("command %d = %q, want %q", i, strings.Join(got[i], " "), want[i])
+		}
+	}
+
+	if got := strings.Join(ClearLossArgs("eth0"), " "); got != "qdisc del dev eth0 root" {
+		t.Errorf("ClearLossArgs = %q", got)
+	}
+}
+
+func TestConsoleCommands(t *testing.T) {
+	p := Profile{Speed: "lte", Delay: "100:300"}
+	if got := p.SpeedCommand(); got != "network speed lte" {
+		t.Errorf("SpeedCommand = %q", got)
+	}
+	if got := p.DelayCommand(); got != "network delay 100:300" {
+		t.Errorf("DelayCommand = %q", got)
+	}
+
+	tests := []struct {
+		airplane bool
+		want     []string
+	}{
+		{true, []string{"airplane-mode enable", "airplane_mode_on 1", "--ez state true"}},
+		{false, []string{"airplane-mode disable", "airplane_mode_on 0", "--ez state false"}},
+	}
+	for _, tt := range tests {
+		got := Profile{AirplaneMode: tt.airplane}.AirplaneModeCommand()
+		for _, part := range tt.want {
+			if !strings.Contains(got, part) {
+				t.Errorf("AirplaneModeCommand(%t) = %q, want it to contain %q", tt.airplane, got, part)
+			}
+		}
+	}
+}
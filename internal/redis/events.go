@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Event types and actions of the device history.
+const (
+	EventSMS  = "sms"
+	EventCall = "call"
+)
+
+// maxEvents bounds the history kept for a device.
+const maxEvents = 1000
+
+// Event is something that happened to a device, such as a received SMS or
+// a call.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Action  string    `json:"action,omitempty"`
+	Number  string    `json:"number,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+func eventsKey(deviceID string) string {
+	return deviceID + ":events"
+}
+
+// RecordEvent adds an event to the history of the device, dropping the
+// oldest events past the limit.
+func (s *AndroidService) RecordEvent(ctx context.Context, deviceID string, event Event) error {
+	eventData, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.redisClient.TxPipeline()
+	pipe.LPush(ctx, eventsKey(deviceID), eventData)
+	pipe.LTrim(ctx, eventsKey(deviceID), 0, maxEvents-1)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ListEvents returns up to limit events of the device, newest first.
+func (s *AndroidService) ListEvents(ctx context.Context, deviceID string, limit int) ([]Event, error) {
+	results, err := s.redisClient.LRange(ctx, eventsKey(deviceID), 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(results))
+	for _, result := range results {
+		var event Event
+		if err := json.Unmarshal([]byte(result), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,163 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/fleets"
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrFleetNotFound = errors.New("fleet not found")
+
+// Fleet is a group of devices of a user created from a matrix, it is
+// billed and deleted as a whole.
+type Fleet struct {
+	ID        string      `json:"id"`
+	Username  string      `json:"username"`
+	Spec      fleets.Spec `json:"spec"`
+	Devices   []Android   `json:"devices"`
+	Cost      int         `json:"cost"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// DeviceIDs returns the ids of the devices of the fleet.
+func (f Fleet) DeviceIDs() []string {
+	ids := make([]string, len(f.Devices))
+	for i, d := range f.Devices {
+		ids[i] = d.DeviceID
+	}
+	return ids
+}
+
+func fleetKey(id string) string {
+	return "fleet:" + id
+}
+
+func userFleetsKey(username string) string {
+	return username + ":fleets"
+}
+
+// SaveFleet stores a fleet, creating it when it is new.
+func (s *AndroidService) SaveFleet(ctx context.Context, fleet Fleet) error {
+	fleetData, err := json.Marshal(fleet)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.redisClient.TxPipeline()
+	pipe.Set(ctx, fleetKey(fleet.ID), fleetData, 0)
+	pipe.SAdd(ctx, userFleetsKey(fleet.Username), fleet.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// CreateFleet charges the user the cost of a new fleet and stores it. The
+// sizes of the fleets of the user and the balance are passed to check,
+// which refuses the fleet with an error, in the same transaction as the
+// charge so that concurrent creations cannot exceed the quotas or the
+// balance.
+func (s *AndroidService) CreateFleet(ctx context.Context, fleet Fleet, check func(sizes []int, balance int) error) error {
+	fleetData, err := json.Marshal(fleet)
+	if err != nil {
+		return err
+	}
+
+	return watch(ctx, s.redisClient, func(tx *redis.Tx) error {
+		user, err := getUser(ctx, tx, fleet.Username)
+		if err != nil {
+			return err
+		}
+		existing, err := listFleets(ctx, tx, fleet.Username)
+		if err != nil {
+			return err
+		}
+
+		sizes := make([]int, len(existing))
+		for i, f := range existing {
+			sizes[i] = len(f.Devices)
+		}
+		if err := check(sizes, user.Balance); err != nil {
+			return err
+		}
+
+		user.Balance -= fleet.Cost
+		userData, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, fleet.Username, userData, 0)
+			pipe.Set(ctx, fleetKey(fleet.ID), fleetData, 0)
+			pipe.SAdd(ctx, userFleetsKey(fleet.Username), fleet.ID)
+			return nil
+		})
+		return err
+	}, fleet.Username, userFleetsKey(fleet.Username))
+}
+
+// GetFleet returns a fleet of the user.
+func (s *AndroidService) GetFleet(ctx context.Context, username string, id string) (Fleet, error) {
+	return getFleet(ctx, s.redisClient, username, id)
+}
+
+// getFleet reads a fleet with client, which may be a transaction.
+func getFleet(ctx context.Context, client redis.Cmdable, username string, id string) (Fleet, error) {
+	result, err := client.Get(ctx, fleetKey(id)).Result()
+	if err == redis.Nil {
+		return Fleet{}, ErrFleetNotFound
+	} else if err != nil {
+		return Fleet{}, err
+	}
+
+	var fleet Fleet
+	if err := json.Unmarshal([]byte(result), &fleet); err != nil {
+		return Fleet{}, err
+	}
+	if fleet.Username != username {
+		return Fleet{}, ErrFleetNotFound
+	}
+
+	return fleet, nil
+}
+
+// ListFleets returns the fleets of the user, oldest first.
+func (s *AndroidService) ListFleets(ctx context.Context, username string) ([]Fleet, error) {
+	return listFleets(ctx, s.redisClient, username)
+}
+
+// listFleets reads the fleets of the user with client, which may be a
+// transaction.
+func listFleets(ctx context.Context, client redis.Cmdable, username string) ([]Fleet, error) {
+	ids, err := client.SMembers(ctx, userFleetsKey(username)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Fleet{}
+	for _, id := range ids {
+		fleet, err := getFleet(ctx, client, username, id)
+		if err == ErrFleetNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		list = append(list, fleet)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+// DeleteFleet deletes a fleet of the user.
+func (s *AndroidService) DeleteFleet(ctx context.Context, username string, id string) error {
+	pipe := s.redisClient.TxPipeline()
+	pipe.Del(ctx, fleetKey(id))
+	pipe.SRem(ctx, userFleetsKey(username), id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -1,6 +1,8 @@
 package redis
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -23,8 +25,26 @@ const (
 	minIdleConns    = 20
 	poolTimeout     = 6 * time.Second
 	idleTimeout     = 12 * time.Second
+
+	// maxWatchRetries bounds the retries of a transaction whose watched
+	// keys were changed by another client.
+	maxWatchRetries = 10
 )
 
+var ErrConflict = errors.New("too many concurrent updates, try again")
+
+// watch runs fn in a transaction watching keys, retrying it when another
+// client changed them before it committed.
+func watch(ctx context.Context, client redis.UniversalClient, fn func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < maxWatchRetries; i++ {
+		err := client.Watch(ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
 func NewUniversalRedisClient(cfg *Config) redis.UniversalClient {
 
 	universalClient := redis.NewUniversalClient(&redis.UniversalOptions{
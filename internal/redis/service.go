@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"math/rand"
 
+	"github.com/SajjadManafi/android-emulator-serverless/internal/network"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -22,6 +23,15 @@ type Android struct {
 	AndroidAPI     string `json:"android_api" redis:"android_api"`
 	DeviceName     string `json:"device_name" redis:"device_name"`
 	Status         string `json:"status" redis:"status"`
+	// Volume is the persistent data volume of the device, a device without
+	// one starts with fresh data.
+	Volume string `json:"volume,omitempty" redis:"volume"`
+	// Appium tells whether an Appium server runs next to the emulator.
+	Appium bool `json:"appium,omitempty" redis:"appium"`
+	// Locale is the locale the device booted with, if any.
+	Locale string `json:"locale,omitempty" redis:"locale"`
+	// Network is the network profile applied to the device, if any.
+	Network *network.Profile `json:"network,omitempty" redis:"-"`
 }
 
 var UsedPorts = "used_ports"
@@ -78,12 +88,37 @@ func (s *AndroidService) GetAndroid(ctx context.Context, deviceID string) (Andro
 	return android, nil
 }
 
-// DeleteAndroid deletes the android with the given deviceID.
+// SetNetwork records the network profile applied to the android.
+func (s *AndroidService) SetNetwork(ctx context.Context, deviceID string, profile network.Profile) error {
+	android, err := s.GetAndroid(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+
+	android.Network = &profile
+
+	androidData, err := json.Marshal(android)
+	if err != nil {
+		return err
+	}
+
+	if err := s.redisClient.Set(ctx, deviceID, androidData, 0).Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteAndroid deletes the android with the given deviceID and its history.
 func (s *AndroidService) DeleteAndroid(ctx context.Context, deviceID string) error {
 	if err := s.redisClient.Del(ctx, deviceID).Err(); err != nil {
 		return err
 	}
 
+	if err := s.redisClient.Del(ctx, eventsKey(deviceID)).Err(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
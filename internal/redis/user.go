@@ -17,8 +17,15 @@ type User struct {
 	UserName string `json:"username" redis:"username"`
 	Password string `json:"password" redis:"password"`
 	Balance  int    `json:"balance" redis:"balance"`
+	Role     string `json:"role" redis:"role"`
 }
 
+// Roles of users, which select the policies applied to them.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 var ErrUserExists = errors.New("user already exists")
 var ErrUserNotFound = errors.New("user not found")
 var ErrInvalidCredentials = errors.New("invalid credentials")
@@ -76,7 +83,12 @@ func (s *UserService) LoginUser(ctx context.Context, username, password string)
 
 // GetUser retrieves a user from Redis by username.
 func (s *UserService) GetUser(ctx context.Context, username string) (User, error) {
-	result, err := s.redisClient.Get(ctx, username).Result()
+	return getUser(ctx, s.redisClient, username)
+}
+
+// getUser reads a user with client, which may be a transaction.
+func getUser(ctx context.Context, client redis.Cmdable, username string) (User, error) {
+	result, err := client.Get(ctx, username).Result()
 	if err == redis.Nil {
 		return User{}, ErrUserNotFound
 	} else if err != nil {
@@ -91,25 +103,28 @@ func (s *UserService) GetUser(ctx context.Context, username string) (User, error
 	return user, nil
 }
 
-// UpdateUserBalance updates the balance of a user in Redis.
+// UpdateUserBalance adds amount to the balance of a user in Redis, in a
+// transaction so that concurrent updates are not lost.
 func (s *UserService) UpdateUserBalance(ctx context.Context, username string, amount int) error {
-	user, err := s.GetUser(ctx, username)
-	if err != nil {
-		return err
-	}
-
-	user.Balance += amount
-
-	userData, err := json.Marshal(user)
-	if err != nil {
-		return err
-	}
-
-	if err := s.redisClient.Set(ctx, username, userData, 0).Err(); err != nil {
+	return watch(ctx, s.redisClient, func(tx *redis.Tx) error {
+		user, err := getUser(ctx, tx, username)
+		if err != nil {
+			return err
+		}
+
+		user.Balance += amount
+
+		userData, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, username, userData, 0)
+			return nil
+		})
 		return err
-	}
-
-	return nil
+	}, username)
 }
 
 // UpdateProfile updates the profile of a user in Redis.
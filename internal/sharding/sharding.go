@@ -0,0 +1,183 @@
+package sharding
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/instrument"
+)
+
+const (
+	defaultMaxShards   = 4
+	defaultMaxRetries  = 2
+	defaultBootTimeout = 10 * time.Minute
+
+	// defaultDuration is the duration assumed for a test without history
+	// when no test has one.
+	defaultDuration = time.Second
+)
+
+// Strategies splitting a suite into shards. ByIndex lets the runner pick
+// the tests of each shard, ByDuration balances the shards by the
+// durations the tests took in earlier runs.
+const (
+	ByIndex    = "index"
+	ByDuration = "duration"
+)
+
+var Strategies = []string{ByIndex, ByDuration}
+
+type Config struct {
+	// MaxShards is the number of devices a run may use, the device of the
+	// user included.
+	MaxShards int `mapstructure:"maxShards"`
+	// MaxRetries is how many times a failed test may be retried.
+	MaxRetries int `mapstructure:"maxRetries"`
+	// BootTimeout bounds the boot of the devices provisioned for a run.
+	BootTimeout time.Duration `mapstructure:"bootTimeout"`
+}
+
+// Policy holds the limits of sharded runs.
+type Policy struct {
+	maxShards   int
+	maxRetries  int
+	bootTimeout time.Duration
+}
+
+func NewPolicy(cfg *Config) *Policy {
+	p := &Policy{
+		maxShards:   cfg.MaxShards,
+		maxRetries:  cfg.MaxRetries,
+		bootTimeout: cfg.BootTimeout,
+	}
+	if p.maxShards <= 0 {
+		p.maxShards = defaultMaxShards
+	}
+	if p.maxRetries <= 0 {
+		p.maxRetries = defaultMaxRetries
+	}
+	if p.bootTimeout <= 0 {
+		p.bootTimeout = defaultBootTimeout
+	}
+	return p
+}
+
+// MaxShards returns the number of devices a run may use.
+func (p *Policy) MaxShards() int {
+	return p.maxShards
+}
+
+// MaxRetries returns how many times a failed test may be retried.
+func (p *Policy) MaxRetries() int {
+	return p.maxRetries
+}
+
+// BootTimeout returns how long a provisioned device may take to boot.
+func (p *Policy) BootTimeout() time.Duration {
+	return p.bootTimeout
+}
+
+// ByDurations splits the tests into n shards of about the same total
+// duration, longest tests first. Tests without a known duration count as
+// the average of the known ones.
+func ByDurations(tests []string, durations map[string]int64, n int) [][]string {
+	var known, total int64
+	for _, t := range tests {
+		if d, ok := durations[t]; ok {
+			known++
+			total += d
+		}
+	}
+	fallback := defaultDuration.Milliseconds()
+	if known > 0 {
+		fallback = max(1, total/known)
+	}
+
+	sorted := append([]string(nil), tests...)
+	duration := func(t string) int64 {
+		if d, ok := durations[t]; ok {
+			return max(1, d)
+		}
+		return fallback
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return duration(sorted[i]) > duration(sorted[j]) })
+
+	shards := make([][]string, n)
+	loads := make([]int64, n)
+	for _, t := range sorted {
+		least := 0
+		for i := range loads {
+			if loads[i] < loads[least] {
+				least = i
+			}
+		}
+		shards[least] = append(shards[least], t)
+		loads[least] += duration(t)
+	}
+	return shards
+}
+
+// Durations returns the average duration of each test in earlier results.
+func Durations(results []instrument.Result) map[string]int64 {
+	sums := map[string]int64{}
+	counts := map[string]int64{}
+	for _, result := range results {
+		for _, t := range result.Tests {
+			if t.Status != instrument.StatusPassed && t.Status != instrument.StatusFailed {
+				continue
+			}
+			sums[t.FullName()] += t.DurationMs
+			counts[t.FullName()]++
+		}
+	}
+
+	durations := map[string]int64{}
+	for name, sum := range sums {
+		durations[name] = sum / counts[name]
+	}
+	return durations
+}
+
+// Merge merges the attempts at the tests of a run into one result, in the
+// order of the tests. The last attempt at a test decides its status, a
+// test that passed after failing is flaky, and a test without attempts is
+// an error.
+func Merge(tests []string, attempts map[string][]instrument.TestResult, duration time.Duration) instrument.Result {
+	result := instrument.Result{
+		Tests:      []instrument.TestResult{},
+		Completed:  true,
+		DurationMs: duration.Milliseconds(),
+	}
+
+	for _, name := range tests {
+		tries := attempts[name]
+		if len(tries) == 0 {
+			class, method, _ := strings.Cut(name, "#")
+			result.Tests = append(result.Tests, instrument.TestResult{
+				Class:  class,
+				Name:   method,
+				Status: instrument.StatusError,
+				Stack:  "test did not run, its shard did not complete",
+			})
+			continue
+		}
+
+		last := tries[len(tries)-1]
+		last.Attempts = len(tries)
+		if last.Status == instrument.StatusPassed && len(tries) > 1 {
+			last.Flaky = true
+			result.Summary.Flaky++
+		}
+		// The screenshot of an earlier failure is kept for a flaky test.
+		for i := len(tries) - 1; i >= 0 && last.Screenshot == ""; i-- {
+			last.Screenshot = tries[i].Screenshot
+		}
+		result.Tests = append(result.Tests, last)
+	}
+
+	for _, t := range result.Tests {
+		result.Summary.Add(t.Status)
+	}
+	return result
+}
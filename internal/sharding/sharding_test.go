@@ -0,0 +1,138 @@
+package sharding
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/instrument"
+)
+
+func TestByDurations(t *testing.T) {
+	tests := []struct {
+		name      string
+		tests     []string
+		durations map[string]int64
+		n         int
+		want      [][]string
+	}{
+		{
+			name:      "longest first on the least loaded shard",
+			tests:     []string{"A#a", "A#b", "A#c", "A#d", "A#e"},
+			durations: map[string]int64{"A#a": 100, "A#b": 700, "A#c": 300, "A#d": 400, "A#e": 200},
+			n:         2,
+			want:      [][]string{{"A#b", "A#e"}, {"A#d", "A#c", "A#a"}},
+		},
+		{
+			name:      "unknown tests count as the average",
+			tests:     []string{"A#a", "A#b", "A#new"},
+			durations: map[string]int64{"A#a": 900, "A#b": 300},
+			n:         2,
+			want:      [][]string{{"A#a"}, {"A#new", "A#b"}},
+		},
+		{
+			name:  "no history keeps the order",
+			tests: []string{"A#a", "A#b", "A#c"},
+			n:     2,
+			want:  [][]string{{"A#a", "A#c"}, {"A#b"}},
+		},
+		{
+			name:      "zero durations still spread",
+			tests:     []string{"A#a", "A#b"},
+			durations: map[string]int64{"A#a": 0, "A#b": 0},
+			n:         2,
+			want:      [][]string{{"A#a"}, {"A#b"}},
+		},
+		{
+			name:  "more shards than tests",
+			tests: []string{"A#a"},
+			n:     3,
+			want:  [][]string{{"A#a"}, nil, nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ByDurations(tt.tests, tt.durations, tt.n)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ByDurations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDurations(t *testing.T) {
+	results := []instrument.Result{
+		{Tests: []instrument.TestResult{
+			{Class: "A", Name: "a", Status: instrument.StatusPassed, DurationMs: 100},
+			{Class: "A", Name: "b", Status: instrument.StatusFailed, DurationMs: 500},
+			{Class: "A", Name: "c", Status: instrument.StatusError, DurationMs: 9000},
+		}},
+		{Tests: []instrument.TestResult{
+			{Class: "A", Name: "a", Status: instrument.StatusPassed, DurationMs: 300},
+			{Class: "A", Name: "d", Status: instrument.StatusSkipped},
+		}},
+	}
+
+	want := map[string]int64{"A#a": 200, "A#b": 500}
+	if got := Durations(results); !reflect.DeepEqual(got, want) {
+		t.Errorf("Durations = %v, want %v", got, want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	attempts := map[string][]instrument.TestResult{
+		"A#passed": {
+			{Class: "A", Name: "passed", Status: instrument.StatusPassed, DeviceID: "d0"},
+		},
+		"A#flaky": {
+			{Class: "A", Name: "flaky", Status: instrument.StatusFailed, Stack: "boom", Screenshot: "shot-1", DeviceID: "d0"},
+			{Class: "A", Name: "flaky", Status: instrument.StatusPassed, DeviceID: "d1"},
+		},
+		"A#failed": {
+			{Class: "A", Name: "failed", Status: instrument.StatusError, DeviceID: "d1"},
+			{Class: "A", Name: "failed", Status: instrument.StatusFailed, Stack: "again", Screenshot: "shot-2", DeviceID: "d0"},
+		},
+		"A#skipped": {
+			{Class: "A", Name: "skipped", Status: instrument.StatusSkipped, DeviceID: "d1"},
+		},
+	}
+	tests := []string{"A#passed", "A#flaky", "A#failed", "A#skipped", "A#lost"}
+
+	result := Merge(tests, attempts, 1500*time.Millisecond)
+
+	want := instrument.Result{
+		Summary: instrument.Summary{Tests: 5, Passed: 2, Failed: 1, Errors: 1, Skipped: 1, Flaky: 1},
+		Tests: []instrument.TestResult{
+			{Class: "A", Name: "passed", Status: instrument.StatusPassed, DeviceID: "d0", Attempts: 1},
+			{Class: "A", Name: "flaky", Status: instrument.StatusPassed, Screenshot: "shot-1", DeviceID: "d1", Attempts: 2, Flaky: true},
+			{Class: "A", Name: "failed", Status: instrument.StatusFailed, Stack: "again", Screenshot: "shot-2", DeviceID: "d0", Attempts: 2},
+			{Class: "A", Name: "skipped", Status: instrument.StatusSkipped, DeviceID: "d1", Attempts: 1},
+			{Class: "A", Name: "lost", Status: instrument.StatusError, Stack: "test did not run, its shard did not complete"},
+		},
+		Completed:  true,
+		DurationMs: 1500,
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Merge = %+v, want %+v", result, want)
+	}
+}
+
+func TestMergeEmpty(t *testing.T) {
+	result := Merge(nil, nil, 0)
+	if result.Tests == nil || len(result.Tests) != 0 || result.Summary != (instrument.Summary{}) {
+		t.Errorf("Merge = %+v, want no tests", result)
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	p := NewPolicy(&Config{})
+	if p.MaxShards() != defaultMaxShards || p.MaxRetries() != defaultMaxRetries || p.BootTimeout() != defaultBootTimeout {
+		t.Errorf("NewPolicy defaults = %d, %d, %s", p.MaxShards(), p.MaxRetries(), p.BootTimeout())
+	}
+
+	p = NewPolicy(&Config{MaxShards: 8, MaxRetries: 1, BootTimeout: time.Minute})
+	if p.MaxShards() != 8 || p.MaxRetries() != 1 || p.BootTimeout() != time.Minute {
+		t.Errorf("NewPolicy = %d, %d, %s", p.MaxShards(), p.MaxRetries(), p.BootTimeout())
+	}
+}
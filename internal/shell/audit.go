@@ -0,0 +1,110 @@
+package shell
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultAuditPath = "./data/audit/exec.log"
+
+// AuditEntry records a command a user asked to run and its outcome.
+type AuditEntry struct {
+	Time     time.Time     `json:"time"`
+	Username string        `json:"username"`
+	Role     string        `json:"role"`
+	DeviceID string        `json:"device_id"`
+	Command  string        `json:"command"`
+	Allowed  bool          `json:"allowed"`
+	Reason   string        `json:"reason,omitempty"`
+	ExitCode *int          `json:"exit_code,omitempty"`
+	TimedOut bool          `json:"timed_out,omitempty"`
+	Streamed bool          `json:"streamed,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// AuditLog appends entries as JSON lines to a file.
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewAuditLog(path string) (*AuditLog, error) {
+	if path == "" {
+		path = defaultAuditPath
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+
+	return &AuditLog{path: path}, nil
+}
+
+// Record appends an entry to the log.
+func (l *AuditLog) Record(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// List returns the latest entries of username, or of everyone when
+// username is empty, newest first.
+func (l *AuditLog) List(username string, limit int) ([]AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []AuditEntry{}
+
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if username != "" && entry.Username != username {
+			continue
+		}
+		entries = append(entries, entry)
+		if limit > 0 && len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,253 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout     = 30 * time.Second
+	defaultOutputLimit = 1 << 20
+)
+
+type Config struct {
+	// DefaultRole applies to users without a role.
+	DefaultRole string                `mapstructure:"defaultRole"`
+	Roles       map[string]RoleConfig `mapstructure:"roles"`
+	// OutputLimit caps the bytes of stdout and stderr each returned.
+	OutputLimit int64  `mapstructure:"outputLimit"`
+	AuditPath   string `mapstructure:"auditPath"`
+}
+
+// RoleConfig lists the commands a role may run. Allow rules are leading
+// words of a command, such as "pm list" or "dumpsys", and "*" matches any
+// command. Deny rules win over allow rules; their first word leads the
+// command and their other words follow in order, with any options or
+// words in between, so "settings put global adb_enabled" also denies
+// "settings put --user 0 global adb_enabled 1".
+type RoleConfig struct {
+	Allow []string `mapstructure:"allow"`
+	Deny  []string `mapstructure:"deny"`
+	// Timeout is both the default and the longest time a command may run.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// AllowOperators lets the role chain commands, redirect and substitute
+	// with shell operators, which makes the rules only apply to the first
+	// command.
+	AllowOperators bool `mapstructure:"allowOperators"`
+}
+
+var (
+	ErrDenied       = errors.New("command denied by policy")
+	ErrEmptyCommand = errors.New("command is empty")
+	ErrUnknownRole  = errors.New("unknown role")
+)
+
+// Decision is the outcome of checking a command against the policy.
+type Decision struct {
+	Role    string
+	Command string
+	Timeout time.Duration
+}
+
+// Policy decides which commands each role may run.
+type Policy struct {
+	defaultRole string
+	roles       map[string]RoleConfig
+	outputLimit int64
+}
+
+func NewPolicy(cfg *Config) *Policy {
+	outputLimit := cfg.OutputLimit
+	if outputLimit <= 0 {
+		outputLimit = defaultOutputLimit
+	}
+
+	return &Policy{
+		defaultRole: cfg.DefaultRole,
+		roles:       cfg.Roles,
+		outputLimit: outputLimit,
+	}
+}
+
+// OutputLimit returns the bytes of stdout and stderr each kept for a command.
+func (p *Policy) OutputLimit() int64 {
+	return p.outputLimit
+}
+
+// Check decides whether role may run command. The requested timeout is
+// capped to the timeout of the role, and defaults to it when zero.
+func (p *Policy) Check(role string, command string, timeout time.Duration) (Decision, error) {
+	if role == "" {
+		role = p.defaultRole
+	}
+
+	decision := Decision{Role: role, Command: strings.TrimSpace(command)}
+
+	rc, ok := p.roles[role]
+	if !ok {
+		return decision, fmt.Errorf("%w %q", ErrUnknownRole, role)
+	}
+
+	maxTimeout := rc.Timeout
+	if maxTimeout <= 0 {
+		maxTimeout = defaultTimeout
+	}
+	decision.Timeout = maxTimeout
+	if timeout > 0 && timeout < maxTimeout {
+		decision.Timeout = timeout
+	}
+
+	if decision.Command == "" {
+		return decision, ErrEmptyCommand
+	}
+
+	if !rc.AllowOperators {
+		if op := findOperator(decision.Command); op != "" {
+			return decision, fmt.Errorf("%w: shell operator %q is not allowed", ErrDenied, op)
+		}
+	}
+
+	words, err := Split(decision.Command)
+	if err != nil {
+		return decision, err
+	}
+
+	for _, rule := range rc.Deny {
+		if matchDenyRule(rule, words) {
+			return decision, fmt.Errorf("%w: %q matches deny rule %q", ErrDenied, decision.Command, rule)
+		}
+	}
+	for _, rule := range rc.Allow {
+		if matchRule(rule, words) {
+			return decision, nil
+		}
+	}
+
+	return decision, fmt.Errorf("%w: %q is not allowed for role %q", ErrDenied, decision.Command, role)
+}
+
+// matchRule reports whether the words of rule lead the command.
+func matchRule(rule string, words []string) bool {
+	ruleWords := strings.Fields(rule)
+	if len(ruleWords) == 1 && ruleWords[0] == "*" {
+		return true
+	}
+	if len(ruleWords) == 0 || len(ruleWords) > len(words) {
+		return false
+	}
+
+	for i, w := range ruleWords {
+		if w != words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchDenyRule reports whether the first word of rule leads the command
+// and its other words follow in order.
+func matchDenyRule(rule string, words []string) bool {
+	ruleWords := strings.Fields(rule)
+	if len(ruleWords) == 1 && ruleWords[0] == "*" {
+		return true
+	}
+	if len(ruleWords) == 0 || len(words) == 0 || ruleWords[0] != words[0] {
+		return false
+	}
+
+	next := 1
+	for _, w := range words[1:] {
+		if next < len(ruleWords) && w == ruleWords[next] {
+			next++
+		}
+	}
+	return next == len(ruleWords)
+}
+
+// findOperator returns the first shell operator outside of quotes, which
+// would let a command run something the rules did not check.
+func findOperator(command string) string {
+	var quote byte
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			i++
+		case quote == '"':
+			switch c {
+			case '"':
+				quote = 0
+			case '$', '`':
+				return string(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case strings.IndexByte(";&|<>`$()\n", c) >= 0:
+			return string(c)
+		}
+	}
+	return ""
+}
+
+// Split splits a command into words the way the shell does for plain
+// words and quotes.
+func Split(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote byte
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case quote == '"':
+			switch {
+			case c == '"':
+				quote = 0
+			case c == '\\' && i+1 < len(command) && strings.IndexByte("\\\"$`", command[i+1]) >= 0:
+				i++
+				word.WriteByte(command[i])
+			default:
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '\\':
+			if i+1 < len(command) {
+				i++
+				word.WriteByte(command[i])
+			}
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
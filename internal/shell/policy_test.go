@@ -0,0 +1,181 @@
+package shell
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testPolicy mirrors the roles of the default config.
+func testPolicy() *Policy {
+	return NewPolicy(&Config{
+		DefaultRole: "user",
+		Roles: map[string]RoleConfig{
+			"user": {
+				Timeout: 30 * time.Second,
+				Allow:   []string{"pm list", "pm path", "settings get", "settings put", "dumpsys", "getprop", "input", "logcat -d"},
+				Deny:    []string{"settings put global adb_enabled", "settings put global development_settings_enabled"},
+			},
+			"admin": {
+				Timeout:        10 * time.Minute,
+				AllowOperators: true,
+				Allow:          []string{"*"},
+			},
+		},
+	})
+}
+
+func TestFindOperator(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"pm list packages", ""},
+		{"pm list packages; reboot", ";"},
+		{"getprop | grep sdk", "|"},
+		{"dumpsys && reboot", "&"},
+		{"logcat -d > /sdcard/log", ">"},
+		{"input text < /dev/zero", "<"},
+		{"getprop `id`", "`"},
+		{"getprop $(id)", "$"},
+		{"getprop (id)", "("},
+		{"getprop\nreboot", "\n"},
+		// Quoted and escaped operators are plain characters.
+		{"input text 'a;b|c>d$e`f'", ""},
+		{`input text "a;b|c>d"`, ""},
+		{`input text a\;b\|c`, ""},
+		{`input text "a\"; reboot"`, ""},
+		// Double quotes still substitute.
+		{`input text "$(id)"`, "$"},
+		{"input text \"`id`\"", "`"},
+		{`input text "a" ; reboot`, ";"},
+		{`input text 'a'"b";reboot`, ";"},
+	}
+	for _, tt := range tests {
+		if got := findOperator(tt.command); got != tt.want {
+			t.Errorf("findOperator(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"pm list packages", []string{"pm", "list", "packages"}},
+		{"  pm \t list  ", []string{"pm", "list"}},
+		{`input text 'hello world'`, []string{"input", "text", "hello world"}},
+		{`input text "hello world"`, []string{"input", "text", "hello world"}},
+		{`input text hello\ world`, []string{"input", "text", "hello world"}},
+		{`a'b'"c"d`, []string{"abcd"}},
+		{`'' x`, []string{"", "x"}},
+		{`"a \"b\" \$c \\d \e"`, []string{`a "b" $c \d \e`}},
+		{`'a \' b`, []string{`a \`, "b"}},
+		{`sett\ings`, []string{"settings"}},
+	}
+	for _, tt := range tests {
+		got, err := Split(tt.command)
+		if err != nil {
+			t.Errorf("Split(%q): %v", tt.command, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Split(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+
+	for _, command := range []string{`input text 'abc`, `input text "abc`, `"a\"`} {
+		if _, err := Split(command); err == nil {
+			t.Errorf("Split(%q): expected an unterminated quote error", command)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		role    string
+		command string
+		allowed bool
+	}{
+		{"user", "pm list packages", true},
+		{"", "pm list packages", true},
+		{"user", "  dumpsys battery  ", true},
+		{"user", "pm install /sdcard/app.apk", false},
+		{"user", "pm", false},
+		{"user", "logcat", false},
+		{"user", "logcat -d", true},
+		{"user", "settings put global airplane_mode_on 1", true},
+		{"user", "settings put secure adb_enabled 0", true},
+		{"user", "settings put global adb_enabled_x 0", true},
+
+		// Deny rules.
+		{"user", "settings put global adb_enabled 0", false},
+		{"user", "settings put global development_settings_enabled 0", false},
+		{"user", "settings put --user 0 global adb_enabled 0", false},
+		{"user", "settings put --user current global adb_enabled 0", false},
+		{"user", "settings put global --lineage adb_enabled 0", false},
+		{"user", `settings put 'global' "adb_enabled" 0`, false},
+		{"user", `settings put glob"al" adb_\enabled 0`, false},
+		{"user", "settings  put\tglobal   adb_enabled 0", false},
+
+		// Operators.
+		{"user", "pm list packages; reboot", false},
+		{"user", "getprop | grep sdk", false},
+		{"user", "getprop $(reboot)", false},
+		{"user", "input text 'a; reboot'", true},
+		{"user", "pm list packages\nreboot", false},
+
+		{"admin", "reboot", true},
+		{"admin", "getprop | grep sdk; reboot", true},
+	}
+	p := testPolicy()
+	for _, tt := range tests {
+		_, err := p.Check(tt.role, tt.command, 0)
+		if tt.allowed && err != nil {
+			t.Errorf("Check(%q, %q): %v, want allowed", tt.role, tt.command, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrDenied) {
+			t.Errorf("Check(%q, %q) = %v, want ErrDenied", tt.role, tt.command, err)
+		}
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	p := testPolicy()
+
+	if _, err := p.Check("guest", "getprop", 0); !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("unknown role: err = %v", err)
+	}
+	if _, err := p.Check("user", "   ", 0); !errors.Is(err, ErrEmptyCommand) {
+		t.Errorf("empty command: err = %v", err)
+	}
+	if _, err := p.Check("user", "getprop 'ro.build", 0); err == nil || errors.Is(err, ErrDenied) {
+		t.Errorf("unterminated quote: err = %v", err)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	p := testPolicy()
+
+	tests := []struct {
+		role      string
+		requested time.Duration
+		want      time.Duration
+	}{
+		{"user", 0, 30 * time.Second},
+		{"user", 5 * time.Second, 5 * time.Second},
+		{"user", time.Hour, 30 * time.Second},
+		{"admin", time.Hour, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		decision, err := p.Check(tt.role, "getprop", tt.requested)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision.Timeout != tt.want {
+			t.Errorf("%s with %v: timeout = %v, want %v", tt.role, tt.requested, decision.Timeout, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package snapshots
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+)
+
+const (
+	defaultMaxPerUser = 10
+	defaultTimeout    = 5 * time.Minute
+	defaultAVDHome    = "${ANDROID_AVD_HOME:-$HOME/.android/avd}"
+)
+
+// Metadata keys of the artifacts holding snapshots.
+const (
+	MetaName       = "snapshot"
+	MetaAndroidAPI = "android_api"
+	MetaDeviceName = "device_name"
+)
+
+type Config struct {
+	// MaxPerUser is the number of snapshots a user may keep, their size
+	// counts against the artifact quota.
+	MaxPerUser int `mapstructure:"maxPerUser"`
+	// Timeout bounds saving and loading a snapshot in the emulator.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// AVDHome is the directory holding the AVD in the containers, as a
+	// shell word.
+	AVDHome string `mapstructure:"avdHome"`
+}
+
+var (
+	ErrInvalidName  = errors.New("snapshot name must be 1 to 64 letters, digits, '.', '_' or '-'")
+	ErrNotFound     = errors.New("snapshot not found")
+	ErrLimitReached = errors.New("snapshot limit reached")
+	ErrIncompatible = errors.New("snapshot was saved on another android version or device")
+)
+
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Snapshot is a saved state of an emulator, stored as an artifact.
+type Snapshot struct {
+	Name       string    `json:"name"`
+	ArtifactID string    `json:"artifact_id"`
+	DeviceID   string    `json:"device_id,omitempty"`
+	AndroidAPI string    `json:"android_api,omitempty"`
+	DeviceName string    `json:"device_name,omitempty"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Request names a snapshot and describes the device it is saved from or
+// restored on.
+type Request struct {
+	Name       string `json:"name"`
+	AndroidAPI string `json:"android_api,omitempty"`
+	DeviceName string `json:"device_name,omitempty"`
+}
+
+// Policy holds the limits of the snapshots.
+type Policy struct {
+	maxPerUser int
+	timeout    time.Duration
+	avdHome    string
+}
+
+func NewPolicy(cfg *Config) *Policy {
+	p := &Policy{
+		maxPerUser: cfg.MaxPerUser,
+		timeout:    cfg.Timeout,
+		avdHome:    cfg.AVDHome,
+	}
+	if p.maxPerUser <= 0 {
+		p.maxPerUser = defaultMaxPerUser
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultTimeout
+	}
+	if p.avdHome == "" {
+		p.avdHome = defaultAVDHome
+	}
+	return p
+}
+
+// MaxPerUser returns the number of snapshots a user may keep.
+func (p *Policy) MaxPerUser() int {
+	return p.maxPerUser
+}
+
+// Timeout returns how long saving or loading a snapshot may take.
+func (p *Policy) Timeout() time.Duration {
+	return p.timeout
+}
+
+// AVDDir returns a shell expression of the directory of the AVD in the
+// container, the image holds a single AVD.
+func (p *Policy) AVDDir() string {
+	return `"` + p.avdPath() + `"`
+}
+
+// Dir returns a shell expression of the snapshot directory of the AVD.
+func (p *Policy) Dir() string {
+	return `"` + p.avdPath() + `/snapshots"`
+}
+
+func (p *Policy) avdPath() string {
+	return `$(set -- ` + p.avdHome + `/*.avd; echo "$1")`
+}
+
+// CheckName checks a snapshot name, which is also its directory name.
+func CheckName(name string) error {
+	if !nameRe.MatchString(name) || name == "." || name == ".." {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// FromArtifact returns the snapshot stored in a, if it holds one.
+func FromArtifact(a artifacts.Artifact) (Snapshot, bool) {
+	if a.Kind != artifacts.KindSnapshot || a.Metadata[MetaName] == "" {
+		return Snapshot{}, false
+	}
+	return Snapshot{
+		Name:       a.Metadata[MetaName],
+		ArtifactID: a.ID,
+		DeviceID:   a.DeviceID,
+		AndroidAPI: a.Metadata[MetaAndroidAPI],
+		DeviceName: a.Metadata[MetaDeviceName],
+		Size:       a.Size,
+		CreatedAt:  a.CreatedAt,
+	}, true
+}
+
+// Compatible checks a snapshot can be loaded on a device of the given
+// android version and device name, unknown values are not compared.
+func (s Snapshot) Compatible(androidAPI string, deviceName string) error {
+	if s.AndroidAPI != "" && androidAPI != "" && s.AndroidAPI != androidAPI {
+		return fmt.Errorf("%w: it needs android %s", ErrIncompatible, s.AndroidAPI)
+	}
+	if s.DeviceName != "" && deviceName != "" && s.DeviceName != deviceName {
+		return fmt.Errorf("%w: it needs a %s", ErrIncompatible, s.DeviceName)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package snapshots
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SajjadManafi/android-emulator-serverless/internal/artifacts"
+)
+
+func TestCheckName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"before-login", true},
+		{"v2.4.1_seeded", true},
+		{"0", true},
+		{strings.Repeat("a", 64), true},
+		{strings.Repeat("a", 65), false},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{".hidden", false},
+		{"-rf", false},
+		{"a/b", false},
+		{"with space", false},
+		{"snap;reboot", false},
+	}
+
+	for _, tt := range tests {
+		err := CheckName(tt.name)
+		if tt.ok && err != nil || !tt.ok && !errors.Is(err, ErrInvalidName) {
+			t.Errorf("CheckName(%q) = %v", tt.name, err)
+		}
+	}
+}
+
+func TestFromArtifact(t *testing.T) {
+	created := time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC)
+	a := artifacts.Artifact{
+		ID:        "a1",
+		Owner:     "alice",
+		DeviceID:  "alice-Device",
+		Kind:      artifacts.KindSnapshot,
+		Size:      1 << 30,
+		CreatedAt: created,
+		Metadata: map[string]string{
+			MetaName:       "before-login",
+			MetaAndroidAPI: "emulator_13.0",
+			MetaDeviceName: "Samsung Galaxy S10",
+		},
+	}
+
+	got, ok := FromArtifact(a)
+	want := Snapshot{
+		Name:       "before-login",
+		ArtifactID: "a1",
+		DeviceID:   "alice-Device",
+		AndroidAPI: "emulator_13.0",
+		DeviceName: "Samsung Galaxy S10",
+		Size:       1 << 30,
+		CreatedAt:  created,
+	}
+	if !ok || got != want {
+		t.Errorf("FromArtifact = %+v, %t, want %+v", got, ok, want)
+	}
+
+	other := a
+	other.Kind = artifacts.KindScreenshot
+	if _, ok := FromArtifact(other); ok {
+		t.Error("FromArtifact of a screenshot is a snapshot")
+	}
+	unnamed := a
+	unnamed.Metadata = map[string]string{MetaAndroidAPI: "emulator_13.0"}
+	if _, ok := FromArtifact(unnamed); ok {
+		t.Error("FromArtifact of a snapshot without a name is a snapshot")
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	s := Snapshot{AndroidAPI: "emulator_13.0", DeviceName: "Samsung Galaxy S10"}
+
+	tests := []struct {
+		name       string
+		snapshot   Snapshot
+		androidAPI string
+		deviceName string
+		err        error
+	}{
+		{"same device", s, "emulator_13.0", "Samsung Galaxy S10", nil},
+		{"device unknown", s, "emulator_13.0", "", nil},
+		{"saved without details", Snapshot{}, "emulator_11.0", "Nexus 5", nil},
+		{"other android", s, "emulator_11.0", "Samsung Galaxy S10", ErrIncompatible},
+		{"other device", s, "emulator_13.0", "Nexus 5", ErrIncompatible},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.snapshot.Compatible(tt.androidAPI, tt.deviceName); !errors.Is(err, tt.err) {
+				t.Errorf("Compatible = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestPolicyDirs(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+
+	// The image keeps a single AVD, under a home with a space.
+	home := filepath.Join(t.TempDir(), "avd home")
+	avd := filepath.Join(home, "nexus_5_13.0.avd")
+	if err := os.MkdirAll(avd, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPolicy(&Config{AVDHome: `"$AVD_HOME"`})
+	if p.MaxPerUser() != defaultMaxPerUser || p.Timeout() != defaultTimeout {
+		t.Errorf("MaxPerUser, Timeout = %d, %v, want the defaults", p.MaxPerUser(), p.Timeout())
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{p.AVDDir(), avd},
+		{p.Dir(), filepath.Join(avd, "snapshots")},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("sh", "-c", "printf %s "+tt.expr)
+		cmd.Env = append(os.Environ(), "AVD_HOME="+home)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("sh -c %s: %v", tt.expr, err)
+		}
+		if string(out) != tt.want {
+			t.Errorf("%s = %q, want %q", tt.expr, out, tt.want)
+		}
+	}
+}
@@ -7,7 +7,7 @@ type Config struct {
 
 type Maker interface {
 	// CreateAccessToken creates a new access token for a specific username
-	CreateAccessToken(username string, name string) (string, error)
+	CreateAccessToken(username string, name string, role string) (string, error)
 
 	// VerifyToken verifies a token
 	VerifyAccessToken(token string) (*AccessPayload, error)
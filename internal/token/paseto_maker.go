@@ -28,8 +28,8 @@ func NewPasetoMaker(symmetricKey string) (Maker, error) {
 }
 
 // CreateAccessToken creates a new access token for a specific email
-func (maker *PasetoMaker) CreateAccessToken(username string, name string) (string, error) {
-	payload, err := NewAccessPayload(username, name)
+func (maker *PasetoMaker) CreateAccessToken(username string, name string, role string) (string, error) {
+	payload, err := NewAccessPayload(username, name, role)
 
 	if err != nil {
 		return "", err
@@ -19,15 +19,17 @@ var (
 type AccessPayload struct {
 	Username  string    `json:"username"`
 	Name      string    `json:"name"`
+	Role      string    `json:"role,omitempty"`
 	IssuedAt  time.Time `json:"issued_at"`
 	ExpiredAt time.Time `json:"expired_at"`
 }
 
-func NewAccessPayload(username string, name string) (*AccessPayload, error) {
+func NewAccessPayload(username string, name string, role string) (*AccessPayload, error) {
 	currentTime := time.Now()
 	return &AccessPayload{
 		Username:  username,
 		Name:      name,
+		Role:      role,
 		IssuedAt:  currentTime,
 		ExpiredAt: currentTime.Add(AccessTokenDuration),
 	}, nil
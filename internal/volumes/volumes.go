@@ -0,0 +1,110 @@
+package volumes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path"
+	"regexp"
+	"time"
+)
+
+const (
+	defaultMountPath = "/home/androidusr/data"
+	defaultOwnerID   = "1300:1301"
+
+	// DataImage is the data partition of the emulator in the volume.
+	DataImage = "userdata-qemu.img"
+)
+
+// Labels of the docker volumes, which identify the volume of a user.
+const (
+	LabelOwner      = "emulator.owner"
+	LabelAndroidAPI = "emulator.android_api"
+	LabelName       = "emulator.volume"
+)
+
+type Config struct {
+	// MountPath is where the volume is mounted in the emulator container.
+	MountPath string `mapstructure:"mountPath"`
+	// OwnerID is the uid:gid of the user running the emulator, who owns
+	// the volume.
+	OwnerID string `mapstructure:"ownerID"`
+}
+
+var (
+	ErrInvalidName = errors.New("volume name must be 1 to 64 letters, digits, '.', '_' or '-'")
+	ErrInvalidAPI  = errors.New("invalid android api")
+	ErrNotFound    = errors.New("volume not found")
+	ErrInUse       = errors.New("volume is used by a device")
+)
+
+var (
+	nameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+	apiRe  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+)
+
+// Volume is a persistent data partition of a user for an android version,
+// it outlives the devices using it.
+type Volume struct {
+	Name       string    `json:"name"`
+	AndroidAPI string    `json:"android_api"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
+	// InUseBy is the device using the volume, if any.
+	InUseBy string `json:"in_use_by,omitempty"`
+}
+
+// Policy holds where and for whom the volumes are mounted.
+type Policy struct {
+	mountPath string
+	ownerID   string
+}
+
+func NewPolicy(cfg *Config) *Policy {
+	p := &Policy{
+		mountPath: path.Clean(cfg.MountPath),
+		ownerID:   cfg.OwnerID,
+	}
+	if cfg.MountPath == "" {
+		p.mountPath = defaultMountPath
+	}
+	if p.ownerID == "" {
+		p.ownerID = defaultOwnerID
+	}
+	return p
+}
+
+// MountPath returns where the volume is mounted in the container.
+func (p *Policy) MountPath() string {
+	return p.mountPath
+}
+
+// OwnerID returns the uid:gid owning the volume.
+func (p *Policy) OwnerID() string {
+	return p.ownerID
+}
+
+// EmulatorArgs returns the emulator arguments keeping the data partition
+// in the volume.
+func (p *Policy) EmulatorArgs() string {
+	return "-data " + path.Join(p.mountPath, DataImage)
+}
+
+// Check checks the name and android api of a volume.
+func Check(name string, androidAPI string) error {
+	if !nameRe.MatchString(name) {
+		return ErrInvalidName
+	}
+	if !apiRe.MatchString(androidAPI) {
+		return ErrInvalidAPI
+	}
+	return nil
+}
+
+// DockerName returns the name of the docker volume of a user, derived from
+// the owner, android api and name as usernames may not be valid names.
+func DockerName(owner string, androidAPI string, name string) string {
+	sum := sha256.Sum256([]byte(owner + "\x00" + androidAPI + "\x00" + name))
+	return "emulator-data-" + hex.EncodeToString(sum[:12])
+}
@@ -0,0 +1,78 @@
+package volumes
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		androidAPI string
+		err        error
+	}{
+		{"work", "emulator_13.0", nil},
+		{"v1.2_beta-3", "emulator_11.0", nil},
+		{"", "emulator_13.0", ErrInvalidName},
+		{"..", "emulator_13.0", ErrInvalidName},
+		{"-v", "emulator_13.0", ErrInvalidName},
+		{"work/../other", "emulator_13.0", ErrInvalidName},
+		{"work", "", ErrInvalidAPI},
+		{"work", "emulator_13.0:latest", ErrInvalidAPI},
+		{"work", "--privileged", ErrInvalidAPI},
+	}
+
+	for _, tt := range tests {
+		if err := Check(tt.name, tt.androidAPI); !errors.Is(err, tt.err) {
+			t.Errorf("Check(%q, %q) = %v, want %v", tt.name, tt.androidAPI, err, tt.err)
+		}
+	}
+}
+
+func TestDockerName(t *testing.T) {
+	dockerRe := regexp.MustCompile(`^emulator-data-[0-9a-f]{24}$`)
+
+	name := DockerName("alice@example.com", "emulator_13.0", "work")
+	if !dockerRe.MatchString(name) {
+		t.Errorf("DockerName = %q, want a valid docker volume name", name)
+	}
+	if DockerName("alice@example.com", "emulator_13.0", "work") != name {
+		t.Error("DockerName is not stable")
+	}
+
+	// The separator keeps the parts from running into each other.
+	others := [][3]string{
+		{"bob", "emulator_13.0", "work"},
+		{"alice@example.com", "emulator_11.0", "work"},
+		{"alice@example.com", "emulator_13.0", "home"},
+		{"alice@example.com", "emulator_13.0w", "ork"},
+		{"alice@example.come", "mulator_13.0", "work"},
+	}
+	for _, o := range others {
+		if DockerName(o[0], o[1], o[2]) == name {
+			t.Errorf("DockerName%q is the name of the volume of alice", o)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		cfg       Config
+		mountPath string
+		ownerID   string
+		args      string
+	}{
+		{Config{}, "/home/androidusr/data", "1300:1301", "-data /home/androidusr/data/userdata-qemu.img"},
+		{Config{MountPath: "/data/", OwnerID: "1000:1000"}, "/data", "1000:1000", "-data /data/userdata-qemu.img"},
+		{Config{MountPath: "/mnt//emulator/./data"}, "/mnt/emulator/data", "1300:1301", "-data /mnt/emulator/data/userdata-qemu.img"},
+	}
+
+	for _, tt := range tests {
+		p := NewPolicy(&tt.cfg)
+		if p.MountPath() != tt.mountPath || p.OwnerID() != tt.ownerID || p.EmulatorArgs() != tt.args {
+			t.Errorf("NewPolicy(%+v) = %q, %q, %q, want %q, %q, %q",
+				tt.cfg, p.MountPath(), p.OwnerID(), p.EmulatorArgs(), tt.mountPath, tt.ownerID, tt.args)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package webp
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	vp8lSignature = 0x2f
+	maxDimension  = 1 << 14
+
+	numLiteralCodes    = 256
+	numLengthCodes     = 24
+	numDistanceCodes   = 40
+	numCodeLengthCodes = 19
+
+	maxCodeLength           = 15
+	maxCodeLengthCodeLength = 7
+)
+
+// codeLengthCodeOrder is the order the code length code lengths are written in.
+var codeLengthCodeOrder = [numCodeLengthCodes]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+var ErrTooLarge = errors.New("webp: image is too large")
+
+// Encode writes img to w as a lossless WebP (VP8L) image. The pixels are
+// entropy coded per channel without predictors, which keeps the encoder
+// small at the cost of a larger output than libwebp produces.
+func Encode(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > maxDimension || height > maxDimension {
+		return ErrTooLarge
+	}
+
+	// Collect the channels in the green, red, blue, alpha order they are coded in.
+	pixels := make([][4]uint8, 0, width*height)
+	var hist [4][]int
+	for i := range hist {
+		hist[i] = make([]int, numLiteralCodes)
+	}
+	hasAlpha := false
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			px := [4]uint8{c.G, c.R, c.B, c.A}
+			for i, v := range px {
+				hist[i][v]++
+			}
+			if c.A != 0xff {
+				hasAlpha = true
+			}
+			pixels = append(pixels, px)
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.writeBits(vp8lSignature, 8)
+	bw.writeBits(uint32(width-1), 14)
+	bw.writeBits(uint32(height-1), 14)
+	if hasAlpha {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+	bw.writeBits(0, 3) // version
+	bw.writeBits(0, 1) // no transform
+	bw.writeBits(0, 1) // no color cache
+	bw.writeBits(0, 1) // no meta prefix codes
+
+	var codes [4]prefixCode
+	for i := range codes {
+		alphabetSize := numLiteralCodes
+		if i == 0 {
+			alphabetSize += numLengthCodes
+		}
+		codes[i] = writePrefixCode(bw, hist[i], alphabetSize)
+	}
+	// Distance code, unused since there are no backward references.
+	writeSimpleCode(bw, []int{0})
+
+	for _, px := range pixels {
+		for i, v := range px {
+			codes[i].write(bw, int(v))
+		}
+	}
+
+	data := bw.bytes()
+	chunkSize := len(data)
+	padding := chunkSize & 1
+
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+chunkSize+padding))
+	copy(header[8:], "WEBP")
+	copy(header[12:], "VP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(chunkSize))
+
+	out := bufio.NewWriter(w)
+	out.Write(header)
+	out.Write(data)
+	if padding == 1 {
+		out.WriteByte(0)
+	}
+	return out.Flush()
+}
+
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+// writeBits writes the n low bits of v, least significant bit first.
+func (bw *bitWriter) writeBits(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nbits -= 8
+	}
+}
+
+func (bw *bitWriter) bytes() []byte {
+	if bw.nbits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc = 0
+		bw.nbits = 0
+	}
+	return bw.buf
+}
+
+// prefixCode holds the bit-reversed canonical codes of an alphabet, ready
+// to be written least significant bit first.
+type prefixCode struct {
+	codes   []uint32
+	lengths []uint8
+}
+
+func (pc prefixCode) write(bw *bitWriter, symbol int) {
+	if n := pc.lengths[symbol]; n > 0 {
+		bw.writeBits(pc.codes[symbol], uint(n))
+	}
+}
+
+// writePrefixCode writes the code for a histogram and returns it. Up to
+// two symbols use the simple code form, others the normal form.
+func writePrefixCode(bw *bitWriter, hist []int, alphabetSize int) prefixCode {
+	var used []int
+	for symbol, count := range hist {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) <= 2 {
+		return writeSimpleCode(bw, used)
+	}
+
+	freq := make([]int, alphabetSize)
+	copy(freq, hist)
+	lengths := huffmanLengths(freq, maxCodeLength)
+
+	// Code the code lengths with their own prefix code.
+	clFreq := make([]int, numCodeLengthCodes)
+	for _, l := range lengths {
+		clFreq[l]++
+	}
+	ensureTwoSymbols(clFreq)
+	clLengths := huffmanLengths(clFreq, maxCodeLengthCodeLength)
+	clCode := newPrefixCode(clLengths)
+
+	bw.writeBits(0, 1) // normal code
+	bw.writeBits(numCodeLengthCodes-4, 4)
+	for _, symbol := range codeLengthCodeOrder {
+		bw.writeBits(uint32(clLengths[symbol]), 3)
+	}
+	bw.writeBits(0, 1) // code lengths for the whole alphabet follow
+	for _, l := range lengths {
+		clCode.write(bw, int(l))
+	}
+
+	return newPrefixCode(lengths)
+}
+
+// writeSimpleCode writes a code of one or two symbols below 256.
+func writeSimpleCode(bw *bitWriter, symbols []int) prefixCode {
+	if len(symbols) == 0 {
+		symbols = []int{0}
+	}
+
+	bw.writeBits(1, 1) // simple code
+	bw.writeBits(uint32(len(symbols)-1), 1)
+	if symbols[0] <= 1 {
+		bw.writeBits(0, 1)
+		bw.writeBits(uint32(symbols[0]), 1)
+	} else {
+		bw.writeBits(1, 1)
+		bw.writeBits(uint32(symbols[0]), 8)
+	}
+
+	lengths := make([]uint8, numLiteralCodes)
+	if len(symbols) == 2 {
+		bw.writeBits(uint32(symbols[1]), 8)
+		lengths[symbols[0]] = 1
+		lengths[symbols[1]] = 1
+	}
+
+	return newPrefixCode(lengths)
+}
+
+// ensureTwoSymbols makes sure a histogram has at least two symbols so the
+// Huffman code built from it uses at least one bit per symbol.
+func ensureTwoSymbols(freq []int) {
+	nonZero := 0
+	for _, f := range freq {
+		if f > 0 {
+			nonZero++
+		}
+	}
+	for i := 0; nonZero < 2 && i < len(freq); i++ {
+		if freq[i] == 0 {
+			freq[i] = 1
+			nonZero++
+		}
+	}
+}
+
+// newPrefixCode assigns canonical codes to the lengths, in order of length
+// then symbol, and reverses them for the LSB-first bit writer.
+func newPrefixCode(lengths []uint8) prefixCode {
+	var count [maxCodeLength + 1]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+
+	var next [maxCodeLength + 1]uint32
+	code := uint32(0)
+	for l := 1; l <= maxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+
+	codes := make([]uint32, len(lengths))
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		codes[symbol] = reverseBits(next[l], uint(l))
+		next[l]++
+	}
+
+	return prefixCode{codes: codes, lengths: lengths}
+}
+
+func reverseBits(v uint32, n uint) uint32 {
+	var r uint32
+	for i := uint(0); i < n; i++ {
+		r = r<<1 | (v>>i)&1
+	}
+	return r
+}
+
+type huffmanNode struct {
+	weight int
+	symbol int
+	left   *huffmanNode
+	right  *huffmanNode
+}
+
+type nodeHeap []*huffmanNode
+
+func (h nodeHeap) Len() int { return len(h) }
+func (h nodeHeap) Less(i, j int) bool {
+	if h[i].weight != h[j].weight {
+		return h[i].weight < h[j].weight
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h nodeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *nodeHeap) Push(x any)   { *h = append(*h, x.(*huffmanNode)) }
+func (h *nodeHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths returns Huffman code lengths no longer than maxLen for
+// the frequencies, which need at least two non-zero entries. Frequencies
+// are flattened until the tree fits.
+func huffmanLengths(freq []int, maxLen int) []uint8 {
+	freq = append([]int(nil), freq...)
+	for {
+		lengths := make([]uint8, len(freq))
+		h := &nodeHeap{}
+		for symbol, f := range freq {
+			if f > 0 {
+				heap.Push(h, &huffmanNode{weight: f, symbol: symbol})
+			}
+		}
+
+		for h.Len() > 1 {
+			a := heap.Pop(h).(*huffmanNode)
+			b := heap.Pop(h).(*huffmanNode)
+			heap.Push(h, &huffmanNode{weight: a.weight + b.weight, symbol: len(freq) + min(a.symbol, b.symbol), left: a, right: b})
+		}
+
+		tooLong := false
+		var walk func(n *huffmanNode, depth int)
+		walk = func(n *huffmanNode, depth int) {
+			if n.left == nil {
+				if depth > maxLen {
+					tooLong = true
+				}
+				lengths[n.symbol] = uint8(depth)
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk(heap.Pop(h).(*huffmanNode), 0)
+
+		if !tooLong {
+			return lengths
+		}
+
+		for i, f := range freq {
+			if f > 0 {
+				freq[i] = (f + 1) / 2
+			}
+		}
+	}
+}
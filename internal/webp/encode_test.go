@@ -0,0 +1,146 @@
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"math/bits"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func randomImage(rng *rand.Rand, width, height int, alpha func() uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), alpha()})
+		}
+	}
+	return img
+}
+
+// roundTrip encodes img, decodes it and compares the pixels.
+func roundTrip(t *testing.T, img image.Image) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, img); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	bounds := img.Bounds()
+	if decoded.Bounds().Dx() != bounds.Dx() || decoded.Bounds().Dy() != bounds.Dy() {
+		t.Fatalf("decoded size %v, want %v", decoded.Bounds().Size(), bounds.Size())
+	}
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			want := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+			if got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestEncodeOpaque(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	opaque := func() uint8 { return 0xff }
+
+	for _, size := range []image.Point{{1, 1}, {3, 5}, {17, 9}, {255, 3}, {64, 64}} {
+		t.Run(size.String(), func(t *testing.T) {
+			roundTrip(t, randomImage(rng, size.X, size.Y, opaque))
+		})
+	}
+}
+
+func TestEncodeTranslucent(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	translucent := func() uint8 { return uint8(rng.Intn(256)) }
+
+	for _, size := range []image.Point{{1, 1}, {7, 3}, {33, 31}} {
+		t.Run(size.String(), func(t *testing.T) {
+			roundTrip(t, randomImage(rng, size.X, size.Y, translucent))
+		})
+	}
+
+	// A few levels of alpha over a premultiplied image.
+	img := image.NewRGBA(image.Rect(0, 0, 5, 3))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 17)
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = []uint8{0, 0xff, 0x80}[i/4%3]
+		for c := i - 3; c < i; c++ {
+			img.Pix[c] = min(img.Pix[c], img.Pix[i])
+		}
+	}
+	t.Run("premultiplied", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := webp.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 15; i++ {
+			x, y := i%5, i/5
+			_, _, _, wantA := img.At(x, y).RGBA()
+			_, _, _, gotA := decoded.At(x, y).RGBA()
+			if gotA != wantA {
+				t.Fatalf("alpha of (%d, %d) = %d, want %d", x, y, gotA, wantA)
+			}
+		}
+	})
+}
+
+func TestEncodeFewColors(t *testing.T) {
+	// A single value per channel and two values per channel are the edge
+	// cases of the prefix codes.
+	single := image.NewNRGBA(image.Rect(0, 0, 9, 7))
+	two := image.NewNRGBA(image.Rect(0, 0, 9, 7))
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 9; x++ {
+			single.SetNRGBA(x, y, color.NRGBA{10, 20, 30, 0xff})
+			two.SetNRGBA(x, y, []color.NRGBA{{0, 0, 0, 0xff}, {0xff, 0xff, 0xff, 0x80}}[(x+y)%2])
+		}
+	}
+	roundTrip(t, single)
+	roundTrip(t, two)
+	roundTrip(t, image.NewGray(image.Rect(0, 0, 3, 3)))
+}
+
+func TestEncodeSkewed(t *testing.T) {
+	// Values with halving frequencies give Huffman codes longer than the
+	// 15 bits allowed, which must be limited.
+	rng := rand.New(rand.NewSource(3))
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(bits.TrailingZeros32(rng.Uint32() | 1<<24))
+	}
+	roundTrip(t, img)
+}
+
+func TestEncodeSubImage(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	img := randomImage(rng, 20, 20, func() uint8 { return 0xff })
+	roundTrip(t, img.SubImage(image.Rect(3, 5, 16, 12)))
+}
+
+func TestEncodeTooLarge(t *testing.T) {
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 0, 5), image.Rect(0, 0, maxDimension+1, 1)} {
+		if err := Encode(&bytes.Buffer{}, image.NewGray(r)); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%v: err = %v, want ErrTooLarge", r, err)
+		}
+	}
+}